@@ -6,6 +6,7 @@
 //	fitwatch --once             # Sync existing files and exit
 //	fitwatch --config           # Show config path
 //	fitwatch --init             # Create default config file
+//	fitwatch auth <consumer>    # Authorize an OAuth consumer (e.g. Strava)
 //
 // Service commands:
 //
@@ -23,14 +24,28 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"syscall"
+	"time"
 
 	"github.com/johnazariah/fitwatch/internal/config"
 	"github.com/johnazariah/fitwatch/internal/consumer"
-	"github.com/johnazariah/fitwatch/internal/consumer/intervals"
+	_ "github.com/johnazariah/fitwatch/internal/consumer/archive"   // registers the "archive" type
+	_ "github.com/johnazariah/fitwatch/internal/consumer/email"     // registers the "email" type
+	_ "github.com/johnazariah/fitwatch/internal/consumer/intervals" // registers the "intervals" type
+	_ "github.com/johnazariah/fitwatch/internal/consumer/plugin"    // registers the "plugin" type
+	_ "github.com/johnazariah/fitwatch/internal/consumer/s3"        // registers the "s3" type
+	_ "github.com/johnazariah/fitwatch/internal/consumer/strava"    // registers the "strava" type
+	_ "github.com/johnazariah/fitwatch/internal/consumer/webdav"    // registers the "webdav" type
+	_ "github.com/johnazariah/fitwatch/internal/consumer/webhook"   // registers the "webhook" type
 	"github.com/johnazariah/fitwatch/internal/daemon"
+	"github.com/johnazariah/fitwatch/internal/oauth"
+	"github.com/johnazariah/fitwatch/internal/pipeline"
 	"github.com/johnazariah/fitwatch/internal/store"
+	"github.com/johnazariah/fitwatch/internal/transform"
 	"github.com/johnazariah/fitwatch/internal/watcher"
 )
 
@@ -45,6 +60,10 @@ func main() {
 		handleServiceCommand(os.Args[2:])
 		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "auth" {
+		handleAuthCommand(os.Args[2:])
+		return
+	}
 
 	// Flags
 	configPath := flag.String("c", config.DefaultConfigPath(), "config file path")
@@ -172,6 +191,98 @@ func handleServiceCommand(args []string) {
 	}
 }
 
+func handleAuthCommand(args []string) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	flags := flag.NewFlagSet("auth", flag.ExitOnError)
+	configPath := flags.String("c", config.DefaultConfigPath(), "config file path")
+	port := flags.Int("port", 0, "loopback port for the redirect (default: any free port)")
+	noBrowser := flags.Bool("no-browser", false, "print the authorization URL without opening a browser")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fitwatch auth [-c config] [-port n] [-no-browser] <consumer>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := authorize(ctx, *configPath, flags.Arg(0), *port, !*noBrowser, logger); err != nil {
+		logger.Error("authorization failed", "consumer", flags.Arg(0), "error", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Authorized %s. Tokens are kept in the sync store and refreshed automatically.\n", flags.Arg(0))
+}
+
+// authorize runs the OAuth authorization flow for the named consumer and
+// saves its token in the sync store, where the consumer finds it.
+func authorize(ctx context.Context, configPath, name string, port int, openBrowser bool, logger *slog.Logger) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	consumers, err := cfg.AllConsumers()
+	if err != nil {
+		return fmt.Errorf("load consumers: %w", err)
+	}
+
+	var cc config.ConsumerConfig
+	for _, c := range consumers {
+		if c.Name() == name {
+			cc = c
+			break
+		}
+	}
+	if cc == nil {
+		return fmt.Errorf("no consumer named %q in %s", name, configPath)
+	}
+
+	storePath := cfg.StorePath
+	if storePath == "" {
+		storePath = config.DefaultStorePath()
+	}
+	syncStore, err := store.New(storePath)
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer func() { _ = syncStore.Close() }()
+
+	c, err := consumer.Build(cc.Type(), consumer.Spec{Name: cc.Name(), Settings: cc, Logger: logger, State: syncStore})
+	if err != nil {
+		return fmt.Errorf("create consumer: %w", err)
+	}
+	oc, ok := c.(oauth.Consumer)
+	if !ok {
+		return fmt.Errorf("%s consumers don't use OAuth", cc.Type())
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid consumer: %w", err)
+	}
+
+	opts := oauth.AuthorizeOptions{Port: port, Out: os.Stdout}
+	if openBrowser {
+		opts.Open = openURL
+	}
+	_, err = oc.OAuth().Authorize(ctx, opts)
+	return err
+}
+
+// openURL opens url in the user's browser.
+func openURL(url string) error {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	case "darwin":
+		return exec.Command("open", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
 func runAsService(configPath string, logger *slog.Logger) {
 	svc, err := daemon.New(
 		daemon.DefaultConfig(),
@@ -219,9 +330,13 @@ func runOnce(configPath string, logger *slog.Logger) {
 		logger.Error("setup failed", "error", err)
 		os.Exit(1)
 	}
+	defer func() { _ = syncStore.Close() }()
+	defer closeDispatcher(dispatcher, logger)
+
+	p := newPipeline(cfg, dispatcher, syncStore, logger)
 
 	// Handle new FIT files
-	handleNewFile := makeFileHandler(ctx, dispatcher, syncStore, logger)
+	handleNewFile := makeFileHandler(ctx, p, logger)
 
 	// Create watcher
 	w := watcher.New(cfg.WatchDirs, handleNewFile, logger)
@@ -230,8 +345,17 @@ func runOnce(configPath string, logger *slog.Logger) {
 	logger.Info("scanning for existing FIT files...")
 	if err := w.ScanExisting(); err != nil {
 		logger.Error("scan failed", "error", err)
+		_ = syncStore.Close()
 		os.Exit(1)
 	}
+
+	// Give any previously failed uploads that are now due another go
+	if n, err := p.RetryDue(ctx); err != nil {
+		logger.Error("retry failed", "error", err)
+	} else if n > 0 {
+		logger.Info("retried failed syncs", "count", n)
+	}
+	logDispatchStats(dispatcher, logger)
 	logger.Info("done")
 }
 
@@ -240,13 +364,37 @@ func runWatcher(ctx context.Context, configPath string, logger *slog.Logger) err
 	if err != nil {
 		return err
 	}
-	_ = syncStore // TODO: wire up properly
+	defer func() { _ = syncStore.Close() }()
+	defer closeDispatcher(dispatcher, logger)
+
+	// Push to each consumer from its own worker pool
+	dispatcher.Start(ctx)
+	defer logDispatchStats(dispatcher, logger)
+
+	p := newPipeline(cfg, dispatcher, syncStore, logger)
 
 	// Handle new FIT files
-	handleNewFile := makeFileHandler(ctx, dispatcher, syncStore, logger)
+	handleNewFile := makeFileHandler(ctx, p, logger)
 
 	// Create watcher
 	w := watcher.New(cfg.WatchDirs, handleNewFile, logger)
+	if cfg.Workers > 0 {
+		w.SetWorkers(cfg.Workers)
+	}
+
+	// Retry failed uploads in the background as they fall due. The retry
+	// loop is stopped and waited for before the dispatcher and store are
+	// closed, so it never pushes or records into a closed one.
+	retryCtx, stopRetries := context.WithCancel(ctx)
+	retriesDone := make(chan struct{})
+	go func() {
+		defer close(retriesDone)
+		p.RunRetries(retryCtx, time.Duration(cfg.Retry.Interval))
+	}()
+	defer func() {
+		stopRetries()
+		<-retriesDone
+	}()
 
 	// Scan existing files first
 	logger.Info("scanning for existing FIT files...")
@@ -284,10 +432,51 @@ func setup(configPath string, logger *slog.Logger) (*config.Config, *store.Store
 	dispatcher := consumer.NewDispatcher()
 	dispatcher.SetLogger(logger)
 
-	if cfg.Intervals.Enabled {
-		ic := intervals.New(cfg.Intervals.AthleteID, cfg.Intervals.APIKey)
-		dispatcher.AddConsumer(ic)
-		logger.Info("enabled consumer", "name", ic.Name())
+	cacheDir := cfg.TransformCache
+	if cacheDir == "" {
+		cacheDir = filepath.Join(filepath.Dir(storePath), "transformed")
+	}
+	dispatcher.SetTransformCache(transform.NewCache(cacheDir))
+
+	consumers, err := cfg.AllConsumers()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("load consumers: %w", err)
+	}
+	for _, cc := range consumers {
+		if !cc.Enabled() {
+			continue
+		}
+
+		c, err := consumer.Build(cc.Type(), consumer.Spec{Name: cc.Name(), Settings: cc, Logger: logger, State: syncStore})
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("create consumer: %w", err)
+		}
+		limits, err := cc.Limits()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		filter, err := cc.Filter()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		chain, err := buildTransforms(cc)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		public, err := cc.Public()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if public {
+			// Hide the privacy zones before any other transform runs.
+			chain = append(transform.Chain{privacyZones(cfg.Privacy)}, chain...)
+		}
+
+		dispatcher.AddConsumer(c)
+		applyLimits(dispatcher, c.Name(), limits)
+		applyFilter(dispatcher, c.Name(), filter)
+		dispatcher.SetTransforms(c.Name(), chain)
+		logger.Info("enabled consumer", "name", c.Name(), "type", cc.Type(), "transforms", chain.String())
 	}
 
 	if err := dispatcher.ValidateAll(); err != nil {
@@ -297,15 +486,121 @@ func setup(configPath string, logger *slog.Logger) (*config.Config, *store.Store
 	return cfg, syncStore, dispatcher, nil
 }
 
-func makeFileHandler(ctx context.Context, dispatcher *consumer.Dispatcher, _ *store.Store, logger *slog.Logger) func(string) {
-	// TODO: use store parameter to record sync results with full metadata
+// applyLimits configures the dispatcher's queue, rate limit and circuit
+// breaker for one consumer.
+func applyLimits(d *consumer.Dispatcher, name string, l config.ConsumerLimits) {
+	d.SetLimits(name, consumer.Limits{
+		Concurrency: l.Concurrency,
+		QueueSize:   l.QueueSize,
+	})
+	d.SetRateLimit(name, consumer.RateLimit{
+		Rate:  l.RatePerMinute / 60,
+		Burst: l.Burst,
+	})
+	d.SetBreaker(name, consumer.BreakerConfig{
+		Threshold: l.BreakerThreshold,
+		Cooldown:  time.Duration(l.BreakerCooldown),
+	})
+}
+
+// applyFilter restricts the files the dispatcher sends to one consumer.
+func applyFilter(d *consumer.Dispatcher, name string, f config.FilterConfig) {
+	filter := consumer.Filter{
+		ActivityTypes:        f.ActivityTypes,
+		ExcludeActivityTypes: f.ExcludeActivityTypes,
+		Dirs:                 f.Dirs,
+		MinDuration:          time.Duration(f.MinDuration),
+		MaxDuration:          time.Duration(f.MaxDuration),
+		MinDistanceMeters:    f.MinDistanceKm * 1000,
+		MaxDistanceMeters:    f.MaxDistanceKm * 1000,
+		Manufacturers:        f.Manufacturers,
+	}
+	if f.After != nil {
+		filter.After = f.After.AsTime(time.Local)
+	}
+	if f.Before != nil {
+		filter.Before = f.Before.AsTime(time.Local)
+	}
+	d.SetFilter(name, filter)
+}
+
+// buildTransforms creates the chain of transforms applied to files before
+// they are pushed to one consumer.
+func buildTransforms(cc config.ConsumerConfig) (transform.Chain, error) {
+	configs, err := cc.Transforms()
+	if err != nil {
+		return nil, err
+	}
+	var chain transform.Chain
+	for _, tc := range configs {
+		t, err := transform.Build(tc.Type(), tc)
+		if err != nil {
+			return nil, fmt.Errorf("consumer %q: %w", cc.Name(), err)
+		}
+		chain = append(chain, t)
+	}
+	return chain, nil
+}
+
+// privacyZones creates the transform that hides the [privacy] zones from
+// public consumers.
+func privacyZones(p config.PrivacyConfig) transform.PrivacyZones {
+	zones := make([]transform.Zone, len(p.Zones))
+	for i, z := range p.Zones {
+		zones[i] = transform.Zone{Lat: z.Lat, Lon: z.Lon, RadiusMeters: z.RadiusM}
+	}
+	return transform.PrivacyZones{Zones: zones, Mode: transform.PrivacyMode(p.Mode)}
+}
+
+// logDispatchStats logs each consumer's push counts and circuit state.
+func logDispatchStats(d *consumer.Dispatcher, logger *slog.Logger) {
+	for _, s := range d.Stats() {
+		logger.Info("consumer stats",
+			"consumer", s.Consumer,
+			"pushes", s.Pushes,
+			"failures", s.Failures,
+			"circuit", s.Breaker.String(),
+			"queued", s.Queued,
+		)
+	}
+}
+
+// closeDispatcher waits for in-flight pushes to finish, then closes the
+// consumers (stopping any plugin processes).
+func closeDispatcher(d *consumer.Dispatcher, logger *slog.Logger) {
+	d.Wait()
+	if err := d.Close(); err != nil {
+		logger.Warn("failed to close consumers", "error", err)
+	}
+}
+
+func newPipeline(cfg *config.Config, dispatcher *consumer.Dispatcher, syncStore *store.Store, logger *slog.Logger) *pipeline.Pipeline {
+	p := pipeline.New(dispatcher, syncStore, logger)
+
+	policy := pipeline.DefaultRetryPolicy()
+	policy.MaxAttempts = cfg.Retry.MaxAttempts
+	policy.BaseDelay = time.Duration(cfg.Retry.BaseDelay)
+	policy.MaxDelay = time.Duration(cfg.Retry.MaxDelay)
+	p.SetRetryPolicy(policy)
+
+	return p
+}
+
+func makeFileHandler(ctx context.Context, p *pipeline.Pipeline, logger *slog.Logger) func(string) {
 	return func(path string) {
-		results := dispatcher.Dispatch(ctx, path)
+		results, err := p.HandleFile(ctx, path)
+		if err != nil {
+			logger.Error("failed to process file", "path", path, "error", err)
+			return
+		}
 
 		for _, r := range results {
-			if r.Success {
-				logger.Info("synced", "path", r.FitPath, "consumer", r.Consumer)
-			} else {
+			switch {
+			case r.Skipped:
+				logger.Info("skipped", "path", r.FitPath, "consumer", r.Consumer, "reason", r.SkipReason)
+			case r.Success:
+				logger.Info("synced", "path", r.FitPath, "consumer", r.Consumer, "remote_id", r.RemoteID, "url", r.RemoteURL)
+			default:
 				logger.Error("sync failed", "path", r.FitPath, "consumer", r.Consumer, "error", r.Error)
 			}
 		}
@@ -3,9 +3,11 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -15,11 +17,61 @@ type Config struct {
 	// WatchDirs are directories to monitor for new FIT files.
 	WatchDirs []string `toml:"watch_dirs"`
 
-	// Intervals.icu configuration
+	// Intervals.icu configuration (kept for compatibility; new setups
+	// should use a [[consumers]] entry with type = "intervals")
 	Intervals IntervalsConfig `toml:"intervals"`
 
+	// Consumers lists the destinations files are sent to.
+	Consumers []ConsumerConfig `toml:"consumers,omitempty"`
+
 	// Store path for sync database (optional, defaults to ~/.fitwatch/fitwatch.db)
 	StorePath string `toml:"store_path,omitempty"`
+
+	// TransformCache is where transformed copies of files are kept
+	// (optional, defaults to a "transformed" directory beside the store).
+	TransformCache string `toml:"transform_cache,omitempty"`
+
+	// Privacy hides places such as home from public consumers.
+	Privacy PrivacyConfig `toml:"privacy,omitempty"`
+
+	// Retry controls how failed uploads are rescheduled.
+	Retry RetryConfig `toml:"retry"`
+
+	// Workers is the number of new files handled at once (optional, defaults to 4).
+	Workers int `toml:"workers,omitempty"`
+}
+
+// RetryConfig holds settings for the persistent retry queue.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts before a sync is marked dead.
+	MaxAttempts int `toml:"max_attempts"`
+
+	// BaseDelay is the delay before the first retry; it doubles each attempt.
+	BaseDelay Duration `toml:"base_delay"`
+
+	// MaxDelay caps the delay between retries.
+	MaxDelay Duration `toml:"max_delay"`
+
+	// Interval is how often the retry queue is checked for due syncs.
+	Interval Duration `toml:"interval"`
+}
+
+// Duration is a time.Duration that reads and writes as a string like "5m".
+type Duration time.Duration
+
+// UnmarshalText parses a duration string such as "90s" or "24h".
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration as a string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
 }
 
 // IntervalsConfig holds Intervals.icu API settings.
@@ -27,6 +79,83 @@ type IntervalsConfig struct {
 	Enabled   bool   `toml:"enabled"`
 	AthleteID string `toml:"athlete_id"`
 	APIKey    string `toml:"api_key"`
+
+	// Compress uploads FIT files gzipped, to save bandwidth.
+	Compress bool `toml:"compress,omitempty"`
+
+	ConsumerLimits
+
+	// Activity sets fields on each activity after it is uploaded.
+	Activity IntervalsActivityConfig `toml:"activity,omitempty"`
+}
+
+// ConsumerLimits controls how fast files are pushed to a consumer.
+type ConsumerLimits struct {
+	// Concurrency is the number of uploads run at once (optional, defaults to 1).
+	Concurrency int `toml:"concurrency,omitempty"`
+
+	// QueueSize is the number of uploads that may wait for a free slot
+	// (optional, defaults to 64).
+	QueueSize int `toml:"queue_size,omitempty"`
+
+	// RatePerMinute caps the average upload rate; 0 means unlimited.
+	RatePerMinute float64 `toml:"rate_per_minute,omitempty"`
+
+	// Burst is the number of uploads allowed back to back under the rate limit.
+	Burst int `toml:"burst,omitempty"`
+
+	// BreakerThreshold is the number of consecutive failures after which
+	// uploads are held back; 0 disables the circuit breaker.
+	BreakerThreshold int `toml:"breaker_threshold"`
+
+	// BreakerCooldown is how long uploads are held back before a probe.
+	BreakerCooldown Duration `toml:"breaker_cooldown"`
+}
+
+// DefaultConsumerLimits returns limits that pause a consumer for a few
+// minutes after a run of failures.
+func DefaultConsumerLimits() ConsumerLimits {
+	return ConsumerLimits{
+		BreakerThreshold: 5,
+		BreakerCooldown:  Duration(5 * time.Minute),
+	}
+}
+
+// Validate checks the limits are usable. prefix names the config section.
+func (l ConsumerLimits) Validate(prefix string) error {
+	if l.Concurrency < 0 || l.QueueSize < 0 || l.Burst < 0 || l.RatePerMinute < 0 || l.BreakerThreshold < 0 {
+		return fmt.Errorf("%s: concurrency, queue_size, rate_per_minute, burst and breaker_threshold must not be negative", prefix)
+	}
+	if l.BreakerThreshold > 0 && l.BreakerCooldown <= 0 {
+		return fmt.Errorf("%s: breaker_cooldown must be positive when the breaker is enabled", prefix)
+	}
+	return nil
+}
+
+// ActivityFieldsConfig holds fields to set on an uploaded activity.
+// Name, description and tags are Go templates over the parsed FIT metadata.
+type ActivityFieldsConfig struct {
+	Name        string   `toml:"name,omitempty"`
+	Description string   `toml:"description,omitempty"`
+	GearID      string   `toml:"gear_id,omitempty"`
+	Tags        []string `toml:"tags,omitempty"`
+	Trainer     *bool    `toml:"trainer,omitempty"`
+	Commute     *bool    `toml:"commute,omitempty"`
+}
+
+// IntervalsActivityConfig holds default activity fields, with overrides
+// for files found in particular watch directories.
+type IntervalsActivityConfig struct {
+	ActivityFieldsConfig
+
+	Dirs []DirActivityConfig `toml:"dirs,omitempty"`
+}
+
+// DirActivityConfig overrides activity fields for files under Dir.
+type DirActivityConfig struct {
+	Dir string `toml:"dir"`
+
+	ActivityFieldsConfig
 }
 
 // DefaultWatchDirs returns platform-specific default directories.
@@ -57,8 +186,21 @@ func DefaultConfig() *Config {
 	return &Config{
 		WatchDirs: DefaultWatchDirs(),
 		Intervals: IntervalsConfig{
-			Enabled: false,
+			Enabled:        false,
+			ConsumerLimits: DefaultConsumerLimits(),
 		},
+		Retry: DefaultRetryConfig(),
+	}
+}
+
+// DefaultRetryConfig returns retry settings that span several days,
+// enough to ride out a weekend outage.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts: 12,
+		BaseDelay:   Duration(5 * time.Minute),
+		MaxDelay:    Duration(24 * time.Hour),
+		Interval:    Duration(1 * time.Minute),
 	}
 }
 
@@ -69,7 +211,10 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
-	var cfg Config
+	cfg := Config{
+		Intervals: IntervalsConfig{ConsumerLimits: DefaultConsumerLimits()},
+		Retry:     DefaultRetryConfig(),
+	}
 	if err := toml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
@@ -120,6 +265,29 @@ func (c *Config) Validate() error {
 			return errors.New("intervals.api_key is required when intervals is enabled")
 		}
 	}
+	if err := c.Intervals.ConsumerLimits.Validate("intervals"); err != nil {
+		return err
+	}
+	for _, d := range c.Intervals.Activity.Dirs {
+		if d.Dir == "" {
+			return errors.New("intervals.activity.dirs entries must set dir")
+		}
+	}
+	if err := c.validateConsumers(); err != nil {
+		return err
+	}
+	if err := c.validatePrivacy(); err != nil {
+		return err
+	}
+	if c.Workers < 0 {
+		return errors.New("workers must not be negative")
+	}
+	if c.Retry.MaxAttempts < 1 {
+		return errors.New("retry.max_attempts must be at least 1")
+	}
+	if c.Retry.BaseDelay <= 0 || c.Retry.MaxDelay <= 0 || c.Retry.Interval <= 0 {
+		return errors.New("retry.base_delay, retry.max_delay and retry.interval must be positive")
+	}
 	return nil
 }
 
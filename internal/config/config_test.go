@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoad_Default(t *testing.T) {
@@ -247,3 +248,191 @@ watch_dirs = ["/my/dir"]
 		t.Error("athlete_id should be empty")
 	}
 }
+
+func TestLoad_RetryConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	configContent := `
+watch_dirs = ["/my/dir"]
+
+[retry]
+max_attempts = 5
+base_delay = "30s"
+max_delay = "6h"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Retry.MaxAttempts != 5 {
+		t.Errorf("expected 5 max attempts, got %d", cfg.Retry.MaxAttempts)
+	}
+	if time.Duration(cfg.Retry.BaseDelay) != 30*time.Second {
+		t.Errorf("expected 30s base delay, got %v", time.Duration(cfg.Retry.BaseDelay))
+	}
+	if time.Duration(cfg.Retry.MaxDelay) != 6*time.Hour {
+		t.Errorf("expected 6h max delay, got %v", time.Duration(cfg.Retry.MaxDelay))
+	}
+	// Unset values keep their defaults
+	if cfg.Retry.Interval != DefaultRetryConfig().Interval {
+		t.Errorf("expected default interval, got %v", time.Duration(cfg.Retry.Interval))
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
+
+func TestLoad_RetryConfigInvalidDuration(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	configContent := `
+[retry]
+base_delay = "soon"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Error("expected error for invalid duration")
+	}
+}
+
+func TestConfig_SaveRetryRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	cfg := DefaultConfig()
+	cfg.Retry.BaseDelay = Duration(2 * time.Minute)
+	if err := cfg.Save(configPath); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load after Save failed: %v", err)
+	}
+	if loaded.Retry != cfg.Retry {
+		t.Errorf("retry config mismatch: got %+v, want %+v", loaded.Retry, cfg.Retry)
+	}
+}
+
+func TestLoad_IntervalsActivityFields(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[intervals]
+enabled = true
+athlete_id = "i123456"
+api_key = "secret-key"
+
+[intervals.activity]
+name = "{{.Title}}"
+tags = ["fitwatch", "{{lower .ActivityType}}"]
+commute = false
+
+[[intervals.activity.dirs]]
+dir = "/home/me/Zwift/Activities"
+gear_id = "b1234"
+trainer = true
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	a := cfg.Intervals.Activity
+	if a.Name != "{{.Title}}" || len(a.Tags) != 2 {
+		t.Errorf("unexpected activity defaults: %+v", a.ActivityFieldsConfig)
+	}
+	if a.Commute == nil || *a.Commute {
+		t.Error("expected commute = false to be set")
+	}
+	if a.Trainer != nil {
+		t.Error("expected trainer unset in defaults")
+	}
+	if len(a.Dirs) != 1 {
+		t.Fatalf("expected 1 dir override, got %d", len(a.Dirs))
+	}
+	d := a.Dirs[0]
+	if d.Dir != "/home/me/Zwift/Activities" || d.GearID != "b1234" || d.Trainer == nil || !*d.Trainer {
+		t.Errorf("unexpected dir override: %+v", d)
+	}
+}
+
+func TestValidate_ActivityDirRequiresDir(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Intervals.Activity.Dirs = []DirActivityConfig{{ActivityFieldsConfig: ActivityFieldsConfig{GearID: "b1"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for dir override without dir")
+	}
+}
+
+func TestLoad_ConsumerLimits(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[intervals]
+enabled = true
+athlete_id = "i123456"
+api_key = "secret-key"
+concurrency = 2
+rate_per_minute = 30
+burst = 5
+breaker_cooldown = "10m"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	l := cfg.Intervals.ConsumerLimits
+	if l.Concurrency != 2 || l.RatePerMinute != 30 || l.Burst != 5 {
+		t.Errorf("unexpected limits %+v", l)
+	}
+	if time.Duration(l.BreakerCooldown) != 10*time.Minute {
+		t.Errorf("expected 10m cooldown, got %v", time.Duration(l.BreakerCooldown))
+	}
+	// Unset fields keep their defaults
+	if l.BreakerThreshold != DefaultConsumerLimits().BreakerThreshold {
+		t.Errorf("expected default breaker threshold, got %d", l.BreakerThreshold)
+	}
+}
+
+func TestValidate_ConsumerLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Intervals.RatePerMinute = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative rate")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Intervals.BreakerCooldown = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for enabled breaker without cooldown")
+	}
+
+	cfg.Intervals.BreakerThreshold = 0
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("disabled breaker needs no cooldown: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// LegacyIntervalsName is the instance name given to the [intervals]
+// section. It matches the name earlier versions recorded in the sync
+// store, so existing sync history carries over.
+const LegacyIntervalsName = "Intervals.icu"
+
+// ConsumerConfig is one [[consumers]] entry. The type key selects the
+// implementation and name identifies the instance (defaulting to the type);
+// the remaining keys are specific to the type and decoded by its factory.
+//
+//	[[consumers]]
+//	type = "intervals"
+//	name = "work-account"
+//	athlete_id = "i12345"
+//	api_key = "..."
+type ConsumerConfig map[string]any
+
+// Type returns the consumer type.
+func (cc ConsumerConfig) Type() string {
+	s, _ := cc["type"].(string)
+	return s
+}
+
+// Name returns the instance name, which defaults to the type.
+// Names are recorded against every sync, so they must be unique and
+// shouldn't change once files have been synced.
+func (cc ConsumerConfig) Name() string {
+	if s, _ := cc["name"].(string); s != "" {
+		return s
+	}
+	return cc.Type()
+}
+
+// Enabled reports whether the instance should run. Entries are enabled
+// unless they set enabled = false; validateConsumers rejects values that
+// aren't booleans.
+func (cc ConsumerConfig) Enabled() bool {
+	enabled, err := cc.flag("enabled", true)
+	return err != nil || enabled
+}
+
+// flag returns a boolean key, or def if the entry doesn't set it. Any
+// other value, such as "false" or 1, is an error rather than a guess.
+func (cc ConsumerConfig) flag(key string, def bool) (bool, error) {
+	v, ok := cc[key]
+	if !ok {
+		return def, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("consumer %q: %s must be true or false, got %v", cc.Name(), key, v)
+	}
+	return b, nil
+}
+
+// Public reports whether the instance publishes activities for others to
+// see, so files sent to it have the [privacy] zones hidden. Entries are
+// private unless they set public = true. Any other value, such as
+// public = "true", is an error rather than private, so a typo can't send
+// the full track.
+func (cc ConsumerConfig) Public() (bool, error) {
+	return cc.flag("public", false)
+}
+
+// Decode unmarshals the entry into v, which should use toml struct tags.
+// Keys v doesn't declare are ignored.
+func (cc ConsumerConfig) Decode(v any) error {
+	if err := decodeTable(cc, v); err != nil {
+		return fmt.Errorf("consumer %q: %w", cc.Name(), err)
+	}
+	return nil
+}
+
+// decodeTable unmarshals a generic TOML table into v.
+func decodeTable(table map[string]any, v any) error {
+	data, err := toml.Marshal(table)
+	if err != nil {
+		return err
+	}
+	return toml.Unmarshal(data, v)
+}
+
+// Limits returns the entry's queue, rate limit and circuit breaker
+// settings, with defaults for any it doesn't set.
+func (cc ConsumerConfig) Limits() (ConsumerLimits, error) {
+	limits := DefaultConsumerLimits()
+	if err := cc.Decode(&limits); err != nil {
+		return ConsumerLimits{}, err
+	}
+	return limits, nil
+}
+
+// FilterConfig is an entry's [consumers.filter] table, which selects the
+// files sent to the consumer. Every key that is set must match; a list
+// matches any of its values. Files that don't match are recorded as skipped.
+//
+//	[consumers.filter]
+//	activity_types = ["cycling"]
+//	exclude_activity_types = ["virtual_activity"]
+//	min_duration = "5m"
+type FilterConfig struct {
+	// ActivityTypes and ExcludeActivityTypes match the sport or sub-sport,
+	// ignoring case and underscores, e.g. "cycling" or "virtual_activity".
+	ActivityTypes        []string `toml:"activity_types,omitempty"`
+	ExcludeActivityTypes []string `toml:"exclude_activity_types,omitempty"`
+
+	// Dirs match files under any of the directories, e.g. a watch dir.
+	Dirs []string `toml:"dirs,omitempty"`
+
+	MinDuration   Duration `toml:"min_duration,omitempty"`
+	MaxDuration   Duration `toml:"max_duration,omitempty"`
+	MinDistanceKm float64  `toml:"min_distance_km,omitempty"`
+	MaxDistanceKm float64  `toml:"max_distance_km,omitempty"`
+
+	// Manufacturers match the recording device's maker, e.g. "garmin".
+	Manufacturers []string `toml:"manufacturers,omitempty"`
+
+	// After and Before are dates in local time, e.g. 2024-01-01: activities
+	// starting on or after After, and before Before, match.
+	After  *toml.LocalDate `toml:"after,omitempty"`
+	Before *toml.LocalDate `toml:"before,omitempty"`
+}
+
+// Validate checks the filter is usable. prefix names the config section.
+func (f FilterConfig) Validate(prefix string) error {
+	if f.MinDuration < 0 || f.MaxDuration < 0 || f.MinDistanceKm < 0 || f.MaxDistanceKm < 0 {
+		return fmt.Errorf("%s: filter durations and distances must not be negative", prefix)
+	}
+	if f.MaxDuration > 0 && f.MinDuration > f.MaxDuration {
+		return fmt.Errorf("%s: filter min_duration is greater than max_duration", prefix)
+	}
+	if f.MaxDistanceKm > 0 && f.MinDistanceKm > f.MaxDistanceKm {
+		return fmt.Errorf("%s: filter min_distance_km is greater than max_distance_km", prefix)
+	}
+	if f.After != nil && f.Before != nil && !f.After.AsTime(time.Local).Before(f.Before.AsTime(time.Local)) {
+		return fmt.Errorf("%s: filter after must be earlier than before", prefix)
+	}
+	return nil
+}
+
+// Filter returns the entry's filter; the zero FilterConfig, which matches
+// every file, if it has none.
+func (cc ConsumerConfig) Filter() (FilterConfig, error) {
+	var s struct {
+		Filter FilterConfig `toml:"filter"`
+	}
+	if err := cc.Decode(&s); err != nil {
+		return FilterConfig{}, err
+	}
+	return s.Filter, nil
+}
+
+// TransformConfig is one entry in a consumer's transforms list. The type
+// key selects the transform; the remaining keys are its settings.
+type TransformConfig map[string]any
+
+// Type returns the transform type.
+func (tc TransformConfig) Type() string {
+	s, _ := tc["type"].(string)
+	return s
+}
+
+// Decode unmarshals the entry into v, which should use toml struct tags.
+func (tc TransformConfig) Decode(v any) error {
+	if err := decodeTable(tc, v); err != nil {
+		return fmt.Errorf("transform %q: %w", tc.Type(), err)
+	}
+	return nil
+}
+
+// Transforms returns the entry's transforms, applied in order to a copy of
+// each file before it is pushed. Each is a type name, or a table with a
+// type and settings:
+//
+//	transforms = ["strip_gps", { type = "shift_time", offset = "-1h" }]
+func (cc ConsumerConfig) Transforms() ([]TransformConfig, error) {
+	var list []any
+	switch v := cc["transforms"].(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		list = v
+	case []map[string]any:
+		for _, m := range v {
+			list = append(list, m)
+		}
+	default:
+		return nil, fmt.Errorf("consumer %q: transforms must be a list", cc.Name())
+	}
+
+	transforms := make([]TransformConfig, 0, len(list))
+	for i, item := range list {
+		switch v := item.(type) {
+		case string:
+			transforms = append(transforms, TransformConfig{"type": v})
+		case map[string]any:
+			transforms = append(transforms, TransformConfig(v))
+		default:
+			return nil, fmt.Errorf("consumer %q: transforms[%d] must be a type name or a table", cc.Name(), i)
+		}
+		if transforms[i].Type() == "" {
+			return nil, fmt.Errorf("consumer %q: transforms[%d]: type is required", cc.Name(), i)
+		}
+	}
+	return transforms, nil
+}
+
+// AllConsumers returns every configured consumer instance: the legacy
+// [intervals] section (if enabled) followed by the [[consumers]] entries.
+func (c *Config) AllConsumers() ([]ConsumerConfig, error) {
+	var all []ConsumerConfig
+
+	if c.Intervals.Enabled {
+		legacy, err := c.Intervals.consumerConfig()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, legacy)
+	}
+
+	return append(all, c.Consumers...), nil
+}
+
+// consumerConfig converts the [intervals] section to the equivalent
+// [[consumers]] entry.
+func (ic IntervalsConfig) consumerConfig() (ConsumerConfig, error) {
+	data, err := toml.Marshal(ic)
+	if err != nil {
+		return nil, fmt.Errorf("intervals: %w", err)
+	}
+
+	cc := ConsumerConfig{}
+	if err := toml.Unmarshal(data, &cc); err != nil {
+		return nil, fmt.Errorf("intervals: %w", err)
+	}
+	cc["type"] = "intervals"
+	cc["name"] = LegacyIntervalsName
+	return cc, nil
+}
+
+// validateConsumers checks every [[consumers]] entry has a type, a unique
+// name, usable limits, a usable filter, well-formed transforms, and
+// boolean enabled and public keys. Whether the consumer and transform
+// types exist is checked when they are built.
+func (c *Config) validateConsumers() error {
+	all, err := c.AllConsumers()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(all))
+	for i, cc := range all {
+		if cc.Type() == "" {
+			return fmt.Errorf("consumers[%d]: type is required", i)
+		}
+		name := cc.Name()
+		if names[name] {
+			return fmt.Errorf("consumers: duplicate name %q (give each instance a unique name)", name)
+		}
+		names[name] = true
+
+		limits, err := cc.Limits()
+		if err != nil {
+			return err
+		}
+		if err := limits.Validate(fmt.Sprintf("consumer %q", name)); err != nil {
+			return err
+		}
+
+		filter, err := cc.Filter()
+		if err != nil {
+			return err
+		}
+		if err := filter.Validate(fmt.Sprintf("consumer %q", name)); err != nil {
+			return err
+		}
+
+		if _, err := cc.Transforms(); err != nil {
+			return err
+		}
+
+		if _, err := cc.flag("enabled", true); err != nil {
+			return err
+		}
+		if _, err := cc.Public(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad_Consumers(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	content := `
+[[consumers]]
+type = "intervals"
+name = "work"
+athlete_id = "i1"
+api_key = "k1"
+rate_per_minute = 30
+
+[[consumers]]
+type = "intervals"
+athlete_id = "i2"
+api_key = "k2"
+enabled = false
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cfg.Consumers) != 2 {
+		t.Fatalf("expected 2 consumers, got %d", len(cfg.Consumers))
+	}
+
+	work := cfg.Consumers[0]
+	if work.Type() != "intervals" || work.Name() != "work" || !work.Enabled() {
+		t.Errorf("unexpected first consumer: type=%q name=%q enabled=%v", work.Type(), work.Name(), work.Enabled())
+	}
+
+	var s struct {
+		AthleteID string `toml:"athlete_id"`
+		APIKey    string `toml:"api_key"`
+	}
+	if err := work.Decode(&s); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if s.AthleteID != "i1" || s.APIKey != "k1" {
+		t.Errorf("unexpected decoded settings: %+v", s)
+	}
+
+	limits, err := work.Limits()
+	if err != nil {
+		t.Fatalf("Limits failed: %v", err)
+	}
+	if limits.RatePerMinute != 30 {
+		t.Errorf("expected rate 30, got %v", limits.RatePerMinute)
+	}
+	if limits.BreakerThreshold != DefaultConsumerLimits().BreakerThreshold {
+		t.Errorf("expected default breaker threshold, got %d", limits.BreakerThreshold)
+	}
+
+	second := cfg.Consumers[1]
+	if second.Name() != "intervals" {
+		t.Errorf("expected name to default to type, got %q", second.Name())
+	}
+	if second.Enabled() {
+		t.Error("expected second consumer to be disabled")
+	}
+}
+
+func TestLoad_ConsumerFilter(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[[consumers]]
+type = "archive"
+dir = "/backup"
+
+[consumers.filter]
+activity_types = ["cycling"]
+exclude_activity_types = ["virtual_activity"]
+dirs = ["/rides"]
+min_duration = "5m"
+min_distance_km = 1.5
+manufacturers = ["garmin"]
+after = 2024-01-01
+before = 2025-01-01
+
+[[consumers]]
+type = "intervals"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	f, err := cfg.Consumers[0].Filter()
+	if err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	if len(f.ActivityTypes) != 1 || len(f.ExcludeActivityTypes) != 1 || len(f.Dirs) != 1 || len(f.Manufacturers) != 1 {
+		t.Errorf("unexpected lists: %+v", f)
+	}
+	if time.Duration(f.MinDuration) != 5*time.Minute || f.MinDistanceKm != 1.5 {
+		t.Errorf("unexpected thresholds: %+v", f)
+	}
+	if f.After == nil || f.After.String() != "2024-01-01" || f.Before == nil || f.Before.String() != "2025-01-01" {
+		t.Errorf("unexpected dates: after=%v before=%v", f.After, f.Before)
+	}
+
+	none, err := cfg.Consumers[1].Filter()
+	if err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	if none.ActivityTypes != nil || none.MinDuration != 0 || none.After != nil {
+		t.Errorf("expected empty filter, got %+v", none)
+	}
+}
+
+func TestLoad_ConsumerTransforms(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+transform_cache = "/var/cache/fitwatch"
+
+[[consumers]]
+type = "strava"
+transforms = ["strip_heart_rate", { type = "shift_time", offset = "-1h" }]
+
+[[consumers]]
+type = "archive"
+dir = "/backup"
+
+[[consumers.transforms]]
+type = "strip_gps"
+
+[[consumers]]
+type = "intervals"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if cfg.TransformCache != "/var/cache/fitwatch" {
+		t.Errorf("unexpected transform cache %q", cfg.TransformCache)
+	}
+
+	strava, err := cfg.Consumers[0].Transforms()
+	if err != nil {
+		t.Fatalf("Transforms failed: %v", err)
+	}
+	if len(strava) != 2 || strava[0].Type() != "strip_heart_rate" || strava[1].Type() != "shift_time" {
+		t.Fatalf("unexpected transforms: %v", strava)
+	}
+	var shift struct {
+		Offset string `toml:"offset"`
+	}
+	if err := strava[1].Decode(&shift); err != nil || shift.Offset != "-1h" {
+		t.Errorf("unexpected settings %+v: %v", shift, err)
+	}
+
+	archive, err := cfg.Consumers[1].Transforms()
+	if err != nil {
+		t.Fatalf("Transforms failed: %v", err)
+	}
+	if len(archive) != 1 || archive[0].Type() != "strip_gps" {
+		t.Errorf("unexpected transforms: %v", archive)
+	}
+
+	none, err := cfg.Consumers[2].Transforms()
+	if err != nil || none != nil {
+		t.Errorf("expected no transforms, got %v, %v", none, err)
+	}
+}
+
+func TestAllConsumers_IncludesLegacyIntervals(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.toml")
+
+	content := `
+[intervals]
+enabled = true
+athlete_id = "i123"
+api_key = "secret"
+concurrency = 2
+breaker_cooldown = "1m"
+
+[intervals.activity]
+name = "{{.Title}}"
+
+[[consumers]]
+type = "intervals"
+name = "second"
+athlete_id = "i456"
+api_key = "other"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	all, err := cfg.AllConsumers()
+	if err != nil {
+		t.Fatalf("AllConsumers failed: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected 2 consumers, got %d", len(all))
+	}
+
+	legacy := all[0]
+	if legacy.Type() != "intervals" || legacy.Name() != LegacyIntervalsName {
+		t.Errorf("unexpected legacy consumer: type=%q name=%q", legacy.Type(), legacy.Name())
+	}
+
+	var s struct {
+		AthleteID string `toml:"athlete_id"`
+		Activity  struct {
+			Name string `toml:"name"`
+		} `toml:"activity"`
+	}
+	if err := legacy.Decode(&s); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if s.AthleteID != "i123" || s.Activity.Name != "{{.Title}}" {
+		t.Errorf("unexpected legacy settings: %+v", s)
+	}
+
+	limits, err := legacy.Limits()
+	if err != nil {
+		t.Fatalf("Limits failed: %v", err)
+	}
+	if limits.Concurrency != 2 || time.Duration(limits.BreakerCooldown) != time.Minute {
+		t.Errorf("unexpected legacy limits: %+v", limits)
+	}
+
+	if all[1].Name() != "second" {
+		t.Errorf("expected second consumer, got %q", all[1].Name())
+	}
+}
+
+func TestAllConsumers_LegacyDisabled(t *testing.T) {
+	cfg := DefaultConfig()
+	all, err := cfg.AllConsumers()
+	if err != nil {
+		t.Fatalf("AllConsumers failed: %v", err)
+	}
+	if len(all) != 0 {
+		t.Errorf("expected no consumers, got %d", len(all))
+	}
+}
+
+func TestValidate_Consumers(t *testing.T) {
+	tests := []struct {
+		name      string
+		consumers []ConsumerConfig
+		wantErr   string
+	}{
+		{
+			name:      "missing type",
+			consumers: []ConsumerConfig{{"name": "x"}},
+			wantErr:   "type is required",
+		},
+		{
+			name: "duplicate name",
+			consumers: []ConsumerConfig{
+				{"type": "intervals", "name": "a"},
+				{"type": "intervals", "name": "a"},
+			},
+			wantErr: "duplicate name",
+		},
+		{
+			name: "duplicate default name",
+			consumers: []ConsumerConfig{
+				{"type": "intervals"},
+				{"type": "intervals"},
+			},
+			wantErr: "duplicate name",
+		},
+		{
+			name:      "invalid limits",
+			consumers: []ConsumerConfig{{"type": "intervals", "burst": int64(-1)}},
+			wantErr:   "burst",
+		},
+		{
+			name: "inverted filter durations",
+			consumers: []ConsumerConfig{{"type": "intervals", "filter": map[string]any{
+				"min_duration": "2h", "max_duration": "1h",
+			}}},
+			wantErr: "min_duration",
+		},
+		{
+			name:      "transforms not a list",
+			consumers: []ConsumerConfig{{"type": "intervals", "transforms": "strip_gps"}},
+			wantErr:   "must be a list",
+		},
+		{
+			name: "transform without type",
+			consumers: []ConsumerConfig{{"type": "intervals", "transforms": []any{
+				map[string]any{"offset": "1h"},
+			}}},
+			wantErr: "type is required",
+		},
+		{
+			name:      "enabled as a string",
+			consumers: []ConsumerConfig{{"type": "intervals", "enabled": "false"}},
+			wantErr:   `consumer "intervals": enabled must be true or false`,
+		},
+		{
+			name: "distinct names",
+			consumers: []ConsumerConfig{
+				{"type": "intervals", "name": "a"},
+				{"type": "intervals", "name": "b"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Consumers = tt.consumers
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidate_ConsumerNameClashesWithLegacy(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Intervals.Enabled = true
+	cfg.Intervals.AthleteID = "i1"
+	cfg.Intervals.APIKey = "k"
+	cfg.Consumers = []ConsumerConfig{{"type": "intervals", "name": LegacyIntervalsName}}
+
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected duplicate name error")
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import "fmt"
+
+// PrivacyConfig is the [privacy] section: places to hide from consumers
+// marked public = true, such as home.
+//
+//	[privacy]
+//	mode = "truncate"
+//
+//	[[privacy.zones]]
+//	name = "home"
+//	lat = 51.5014
+//	lon = -0.1419
+//	radius_m = 500
+type PrivacyConfig struct {
+	// Mode is "truncate" (the default) to remove the track inside zones,
+	// or "strip" to keep those records but remove their positions.
+	Mode  string        `toml:"mode,omitempty"`
+	Zones []PrivacyZone `toml:"zones,omitempty"`
+}
+
+// PrivacyZone is a circle around a place to hide.
+type PrivacyZone struct {
+	Name    string  `toml:"name,omitempty"`
+	Lat     float64 `toml:"lat"`
+	Lon     float64 `toml:"lon"`
+	RadiusM float64 `toml:"radius_m"`
+}
+
+// Validate checks the mode and zones are usable.
+func (p PrivacyConfig) Validate() error {
+	switch p.Mode {
+	case "", "truncate", "strip":
+	default:
+		return fmt.Errorf("privacy.mode must be \"truncate\" or \"strip\", got %q", p.Mode)
+	}
+	for i, z := range p.Zones {
+		name := z.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		if z.Lat < -90 || z.Lat > 90 || z.Lon < -180 || z.Lon > 180 {
+			return fmt.Errorf("privacy zone %s: lat must be within ±90 and lon within ±180", name)
+		}
+		if z.RadiusM <= 0 {
+			return fmt.Errorf("privacy zone %s: radius_m must be positive", name)
+		}
+	}
+	return nil
+}
+
+// validatePrivacy checks the [privacy] section, and that there are zones
+// if any consumer is public: a public consumer would otherwise get every
+// track unchanged.
+func (c *Config) validatePrivacy() error {
+	if err := c.Privacy.Validate(); err != nil {
+		return err
+	}
+	if len(c.Privacy.Zones) > 0 {
+		return nil
+	}
+
+	all, err := c.AllConsumers()
+	if err != nil {
+		return err
+	}
+	for _, cc := range all {
+		public, err := cc.Public()
+		if err != nil {
+			return err
+		}
+		if cc.Enabled() && public {
+			return fmt.Errorf("consumer %q is public but no [[privacy.zones]] are configured", cc.Name())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad_Privacy(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[privacy]
+mode = "strip"
+
+[[privacy.zones]]
+name = "home"
+lat = 51.5014
+lon = -0.1419
+radius_m = 500
+
+[[privacy.zones]]
+lat = 51.5080
+lon = -0.1281
+radius_m = 250
+
+[[consumers]]
+type = "strava"
+public = true
+
+[[consumers]]
+type = "archive"
+dir = "/backup"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	if cfg.Privacy.Mode != "strip" || len(cfg.Privacy.Zones) != 2 {
+		t.Fatalf("unexpected privacy config: %+v", cfg.Privacy)
+	}
+	if z := cfg.Privacy.Zones[0]; z.Name != "home" || z.Lat != 51.5014 || z.Lon != -0.1419 || z.RadiusM != 500 {
+		t.Errorf("unexpected zone: %+v", z)
+	}
+	first, err := cfg.Consumers[0].Public()
+	if err != nil {
+		t.Fatalf("Public failed: %v", err)
+	}
+	second, err := cfg.Consumers[1].Public()
+	if err != nil {
+		t.Fatalf("Public failed: %v", err)
+	}
+	if !first || second {
+		t.Error("expected only the first consumer to be public")
+	}
+}
+
+func TestValidate_Privacy(t *testing.T) {
+	home := PrivacyZone{Name: "home", Lat: 51.5, Lon: -0.14, RadiusM: 500}
+	tests := []struct {
+		name      string
+		privacy   PrivacyConfig
+		consumers []ConsumerConfig
+		wantErr   string
+	}{
+		{
+			name:      "public with zones",
+			privacy:   PrivacyConfig{Zones: []PrivacyZone{home}},
+			consumers: []ConsumerConfig{{"type": "strava", "public": true}},
+		},
+		{
+			name:      "public without zones",
+			consumers: []ConsumerConfig{{"type": "strava", "public": true}},
+			wantErr:   "no [[privacy.zones]]",
+		},
+		{
+			name:      "disabled public without zones",
+			consumers: []ConsumerConfig{{"type": "strava", "public": true, "enabled": false}},
+		},
+		{
+			name:      "public as a string",
+			privacy:   PrivacyConfig{Zones: []PrivacyZone{home}},
+			consumers: []ConsumerConfig{{"type": "strava", "public": "true"}},
+			wantErr:   `consumer "strava": public must be true or false`,
+		},
+		{
+			name:      "public as a number",
+			consumers: []ConsumerConfig{{"type": "strava", "public": int64(1)}},
+			wantErr:   "public must be true or false",
+		},
+		{
+			name:    "unknown mode",
+			privacy: PrivacyConfig{Mode: "blur", Zones: []PrivacyZone{home}},
+			wantErr: "privacy.mode",
+		},
+		{
+			name:    "zero radius",
+			privacy: PrivacyConfig{Zones: []PrivacyZone{{Name: "work", Lat: 51.5, Lon: -0.14}}},
+			wantErr: "privacy zone work: radius_m",
+		},
+		{
+			name:    "latitude out of range",
+			privacy: PrivacyConfig{Zones: []PrivacyZone{{Lat: 95, Lon: -0.14, RadiusM: 100}}},
+			wantErr: "privacy zone 0: lat",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Privacy = tt.privacy
+			cfg.Consumers = tt.consumers
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Package archive provides a consumer that files FIT files into a local
+// library directory.
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/consumer/pathtmpl"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+const defaultName = "archive"
+
+// DefaultPathTemplate files activities by year and month, named by date,
+// sport and title.
+const DefaultPathTemplate = pathtmpl.Default
+
+// Consumer copies FIT files into a library directory, at a path given by a
+// template over the file's metadata.
+type Consumer struct {
+	// Dir is the root of the library.
+	Dir string
+
+	name   string
+	tmpl   *pathtmpl.Template
+	logger *slog.Logger
+}
+
+// New creates an archive consumer that files into dir using
+// DefaultPathTemplate.
+func New(dir string) *Consumer {
+	return &Consumer{
+		Dir:    dir,
+		name:   defaultName,
+		tmpl:   pathtmpl.MustParse(DefaultPathTemplate),
+		logger: slog.Default(),
+	}
+}
+
+// SetLogger configures the logger for the consumer.
+func (c *Consumer) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetPathTemplate sets the template for paths within the library; see
+// package pathtmpl for the available functions. Paths use forward slashes on
+// all platforms, and ".fit" is added if the result has no extension.
+func (c *Consumer) SetPathTemplate(text string) error {
+	tmpl, err := pathtmpl.Parse(text)
+	if err != nil {
+		return err
+	}
+	c.tmpl = tmpl
+	return nil
+}
+
+// Name returns the consumer's instance name.
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Validate checks configuration.
+func (c *Consumer) Validate() error {
+	if c.Dir == "" {
+		return errors.New("library directory is required")
+	}
+	return nil
+}
+
+// Push copies a FIT file into the library. The copy is written to a
+// temporary file, checked against the source's SHA-256 and then moved into
+// place, so the library never holds a partial file. If the templated name
+// is taken by a different file, a numbered name ("_2", "_3", ...) is used
+// instead; if it already holds this file, the push is reported as a
+// duplicate.
+func (c *Consumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	if err := c.Validate(); err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	src, err := os.Open(fitPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, consumer.Permanent(fmt.Errorf("open file: %w", err))
+		}
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	// A file we can't parse is still archived, filed under its
+	// modification time.
+	data, start, err := pathtmpl.Load(fitPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := c.tmpl.Render(data, start)
+	if err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	dest := filepath.Join(c.Dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("create directory: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tmp, err := writeTemp(src, filepath.Dir(dest), data.Hash)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	final, duplicate, err := place(tmp, dest, data.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	relFinal, err := filepath.Rel(c.Dir, final)
+	if err != nil {
+		relFinal = final
+	}
+	receipt := &consumer.Receipt{
+		RemoteID:  filepath.ToSlash(relFinal),
+		RemoteURL: fileURL(final),
+		Duplicate: duplicate,
+	}
+
+	if duplicate {
+		c.logger.Info("already archived", "file", filepath.Base(fitPath), "path", final)
+	} else {
+		c.logger.Info("archived", "file", filepath.Base(fitPath), "path", final)
+	}
+	return receipt, nil
+}
+
+// writeTemp copies src to a temporary file in dir and checks the copy on
+// disk has the expected SHA-256. It returns the temporary file's path.
+func writeTemp(src io.Reader, dir, wantHash string) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".fitwatch-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	path := tmp.Name()
+
+	fail := func(err error) (string, error) {
+		_ = tmp.Close()
+		_ = os.Remove(path)
+		return "", err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(src, hash)); err != nil {
+		return fail(fmt.Errorf("copy file: %w", err))
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return fail(fmt.Errorf("chmod temp file: %w", err))
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(fmt.Errorf("sync temp file: %w", err))
+	}
+	if err := tmp.Close(); err != nil {
+		return fail(fmt.Errorf("close temp file: %w", err))
+	}
+
+	// The source should match the hash we named it by, and the copy should
+	// read back the same as what we wrote.
+	if copied := hex.EncodeToString(hash.Sum(nil)); copied != wantHash {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("source changed while copying (sha256 %s, expected %s)", copied, wantHash)
+	}
+	written, err := fitparser.HashFile(path)
+	if err != nil {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("verify copy: %w", err)
+	}
+	if written != wantHash {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("verify copy: sha256 %s, expected %s", written, wantHash)
+	}
+
+	return path, nil
+}
+
+// place moves the verified temporary file to dest, or to the first free
+// numbered alternative if a different file is already there. It never
+// replaces an existing file. duplicate is set if a file with the same hash
+// was found instead.
+func place(tmp, dest, hash string) (final string, duplicate bool, err error) {
+	for i := 1; i <= delivery.MaxNameCollisions; i++ {
+		candidate := delivery.Numbered(dest, i)
+
+		placed, err := moveNoReplace(tmp, candidate)
+		if err != nil {
+			return "", false, err
+		}
+		if placed {
+			return candidate, false, nil
+		}
+
+		existing, err := fitparser.HashFile(candidate)
+		if err != nil {
+			return "", false, fmt.Errorf("hash existing file: %w", err)
+		}
+		if existing == hash {
+			return candidate, true, nil
+		}
+	}
+	return "", false, consumer.Permanent(fmt.Errorf("%s: more than %d different files with this name", dest, delivery.MaxNameCollisions))
+}
+
+// moveNoReplace moves tmp to dest unless dest exists, reporting whether it
+// did. A hard link makes the check and the move a single step; where links
+// aren't supported it falls back to a check followed by a rename.
+func moveNoReplace(tmp, dest string) (bool, error) {
+	err := os.Link(tmp, dest)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return false, nil
+	}
+
+	if _, statErr := os.Lstat(dest); statErr == nil {
+		return false, nil
+	} else if !errors.Is(statErr, fs.ErrNotExist) {
+		return false, fmt.Errorf("stat %s: %w", dest, statErr)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return false, fmt.Errorf("move into place: %w", err)
+	}
+	return true, nil
+}
+
+// fileURL returns a file:// URL for an absolute or relative path.
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	p := filepath.ToSlash(path)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
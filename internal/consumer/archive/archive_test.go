@@ -0,0 +1,223 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// sampleFitPath returns the repository's sample FIT file, copied into dir
+// under the given name.
+func sampleFitPath(t *testing.T, dir, name string) (string, *fitparser.Metadata) {
+	t.Helper()
+	_, thisFile, _, _ := runtime.Caller(0)
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(thisFile), "..", "..", "..", "testdata", "sample.fit"))
+	if err != nil {
+		t.Skipf("sample.fit not available: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := fitparser.Parse(path)
+	if err != nil || meta.StartTime == nil {
+		t.Skipf("sample.fit not parseable: %v", err)
+	}
+	return path, meta
+}
+
+// listFiles returns every file under dir, relative and slash-separated.
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestConsumer_Validate(t *testing.T) {
+	if err := New("").Validate(); err == nil {
+		t.Error("expected error for missing directory")
+	}
+	if err := New(t.TempDir()).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConsumer_Push_FilesByTemplate(t *testing.T) {
+	srcDir, library := t.TempDir(), t.TempDir()
+	fitPath, meta := sampleFitPath(t, srcDir, "2025-02-23_Hudayriyat_Ascend.fit")
+
+	c := New(library)
+	receipt, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	start := meta.StartTime.Local()
+	want := start.Format("2006/01/2006-01-02") + "_" + strings.ToLower(meta.ActivityType) + "_Hudayriyat_Ascend.fit"
+	if receipt.RemoteID != want {
+		t.Errorf("expected %s, got %s", want, receipt.RemoteID)
+	}
+	if receipt.Duplicate {
+		t.Error("first push should not be a duplicate")
+	}
+	if !strings.HasPrefix(receipt.RemoteURL, "file://") {
+		t.Errorf("expected file URL, got %s", receipt.RemoteURL)
+	}
+
+	hash, err := fitparser.HashFile(filepath.Join(library, filepath.FromSlash(want)))
+	if err != nil {
+		t.Fatalf("archived file missing: %v", err)
+	}
+	if hash != meta.Hash {
+		t.Error("archived file differs from source")
+	}
+
+	if files := listFiles(t, library); len(files) != 1 {
+		t.Errorf("expected only the archived file, got %v", files)
+	}
+}
+
+func TestConsumer_Push_SameFileIsDuplicate(t *testing.T) {
+	srcDir, library := t.TempDir(), t.TempDir()
+	fitPath, _ := sampleFitPath(t, srcDir, "ride.fit")
+
+	c := New(library)
+	first, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("first Push failed: %v", err)
+	}
+	second, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("second Push failed: %v", err)
+	}
+
+	if !second.Duplicate {
+		t.Error("expected second push to be a duplicate")
+	}
+	if second.RemoteID != first.RemoteID {
+		t.Errorf("expected %s, got %s", first.RemoteID, second.RemoteID)
+	}
+	if files := listFiles(t, library); len(files) != 1 {
+		t.Errorf("expected one file, got %v", files)
+	}
+}
+
+func TestConsumer_Push_NeverOverwritesDifferentFile(t *testing.T) {
+	srcDir, library := t.TempDir(), t.TempDir()
+	fitPath := filepath.Join(srcDir, "ride.fit")
+	if err := os.WriteFile(fitPath, []byte("new contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(library)
+	if err := c.SetPathTemplate("{{name}}.fit"); err != nil {
+		t.Fatal(err)
+	}
+
+	existing := filepath.Join(library, "ride.fit")
+	if err := os.WriteFile(existing, []byte("someone else's ride"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if receipt.RemoteID != "ride_2.fit" {
+		t.Errorf("expected ride_2.fit, got %s", receipt.RemoteID)
+	}
+
+	data, err := os.ReadFile(existing)
+	if err != nil || string(data) != "someone else's ride" {
+		t.Errorf("existing file was changed: %q, %v", data, err)
+	}
+	data, err = os.ReadFile(filepath.Join(library, "ride_2.fit"))
+	if err != nil || string(data) != "new contents" {
+		t.Errorf("unexpected archived contents: %q, %v", data, err)
+	}
+
+	// Pushing again finds the numbered copy rather than making another.
+	again, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("second Push failed: %v", err)
+	}
+	if !again.Duplicate || again.RemoteID != "ride_2.fit" {
+		t.Errorf("expected duplicate of ride_2.fit, got %+v", again)
+	}
+}
+
+func TestConsumer_Push_UnparseableFileUsesModTime(t *testing.T) {
+	srcDir, library := t.TempDir(), t.TempDir()
+	fitPath := filepath.Join(srcDir, "corrupt.fit")
+	if err := os.WriteFile(fitPath, []byte("not a FIT file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 9, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(fitPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := New(library).Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if want := "2024/03/2024-03-09_activity_corrupt.fit"; receipt.RemoteID != want {
+		t.Errorf("expected %s, got %s", want, receipt.RemoteID)
+	}
+}
+
+func TestConsumer_Push_PathOutsideLibrary(t *testing.T) {
+	srcDir, library := t.TempDir(), t.TempDir()
+	fitPath := filepath.Join(srcDir, "ride.fit")
+	if err := os.WriteFile(fitPath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(library)
+	if err := c.SetPathTemplate("../escape/{{name}}.fit"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.Push(context.Background(), fitPath)
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(library), "escape")); !os.IsNotExist(err) {
+		t.Error("file was written outside the library")
+	}
+}
+
+func TestConsumer_Push_MissingFile(t *testing.T) {
+	_, err := New(t.TempDir()).Push(context.Background(), filepath.Join(t.TempDir(), "missing.fit"))
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
+
+func TestSetPathTemplate_Invalid(t *testing.T) {
+	c := New(t.TempDir())
+	if err := c.SetPathTemplate("{{year"); err == nil {
+		t.Error("expected parse error")
+	}
+	if err := c.SetPathTemplate("{{nosuchfunc}}"); err == nil {
+		t.Error("expected error for unknown function")
+	}
+}
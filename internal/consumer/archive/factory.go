@@ -0,0 +1,31 @@
+package archive
+
+import "github.com/johnazariah/fitwatch/internal/consumer"
+
+func init() {
+	consumer.Register("archive", newFromSpec)
+}
+
+// settings are the config keys of an archive consumer.
+type settings struct {
+	Dir  string `toml:"dir"`
+	Path string `toml:"path"`
+}
+
+// newFromSpec builds a consumer from its [[consumers]] entry.
+func newFromSpec(spec consumer.Spec) (consumer.Consumer, error) {
+	var s settings
+	if err := spec.Settings.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	c := New(s.Dir)
+	c.name = spec.Name
+	c.SetLogger(spec.Logger)
+	if s.Path != "" {
+		if err := c.SetPathTemplate(s.Path); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
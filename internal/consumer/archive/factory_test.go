@@ -0,0 +1,24 @@
+package archive
+
+import (
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/consumertest"
+)
+
+func TestFactory_Build(t *testing.T) {
+	ac := consumertest.Build[*Consumer](t, "archive", "nas", `
+dir = "/library"
+path = "{{year}}/{{name}}.fit"
+`)
+	if ac.Dir != "/library" {
+		t.Errorf("unexpected dir: %s", ac.Dir)
+	}
+}
+
+func TestFactory_InvalidPathTemplate(t *testing.T) {
+	consumertest.BuildError(t, "archive", `
+dir = "/library"
+path = "{{year"
+`, "parse path template")
+}
@@ -0,0 +1,139 @@
+package consumer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for pushes that weren't attempted because the
+// consumer's circuit breaker is open. It is retryable.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a consumer's circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets pushes through as normal.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen holds pushes back after repeated failures.
+	BreakerOpen
+
+	// BreakerHalfOpen lets a single probe push through to test whether
+	// the destination has recovered.
+	BreakerHalfOpen
+)
+
+// String returns the state's name.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig configures a circuit breaker. After Threshold consecutive
+// retryable failures the breaker opens; after Cooldown it half-opens and
+// lets one probe through. A zero Threshold disables the breaker.
+type BreakerConfig struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// breaker is a consumer's circuit breaker.
+type breaker struct {
+	mu       sync.Mutex
+	cfg      BreakerConfig
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(cfg BreakerConfig) *breaker {
+	return &breaker{cfg: cfg}
+}
+
+// allow reports whether a push may proceed now. If not, wait is how long
+// until the breaker might allow one. A push allowed while half-open is the
+// probe; others are held back until it reports.
+func (b *breaker) allow(now time.Time) (ok bool, wait time.Duration, transition bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cfg.Threshold <= 0 {
+		return true, 0, false
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		reopen := b.openedAt.Add(b.cfg.Cooldown)
+		if now.Before(reopen) {
+			return false, reopen.Sub(now), false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true, 0, true
+
+	case BreakerHalfOpen:
+		if b.probing {
+			// Check back shortly; the probe will have settled the state.
+			return false, min(b.cfg.Cooldown, time.Second), false
+		}
+		b.probing = true
+		return true, 0, false
+
+	default:
+		return true, 0, false
+	}
+}
+
+// record updates the breaker with a push outcome and returns the new state
+// if it changed. failed should be set only for failures that say something
+// about the destination's health (not, say, a file it rejected).
+func (b *breaker) record(now time.Time, failed bool) (BreakerState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cfg.Threshold <= 0 {
+		return b.state, false
+	}
+
+	prev := b.state
+	b.probing = false
+
+	if !failed {
+		b.failures = 0
+		b.state = BreakerClosed
+		return b.state, b.state != prev
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.cfg.Threshold {
+		b.state = BreakerOpen
+		b.openedAt = now
+	}
+	return b.state, b.state != prev
+}
+
+// release gives up a probe without recording an outcome, e.g. when the
+// push was interrupted.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// snapshot returns the current state and consecutive failure count.
+func (b *breaker) snapshot() (BreakerState, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.failures
+}
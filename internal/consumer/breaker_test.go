@@ -0,0 +1,181 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(BreakerConfig{Threshold: 3, Cooldown: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if state, _ := b.record(now, true); state != BreakerClosed {
+			t.Fatalf("expected closed after %d failures, got %s", i+1, state)
+		}
+	}
+	state, changed := b.record(now, true)
+	if state != BreakerOpen || !changed {
+		t.Fatalf("expected breaker to open, got %s (changed=%v)", state, changed)
+	}
+
+	ok, wait, _ := b.allow(now.Add(10 * time.Second))
+	if ok {
+		t.Error("expected open breaker to hold pushes")
+	}
+	if wait != 50*time.Second {
+		t.Errorf("expected 50s until probe, got %v", wait)
+	}
+}
+
+func TestBreaker_SuccessResetsCount(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(BreakerConfig{Threshold: 2, Cooldown: time.Minute})
+
+	b.record(now, true)
+	b.record(now, false)
+	if state, _ := b.record(now, true); state != BreakerClosed {
+		t.Errorf("expected closed: success should reset the failure count, got %s", state)
+	}
+}
+
+func TestBreaker_HalfOpenProbe(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(BreakerConfig{Threshold: 1, Cooldown: time.Minute})
+	b.record(now, true)
+
+	later := now.Add(time.Minute)
+	ok, _, probing := b.allow(later)
+	if !ok || !probing {
+		t.Fatalf("expected probe after cooldown, got ok=%v probing=%v", ok, probing)
+	}
+	if state, _ := b.snapshot(); state != BreakerHalfOpen {
+		t.Errorf("expected half-open, got %s", state)
+	}
+
+	// Only one probe at a time
+	if ok, _, _ := b.allow(later); ok {
+		t.Error("expected second push to wait for the probe")
+	}
+
+	// A failed probe reopens the breaker for another cooldown
+	if state, _ := b.record(later, true); state != BreakerOpen {
+		t.Fatalf("expected failed probe to reopen, got %s", state)
+	}
+	if ok, _, _ := b.allow(later.Add(30 * time.Second)); ok {
+		t.Error("expected breaker to stay open for the new cooldown")
+	}
+
+	// A successful probe closes it
+	ok, _, _ = b.allow(later.Add(time.Minute))
+	if !ok {
+		t.Fatal("expected second probe")
+	}
+	if state, changed := b.record(later.Add(time.Minute), false); state != BreakerClosed || !changed {
+		t.Errorf("expected successful probe to close, got %s", state)
+	}
+}
+
+func TestBreaker_ReleaseFreesProbe(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(BreakerConfig{Threshold: 1, Cooldown: time.Minute})
+	b.record(now, true)
+
+	later := now.Add(time.Minute)
+	b.allow(later)
+	b.release()
+	if ok, _, _ := b.allow(later); !ok {
+		t.Error("expected a new probe after the first was released")
+	}
+}
+
+func TestBreaker_DisabledByDefault(t *testing.T) {
+	b := newBreaker(BreakerConfig{})
+	for i := 0; i < 100; i++ {
+		b.record(time.Now(), true)
+	}
+	if ok, _, _ := b.allow(time.Now()); !ok {
+		t.Error("expected zero-threshold breaker never to open")
+	}
+}
+
+func TestDispatcher_CircuitOpensAndFailsFast(t *testing.T) {
+	c := &scriptedConsumer{errs: []error{
+		Retryable(errors.New("down")),
+		Retryable(errors.New("down")),
+	}}
+	d := NewDispatcher(c)
+	d.SetBreaker("scripted", BreakerConfig{Threshold: 2, Cooldown: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		d.Dispatch(context.Background(), "ride.fit")
+	}
+
+	results := d.Dispatch(context.Background(), "ride.fit")
+	if results[0].Success || !errors.Is(results[0].Error, ErrCircuitOpen) {
+		t.Fatalf("expected circuit open error, got %+v", results[0])
+	}
+	if !IsRetryable(results[0].Error) {
+		t.Error("circuit open should be retryable")
+	}
+	if c.calls != 2 {
+		t.Errorf("expected push not attempted while open, got %d calls", c.calls)
+	}
+
+	stats := d.Stats()
+	if len(stats) != 1 || stats[0].Breaker != BreakerOpen || stats[0].ConsecutiveFailures != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats[0].Pushes != 2 || stats[0].Failures != 2 {
+		t.Errorf("expected 2 pushes and 2 failures, got %+v", stats[0])
+	}
+}
+
+func TestDispatcher_PermanentErrorsDontOpenCircuit(t *testing.T) {
+	c := &scriptedConsumer{errs: []error{
+		Permanent(errors.New("not a FIT file")),
+		Permanent(errors.New("not a FIT file")),
+		Permanent(errors.New("not a FIT file")),
+	}}
+	d := NewDispatcher(c)
+	d.SetBreaker("scripted", BreakerConfig{Threshold: 2, Cooldown: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		d.Dispatch(context.Background(), "ride.fit")
+	}
+	if c.calls != 3 {
+		t.Errorf("expected every push attempted, got %d", c.calls)
+	}
+	if d.Stats()[0].Breaker != BreakerClosed {
+		t.Errorf("expected circuit to stay closed, got %s", d.Stats()[0].Breaker)
+	}
+}
+
+func TestDispatcher_PoolHoldsFilesWhileOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &scriptedConsumer{errs: []error{Retryable(errors.New("down"))}}
+	d := NewDispatcher(c)
+	d.SetBreaker("scripted", BreakerConfig{Threshold: 1, Cooldown: 100 * time.Millisecond})
+	d.Start(ctx)
+
+	if r := d.DispatchTo(ctx, "a.fit", []string{"scripted"}); r[0].Success {
+		t.Fatal("expected first push to fail")
+	}
+
+	// The next file waits for the cooldown, then goes through as the probe.
+	start := time.Now()
+	r := d.DispatchTo(ctx, "b.fit", []string{"scripted"})
+	if !r[0].Success {
+		t.Fatalf("expected probe to succeed, got %+v", r[0])
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected push to be held for the cooldown, took %v", elapsed)
+	}
+	if d.Stats()[0].Breaker != BreakerClosed {
+		t.Errorf("expected circuit closed after successful probe, got %s", d.Stats()[0].Breaker)
+	}
+}
@@ -4,9 +4,15 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/johnazariah/fitwatch/internal/transform"
 )
 
 // Consumer processes FIT files and sends them to a destination.
@@ -15,19 +21,66 @@ type Consumer interface {
 	Name() string
 
 	// Push sends a FIT file to the destination.
-	// Returns nil on success, or an error if the push failed.
-	Push(ctx context.Context, fitPath string) error
+	// Returns a receipt on success, or an error if the push failed.
+	// A consumer reporting a DuplicateError may also return a receipt
+	// identifying the existing remote activity.
+	Push(ctx context.Context, fitPath string) (*Receipt, error)
 
 	// Validate checks if the consumer is properly configured.
 	Validate() error
 }
 
+// Receipt describes where a pushed file ended up at the destination.
+// Fields the destination doesn't report are left empty.
+type Receipt struct {
+	RemoteID  string
+	RemoteURL string
+
+	// Duplicate is set when the destination said it already had the activity.
+	Duplicate bool
+}
+
+type previousKey struct{}
+
+// WithPreviousRemoteIDs returns a context carrying the remote IDs that
+// consumers, by name, returned for an earlier version of the file being
+// pushed, i.e. one whose content has changed since it was sent.
+func WithPreviousRemoteIDs(ctx context.Context, ids map[string]string) context.Context {
+	return context.WithValue(ctx, previousKey{}, ids)
+}
+
+// PreviousRemoteID returns the remote ID the named consumer returned for an
+// earlier version of the file being pushed, or "" if there is none. A
+// consumer that looks for the activity at the destination can use it to
+// tell its own earlier upload from a copy that got there another way.
+func PreviousRemoteID(ctx context.Context, name string) string {
+	ids, _ := ctx.Value(previousKey{}).(map[string]string)
+	return ids[name]
+}
+
 // Result represents the outcome of pushing a FIT file.
 type Result struct {
 	Consumer string
 	FitPath  string
 	Success  bool
 	Error    error
+
+	// RemoteID and RemoteURL identify the activity at the destination, if known.
+	RemoteID  string
+	RemoteURL string
+
+	// Duplicate is set when the destination reported that it already had
+	// the activity. Such pushes count as successful.
+	Duplicate bool
+
+	// Skipped is set when the consumer's filter didn't match the file, so
+	// nothing was pushed; SkipReason says why.
+	Skipped    bool
+	SkipReason string
+
+	// Transforms names the chain applied to the file before it was pushed,
+	// if any; see SetTransforms.
+	Transforms string
 }
 
 // Dispatcher sends FIT files to multiple consumers.
@@ -35,18 +88,40 @@ type Dispatcher struct {
 	consumers  []Consumer
 	maxRetries int
 	logger     *slog.Logger
+
+	// Worker pool state, set up by Start.
+	mu      sync.Mutex
+	limits  map[string]Limits
+	lanes   map[string]*lane
+	poolCtx context.Context
+	workers sync.WaitGroup
+
+	// Per-consumer rate limiters and circuit breakers.
+	rateLimits map[string]RateLimit
+	breakers   map[string]BreakerConfig
+	guards     map[string]*guard
+
+	// Per-consumer routing filters.
+	filters map[string]Filter
+
+	// Per-consumer transform chains, and where their output is kept.
+	transforms     map[string]transform.Chain
+	transformCache *transform.Cache
 }
 
 // NewDispatcher creates a dispatcher with the given consumers.
+// By default each push is attempted once; long-term retries are scheduled
+// persistently by the caller rather than slept through in-process.
 func NewDispatcher(consumers ...Consumer) *Dispatcher {
 	return &Dispatcher{
 		consumers:  consumers,
-		maxRetries: 3,
+		maxRetries: 0,
 		logger:     slog.Default(),
 	}
 }
 
-// SetMaxRetries configures the number of retry attempts for failed pushes.
+// SetMaxRetries configures the number of immediate in-process retry attempts
+// for failed pushes.
 func (d *Dispatcher) SetMaxRetries(n int) {
 	d.maxRetries = n
 }
@@ -57,60 +132,136 @@ func (d *Dispatcher) SetLogger(logger *slog.Logger) {
 }
 
 // AddConsumer adds a consumer to the dispatcher.
+// Consumers must be added before Start.
 func (d *Dispatcher) AddConsumer(c Consumer) {
 	d.consumers = append(d.consumers, c)
 }
 
+// ConsumerNames returns the names of all registered consumers.
+func (d *Dispatcher) ConsumerNames() []string {
+	names := make([]string, 0, len(d.consumers))
+	for _, c := range d.consumers {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
 // Dispatch sends a FIT file to all registered consumers.
-// Returns results for each consumer (success or failure).
-// Automatically retries failed pushes with exponential backoff.
+// Returns results for each consumer (success, failure or skipped by its
+// filter).
+// Failed pushes are retried in-process with exponential backoff if
+// SetMaxRetries has been configured.
 func (d *Dispatcher) Dispatch(ctx context.Context, fitPath string) []Result {
-	results := make([]Result, 0, len(d.consumers))
+	return d.DispatchTo(ctx, fitPath, d.ConsumerNames())
+}
 
-	for _, c := range d.consumers {
-		err := d.pushWithRetry(ctx, c, fitPath)
-		results = append(results, Result{
-			Consumer: c.Name(),
-			FitPath:  fitPath,
-			Success:  err == nil,
-			Error:    err,
-		})
+// DispatchTo sends a FIT file to the named consumers only, and waits for
+// all of them. Results are returned in consumer registration order.
+// Names that don't match a registered consumer are ignored.
+func (d *Dispatcher) DispatchTo(ctx context.Context, fitPath string, names []string) []Result {
+	order := make(map[string]int, len(d.consumers))
+	for i, c := range d.consumers {
+		order[c.Name()] = i
 	}
 
+	results := make([]Result, 0, len(names))
+	for r := range d.DispatchAsync(ctx, fitPath, names) {
+		results = append(results, r)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return order[results[i].Consumer] < order[results[j].Consumer]
+	})
 	return results
 }
 
+// push sends a FIT file, transformed if the consumer has transforms, to
+// one consumer and describes the outcome.
+// If block is set, it waits out an open circuit breaker; otherwise the
+// push fails with ErrCircuitOpen.
+func (d *Dispatcher) push(ctx context.Context, c Consumer, fitPath string, block bool) Result {
+	src, chain, err := d.transformFor(ctx, c.Name(), fitPath)
+	if err != nil {
+		return Result{Consumer: c.Name(), FitPath: fitPath, Error: err, Transforms: chain}
+	}
+
+	receipt, err := d.pushWithRetry(ctx, c, src, block)
+	r := Result{
+		Consumer:   c.Name(),
+		FitPath:    fitPath,
+		Success:    err == nil,
+		Error:      err,
+		Transforms: chain,
+	}
+	if receipt != nil {
+		r.RemoteID = receipt.RemoteID
+		r.RemoteURL = receipt.RemoteURL
+		r.Duplicate = receipt.Duplicate
+	}
+	if IsDuplicate(err) {
+		r.Success = true
+		r.Duplicate = true
+		r.Error = nil
+	}
+	if r.Duplicate {
+		d.logger.Info("destination already has activity", "consumer", c.Name(), "path", fitPath, "remote_id", r.RemoteID)
+	}
+	return r
+}
+
 // pushWithRetry attempts to push with exponential backoff.
-func (d *Dispatcher) pushWithRetry(ctx context.Context, c Consumer, fitPath string) error {
+// Permanent and duplicate errors are returned immediately; rate-limited
+// errors wait for the destination's Retry-After instead of the backoff.
+// Every attempt first waits for the consumer's rate limiter and circuit
+// breaker.
+func (d *Dispatcher) pushWithRetry(ctx context.Context, c Consumer, fitPath string, block bool) (*Receipt, error) {
 	var lastErr error
 	backoff := 1 * time.Second
+	g := d.guardFor(c.Name())
 
 	for attempt := 0; attempt <= d.maxRetries; attempt++ {
 		if attempt > 0 {
-			d.logger.Info("retrying upload", "consumer", c.Name(), "attempt", attempt, "backoff", backoff)
+			wait := backoff
+			if after, ok := RetryAfter(lastErr); ok {
+				wait = after
+			}
+			d.logger.Info("retrying upload", "consumer", c.Name(), "attempt", attempt, "backoff", wait)
 
 			select {
 			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(backoff):
+				return nil, ctx.Err()
+			case <-time.After(wait):
 			}
 
 			// Exponential backoff: 1s, 2s, 4s, 8s...
 			backoff = min(backoff*2, 30*time.Second)
 		}
 
-		lastErr = c.Push(ctx, fitPath)
+		if err := d.admit(ctx, c, g, block); err != nil {
+			return nil, err
+		}
+
+		receipt, err := c.Push(ctx, fitPath)
+		d.observe(ctx, c, g, err)
+		lastErr = err
 		if lastErr == nil {
 			if attempt > 0 {
 				d.logger.Info("retry succeeded", "consumer", c.Name(), "attempts", attempt+1)
 			}
-			return nil
+			return receipt, nil
+		}
+
+		if !IsRetryable(lastErr) {
+			return receipt, lastErr
 		}
 
 		d.logger.Warn("push failed", "consumer", c.Name(), "attempt", attempt+1, "error", lastErr)
 	}
 
-	return fmt.Errorf("failed after %d attempts: %w", d.maxRetries+1, lastErr)
+	if d.maxRetries == 0 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("failed after %d attempts: %w", d.maxRetries+1, lastErr)
 }
 
 // ValidateAll checks all consumers are properly configured.
@@ -122,3 +273,17 @@ func (d *Dispatcher) ValidateAll() error {
 	}
 	return nil
 }
+
+// Close closes every consumer that holds resources, i.e. implements
+// io.Closer. Call it once dispatching has finished.
+func (d *Dispatcher) Close() error {
+	var errs []error
+	for _, c := range d.consumers {
+		if closer, ok := c.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", c.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,46 @@
+// Package consumertest provides helpers for testing consumer factories.
+package consumertest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+// Settings decodes from a TOML document, as a [[consumers]] entry would.
+type Settings string
+
+// Decode unmarshals the document into v.
+func (s Settings) Decode(v any) error { return toml.Unmarshal([]byte(s), v) }
+
+// Build builds a consumer of type typ named name from a TOML document. It
+// fails the test unless the factory succeeds, returns a T, and gives it the
+// spec's name.
+func Build[T consumer.Consumer](t testing.TB, typ, name, settings string) T {
+	t.Helper()
+	c, err := consumer.Build(typ, consumer.Spec{Name: name, Settings: Settings(settings)})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	built, ok := c.(T)
+	if !ok {
+		t.Fatalf("Build returned a %T", c)
+	}
+	if built.Name() != name {
+		t.Errorf("expected name %s, got %s", name, built.Name())
+	}
+	return built
+}
+
+// BuildError fails the test unless building a consumer of type typ from a
+// TOML document fails with an error containing want.
+func BuildError(t testing.TB, typ, settings, want string) {
+	t.Helper()
+	_, err := consumer.Build(typ, consumer.Spec{Name: typ, Settings: Settings(settings)})
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+}
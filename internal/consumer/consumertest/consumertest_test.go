@@ -0,0 +1,61 @@
+package consumertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+// fake is a consumer built from a dir setting.
+type fake struct {
+	name string
+	Dir  string
+}
+
+func (f *fake) Name() string    { return f.name }
+func (f *fake) Validate() error { return nil }
+func (f *fake) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	return &consumer.Receipt{}, nil
+}
+
+func init() {
+	consumer.Register("consumertest-fake", func(spec consumer.Spec) (consumer.Consumer, error) {
+		f := &fake{name: spec.Name}
+		if err := spec.Settings.Decode(f); err != nil {
+			return nil, err
+		}
+		if f.Dir == "" {
+			return nil, errors.New("dir is required")
+		}
+		return f, nil
+	})
+}
+
+func TestSettings_Decode(t *testing.T) {
+	var v struct {
+		Dir  string   `toml:"dir"`
+		Tags []string `toml:"tags"`
+	}
+	if err := Settings("dir = \"/library\"\ntags = [\"a\", \"b\"]").Decode(&v); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if v.Dir != "/library" || len(v.Tags) != 2 {
+		t.Errorf("unexpected settings: %+v", v)
+	}
+	if err := Settings("dir = ").Decode(&v); err == nil {
+		t.Error("expected error for invalid TOML")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	f := Build[*fake](t, "consumertest-fake", "nas", `dir = "/library"`)
+	if f.Name() != "nas" || f.Dir != "/library" {
+		t.Errorf("unexpected consumer: %+v", f)
+	}
+}
+
+func TestBuildError(t *testing.T) {
+	BuildError(t, "consumertest-fake", ``, "dir is required")
+}
@@ -0,0 +1,158 @@
+// Package delivery holds what the consumers that deliver FIT files to a
+// destination have in common: the data and functions available to their
+// templates, the FIT media type, how names that are taken are numbered, and
+// how error responses are described.
+package delivery
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+const (
+	// ContentType is the registered media type for FIT files.
+	ContentType = "application/vnd.ant.fit"
+
+	// MaxNameCollisions is how many numbered alternatives are tried when a
+	// different file already has a file's templated name.
+	MaxNameCollisions = 100
+)
+
+// Numbered returns name with "_n" before its extension, or name itself for
+// n = 1: the nth choice of name when a different file already has it.
+func Numbered(name string, n int) string {
+	if n == 1 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// TemplateData is the data available to templates over a FIT file, such as
+// email subjects, Intervals.icu activity names and archive paths.
+// All fitparser.Metadata fields are available directly, e.g. {{.ActivityType}}.
+type TemplateData struct {
+	fitparser.Metadata
+
+	// Filename is the base name of the FIT file.
+	Filename string
+
+	// Dir is the directory containing the FIT file. It is left out of
+	// JSON, so a local path isn't sent anywhere unless a template asks.
+	Dir string `json:"-"`
+
+	// Title is the name derived from the filename, e.g. "Hudayriyat Ascend",
+	// or "" if the filename has none.
+	Title string
+}
+
+// NewTemplateData returns the template data for a FIT file. meta may be nil
+// if the file couldn't be parsed; templates still see the filename.
+func NewTemplateData(fitPath string, meta *fitparser.Metadata) *TemplateData {
+	data := &TemplateData{
+		Filename: filepath.Base(fitPath),
+		Dir:      filepath.Dir(fitPath),
+		Title:    fitparser.TitleFromFilename(filepath.Base(fitPath)),
+	}
+	if meta != nil {
+		data.Metadata = *meta
+	}
+	return data
+}
+
+// Start returns the activity's start time in local time, or the zero time
+// if it isn't known.
+func (d *TemplateData) Start() time.Time {
+	if d.StartTime == nil {
+		return time.Time{}
+	}
+	return d.StartTime.Local()
+}
+
+// Funcs returns the template functions for a file whose activity started at
+// start, in the time zone to show it in. start may be zero if it isn't
+// known. Consumers add their own, such as path components, on top.
+//
+//	km        meters as kilometers, e.g. {{km .DistanceMeters}} -> "42.2"
+//	duration  seconds, e.g. {{duration .DurationSecs}} -> "1h2m3s"
+//	date      {{date}} is the start as "2025-02-23", {{date "15:04"}} the
+//	          start in another layout, and {{date "2006-01-02" .EndTime}}
+//	          another time, in local time
+//	lower     lower-cases a string
+func Funcs(start time.Time) template.FuncMap {
+	return template.FuncMap{
+		"km": func(m float64) string {
+			return fmt.Sprintf("%.1f", m/1000)
+		},
+		"duration": func(secs int) string {
+			return (time.Duration(secs) * time.Second).String()
+		},
+		"date": func(args ...any) (string, error) {
+			return formatDate(start, args)
+		},
+		"lower": strings.ToLower,
+	}
+}
+
+// formatDate implements the date template function. A time that isn't
+// known formats as "".
+func formatDate(start time.Time, args []any) (string, error) {
+	if len(args) > 2 {
+		return "", fmt.Errorf("date takes a layout and a time, got %d arguments", len(args))
+	}
+
+	layout := "2006-01-02"
+	if len(args) > 0 {
+		s, ok := args[0].(string)
+		if !ok {
+			return "", fmt.Errorf("date layout must be a string, got %T", args[0])
+		}
+		layout = s
+	}
+
+	t := start
+	if len(args) > 1 {
+		switch v := args[1].(type) {
+		case *time.Time:
+			if v == nil {
+				return "", nil
+			}
+			t = v.Local()
+		case time.Time:
+			t = v.Local()
+		default:
+			return "", fmt.Errorf("date needs a time, got %T", args[1])
+		}
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+	return t.Format(layout), nil
+}
+
+// ResponseError is an error response from a destination.
+type ResponseError struct {
+	Status string // e.g. "409 Conflict"
+	Body   []byte // the start of the body, which may be empty
+}
+
+func (e *ResponseError) Error() string {
+	if msg := strings.TrimSpace(string(e.Body)); msg != "" {
+		return e.Status + ": " + msg
+	}
+	return e.Status
+}
+
+// ReadError describes an error response from its status and the start of
+// its body.
+func ReadError(resp *http.Response) *ResponseError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return &ResponseError{Status: resp.Status, Body: body}
+}
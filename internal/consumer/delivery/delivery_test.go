@@ -0,0 +1,116 @@
+package delivery
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+func TestNewTemplateData(t *testing.T) {
+	path := filepath.Join("rides", "2025-02-23_Hudayriyat_Ascend.fit")
+	data := NewTemplateData(path, &fitparser.Metadata{ActivityType: "Cycling"})
+	if data.Filename != "2025-02-23_Hudayriyat_Ascend.fit" || data.Dir != "rides" || data.Title != "Hudayriyat Ascend" || data.ActivityType != "Cycling" {
+		t.Errorf("unexpected data %+v", data)
+	}
+
+	data = NewTemplateData("2025-02-23.fit", nil)
+	if data.Title != "" || data.StartTime != nil || !data.Start().IsZero() {
+		t.Errorf("unexpected data %+v", data)
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	start := time.Date(2025, 2, 23, 6, 30, 0, 0, time.Local)
+	end := start.Add(90 * time.Minute)
+	data := NewTemplateData("ride.fit", &fitparser.Metadata{
+		ActivityType:   "Cycling",
+		StartTime:      &start,
+		EndTime:        &end,
+		DistanceMeters: 42195,
+		DurationSecs:   3723,
+	})
+
+	tests := []struct {
+		tmpl, want string
+	}{
+		{"{{km .DistanceMeters}}", "42.2"},
+		{"{{duration .DurationSecs}}", "1h2m3s"},
+		{"{{lower .ActivityType}}", "cycling"},
+		{"{{date}}", "2025-02-23"},
+		{`{{date "15:04"}}`, "06:30"},
+		{`{{date "15:04" .EndTime}}`, "08:00"},
+	}
+	for _, tt := range tests {
+		tmpl, err := template.New("").Funcs(Funcs(data.Start())).Parse(tt.tmpl)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.tmpl, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			t.Errorf("%s failed: %v", tt.tmpl, err)
+			continue
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestFuncs_DateUnknown(t *testing.T) {
+	data := NewTemplateData("ride.fit", nil)
+	for _, text := range []string{"{{date}}", `{{date "2006"}}`, `{{date "2006" .EndTime}}`} {
+		tmpl := template.Must(template.New("").Funcs(Funcs(data.Start())).Parse(text))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil || buf.Len() != 0 {
+			t.Errorf("%s = %q, %v; want empty", text, buf.String(), err)
+		}
+	}
+}
+
+func TestFuncs_DateBadArguments(t *testing.T) {
+	for _, text := range []string{"{{date 2006}}", `{{date "2006" "now"}}`, `{{date "2006" . .}}`} {
+		tmpl := template.Must(template.New("").Funcs(Funcs(time.Now())).Parse(text))
+		if err := tmpl.Execute(&bytes.Buffer{}, time.Now()); err == nil {
+			t.Errorf("%s should fail", text)
+		}
+	}
+}
+
+func TestNumbered(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"2025/ride.fit", 1, "2025/ride.fit"},
+		{"2025/ride.fit", 2, "2025/ride_2.fit"},
+		{"2025.02/ride", 3, "2025.02/ride_3"},
+	}
+	for _, tt := range tests {
+		if got := Numbered(tt.name, tt.n); got != tt.want {
+			t.Errorf("Numbered(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestReadError(t *testing.T) {
+	tests := []struct {
+		body, want string
+	}{
+		{"bucket is read-only\n", "403 Forbidden: bucket is read-only"},
+		{"", "403 Forbidden"},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Status: "403 Forbidden", Body: io.NopCloser(strings.NewReader(tt.body))}
+		if got := ReadError(resp).Error(); got != tt.want {
+			t.Errorf("ReadError = %q, want %q", got, tt.want)
+		}
+	}
+}
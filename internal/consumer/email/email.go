@@ -0,0 +1,348 @@
+// Package email provides a consumer that mails FIT files as attachments,
+// for platforms and coaches that accept activities by email.
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+const defaultName = "email"
+
+// Security is how the connection to the SMTP server is protected.
+type Security string
+
+const (
+	// SecurityStartTLS upgrades a plain connection with STARTTLS, and fails
+	// if the server doesn't offer it. The usual choice, on port 587.
+	SecurityStartTLS Security = "starttls"
+
+	// SecurityTLS connects over TLS from the start, usually on port 465.
+	SecurityTLS Security = "tls"
+
+	// SecurityNone sends everything in the clear. Only for relays on the
+	// local machine or network.
+	SecurityNone Security = "none"
+)
+
+// DefaultTimeout bounds a whole send when the context has no deadline.
+const DefaultTimeout = 2 * time.Minute
+
+// Consumer mails each FIT file, optionally zipped, to a list of recipients.
+// The subject and body are templates over the file's metadata.
+type Consumer struct {
+	Host     string
+	Port     int
+	Security Security
+
+	// Username and Password authenticate with the server (PLAIN) if set.
+	Username string
+	Password string
+
+	From string
+	To   []string
+
+	// Zip attaches the file as a .zip archive, for services that reject
+	// .fit attachments.
+	Zip bool
+
+	name    string
+	subject *template.Template
+	body    *template.Template
+	logger  *slog.Logger
+
+	// tlsConfig, if set, is used instead of the defaults for the server.
+	tlsConfig *tls.Config
+}
+
+// New creates an email consumer that sends from one address to others
+// through host, on port 587 with STARTTLS.
+func New(host, from string, to ...string) *Consumer {
+	subject, err := parseTemplate("subject", DefaultSubject)
+	if err != nil {
+		panic(err)
+	}
+	body, err := parseTemplate("body", DefaultBody)
+	if err != nil {
+		panic(err)
+	}
+	return &Consumer{
+		Host:     host,
+		Port:     587,
+		Security: SecurityStartTLS,
+		From:     from,
+		To:       to,
+		name:     defaultName,
+		subject:  subject,
+		body:     body,
+		logger:   slog.Default(),
+	}
+}
+
+// SetLogger configures the logger for the consumer.
+func (c *Consumer) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetSubjectTemplate sets the subject template; see delivery.TemplateData.
+func (c *Consumer) SetSubjectTemplate(text string) error {
+	tmpl, err := parseTemplate("subject", text)
+	if err != nil {
+		return err
+	}
+	c.subject = tmpl
+	return nil
+}
+
+// SetBodyTemplate sets the plain text body template; see delivery.TemplateData.
+func (c *Consumer) SetBodyTemplate(text string) error {
+	tmpl, err := parseTemplate("body", text)
+	if err != nil {
+		return err
+	}
+	c.body = tmpl
+	return nil
+}
+
+// Name returns the consumer's instance name.
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Validate checks configuration.
+func (c *Consumer) Validate() error {
+	if c.Host == "" {
+		return errors.New("SMTP host is required")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+	switch c.Security {
+	case SecurityStartTLS, SecurityTLS, SecurityNone:
+	default:
+		return fmt.Errorf("unknown security %q (want starttls, tls or none)", c.Security)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("invalid from address %q: %w", c.From, err)
+	}
+	if len(c.To) == 0 {
+		return errors.New("at least one recipient is required")
+	}
+	for _, to := range c.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+	}
+	if c.Password != "" && c.Username == "" {
+		return errors.New("username is required with a password")
+	}
+	return nil
+}
+
+// Push mails a FIT file. The receipt's RemoteID is the message's
+// Message-ID.
+func (c *Consumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	if err := c.Validate(); err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	content, err := os.ReadFile(fitPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, consumer.Permanent(fmt.Errorf("read file: %w", err))
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	msg, err := c.newMessage(fitPath, content)
+	if err != nil {
+		return nil, consumer.Permanent(err)
+	}
+	raw, err := msg.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("build message: %w", err)
+	}
+
+	if err := c.send(ctx, msg, raw); err != nil {
+		return nil, err
+	}
+
+	c.logger.Info("mailed", "file", msg.attachment.filename, "to", strings.Join(c.To, ", "))
+	return &consumer.Receipt{RemoteID: msg.id}, nil
+}
+
+// newMessage builds the message for a FIT file.
+func (c *Consumer) newMessage(fitPath string, content []byte) (*message, error) {
+	from, _ := mail.ParseAddress(c.From)
+	msg := &message{from: *from, date: time.Now()}
+	for _, addr := range c.To {
+		to, _ := mail.ParseAddress(addr)
+		msg.to = append(msg.to, *to)
+	}
+
+	data := newTemplateData(fitPath)
+	var err error
+	if msg.subject, err = execTemplate(c.subject, data); err != nil {
+		return nil, err
+	}
+	// Headers can't span lines.
+	msg.subject = strings.Join(strings.Fields(msg.subject), " ")
+	if msg.body, err = execTemplate(c.body, data); err != nil {
+		return nil, err
+	}
+	if msg.attachment, err = newAttachment(data.Filename, content, c.Zip); err != nil {
+		return nil, err
+	}
+
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	if msg.id, err = newMessageID(domain); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// send delivers a message through the server.
+func (c *Consumer) send(ctx context.Context, msg *message, raw []byte) error {
+	// Cancelling ctx closes the connection, which ends any exchange in
+	// progress.
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); ok {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+	}
+	defer cancel()
+
+	client, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	if c.Security == SecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return consumer.Permanent(errors.New("server doesn't offer STARTTLS"))
+		}
+		if err := client.StartTLS(c.tlsConfigFor()); err != nil {
+			return classify(ctx, fmt.Errorf("STARTTLS: %w", err))
+		}
+	}
+
+	if c.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return consumer.Permanent(errors.New("server doesn't offer authentication"))
+		}
+		if err := client.Auth(c.auth()); err != nil {
+			return classify(ctx, fmt.Errorf("authenticate: %w", err))
+		}
+	}
+
+	if err := client.Mail(msg.from.Address); err != nil {
+		return classify(ctx, fmt.Errorf("MAIL FROM: %w", err))
+	}
+	for _, to := range msg.to {
+		if err := client.Rcpt(to.Address); err != nil {
+			return classify(ctx, fmt.Errorf("RCPT TO %s: %w", to.Address, err))
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return classify(ctx, fmt.Errorf("DATA: %w", err))
+	}
+	if _, err := w.Write(raw); err != nil {
+		return classify(ctx, fmt.Errorf("write message: %w", err))
+	}
+	if err := w.Close(); err != nil {
+		return classify(ctx, fmt.Errorf("send message: %w", err))
+	}
+	// The message has been accepted; a failed QUIT doesn't change that.
+	_ = client.Quit()
+	return nil
+}
+
+// dial connects to the server, over TLS for SecurityTLS. The connection
+// is closed when ctx ends.
+func (c *Consumer) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, classify(ctx, fmt.Errorf("connect to %s: %w", addr, err))
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	context.AfterFunc(ctx, func() { _ = conn.Close() })
+
+	if c.Security == SecurityTLS {
+		conn = tls.Client(conn, c.tlsConfigFor())
+	}
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, classify(ctx, fmt.Errorf("connect to %s: %w", addr, err))
+	}
+	return client, nil
+}
+
+func (c *Consumer) tlsConfigFor() *tls.Config {
+	if c.tlsConfig != nil {
+		return c.tlsConfig
+	}
+	return &tls.Config{ServerName: c.Host}
+}
+
+// auth returns PLAIN authentication. net/smtp only allows it over TLS, or
+// to localhost; with SecurityNone the user has chosen to send in the clear.
+func (c *Consumer) auth() smtp.Auth {
+	plain := smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	if c.Security == SecurityNone {
+		return insecureAuth{plain}
+	}
+	return plain
+}
+
+// insecureAuth lets PLAIN authentication go over an unencrypted connection.
+type insecureAuth struct {
+	smtp.Auth
+}
+
+func (a insecureAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	s := *server
+	s.TLS = true
+	return a.Auth.Start(&s)
+}
+
+// classify wraps an SMTP error: 4xx replies are transient, 5xx replies are
+// permanent, and connection problems are retried.
+func classify(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w (%w)", err, ctx.Err())
+	}
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		if tpErr.Code >= 500 {
+			return consumer.Permanent(err)
+		}
+		return consumer.Retryable(err)
+	}
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return consumer.Permanent(err)
+	}
+	return consumer.Retryable(err)
+}
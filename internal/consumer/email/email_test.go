@@ -0,0 +1,390 @@
+package email
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+)
+
+// fakeSMTP is an SMTP server that accepts PLAIN authentication for
+// alice/secret and records the messages it's given.
+type fakeSMTP struct {
+	addr *net.TCPAddr
+	tls  *tls.Config
+	pool *x509.CertPool
+
+	implicitTLS bool              // TLS from the start, as on port 465
+	noStartTLS  bool              // don't offer STARTTLS
+	replies     map[string]string // replies to override, by command
+
+	mu       sync.Mutex
+	messages []receivedMessage
+}
+
+// receivedMessage is a message as the server received it.
+type receivedMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+func newFakeSMTP(t *testing.T, configure func(*fakeSMTP)) *fakeSMTP {
+	t.Helper()
+
+	// Borrow httptest's certificate, which is valid for 127.0.0.1.
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	f := &fakeSMTP{
+		tls:     &tls.Config{Certificates: ts.TLS.Certificates},
+		pool:    pool,
+		replies: make(map[string]string),
+	}
+	ts.Close()
+	if configure != nil {
+		configure(f)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	f.addr = ln.Addr().(*net.TCPAddr)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	secure := f.implicitTLS
+	if secure {
+		conn = tls.Server(conn, f.tls)
+	}
+	tp := textproto.NewConn(conn)
+
+	var msg receivedMessage
+	_ = tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		if reply, ok := f.replies[verb]; ok {
+			_ = tp.PrintfLine("%s", reply)
+			continue
+		}
+
+		switch verb {
+		case "EHLO":
+			_ = tp.PrintfLine("250-fake")
+			if !secure && !f.noStartTLS {
+				_ = tp.PrintfLine("250-STARTTLS")
+			}
+			_ = tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 ready")
+			conn = tls.Server(conn, f.tls)
+			tp = textproto.NewConn(conn)
+			secure = true
+		case "AUTH":
+			resp, _ := strings.CutPrefix(arg, "PLAIN ")
+			creds, _ := base64.StdEncoding.DecodeString(resp)
+			if string(creds) == "\x00alice\x00secret" {
+				_ = tp.PrintfLine("235 authenticated")
+			} else {
+				_ = tp.PrintfLine("535 bad credentials")
+			}
+		case "MAIL":
+			msg = receivedMessage{from: arg}
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			msg.to = append(msg.to, arg)
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = data
+			f.mu.Lock()
+			f.messages = append(f.messages, msg)
+			f.mu.Unlock()
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (f *fakeSMTP) received() []receivedMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]receivedMessage(nil), f.messages...)
+}
+
+func newTestConsumer(f *fakeSMTP) *Consumer {
+	c := New("127.0.0.1", "Fitwatch <alice@example.com>", "coach@example.com")
+	c.Port = f.addr.Port
+	c.Username = "alice"
+	c.Password = "secret"
+	c.tlsConfig = &tls.Config{RootCAs: f.pool, ServerName: "127.0.0.1"}
+	if f.implicitTLS {
+		c.Security = SecurityTLS
+	}
+	return c
+}
+
+func writeFit(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// parseMessage returns the message's headers, text body and attachment.
+func parseMessage(t *testing.T, data []byte) (mail.Header, string, *multipart.Part, []byte) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("unexpected content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+
+	text, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("missing text part: %v", err)
+	}
+	body, _ := io.ReadAll(text) // multipart decodes quoted-printable
+
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("missing attachment: %v", err)
+	}
+	encoded, _ := io.ReadAll(part)
+	content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil {
+		t.Fatalf("invalid attachment: %v", err)
+	}
+	return msg.Header, string(body), part, content
+}
+
+func TestConsumer_Validate(t *testing.T) {
+	valid := func() *Consumer { return New("smtp.example.com", "me@example.com", "coach@example.com") }
+	tests := []struct {
+		name    string
+		modify  func(*Consumer)
+		wantErr bool
+	}{
+		{"valid", func(*Consumer) {}, false},
+		{"named from", func(c *Consumer) { c.From = "Me <me@example.com>" }, false},
+		{"missing host", func(c *Consumer) { c.Host = "" }, true},
+		{"bad port", func(c *Consumer) { c.Port = 0 }, true},
+		{"unknown security", func(c *Consumer) { c.Security = "ssl" }, true},
+		{"bad from", func(c *Consumer) { c.From = "me" }, true},
+		{"no recipients", func(c *Consumer) { c.To = nil }, true},
+		{"bad recipient", func(c *Consumer) { c.To = []string{"coach"} }, true},
+		{"password without user", func(c *Consumer) { c.Password = "pw" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_StartTLS(t *testing.T) {
+	f := newFakeSMTP(t, nil)
+	c := newTestConsumer(f)
+
+	receipt, err := c.Push(context.Background(), writeFit(t, "2024-03-09_Evening Ride.fit", "fit data"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	msgs := f.received()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	if msgs[0].from != "FROM:<alice@example.com>" || strings.Join(msgs[0].to, ",") != "TO:<coach@example.com>" {
+		t.Errorf("unexpected envelope: %+v", msgs[0])
+	}
+
+	header, body, part, content := parseMessage(t, msgs[0].data)
+	if got := header.Get("Subject"); got != "Activity: Evening Ride" {
+		t.Errorf("unexpected subject: %q", got)
+	}
+	if got := header.Get("Message-ID"); got != receipt.RemoteID || !strings.HasSuffix(got, "@example.com>") {
+		t.Errorf("Message-ID %q doesn't match receipt %+v", got, receipt)
+	}
+	if body != "Evening Ride\n\nThe FIT file is attached. Sent by fitwatch." {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if part.FileName() != "2024-03-09_Evening Ride.fit" || !strings.HasPrefix(part.Header.Get("Content-Type"), delivery.ContentType) {
+		t.Errorf("unexpected attachment: %v", part.Header)
+	}
+	if string(content) != "fit data" {
+		t.Errorf("unexpected attachment content: %q", content)
+	}
+}
+
+func TestConsumer_Push_ImplicitTLS(t *testing.T) {
+	f := newFakeSMTP(t, func(f *fakeSMTP) { f.implicitTLS = true })
+
+	if _, err := newTestConsumer(f).Push(context.Background(), writeFit(t, "ride.fit", "fit data")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if len(f.received()) != 1 {
+		t.Fatal("message not received")
+	}
+}
+
+func TestConsumer_Push_ZipAndTemplates(t *testing.T) {
+	f := newFakeSMTP(t, nil)
+	c := newTestConsumer(f)
+	c.Zip = true
+	if err := c.SetSubjectTemplate("Upload {{.Filename}}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetBodyTemplate("Hi coach, here's {{.Title}}."); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Push(context.Background(), writeFit(t, "ride.fit", "fit data")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	header, body, part, content := parseMessage(t, f.received()[0].data)
+	if got := header.Get("Subject"); got != "Upload ride.fit" {
+		t.Errorf("unexpected subject: %q", got)
+	}
+	if body != "Hi coach, here's ride." {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if part.FileName() != "ride.zip" {
+		t.Errorf("unexpected attachment name: %q", part.FileName())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "ride.fit" {
+		t.Fatalf("unexpected zip contents: %v", zr.File)
+	}
+	rc, _ := zr.File[0].Open()
+	unzipped, _ := io.ReadAll(rc)
+	if string(unzipped) != "fit data" {
+		t.Errorf("unexpected zipped content: %q", unzipped)
+	}
+}
+
+func TestConsumer_Push_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*fakeSMTP)
+		modify    func(*Consumer)
+		permanent bool
+	}{
+		{"no STARTTLS", func(f *fakeSMTP) { f.noStartTLS = true }, nil, true},
+		{"untrusted certificate", nil, func(c *Consumer) { c.tlsConfig = nil }, true},
+		{"bad credentials", nil, func(c *Consumer) { c.Password = "wrong" }, true},
+		{"recipient rejected", func(f *fakeSMTP) { f.replies["RCPT"] = "550 no such user" }, nil, true},
+		{"mailbox busy", func(f *fakeSMTP) { f.replies["MAIL"] = "451 try again later" }, nil, false},
+		{"connection refused", nil, func(c *Consumer) { c.Port = 1 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeSMTP(t, tt.configure)
+			c := newTestConsumer(f)
+			if tt.modify != nil {
+				tt.modify(c)
+			}
+
+			_, err := c.Push(context.Background(), writeFit(t, "ride.fit", "fit data"))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if consumer.IsPermanent(err) != tt.permanent {
+				t.Errorf("IsPermanent = %v, want %v: %v", consumer.IsPermanent(err), tt.permanent, err)
+			}
+			if len(f.received()) != 0 {
+				t.Error("message should not have been sent")
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_MissingFile(t *testing.T) {
+	f := newFakeSMTP(t, nil)
+	_, err := newTestConsumer(f).Push(context.Background(), filepath.Join(t.TempDir(), "missing.fit"))
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
+
+func TestDefaultBody_WithMetadata(t *testing.T) {
+	body, err := parseTemplate("body", DefaultBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 9, 18, 30, 0, 0, time.Local)
+	data := &delivery.TemplateData{Title: "Evening Ride"}
+	data.ActivityType = "CYCLING"
+	data.StartTime = &start
+	data.DistanceMeters = 42195
+	data.DurationSecs = 3723
+
+	got, err := execTemplate(body, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Evening Ride (cycling)\n\nStart:    2024-03-09 18:30\nDistance: 42.2 km\nDuration: 1h2m3s\n\nThe FIT file is attached. Sent by fitwatch."
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package email
+
+import (
+	"strings"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+func init() {
+	consumer.Register("email", newFromSpec)
+}
+
+// settings are the config keys of an email consumer.
+type settings struct {
+	Host     string   `toml:"host"`
+	Port     int      `toml:"port"`
+	Security string   `toml:"security"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+	Subject  string   `toml:"subject"`
+	Body     string   `toml:"body"`
+	Zip      bool     `toml:"zip"`
+}
+
+// newFromSpec builds a consumer from its [[consumers]] entry.
+func newFromSpec(spec consumer.Spec) (consumer.Consumer, error) {
+	var s settings
+	if err := spec.Settings.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	c := New(s.Host, s.From, s.To...)
+	c.name = spec.Name
+	c.SetLogger(spec.Logger)
+	c.Username = s.Username
+	c.Password = s.Password
+	c.Zip = s.Zip
+	if s.Security != "" {
+		c.Security = Security(strings.ToLower(s.Security))
+	}
+	switch {
+	case s.Port != 0:
+		c.Port = s.Port
+	case c.Security == SecurityTLS:
+		c.Port = 465
+	}
+
+	if s.Subject != "" {
+		if err := c.SetSubjectTemplate(s.Subject); err != nil {
+			return nil, err
+		}
+	}
+	if s.Body != "" {
+		if err := c.SetBodyTemplate(s.Body); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,35 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/consumertest"
+)
+
+func TestFactory_Build(t *testing.T) {
+	ec := consumertest.Build[*Consumer](t, "email", "coach", `
+host = "smtp.example.com"
+security = "TLS"
+username = "me@example.com"
+password = "secret"
+from = "Fitwatch <me@example.com>"
+to = ["coach@example.com", "upload@platform.example"]
+subject = "Ride: {{.Title}}"
+zip = true
+`)
+	if ec.Security != SecurityTLS || ec.Port != 465 || !ec.Zip || len(ec.To) != 2 {
+		t.Errorf("unexpected consumer: %+v", ec)
+	}
+	if err := ec.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestFactory_InvalidTemplate(t *testing.T) {
+	consumertest.BuildError(t, "email", `
+host = "smtp.example.com"
+from = "me@example.com"
+to = ["coach@example.com"]
+body = "{{.Title"
+`, "parse body template")
+}
@@ -0,0 +1,193 @@
+package email
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+const (
+	// DefaultSubject is the subject template used unless one is configured.
+	DefaultSubject = "Activity: {{.Title}}"
+
+	// DefaultBody is the body template used unless one is configured.
+	DefaultBody = `{{.Title}}{{with .ActivityType}} ({{lower .}}){{end}}
+{{if or .StartTime .DistanceMeters .DurationSecs}}
+{{with .StartTime}}Start:    {{date "2006-01-02 15:04" .}}
+{{end}}{{if .DistanceMeters}}Distance: {{km .DistanceMeters}} km
+{{end}}{{if .DurationSecs}}Duration: {{duration .DurationSecs}}
+{{end}}{{end}}
+The FIT file is attached. Sent by fitwatch.`
+)
+
+// parseTemplate parses a subject or body template. The functions are bound
+// per file when the template is executed.
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(delivery.Funcs(time.Time{})).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func execTemplate(tmpl *template.Template, data *delivery.TemplateData) (string, error) {
+	t, err := tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Funcs(delivery.Funcs(data.Start())).Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render %s: %w", tmpl.Name(), err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// newTemplateData gathers the template data for a FIT file. Metadata is
+// best-effort: a file that can't be parsed is still sent. A file without a
+// title in its name is titled by its name, so the subject isn't empty.
+func newTemplateData(fitPath string) *delivery.TemplateData {
+	meta, _ := fitparser.Parse(fitPath)
+	data := delivery.NewTemplateData(fitPath, meta)
+	if data.Title == "" {
+		data.Title = strings.TrimSuffix(data.Filename, filepath.Ext(data.Filename))
+	}
+	return data
+}
+
+// attachment is a file attached to a message.
+type attachment struct {
+	filename    string
+	contentType string
+	content     []byte
+}
+
+// newAttachment returns the FIT file as an attachment, zipped if asked.
+func newAttachment(filename string, content []byte, zipped bool) (*attachment, error) {
+	if !zipped {
+		return &attachment{filename: filename, contentType: delivery.ContentType, content: content}, nil
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("zip file: %w", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, fmt.Errorf("zip file: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("zip file: %w", err)
+	}
+	zipName := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".zip"
+	return &attachment{filename: zipName, contentType: "application/zip", content: buf.Bytes()}, nil
+}
+
+// message is an email ready to send.
+type message struct {
+	from       mail.Address
+	to         []mail.Address
+	subject    string
+	body       string
+	attachment *attachment
+	date       time.Time
+	id         string
+}
+
+// newMessageID returns a unique Message-ID for a message from domain.
+func newMessageID(domain string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate message ID: %w", err)
+	}
+	return "<" + hex.EncodeToString(b) + ".fitwatch@" + domain + ">", nil
+}
+
+// bytes renders the message as a multipart/mixed MIME document with CRLF
+// line endings: the text body, then the attachment.
+func (m *message) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	to := make([]string, len(m.to))
+	for i, addr := range m.to {
+		to[i] = addr.String()
+	}
+	headers := [][2]string{
+		{"From", m.from.String()},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.subject)},
+		{"Date", m.date.Format(time.RFC1123Z)},
+		{"Message-ID", m.id},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()})},
+	}
+	var head bytes.Buffer
+	for _, h := range headers {
+		fmt.Fprintf(&head, "%s: %s\r\n", h[0], h[1])
+	}
+	head.WriteString("\r\n")
+
+	text, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(text)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(m.body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	if a := m.attachment; a != nil {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(a.contentType, map[string]string{"name": a.filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64Lines(part, a.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return append(head.Bytes(), buf.Bytes()...), nil
+}
+
+// writeBase64Lines writes content base64-encoded in lines of 76 characters,
+// as RFC 2045 requires.
+func writeBase64Lines(w io.Writer, content []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 0 {
+		n := min(76, len(encoded))
+		if _, err := w.Write([]byte(encoded[:n] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
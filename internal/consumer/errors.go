@@ -0,0 +1,146 @@
+package consumer
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PermanentError indicates a push that will not succeed if retried,
+// such as rejected credentials or a file the destination refuses.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// RetryableError indicates a transient failure, such as a network error
+// or a 5xx response, that may succeed later.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+// RateLimitError indicates the destination asked us to slow down.
+// RetryAfter is how long it asked us to wait, or zero if it didn't say.
+type RateLimitError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string { return e.Err.Error() }
+func (e *RateLimitError) Unwrap() error { return e.Err }
+
+// DuplicateError indicates the destination already has this activity.
+// The dispatcher treats it as a successful sync.
+type DuplicateError struct {
+	Err error
+}
+
+func (e *DuplicateError) Error() string { return e.Err.Error() }
+func (e *DuplicateError) Unwrap() error { return e.Err }
+
+// Permanent wraps err as a PermanentError.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// Retryable wraps err as a RetryableError.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{Err: err}
+}
+
+// RateLimited wraps err as a RateLimitError with the given wait.
+func RateLimited(err error, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &RateLimitError{Err: err, RetryAfter: retryAfter}
+}
+
+// Duplicate wraps err as a DuplicateError.
+func Duplicate(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DuplicateError{Err: err}
+}
+
+// IsPermanent reports whether err should not be retried.
+func IsPermanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
+
+// IsDuplicate reports whether err means the destination already has the file.
+func IsDuplicate(err error) bool {
+	var de *DuplicateError
+	return errors.As(err, &de)
+}
+
+// IsRetryable reports whether err may succeed if retried.
+// Unclassified errors are assumed to be retryable.
+func IsRetryable(err error) bool {
+	return err != nil && !IsPermanent(err) && !IsDuplicate(err)
+}
+
+// RetryAfter returns the wait requested by a RateLimitError in err's chain.
+// ok is false if err is not rate-limited or no wait was given.
+func RetryAfter(err error) (d time.Duration, ok bool) {
+	var re *RateLimitError
+	if errors.As(err, &re) && re.RetryAfter > 0 {
+		return re.RetryAfter, true
+	}
+	return 0, false
+}
+
+// ClassifyHTTPStatus wraps err according to the HTTP status of a failed
+// request. 429 becomes a RateLimitError honouring any Retry-After header,
+// 408 and 5xx are retryable, and other 4xx responses are permanent.
+func ClassifyHTTPStatus(status int, header http.Header, err error) error {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return RateLimited(err, ParseRetryAfter(header.Get("Retry-After"), time.Now()))
+	case status == http.StatusRequestTimeout || status >= 500:
+		return Retryable(err)
+	case status >= 400:
+		return Permanent(err)
+	default:
+		return err
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header value, which may be either a
+// number of seconds or an HTTP date. Returns zero if the value is missing,
+// malformed, or in the past.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,216 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestErrorClassification(t *testing.T) {
+	base := errors.New("boom")
+
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+		duplicate bool
+		retryable bool
+	}{
+		{"plain", base, false, false, true},
+		{"permanent", Permanent(base), true, false, false},
+		{"retryable", Retryable(base), false, false, true},
+		{"rate limited", RateLimited(base, time.Minute), false, false, true},
+		{"duplicate", Duplicate(base), false, true, false},
+		{"wrapped permanent", fmt.Errorf("push: %w", Permanent(base)), true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.permanent {
+				t.Errorf("IsPermanent = %v, want %v", got, tt.permanent)
+			}
+			if got := IsDuplicate(tt.err); got != tt.duplicate {
+				t.Errorf("IsDuplicate = %v, want %v", got, tt.duplicate)
+			}
+			if got := IsRetryable(tt.err); got != tt.retryable {
+				t.Errorf("IsRetryable = %v, want %v", got, tt.retryable)
+			}
+			if !errors.Is(tt.err, base) {
+				t.Error("expected wrapped error to unwrap to base")
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d, ok := RetryAfter(RateLimited(errors.New("slow down"), 90*time.Second)); !ok || d != 90*time.Second {
+		t.Errorf("RetryAfter = %v, %v; want 90s, true", d, ok)
+	}
+	if _, ok := RetryAfter(RateLimited(errors.New("slow down"), 0)); ok {
+		t.Error("expected no wait for rate limit without Retry-After")
+	}
+	if _, ok := RetryAfter(errors.New("other")); ok {
+		t.Error("expected no wait for unclassified error")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 12, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{"Mon, 12 Jan 2026 10:05:00 GMT", 5 * time.Minute},
+		{"Mon, 12 Jan 2026 09:00:00 GMT", 0}, // in the past
+	}
+
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyHTTPStatus(t *testing.T) {
+	base := errors.New("API error")
+	header := http.Header{}
+	header.Set("Retry-After", "30")
+
+	if err := ClassifyHTTPStatus(http.StatusUnauthorized, nil, base); !IsPermanent(err) {
+		t.Errorf("401 should be permanent: %v", err)
+	}
+	if err := ClassifyHTTPStatus(http.StatusServiceUnavailable, nil, base); !IsRetryable(err) || IsPermanent(err) {
+		t.Errorf("503 should be retryable: %v", err)
+	}
+	if err := ClassifyHTTPStatus(http.StatusRequestTimeout, nil, base); IsPermanent(err) {
+		t.Errorf("408 should be retryable: %v", err)
+	}
+	err := ClassifyHTTPStatus(http.StatusTooManyRequests, header, base)
+	if d, ok := RetryAfter(err); !ok || d != 30*time.Second {
+		t.Errorf("429 should carry Retry-After 30s, got %v, %v", d, ok)
+	}
+}
+
+// scriptedConsumer returns errors from a fixed script, one per push.
+type scriptedConsumer struct {
+	errs  []error
+	calls int
+}
+
+func (s *scriptedConsumer) Name() string    { return "scripted" }
+func (s *scriptedConsumer) Validate() error { return nil }
+
+func (s *scriptedConsumer) Push(ctx context.Context, fitPath string) (*Receipt, error) {
+	s.calls++
+	if len(s.errs) == 0 {
+		return &Receipt{RemoteID: "r1"}, nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return nil, err
+}
+
+func TestDispatcher_DoesNotRetryPermanentErrors(t *testing.T) {
+	c := &scriptedConsumer{errs: []error{Permanent(errors.New("bad API key"))}}
+	d := NewDispatcher(c)
+	d.SetMaxRetries(3)
+
+	results := d.Dispatch(context.Background(), "ride.fit")
+	if len(results) != 1 || results[0].Success {
+		t.Fatalf("expected failed result, got %+v", results)
+	}
+	if !IsPermanent(results[0].Error) {
+		t.Errorf("expected permanent error, got %v", results[0].Error)
+	}
+	if c.calls != 1 {
+		t.Errorf("expected 1 push, got %d", c.calls)
+	}
+}
+
+func TestDispatcher_DuplicateCountsAsSuccess(t *testing.T) {
+	c := &scriptedConsumer{errs: []error{Duplicate(errors.New("already exists"))}}
+	d := NewDispatcher(c)
+	d.SetMaxRetries(3)
+
+	results := d.Dispatch(context.Background(), "ride.fit")
+	if len(results) != 1 || !results[0].Success || !results[0].Duplicate {
+		t.Fatalf("expected successful duplicate result, got %+v", results)
+	}
+	if results[0].Error != nil {
+		t.Errorf("expected no error, got %v", results[0].Error)
+	}
+	if c.calls != 1 {
+		t.Errorf("expected 1 push, got %d", c.calls)
+	}
+}
+
+func TestDispatcher_PropagatesReceipt(t *testing.T) {
+	c := &scriptedConsumer{}
+	d := NewDispatcher(c)
+
+	results := d.Dispatch(context.Background(), "ride.fit")
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected success, got %+v", results)
+	}
+	if results[0].RemoteID != "r1" {
+		t.Errorf("expected remote ID r1, got %q", results[0].RemoteID)
+	}
+}
+
+func TestDispatcher_HonoursRetryAfter(t *testing.T) {
+	c := &scriptedConsumer{errs: []error{RateLimited(errors.New("slow down"), 10*time.Millisecond)}}
+	d := NewDispatcher(c)
+	d.SetMaxRetries(1)
+
+	start := time.Now()
+	results := d.Dispatch(context.Background(), "ride.fit")
+	elapsed := time.Since(start)
+
+	if !results[0].Success {
+		t.Fatalf("expected success after rate limit, got %+v", results[0])
+	}
+	// The default first backoff is 1s; Retry-After should replace it.
+	if elapsed >= time.Second {
+		t.Errorf("expected Retry-After wait instead of backoff, took %v", elapsed)
+	}
+}
+
+// closingConsumer records whether it was closed.
+type closingConsumer struct {
+	scriptedConsumer
+	closed bool
+	err    error
+}
+
+func (c *closingConsumer) Name() string { return "closing" }
+
+func (c *closingConsumer) Close() error {
+	c.closed = true
+	return c.err
+}
+
+func TestDispatcher_Close(t *testing.T) {
+	closer := &closingConsumer{err: errors.New("stuck")}
+
+	d := NewDispatcher()
+	d.AddConsumer(&scriptedConsumer{})
+	d.AddConsumer(closer)
+
+	err := d.Close()
+	if !closer.closed {
+		t.Error("expected consumer to be closed")
+	}
+	if !errors.Is(err, closer.err) {
+		t.Errorf("expected close error, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package consumer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// Filter selects the files a consumer receives. Every criterion that is set
+// must match; a criterion listing several values matches any of them. The
+// zero Filter matches every file.
+type Filter struct {
+	// ActivityTypes match the file's sport or sub-sport, ignoring case,
+	// spaces and underscores: "cycling" matches every ride and
+	// "virtual_activity" matches Zwift rides.
+	ActivityTypes []string
+
+	// ExcludeActivityTypes rejects files whose sport or sub-sport matches.
+	ExcludeActivityTypes []string
+
+	// Dirs match files under any of the directories, typically watch dirs.
+	Dirs []string
+
+	// MinDuration and MaxDuration bound the timer time; zero means no bound.
+	MinDuration time.Duration
+	MaxDuration time.Duration
+
+	// MinDistanceMeters and MaxDistanceMeters bound the distance; zero means
+	// no bound.
+	MinDistanceMeters float64
+	MaxDistanceMeters float64
+
+	// Manufacturers match the recording device's manufacturer, e.g. "garmin"
+	// or "zwift", ignoring case.
+	Manufacturers []string
+
+	// After and Before bound the start time: files starting at or after
+	// After, and before Before. The zero time means no bound.
+	After  time.Time
+	Before time.Time
+}
+
+// needsMetadata reports whether the filter looks at the file's contents,
+// as opposed to only its path.
+func (f Filter) needsMetadata() bool {
+	return len(f.ActivityTypes) > 0 || len(f.ExcludeActivityTypes) > 0 ||
+		f.MinDuration > 0 || f.MaxDuration > 0 ||
+		f.MinDistanceMeters > 0 || f.MaxDistanceMeters > 0 ||
+		len(f.Manufacturers) > 0 || !f.After.IsZero() || !f.Before.IsZero()
+}
+
+// Match reports whether the file at fitPath, with the given metadata,
+// passes the filter, and if not, why. meta may be nil if the file couldn't
+// be parsed, in which case only a filter on Dirs alone can match.
+func (f Filter) Match(fitPath string, meta *fitparser.Metadata) (bool, string) {
+	if len(f.Dirs) > 0 && !underAny(fitPath, f.Dirs) {
+		return false, "not under " + strings.Join(f.Dirs, ", ")
+	}
+	if !f.needsMetadata() {
+		return true, ""
+	}
+	if meta == nil {
+		return false, "no activity metadata"
+	}
+
+	types := []string{meta.ActivityType, meta.Sport, meta.SubSport}
+	if len(f.ActivityTypes) > 0 && !matchesAny(types, f.ActivityTypes) {
+		return false, fmt.Sprintf("activity type %q not in %s", meta.ActivityType, strings.Join(f.ActivityTypes, ", "))
+	}
+	if matchesAny(types, f.ExcludeActivityTypes) {
+		return false, fmt.Sprintf("activity type %q excluded", meta.ActivityType)
+	}
+
+	duration := time.Duration(meta.DurationSecs) * time.Second
+	if f.MinDuration > 0 && duration < f.MinDuration {
+		return false, fmt.Sprintf("duration %s shorter than %s", duration, f.MinDuration)
+	}
+	if f.MaxDuration > 0 && duration > f.MaxDuration {
+		return false, fmt.Sprintf("duration %s longer than %s", duration, f.MaxDuration)
+	}
+
+	if f.MinDistanceMeters > 0 && meta.DistanceMeters < f.MinDistanceMeters {
+		return false, fmt.Sprintf("distance %.1f km shorter than %.1f km", meta.DistanceMeters/1000, f.MinDistanceMeters/1000)
+	}
+	if f.MaxDistanceMeters > 0 && meta.DistanceMeters > f.MaxDistanceMeters {
+		return false, fmt.Sprintf("distance %.1f km longer than %.1f km", meta.DistanceMeters/1000, f.MaxDistanceMeters/1000)
+	}
+
+	if len(f.Manufacturers) > 0 && !matchesAny([]string{meta.Manufacturer}, f.Manufacturers) {
+		return false, fmt.Sprintf("manufacturer %q not in %s", meta.Manufacturer, strings.Join(f.Manufacturers, ", "))
+	}
+
+	if !f.After.IsZero() || !f.Before.IsZero() {
+		if meta.StartTime == nil {
+			return false, "no start time"
+		}
+		start := *meta.StartTime
+		if !f.After.IsZero() && start.Before(f.After) {
+			return false, fmt.Sprintf("started %s, before %s", start.Local().Format(time.DateOnly), f.After.Format(time.DateOnly))
+		}
+		if !f.Before.IsZero() && !start.Before(f.Before) {
+			return false, fmt.Sprintf("started %s, not before %s", start.Local().Format(time.DateOnly), f.Before.Format(time.DateOnly))
+		}
+	}
+	return true, ""
+}
+
+// SetFilter restricts the named consumer to files matching f; files that
+// don't match are reported as skipped rather than pushed. It must be called
+// before dispatching.
+func (d *Dispatcher) SetFilter(name string, f Filter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.filters == nil {
+		d.filters = make(map[string]Filter)
+	}
+	d.filters[name] = f
+}
+
+// route splits targets into the consumers whose filters pass the file and
+// skipped results for the rest. The file is only parsed if a filter needs
+// its metadata.
+func (d *Dispatcher) route(fitPath string, targets []Consumer) ([]Consumer, []Result) {
+	d.mu.Lock()
+	filters := d.filters
+	d.mu.Unlock()
+	if len(filters) == 0 {
+		return targets, nil
+	}
+
+	var (
+		meta   *fitparser.Metadata
+		parsed bool
+		kept   []Consumer
+		skips  []Result
+	)
+	for _, c := range targets {
+		f, ok := filters[c.Name()]
+		if !ok {
+			kept = append(kept, c)
+			continue
+		}
+		if f.needsMetadata() && !parsed {
+			parsed = true
+			m, err := fitparser.Parse(fitPath)
+			if err != nil {
+				d.logger.Warn("could not parse FIT file for routing", "path", fitPath, "error", err)
+			} else {
+				meta = m
+			}
+		}
+
+		if ok, reason := f.Match(fitPath, meta); !ok {
+			d.logger.Debug("skipped by filter", "consumer", c.Name(), "path", fitPath, "reason", reason)
+			skips = append(skips, Result{Consumer: c.Name(), FitPath: fitPath, Skipped: true, SkipReason: reason})
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept, skips
+}
+
+// normalizeName lower-cases s and drops spaces, underscores and hyphens, so
+// "Virtual Activity", "virtual_activity" and "VirtualActivity" compare equal.
+func normalizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '_', '-':
+			return -1
+		}
+		return r
+	}, strings.ToLower(s))
+}
+
+// matchesAny reports whether any non-empty value matches any of patterns.
+func matchesAny(values, patterns []string) bool {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		for _, p := range patterns {
+			if normalizeName(v) == normalizeName(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// underAny reports whether path is in any of dirs or their subdirectories.
+func underAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && filepath.IsLocal(rel) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package consumer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+func TestFilter_Match(t *testing.T) {
+	start := time.Date(2024, 6, 1, 7, 0, 0, 0, time.Local)
+	zwift := &fitparser.Metadata{
+		ActivityType:   "VirtualActivity",
+		Sport:          "Cycling",
+		SubSport:       "VirtualActivity",
+		StartTime:      &start,
+		DurationSecs:   3600,
+		DistanceMeters: 30000,
+		Manufacturer:   "Zwift",
+	}
+	path := filepath.Join("rides", "zwift", "ride.fit")
+
+	tests := []struct {
+		name   string
+		filter Filter
+		meta   *fitparser.Metadata
+		want   bool
+		reason string
+	}{
+		{"zero filter", Filter{}, zwift, true, ""},
+		{"zero filter, unparsed", Filter{}, nil, true, ""},
+		{"sport", Filter{ActivityTypes: []string{"cycling"}}, zwift, true, ""},
+		{"sub-sport", Filter{ActivityTypes: []string{"running", "virtual_activity"}}, zwift, true, ""},
+		{"other type", Filter{ActivityTypes: []string{"running"}}, zwift, false, "activity type"},
+		{"excluded type", Filter{ExcludeActivityTypes: []string{"Virtual Activity"}}, zwift, false, "excluded"},
+		{"in dir", Filter{Dirs: []string{filepath.Join("rides", "zwift")}}, zwift, true, ""},
+		{"in parent dir", Filter{Dirs: []string{"rides"}}, zwift, true, ""},
+		{"other dir", Filter{Dirs: []string{filepath.Join("rides", "garmin")}}, zwift, false, "not under"},
+		{"dir prefix isn't a parent", Filter{Dirs: []string{filepath.Join("rides", "zw")}}, zwift, false, "not under"},
+		{"dir only, unparsed", Filter{Dirs: []string{"rides"}}, nil, true, ""},
+		{"long enough", Filter{MinDuration: 5 * time.Minute}, zwift, true, ""},
+		{"too short", Filter{MinDuration: 2 * time.Hour}, zwift, false, "shorter than 2h0m0s"},
+		{"too long", Filter{MaxDuration: 30 * time.Minute}, zwift, false, "longer than 30m0s"},
+		{"far enough", Filter{MinDistanceMeters: 20000, MaxDistanceMeters: 40000}, zwift, true, ""},
+		{"too near", Filter{MinDistanceMeters: 50000}, zwift, false, "distance 30.0 km"},
+		{"too far", Filter{MaxDistanceMeters: 10000}, zwift, false, "longer than 10.0 km"},
+		{"manufacturer", Filter{Manufacturers: []string{"garmin", "zwift"}}, zwift, true, ""},
+		{"other manufacturer", Filter{Manufacturers: []string{"garmin"}}, zwift, false, "manufacturer"},
+		{"in range", Filter{After: start.AddDate(0, 0, -1), Before: start.AddDate(0, 0, 1)}, zwift, true, ""},
+		{"after is inclusive", Filter{After: start}, zwift, true, ""},
+		{"before is exclusive", Filter{Before: start}, zwift, false, "not before"},
+		{"too early", Filter{After: start.AddDate(0, 1, 0)}, zwift, false, "before"},
+		{"metadata needed, unparsed", Filter{MinDuration: time.Minute}, nil, false, "no activity metadata"},
+		{"all criteria", Filter{
+			ActivityTypes: []string{"cycling"},
+			Dirs:          []string{"rides"},
+			MinDuration:   5 * time.Minute,
+			Manufacturers: []string{"zwift"},
+		}, zwift, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := tt.filter.Match(path, tt.meta)
+			if got != tt.want {
+				t.Fatalf("Match() = %v (%s), want %v", got, reason, tt.want)
+			}
+			if !strings.Contains(reason, tt.reason) {
+				t.Errorf("reason %q doesn't mention %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDispatcher_SkipsFilteredConsumers(t *testing.T) {
+	_, filename, _, _ := runtime.Caller(0)
+	samplePath := filepath.Join(filepath.Dir(filename), "..", "..", "testdata", "sample.fit")
+	if _, err := os.Stat(samplePath); os.IsNotExist(err) {
+		t.Skip("sample.fit not found in testdata")
+	}
+
+	intervals := newGatedConsumer("intervals")
+	archive := newGatedConsumer("archive")
+	everything := newGatedConsumer("everything")
+	for _, c := range []*gatedConsumer{intervals, archive, everything} {
+		c.open()
+	}
+
+	// sample.fit is a Zwift ride of about two hours.
+	d := NewDispatcher(intervals, archive, everything)
+	d.SetFilter("intervals", Filter{ActivityTypes: []string{"cycling"}, MinDuration: 5 * time.Minute})
+	d.SetFilter("archive", Filter{ExcludeActivityTypes: []string{"virtual_activity"}})
+
+	results := d.Dispatch(context.Background(), samplePath)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	if !results[0].Success || results[0].Skipped {
+		t.Errorf("expected intervals to be pushed, got %+v", results[0])
+	}
+	if !results[1].Skipped || results[1].Success || results[1].Error != nil || !strings.Contains(results[1].SkipReason, "excluded") {
+		t.Errorf("expected archive to be skipped, got %+v", results[1])
+	}
+	if !results[2].Success {
+		t.Errorf("expected unfiltered consumer to be pushed, got %+v", results[2])
+	}
+	if len(archive.pushed) != 0 {
+		t.Errorf("skipped consumer was pushed %v", archive.pushed)
+	}
+}
+
+func TestDispatcher_FilterWithPool(t *testing.T) {
+	c := newGatedConsumer("archive")
+	c.open()
+	d := NewDispatcher(c)
+	d.SetFilter("archive", Filter{Dirs: []string{"garmin"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Start(ctx)
+	defer func() {
+		cancel()
+		d.Wait()
+	}()
+
+	// Path-only filters don't need the file to exist.
+	results := d.Dispatch(ctx, filepath.Join("zwift", "ride.fit"))
+	if len(results) != 1 || !results[0].Skipped {
+		t.Fatalf("expected skipped result, got %+v", results)
+	}
+	results = d.Dispatch(ctx, filepath.Join("garmin", "ride.fit"))
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected pushed result, got %+v", results)
+	}
+}
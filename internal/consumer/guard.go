@@ -0,0 +1,161 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// guard holds a consumer's rate limiter, circuit breaker and counters.
+type guard struct {
+	limiter *tokenBucket
+	breaker *breaker
+
+	// Guarded by the dispatcher's mu.
+	pushes   int
+	failures int
+}
+
+// ConsumerStats describes a consumer's dispatch state.
+type ConsumerStats struct {
+	Consumer string
+	Breaker  BreakerState
+
+	// ConsecutiveFailures counts retryable failures since the last success.
+	ConsecutiveFailures int
+
+	// Pushes and Failures count attempts since the dispatcher was created.
+	Pushes   int
+	Failures int
+
+	// Queued is the number of pushes waiting for a worker (pool only).
+	Queued int
+}
+
+// SetRateLimit limits how fast pushes are sent to the named consumer.
+// It must be called before dispatching.
+func (d *Dispatcher) SetRateLimit(name string, rl RateLimit) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.rateLimits == nil {
+		d.rateLimits = make(map[string]RateLimit)
+	}
+	d.rateLimits[name] = rl
+}
+
+// SetBreaker configures the circuit breaker for the named consumer.
+// It must be called before dispatching.
+func (d *Dispatcher) SetBreaker(name string, cfg BreakerConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.breakers == nil {
+		d.breakers = make(map[string]BreakerConfig)
+	}
+	d.breakers[name] = cfg
+}
+
+func (d *Dispatcher) guardFor(name string) *guard {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.guards == nil {
+		d.guards = make(map[string]*guard)
+	}
+	g, ok := d.guards[name]
+	if !ok {
+		g = &guard{
+			limiter: newTokenBucket(d.rateLimits[name]),
+			breaker: newBreaker(d.breakers[name]),
+		}
+		d.guards[name] = g
+	}
+	return g
+}
+
+// admit waits for the consumer's rate limiter and circuit breaker to allow
+// a push. If block is false and the breaker is open, it fails straight away
+// with ErrCircuitOpen rather than waiting for the cooldown.
+func (d *Dispatcher) admit(ctx context.Context, c Consumer, g *guard, block bool) error {
+	if err := g.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	for {
+		ok, wait, probing := g.breaker.allow(time.Now())
+		if probing {
+			d.logger.Info("circuit half-open, probing", "consumer", c.Name())
+		}
+		if ok {
+			return nil
+		}
+		if !block {
+			return Retryable(fmt.Errorf("%s: %w", c.Name(), ErrCircuitOpen))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// observe updates the consumer's guard with the outcome of a push.
+func (d *Dispatcher) observe(ctx context.Context, c Consumer, g *guard, err error) {
+	d.mu.Lock()
+	g.pushes++
+	if err != nil && !IsDuplicate(err) {
+		g.failures++
+	}
+	d.mu.Unlock()
+
+	if ctx.Err() != nil {
+		// Interrupted, so this says nothing about the destination.
+		g.breaker.release()
+		return
+	}
+
+	if after, ok := RetryAfter(err); ok {
+		g.limiter.pause(time.Now().Add(after))
+	}
+
+	// Only transient failures count against the destination; a file it
+	// rejects outright shows it is up.
+	state, changed := g.breaker.record(time.Now(), IsRetryable(err))
+	if !changed {
+		return
+	}
+	switch state {
+	case BreakerOpen:
+		_, failures := g.breaker.snapshot()
+		d.logger.Warn("circuit opened, holding pushes", "consumer", c.Name(), "failures", failures, "cooldown", g.breaker.cfg.Cooldown, "error", err)
+	case BreakerClosed:
+		d.logger.Info("circuit closed", "consumer", c.Name())
+	}
+}
+
+// Stats returns the dispatch state of each consumer, in registration order.
+func (d *Dispatcher) Stats() []ConsumerStats {
+	stats := make([]ConsumerStats, 0, len(d.consumers))
+	for _, c := range d.consumers {
+		g := d.guardFor(c.Name())
+		state, failures := g.breaker.snapshot()
+
+		d.mu.Lock()
+		s := ConsumerStats{
+			Consumer:            c.Name(),
+			Breaker:             state,
+			ConsecutiveFailures: failures,
+			Pushes:              g.pushes,
+			Failures:            g.failures,
+		}
+		if ln, ok := d.lanes[c.Name()]; ok {
+			s.Queued = len(ln.jobs)
+		}
+		d.mu.Unlock()
+
+		stats = append(stats, s)
+	}
+	return stats
+}
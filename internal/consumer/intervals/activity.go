@@ -0,0 +1,222 @@
+package intervals
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// ActivityFields are set on an activity after it has been uploaded.
+// Name, Description and each tag are text/template strings evaluated
+// against delivery.TemplateData; fields left empty are not changed.
+type ActivityFields struct {
+	Name        string
+	Description string
+	GearID      string
+	Tags        []string
+	Trainer     *bool
+	Commute     *bool
+}
+
+// IsZero reports whether no fields are set.
+func (f ActivityFields) IsZero() bool {
+	return f.Name == "" && f.Description == "" && f.GearID == "" &&
+		len(f.Tags) == 0 && f.Trainer == nil && f.Commute == nil
+}
+
+// merge returns f with any fields set in over replacing its own.
+func (f ActivityFields) merge(over ActivityFields) ActivityFields {
+	if over.Name != "" {
+		f.Name = over.Name
+	}
+	if over.Description != "" {
+		f.Description = over.Description
+	}
+	if over.GearID != "" {
+		f.GearID = over.GearID
+	}
+	if len(over.Tags) > 0 {
+		f.Tags = over.Tags
+	}
+	if over.Trainer != nil {
+		f.Trainer = over.Trainer
+	}
+	if over.Commute != nil {
+		f.Commute = over.Commute
+	}
+	return f
+}
+
+// SetActivityFields configures the fields set on every uploaded activity.
+// dirs maps a watch directory to fields that override the defaults for
+// files beneath it; the most specific directory wins.
+// Returns an error if any template fails to parse.
+func (c *Consumer) SetActivityFields(defaults ActivityFields, dirs map[string]ActivityFields) error {
+	all := []ActivityFields{defaults}
+	for _, f := range dirs {
+		all = append(all, f)
+	}
+	for _, f := range all {
+		for _, text := range append([]string{f.Name, f.Description}, f.Tags...) {
+			if _, err := parseTemplate(text, time.Time{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.activityDefaults = defaults
+	c.activityDirs = make(map[string]ActivityFields, len(dirs))
+	for dir, f := range dirs {
+		c.activityDirs[filepath.Clean(dir)] = f
+	}
+	return nil
+}
+
+// activityFieldsFor returns the fields to set for a file, applying the
+// override of the most specific configured directory containing it.
+func (c *Consumer) activityFieldsFor(fitPath string) ActivityFields {
+	dirs := make([]string, 0, len(c.activityDirs))
+	for dir := range c.activityDirs {
+		dirs = append(dirs, dir)
+	}
+	// Longest first, so nested directories beat their parents.
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+
+	path := filepath.Clean(fitPath)
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return c.activityDefaults.merge(c.activityDirs[dir])
+		}
+	}
+	return c.activityDefaults
+}
+
+// activityUpdate is the body of an Intervals.icu activity update.
+type activityUpdate struct {
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Gear        *gearRef `json:"gear,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Trainer     *bool    `json:"trainer,omitempty"`
+	Commute     *bool    `json:"commute,omitempty"`
+}
+
+type gearRef struct {
+	ID string `json:"id"`
+}
+
+// renderUpdate evaluates the field templates for a FIT file.
+func renderUpdate(fields ActivityFields, fitPath string, meta *fitparser.Metadata) (*activityUpdate, error) {
+	data := delivery.NewTemplateData(fitPath, meta)
+
+	update := &activityUpdate{
+		Trainer: fields.Trainer,
+		Commute: fields.Commute,
+	}
+	if fields.GearID != "" {
+		update.Gear = &gearRef{ID: fields.GearID}
+	}
+
+	var err error
+	if update.Name, err = execTemplate(fields.Name, data); err != nil {
+		return nil, fmt.Errorf("name: %w", err)
+	}
+	if update.Description, err = execTemplate(fields.Description, data); err != nil {
+		return nil, fmt.Errorf("description: %w", err)
+	}
+	for _, tag := range fields.Tags {
+		s, err := execTemplate(tag, data)
+		if err != nil {
+			return nil, fmt.Errorf("tag: %w", err)
+		}
+		// Tags that render empty (e.g. missing metadata) are dropped.
+		if s != "" {
+			update.Tags = append(update.Tags, s)
+		}
+	}
+
+	return update, nil
+}
+
+// parseTemplate parses a field template with the functions for a file
+// started at start.
+func parseTemplate(text string, start time.Time) (*template.Template, error) {
+	tmpl, err := template.New("").Funcs(delivery.Funcs(start)).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse template %q: %w", text, err)
+	}
+	return tmpl, nil
+}
+
+func execTemplate(text string, data *delivery.TemplateData) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := parseTemplate(text, data.Start())
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// applyActivityFields sets the configured fields on a newly uploaded activity.
+// meta may be nil if the file couldn't be parsed; templates still see the
+// filename.
+func (c *Consumer) applyActivityFields(ctx context.Context, activityID, fitPath string, meta *fitparser.Metadata) error {
+	fields := c.activityFieldsFor(fitPath)
+	if fields.IsZero() {
+		return nil
+	}
+
+	update, err := renderUpdate(fields, fitPath, meta)
+	if err != nil {
+		return consumer.Permanent(fmt.Errorf("render activity fields: %w", err))
+	}
+
+	return c.updateActivity(ctx, activityID, update)
+}
+
+// updateActivity sends an activity update to Intervals.icu.
+func (c *Consumer) updateActivity(ctx context.Context, activityID string, update *activityUpdate) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("encode update: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/v1/activity/%s", c.BaseURL, activityID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("API_KEY", c.APIKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return consumer.Retryable(fmt.Errorf("send request: %w", err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return consumer.ClassifyHTTPStatus(resp.StatusCode, resp.Header,
+			fmt.Errorf("API error %d: %s", resp.StatusCode, string(respBody)))
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package intervals
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// activityServer is an Intervals.icu stand-in that accepts uploads and
+// records activity updates.
+type activityServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	updates map[string]map[string]any
+}
+
+func newActivityServer(t *testing.T) *activityServer {
+	s := &activityServer{updates: make(map[string]map[string]any)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id": "i100"}`))
+		case r.Method == http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			var update map[string]any
+			if err := json.Unmarshal(body, &update); err != nil {
+				t.Errorf("invalid update body: %v", err)
+			}
+			s.mu.Lock()
+			s.updates[r.URL.Path] = update
+			s.mu.Unlock()
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *activityServer) update(id string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updates["/api/v1/activity/"+id]
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestConsumer_Push_UpdatesActivityFields(t *testing.T) {
+	server := newActivityServer(t)
+
+	fitPath := filepath.Join(t.TempDir(), "2025-02-23_Hudayriyat_Ascend.fit")
+	if err := os.WriteFile(fitPath, []byte("fake FIT data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+	err := c.SetActivityFields(ActivityFields{
+		Name:        "{{.Title}} (indoor)",
+		Description: "Uploaded from {{.Filename}}",
+		GearID:      "b42",
+		Tags:        []string{"fitwatch", "{{.ActivityType}}"},
+		Trainer:     boolPtr(true),
+	}, nil)
+	if err != nil {
+		t.Fatalf("SetActivityFields failed: %v", err)
+	}
+
+	if _, err := c.Push(context.Background(), fitPath); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	update := server.update("i100")
+	if update == nil {
+		t.Fatal("expected activity update")
+	}
+	if update["name"] != "Hudayriyat Ascend (indoor)" {
+		t.Errorf("unexpected name %v", update["name"])
+	}
+	if update["description"] != "Uploaded from 2025-02-23_Hudayriyat_Ascend.fit" {
+		t.Errorf("unexpected description %v", update["description"])
+	}
+	if gear, _ := update["gear"].(map[string]any); gear["id"] != "b42" {
+		t.Errorf("unexpected gear %v", update["gear"])
+	}
+	// The fake file has no activity type, so that tag is dropped.
+	if tags, _ := update["tags"].([]any); len(tags) != 1 || tags[0] != "fitwatch" {
+		t.Errorf("unexpected tags %v", update["tags"])
+	}
+	if update["trainer"] != true {
+		t.Errorf("expected trainer flag, got %v", update["trainer"])
+	}
+	if _, ok := update["commute"]; ok {
+		t.Error("commute should not be sent when unset")
+	}
+}
+
+func TestConsumer_Push_NoActivityFieldsNoUpdate(t *testing.T) {
+	server := newActivityServer(t)
+
+	fitPath := filepath.Join(t.TempDir(), "test.fit")
+	if err := os.WriteFile(fitPath, []byte("fake FIT data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+
+	if _, err := c.Push(context.Background(), fitPath); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if server.update("i100") != nil {
+		t.Error("expected no activity update without configured fields")
+	}
+}
+
+func TestConsumer_Push_UpdateFailureStillSucceeds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id": "i100"}`))
+	}))
+	defer server.Close()
+
+	fitPath := filepath.Join(t.TempDir(), "test.fit")
+	if err := os.WriteFile(fitPath, []byte("fake FIT data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+	if err := c.SetActivityFields(ActivityFields{GearID: "b42"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("upload succeeded, push should too: %v", err)
+	}
+	if receipt.RemoteID != "i100" {
+		t.Errorf("expected remote ID i100, got %q", receipt.RemoteID)
+	}
+}
+
+func TestConsumer_ActivityFieldsFor_DirOverrides(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "fit")
+	zwift := filepath.Join(root, "Zwift")
+	workouts := filepath.Join(zwift, "Workouts")
+
+	c := New("athlete123", "apikey456")
+	err := c.SetActivityFields(ActivityFields{Name: "{{.Title}}", GearID: "road"}, map[string]ActivityFields{
+		zwift:    {GearID: "trainer", Trainer: boolPtr(true)},
+		workouts: {Name: "Workout: {{.Title}}"},
+	})
+	if err != nil {
+		t.Fatalf("SetActivityFields failed: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		wantName string
+		wantGear string
+		trainer  bool
+	}{
+		{filepath.Join(root, "Outdoor", "ride.fit"), "{{.Title}}", "road", false},
+		{filepath.Join(zwift, "ride.fit"), "{{.Title}}", "trainer", true},
+		{filepath.Join(workouts, "ride.fit"), "Workout: {{.Title}}", "road", false},
+		{filepath.Join(root, "Zwiftless", "ride.fit"), "{{.Title}}", "road", false},
+	}
+
+	for _, tt := range tests {
+		got := c.activityFieldsFor(tt.path)
+		if got.Name != tt.wantName || got.GearID != tt.wantGear || (got.Trainer != nil) != tt.trainer {
+			t.Errorf("activityFieldsFor(%s) = %+v", tt.path, got)
+		}
+	}
+}
+
+func TestConsumer_SetActivityFields_InvalidTemplate(t *testing.T) {
+	c := New("athlete123", "apikey456")
+	if err := c.SetActivityFields(ActivityFields{Name: "{{.Title"}, nil); err == nil {
+		t.Error("expected error for malformed template")
+	}
+	if err := c.SetActivityFields(ActivityFields{}, map[string]ActivityFields{"/fit": {Tags: []string{"{{nope}}"}}}); err == nil {
+		t.Error("expected error for unknown template function")
+	}
+}
+
+func TestRenderUpdate_RealFitMetadata(t *testing.T) {
+	_, thisFile, _, _ := runtime.Caller(0)
+	samplePath := filepath.Join(filepath.Dir(thisFile), "..", "..", "..", "testdata", "sample.fit")
+	meta, err := fitparser.Parse(samplePath)
+	if err != nil {
+		t.Skipf("sample.fit not available: %v", err)
+	}
+
+	update, err := renderUpdate(ActivityFields{
+		Name:        "{{.ActivityType}} {{km .DistanceMeters}} km",
+		Description: "{{duration .DurationSecs}} on {{date \"2006-01-02\" .StartTime}}",
+	}, samplePath, meta)
+	if err != nil {
+		t.Fatalf("renderUpdate failed: %v", err)
+	}
+
+	wantName := meta.ActivityType + " " + fmtKm(meta.DistanceMeters) + " km"
+	if update.Name != wantName {
+		t.Errorf("name = %q, want %q", update.Name, wantName)
+	}
+	wantDesc := (time.Duration(meta.DurationSecs) * time.Second).String() + " on " + meta.StartTime.Local().Format("2006-01-02")
+	if update.Description != wantDesc {
+		t.Errorf("description = %q, want %q", update.Description, wantDesc)
+	}
+}
+
+func fmtKm(m float64) string {
+	return delivery.Funcs(time.Time{})["km"].(func(float64) string)(m)
+}
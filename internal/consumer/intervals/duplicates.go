@@ -0,0 +1,134 @@
+package intervals
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// listWindow pads the activity listing either side of the FIT file's time
+// range. The API filters on the athlete's local date, which we don't know,
+// so the window has to cover any time zone.
+const listWindow = 24 * time.Hour
+
+// remoteActivity is the subset of an Intervals.icu activity listing we use.
+type remoteActivity struct {
+	ID             string `json:"id"`
+	StartDate      string `json:"start_date"`
+	StartDateLocal string `json:"start_date_local"`
+	ElapsedTime    int    `json:"elapsed_time"`
+}
+
+// span returns the activity's start and end times.
+// ok is false if the start time can't be parsed.
+func (a remoteActivity) span() (start, end time.Time, ok bool) {
+	var err error
+	start, err = time.Parse(time.RFC3339, a.StartDate)
+	if err != nil {
+		// Fall back to local time, which is only right if fitwatch runs
+		// in the athlete's time zone, but is better than nothing.
+		start, err = time.ParseInLocation("2006-01-02T15:04:05", a.StartDateLocal, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	return start, start.Add(time.Duration(a.ElapsedTime) * time.Second), true
+}
+
+// fitSpan returns the time range covered by a FIT file.
+// ok is false if the file has no start time.
+func fitSpan(meta *fitparser.Metadata) (start, end time.Time, ok bool) {
+	if meta == nil || meta.StartTime == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	start = *meta.StartTime
+
+	switch {
+	case meta.EndTime != nil && meta.EndTime.After(start):
+		end = *meta.EndTime
+	case meta.ElapsedSecs > 0:
+		end = start.Add(time.Duration(meta.ElapsedSecs) * time.Second)
+	default:
+		end = start.Add(time.Duration(meta.DurationSecs) * time.Second)
+	}
+	return start, end, true
+}
+
+// overlaps reports whether the ranges [aStart, aEnd] and [bStart, bEnd]
+// share any time. A zero-length range overlaps a range containing it.
+func overlaps(aStart, aEnd, bStart, bEnd time.Time) bool {
+	return !aStart.After(bEnd) && !bStart.After(aEnd)
+}
+
+// findOverlapping returns the ID of an existing activity whose time range
+// overlaps the FIT file's, or "" if there is none (or the file has no
+// start time to compare). The activity ignore, fitwatch's own upload of an
+// earlier version of the file, doesn't count.
+func (c *Consumer) findOverlapping(ctx context.Context, meta *fitparser.Metadata, ignore string) (string, error) {
+	start, end, ok := fitSpan(meta)
+	if !ok {
+		return "", nil
+	}
+
+	activities, err := c.listActivities(ctx, start.Add(-listWindow), end.Add(listWindow))
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range activities {
+		aStart, aEnd, ok := a.span()
+		if !ok || a.ID == "" || a.ID == ignore {
+			continue
+		}
+		if overlaps(start, end, aStart, aEnd) {
+			return a.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// listActivities lists the athlete's activities between two dates.
+func (c *Consumer) listActivities(ctx context.Context, oldest, newest time.Time) ([]remoteActivity, error) {
+	query := url.Values{}
+	query.Set("oldest", oldest.Format("2006-01-02"))
+	query.Set("newest", newest.Format("2006-01-02"))
+	reqURL := fmt.Sprintf("%s/api/v1/athlete/%s/activities?%s", c.BaseURL, c.AthleteID, query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.SetBasicAuth("API_KEY", c.APIKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("list activities: %w", err)
+		}
+		return nil, consumer.Retryable(fmt.Errorf("list activities: %w", err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, consumer.Retryable(fmt.Errorf("list activities: read response: %w", err))
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, consumer.ClassifyHTTPStatus(resp.StatusCode, resp.Header,
+			fmt.Errorf("list activities: API error %d: %s", resp.StatusCode, string(body)))
+	}
+
+	var activities []remoteActivity
+	if err := json.Unmarshal(body, &activities); err != nil {
+		return nil, consumer.Retryable(fmt.Errorf("list activities: decode response: %w", err))
+	}
+	return activities, nil
+}
@@ -0,0 +1,208 @@
+package intervals
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+func sampleFitPath(t *testing.T) (string, *fitparser.Metadata) {
+	t.Helper()
+	_, thisFile, _, _ := runtime.Caller(0)
+	path := filepath.Join(filepath.Dir(thisFile), "..", "..", "..", "testdata", "sample.fit")
+	meta, err := fitparser.Parse(path)
+	if err != nil || meta.StartTime == nil {
+		t.Skipf("sample.fit not available: %v", err)
+	}
+	return path, meta
+}
+
+// listingServer serves a fixed activity listing and counts uploads.
+func listingServer(t *testing.T, activities []remoteActivity, status int) (*httptest.Server, *int, *http.Request) {
+	uploads := 0
+	var listReq http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listReq = *r
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(activities)
+		case http.MethodPost:
+			uploads++
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id": "i999"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &uploads, &listReq
+}
+
+func TestConsumer_Push_SkipsOverlappingActivity(t *testing.T) {
+	fitPath, meta := sampleFitPath(t)
+
+	// An activity from another sync source that started a minute later.
+	existing := remoteActivity{
+		ID:          "i555",
+		StartDate:   meta.StartTime.Add(time.Minute).UTC().Format(time.RFC3339),
+		ElapsedTime: 600,
+	}
+	server, uploads, listReq := listingServer(t, []remoteActivity{existing}, http.StatusOK)
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+
+	receipt, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if *uploads != 0 {
+		t.Errorf("expected no upload, got %d", *uploads)
+	}
+	if !receipt.Duplicate || receipt.RemoteID != "i555" {
+		t.Errorf("expected duplicate receipt for i555, got %+v", receipt)
+	}
+	if receipt.RemoteURL != server.URL+"/activities/i555" {
+		t.Errorf("unexpected remote URL %q", receipt.RemoteURL)
+	}
+
+	// The listing window covers the ride's date in any time zone.
+	q := listReq.URL.Query()
+	if listReq.URL.Path != "/api/v1/athlete/athlete123/activities" {
+		t.Errorf("unexpected listing path %s", listReq.URL.Path)
+	}
+	day := meta.StartTime.Format("2006-01-02")
+	if q.Get("oldest") > day || q.Get("newest") < day {
+		t.Errorf("listing window %s..%s doesn't include %s", q.Get("oldest"), q.Get("newest"), day)
+	}
+
+	// The dispatcher records this as a successful sync.
+	results := consumer.NewDispatcher(c).Dispatch(context.Background(), fitPath)
+	if !results[0].Success || !results[0].Duplicate || results[0].RemoteID != "i555" {
+		t.Errorf("expected successful duplicate result, got %+v", results[0])
+	}
+}
+
+func TestConsumer_Push_UploadsWhenNoOverlap(t *testing.T) {
+	fitPath, meta := sampleFitPath(t)
+
+	// Same day, but finished an hour before the ride started.
+	earlier := remoteActivity{
+		ID:          "i554",
+		StartDate:   meta.StartTime.Add(-2 * time.Hour).UTC().Format(time.RFC3339),
+		ElapsedTime: 3600,
+	}
+	server, uploads, _ := listingServer(t, []remoteActivity{earlier}, http.StatusOK)
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+
+	receipt, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if *uploads != 1 {
+		t.Errorf("expected 1 upload, got %d", *uploads)
+	}
+	if receipt.Duplicate || receipt.RemoteID != "i999" {
+		t.Errorf("expected receipt for new activity, got %+v", receipt)
+	}
+}
+
+func TestConsumer_Push_IgnoresOwnEarlierUpload(t *testing.T) {
+	fitPath, meta := sampleFitPath(t)
+
+	// fitwatch uploaded the file while it was still being written.
+	partial := remoteActivity{
+		ID:          "i555",
+		StartDate:   meta.StartTime.UTC().Format(time.RFC3339),
+		ElapsedTime: 60,
+	}
+	server, uploads, _ := listingServer(t, []remoteActivity{partial}, http.StatusOK)
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+
+	ctx := consumer.WithPreviousRemoteIDs(context.Background(), map[string]string{c.Name(): "i555"})
+	receipt, err := c.Push(ctx, fitPath)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if *uploads != 1 {
+		t.Errorf("expected the changed file to be uploaded, got %d uploads", *uploads)
+	}
+	if receipt.Duplicate || receipt.RemoteID != "i999" {
+		t.Errorf("expected receipt for new activity, got %+v", receipt)
+	}
+}
+
+func TestConsumer_Push_ListingFailureIsNotUploaded(t *testing.T) {
+	fitPath, _ := sampleFitPath(t)
+	server, uploads, _ := listingServer(t, nil, http.StatusServiceUnavailable)
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+
+	_, err := c.Push(context.Background(), fitPath)
+	if err == nil {
+		t.Fatal("expected error when the duplicate check fails")
+	}
+	if !consumer.IsRetryable(err) || consumer.IsPermanent(err) {
+		t.Errorf("expected retryable error, got %v", err)
+	}
+	if *uploads != 0 {
+		t.Errorf("expected no upload, got %d", *uploads)
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	base := time.Date(2025, 2, 23, 6, 0, 0, 0, time.UTC)
+	at := func(mins int) time.Time { return base.Add(time.Duration(mins) * time.Minute) }
+
+	tests := []struct {
+		name                       string
+		aStart, aEnd, bStart, bEnd time.Time
+		want                       bool
+	}{
+		{"identical", at(0), at(60), at(0), at(60), true},
+		{"contained", at(0), at(60), at(10), at(20), true},
+		{"partial", at(0), at(60), at(50), at(90), true},
+		{"touching", at(0), at(60), at(60), at(90), true},
+		{"before", at(0), at(60), at(61), at(90), false},
+		{"after", at(100), at(160), at(0), at(60), false},
+		{"zero-length inside", at(30), at(30), at(0), at(60), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overlaps(tt.aStart, tt.aEnd, tt.bStart, tt.bEnd); got != tt.want {
+				t.Errorf("overlaps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteActivity_SpanFallsBackToLocalDate(t *testing.T) {
+	a := remoteActivity{StartDateLocal: "2025-02-23T06:00:00", ElapsedTime: 90}
+	start, end, ok := a.span()
+	if !ok {
+		t.Fatal("expected local start date to parse")
+	}
+	if end.Sub(start) != 90*time.Second {
+		t.Errorf("expected 90s span, got %v", end.Sub(start))
+	}
+
+	if _, _, ok := (remoteActivity{}).span(); ok {
+		t.Error("expected no span without a start date")
+	}
+}
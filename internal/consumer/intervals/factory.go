@@ -0,0 +1,81 @@
+package intervals
+
+import (
+	"errors"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+func init() {
+	consumer.Register("intervals", newFromSpec)
+}
+
+// settings are the config keys of an intervals consumer.
+type settings struct {
+	AthleteID string           `toml:"athlete_id"`
+	APIKey    string           `toml:"api_key"`
+	BaseURL   string           `toml:"base_url"`
+	Compress  bool             `toml:"compress"`
+	Activity  activitySettings `toml:"activity"`
+}
+
+// fieldSettings are the config keys for activity fields.
+type fieldSettings struct {
+	Name        string   `toml:"name"`
+	Description string   `toml:"description"`
+	GearID      string   `toml:"gear_id"`
+	Tags        []string `toml:"tags"`
+	Trainer     *bool    `toml:"trainer"`
+	Commute     *bool    `toml:"commute"`
+}
+
+func (f fieldSettings) fields() ActivityFields {
+	return ActivityFields{
+		Name:        f.Name,
+		Description: f.Description,
+		GearID:      f.GearID,
+		Tags:        f.Tags,
+		Trainer:     f.Trainer,
+		Commute:     f.Commute,
+	}
+}
+
+// activitySettings are default activity fields with per-directory overrides.
+type activitySettings struct {
+	fieldSettings
+
+	Dirs []struct {
+		Dir string `toml:"dir"`
+
+		fieldSettings
+	} `toml:"dirs"`
+}
+
+// newFromSpec builds a consumer from its [[consumers]] entry.
+func newFromSpec(spec consumer.Spec) (consumer.Consumer, error) {
+	var s settings
+	if err := spec.Settings.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	c := New(s.AthleteID, s.APIKey)
+	c.name = spec.Name
+	c.Compress = s.Compress
+	if s.BaseURL != "" {
+		c.BaseURL = s.BaseURL
+	}
+	c.SetLogger(spec.Logger)
+
+	dirs := make(map[string]ActivityFields, len(s.Activity.Dirs))
+	for _, d := range s.Activity.Dirs {
+		if d.Dir == "" {
+			return nil, errors.New("activity.dirs entries must set dir")
+		}
+		dirs[d.Dir] = d.fields()
+	}
+	if err := c.SetActivityFields(s.Activity.fields(), dirs); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,51 @@
+package intervals
+
+import (
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/consumertest"
+)
+
+func TestFactory_Build(t *testing.T) {
+	ic := consumertest.Build[*Consumer](t, "intervals", "work", `
+athlete_id = "i42"
+api_key = "secret"
+base_url = "http://localhost:1234"
+compress = true
+
+[activity]
+name = "{{.Title}}"
+
+[[activity.dirs]]
+dir = "/fit/indoor"
+trainer = true
+`)
+	if ic.AthleteID != "i42" || ic.APIKey != "secret" {
+		t.Errorf("unexpected credentials: %s %s", ic.AthleteID, ic.APIKey)
+	}
+	if ic.BaseURL != "http://localhost:1234" {
+		t.Errorf("unexpected base URL: %s", ic.BaseURL)
+	}
+	if !ic.Compress {
+		t.Error("expected compress to be set")
+	}
+
+	fields := ic.activityFieldsFor("/fit/indoor/ride.fit")
+	if fields.Name != "{{.Title}}" || fields.Trainer == nil || !*fields.Trainer {
+		t.Errorf("unexpected activity fields: %+v", fields)
+	}
+}
+
+func TestFactory_DefaultBaseURL(t *testing.T) {
+	ic := consumertest.Build[*Consumer](t, "intervals", "intervals", `athlete_id = "i1"`)
+	if ic.BaseURL != defaultBaseURL {
+		t.Errorf("expected default base URL, got %s", ic.BaseURL)
+	}
+}
+
+func TestFactory_ActivityDirRequiresDir(t *testing.T) {
+	consumertest.BuildError(t, "intervals", `
+[[activity.dirs]]
+trainer = true
+`, "activity.dirs entries must set dir")
+}
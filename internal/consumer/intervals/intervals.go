@@ -2,21 +2,24 @@
 package intervals
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
 )
 
 const (
 	defaultBaseURL = "https://intervals.icu"
+	defaultName    = "Intervals.icu"
 )
 
 // Consumer pushes FIT files to Intervals.icu.
@@ -24,7 +27,16 @@ type Consumer struct {
 	AthleteID string
 	APIKey    string
 	BaseURL   string
-	client    *http.Client
+
+	// Compress gzips files on the fly and uploads them as .fit.gz.
+	Compress bool
+
+	name   string
+	client *http.Client
+	logger *slog.Logger
+
+	activityDefaults ActivityFields
+	activityDirs     map[string]ActivityFields
 }
 
 // New creates an Intervals.icu consumer.
@@ -33,13 +45,21 @@ func New(athleteID, apiKey string) *Consumer {
 		AthleteID: athleteID,
 		APIKey:    apiKey,
 		BaseURL:   defaultBaseURL,
+		name:      defaultName,
 		client:    &http.Client{},
+		logger:    slog.Default(),
 	}
 }
 
-// Name returns the consumer name.
+// SetLogger configures the logger for the consumer.
+func (c *Consumer) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Name returns the consumer's instance name, "Intervals.icu" unless it
+// was configured as a named [[consumers]] entry.
 func (c *Consumer) Name() string {
-	return "Intervals.icu"
+	return c.name
 }
 
 // Validate checks configuration.
@@ -53,96 +73,160 @@ func (c *Consumer) Validate() error {
 	return nil
 }
 
-// Push uploads a FIT file to Intervals.icu.
-func (c *Consumer) Push(ctx context.Context, fitPath string) error {
+// Push uploads a FIT file to Intervals.icu, then sets any configured
+// activity fields on the new activity.
+// If the athlete already has an activity overlapping the file's time range
+// (e.g. synced directly from Garmin or Zwift), the upload is skipped and the
+// receipt identifies the existing activity instead. fitwatch's own upload of
+// an earlier version of a file that has since changed doesn't count (see
+// consumer.PreviousRemoteID), so the new content is uploaded.
+// The receipt carries the activity's ID and URL.
+func (c *Consumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
 	if err := c.Validate(); err != nil {
-		return err
+		return nil, consumer.Permanent(err)
+	}
+
+	// Metadata is best-effort: a file we can't parse is still uploaded,
+	// just without the duplicate check or metadata in templates.
+	meta, err := fitparser.Parse(fitPath)
+	if err != nil {
+		meta = nil
+	}
+
+	existingID, err := c.findOverlapping(ctx, meta, consumer.PreviousRemoteID(ctx, c.Name()))
+	if err != nil {
+		return nil, fmt.Errorf("check for existing activity: %w", err)
+	}
+	if existingID != "" {
+		return &consumer.Receipt{
+			RemoteID:  existingID,
+			RemoteURL: c.ActivityURL(existingID),
+			Duplicate: true,
+		}, nil
 	}
 
 	// Open the FIT file
 	file, err := os.Open(fitPath)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, consumer.Permanent(fmt.Errorf("open file: %w", err))
+		}
+		return nil, fmt.Errorf("open file: %w", err)
 	}
 	defer func() { _ = file.Close() }()
 
-	// Create multipart form
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
-
 	filename := filepath.Base(fitPath)
 
 	// Extract activity name from filename (e.g., "2025-02-23_Hudayriyat_Ascend.fit" -> "Hudayriyat Ascend")
-	activityName := extractActivityName(filename)
-	if activityName != "" {
-		if err := writer.WriteField("name", activityName); err != nil {
-			return fmt.Errorf("write name field: %w", err)
-		}
-	}
-
-	part, err := writer.CreateFormFile("file", filename)
-	if err != nil {
-		return fmt.Errorf("create form file: %w", err)
-	}
-
-	if _, err := io.Copy(part, file); err != nil {
-		return fmt.Errorf("copy file: %w", err)
-	}
+	activityName := fitparser.TitleFromFilename(filename)
 
-	if err := writer.Close(); err != nil {
-		return fmt.Errorf("close writer: %w", err)
-	}
+	// Stream the multipart form rather than buffering the whole file
+	body := newUploadBody(file, filename, activityName, c.Compress)
 
 	// Build request
 	url := fmt.Sprintf("%s/api/v1/athlete/%s/activities", c.BaseURL, c.AthleteID)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, &buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		_ = body.wait()
+		return nil, fmt.Errorf("create request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Content-Type", body.contentType)
 	req.SetBasicAuth("API_KEY", c.APIKey)
 
 	// Send request
 	resp, err := c.client.Do(req)
+	if writeErr := body.wait(); writeErr != nil {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return nil, writeErr
+	}
 	if err != nil {
-		return fmt.Errorf("send request: %w", err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("send request: %w", err)
+		}
+		return nil, consumer.Retryable(fmt.Errorf("send request: %w", err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, consumer.Retryable(fmt.Errorf("read response: %w", err))
+	}
+
 	// Check response
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		apiErr := fmt.Errorf("API error %d: %s", resp.StatusCode, string(respBody))
+		if isDuplicateResponse(resp.StatusCode, respBody) {
+			receipt := c.parseReceipt(respBody)
+			receipt.Duplicate = true
+			return receipt, consumer.Duplicate(apiErr)
+		}
+		return nil, consumer.ClassifyHTTPStatus(resp.StatusCode, resp.Header, apiErr)
 	}
 
-	return nil
+	receipt := c.parseReceipt(respBody)
+
+	// The upload has succeeded, so a failed update mustn't fail the push:
+	// retrying would upload the file a second time.
+	if receipt.RemoteID != "" {
+		if err := c.applyActivityFields(ctx, receipt.RemoteID, fitPath, meta); err != nil {
+			c.logger.Warn("failed to update activity fields", "path", fitPath, "activity", receipt.RemoteID, "error", err)
+		}
+	}
+
+	return receipt, nil
 }
 
-// extractActivityName extracts a human-readable name from a FIT filename.
-// Examples:
-//   - "2025-02-23_Hudayriyat_Ascend.fit" -> "Hudayriyat Ascend"
-//   - "2025-02-23_.fit" -> "" (no name)
-//   - "activity.fit" -> "" (no recognizable pattern)
-func extractActivityName(filename string) string {
-	// Remove extension
-	name := strings.TrimSuffix(filename, filepath.Ext(filename))
+// uploadResponse is the subset of the Intervals.icu upload response we use.
+// The created activity's ID is returned at the top level, and also in the
+// activities list when a file produces more than one activity.
+type uploadResponse struct {
+	ID         string `json:"id"`
+	Activities []struct {
+		ID string `json:"id"`
+	} `json:"activities"`
+}
 
-	// Pattern: YYYY-MM-DD_Name or similar date prefix
-	datePattern := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[_-]?`)
-	name = datePattern.ReplaceAllString(name, "")
+// parseReceipt extracts the activity ID from an upload response body.
+// Unrecognised bodies yield an empty receipt rather than an error: the
+// upload itself succeeded.
+func (c *Consumer) parseReceipt(body []byte) *consumer.Receipt {
+	var resp uploadResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return &consumer.Receipt{}
+	}
 
-	// If nothing left after removing date, no name
-	if name == "" || name == "_" {
-		return ""
+	id := resp.ID
+	if id == "" && len(resp.Activities) > 0 {
+		id = resp.Activities[0].ID
+	}
+	if id == "" {
+		return &consumer.Receipt{}
 	}
 
-	// Replace underscores and hyphens with spaces
-	name = strings.ReplaceAll(name, "_", " ")
-	name = strings.ReplaceAll(name, "-", " ")
+	return &consumer.Receipt{
+		RemoteID:  id,
+		RemoteURL: c.ActivityURL(id),
+	}
+}
 
-	// Clean up multiple spaces and trim
-	name = strings.Join(strings.Fields(name), " ")
+// ActivityURL returns the web URL for an activity.
+func (c *Consumer) ActivityURL(activityID string) string {
+	return fmt.Sprintf("%s/activities/%s", strings.TrimRight(c.BaseURL, "/"), activityID)
+}
 
-	return name
+// isDuplicateResponse reports whether an error response means the activity
+// already exists. Intervals.icu signals this with 409, or with a 422 whose
+// message mentions a duplicate.
+func isDuplicateResponse(status int, body []byte) bool {
+	switch status {
+	case http.StatusConflict:
+		return true
+	case http.StatusUnprocessableEntity:
+		return strings.Contains(strings.ToLower(string(body)), "duplicate")
+	default:
+		return false
+	}
 }
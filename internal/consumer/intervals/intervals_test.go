@@ -9,6 +9,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
 )
 
 func TestConsumer_Name(t *testing.T) {
@@ -79,7 +82,7 @@ func TestConsumer_Push_Success(t *testing.T) {
 
 	// Push the file
 	ctx := context.Background()
-	if err := c.Push(ctx, fitPath); err != nil {
+	if _, err := c.Push(ctx, fitPath); err != nil {
 		t.Fatalf("Push failed: %v", err)
 	}
 
@@ -124,7 +127,7 @@ func TestConsumer_Push_ServerError(t *testing.T) {
 	c.BaseURL = server.URL
 
 	ctx := context.Background()
-	err := c.Push(ctx, fitPath)
+	_, err := c.Push(ctx, fitPath)
 	if err == nil {
 		t.Error("expected error for server error")
 	}
@@ -150,7 +153,7 @@ func TestConsumer_Push_Unauthorized(t *testing.T) {
 	c.BaseURL = server.URL
 
 	ctx := context.Background()
-	err := c.Push(ctx, fitPath)
+	_, err := c.Push(ctx, fitPath)
 	if err == nil {
 		t.Error("expected error for unauthorized")
 	}
@@ -163,7 +166,7 @@ func TestConsumer_Push_FileNotFound(t *testing.T) {
 	c := New("athlete123", "apikey456")
 
 	ctx := context.Background()
-	err := c.Push(ctx, "/nonexistent/path/to/file.fit")
+	_, err := c.Push(ctx, "/nonexistent/path/to/file.fit")
 	if err == nil {
 		t.Error("expected error for non-existent file")
 	}
@@ -179,7 +182,7 @@ func TestConsumer_Push_InvalidConfig(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	err := c.Push(ctx, fitPath)
+	_, err := c.Push(ctx, fitPath)
 	if err == nil {
 		t.Error("expected error for invalid config")
 	}
@@ -208,7 +211,7 @@ func TestConsumer_Push_ContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel() // Cancel immediately
 
-	err := c.Push(ctx, fitPath)
+	_, err := c.Push(ctx, fitPath)
 	if err == nil {
 		t.Error("expected error for canceled context")
 	}
@@ -224,6 +227,11 @@ func TestConsumer_Push_RealFitFile(t *testing.T) {
 	var receivedSize int
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The duplicate check lists existing activities first; there are none.
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
 		body, _ := io.ReadAll(r.Body)
 		receivedSize = len(body)
 		w.WriteHeader(http.StatusCreated)
@@ -234,7 +242,7 @@ func TestConsumer_Push_RealFitFile(t *testing.T) {
 	c.BaseURL = server.URL
 
 	ctx := context.Background()
-	if err := c.Push(ctx, fitPath); err != nil {
+	if _, err := c.Push(ctx, fitPath); err != nil {
 		t.Fatalf("Push failed: %v", err)
 	}
 
@@ -263,7 +271,7 @@ func TestConsumer_Push_RateLimited(t *testing.T) {
 	c.BaseURL = server.URL
 
 	ctx := context.Background()
-	err := c.Push(ctx, fitPath)
+	_, err := c.Push(ctx, fitPath)
 	if err == nil {
 		t.Error("expected error for rate limit")
 	}
@@ -290,7 +298,7 @@ func TestConsumer_Push_DuplicateActivity(t *testing.T) {
 	c.BaseURL = server.URL
 
 	ctx := context.Background()
-	err := c.Push(ctx, fitPath)
+	_, err := c.Push(ctx, fitPath)
 	if err == nil {
 		t.Error("expected error for duplicate")
 	}
@@ -321,34 +329,147 @@ func BenchmarkConsumer_Push(b *testing.B) {
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		if err := c.Push(ctx, fitPath); err != nil {
+		if _, err := c.Push(ctx, fitPath); err != nil {
 			b.Fatal(err)
 		}
 	}
 }
 
-func TestExtractActivityName(t *testing.T) {
+func TestConsumer_Push_ErrorClassification(t *testing.T) {
 	tests := []struct {
-		filename string
-		want     string
+		name       string
+		status     int
+		body       string
+		retryAfter string
+		check      func(error) bool
 	}{
-		{"2025-02-23_Hudayriyat_Ascend.fit", "Hudayriyat Ascend"},
-		{"2025-02-23_Coffee_Trail.fit", "Coffee Trail"},
-		{"2025-05-05_Area_52.fit", "Area 52"},
-		{"2025-02-23_.fit", ""},
-		{"2025-02-23.fit", ""},
-		{"activity.fit", "activity"},
-		{"My_Ride.fit", "My Ride"},
-		{"2025-04-07_Al_Wathba-L.fit", "Al Wathba L"},
-		{"2025-05-30_Heian_Samurai_Circuit.fit", "Heian Samurai Circuit"},
+		{"unauthorized is permanent", http.StatusUnauthorized, `{"error": "unauthorized"}`, "", consumer.IsPermanent},
+		{"bad request is permanent", http.StatusBadRequest, `{"error": "not a FIT file"}`, "", consumer.IsPermanent},
+		{"conflict is duplicate", http.StatusConflict, `{"error": "activity already exists"}`, "", consumer.IsDuplicate},
+		{"422 duplicate is duplicate", http.StatusUnprocessableEntity, `{"error": "Duplicate activity"}`, "", consumer.IsDuplicate},
+		{"422 other is permanent", http.StatusUnprocessableEntity, `{"error": "invalid"}`, "", consumer.IsPermanent},
+		{"server error is retryable", http.StatusServiceUnavailable, `{"error": "down"}`, "", func(err error) bool {
+			return consumer.IsRetryable(err) && !consumer.IsPermanent(err)
+		}},
+		{"rate limit carries Retry-After", http.StatusTooManyRequests, `{"error": "rate limited"}`, "42", func(err error) bool {
+			d, ok := consumer.RetryAfter(err)
+			return ok && d == 42*time.Second
+		}},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.filename, func(t *testing.T) {
-			got := extractActivityName(tt.filename)
-			if got != tt.want {
-				t.Errorf("extractActivityName(%q) = %q, want %q", tt.filename, got, tt.want)
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			fitPath := filepath.Join(t.TempDir(), "test.fit")
+			if err := os.WriteFile(fitPath, []byte("fake FIT data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c := New("athlete123", "apikey456")
+			c.BaseURL = server.URL
+
+			_, err := c.Push(context.Background(), fitPath)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !tt.check(err) {
+				t.Errorf("unexpected classification for %v", err)
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_InvalidConfigIsPermanent(t *testing.T) {
+	c := New("", "apikey456")
+	_, err := c.Push(context.Background(), "/any/file.fit")
+	if !consumer.IsPermanent(err) {
+		t.Errorf("expected permanent error for invalid config, got %v", err)
+	}
+}
+
+func TestConsumer_Push_Receipt(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		wantID string
+	}{
+		{"top-level id", `{"id": "i123", "name": "Morning Ride"}`, "i123"},
+		{"activities list", `{"activities": [{"id": "i456"}]}`, "i456"},
+		{"unrecognised body", `OK`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			fitPath := filepath.Join(t.TempDir(), "test.fit")
+			if err := os.WriteFile(fitPath, []byte("fake FIT data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			c := New("athlete123", "apikey456")
+			c.BaseURL = server.URL
+
+			receipt, err := c.Push(context.Background(), fitPath)
+			if err != nil {
+				t.Fatalf("Push failed: %v", err)
+			}
+			if receipt.RemoteID != tt.wantID {
+				t.Errorf("expected remote ID %q, got %q", tt.wantID, receipt.RemoteID)
+			}
+			if tt.wantID == "" {
+				if receipt.RemoteURL != "" {
+					t.Errorf("expected no remote URL, got %q", receipt.RemoteURL)
+				}
+				return
+			}
+			if want := server.URL + "/activities/" + tt.wantID; receipt.RemoteURL != want {
+				t.Errorf("expected remote URL %q, got %q", want, receipt.RemoteURL)
 			}
 		})
 	}
 }
+
+func TestConsumer_Push_DuplicateReceipt(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error": "activity already exists", "id": "i789"}`))
+	}))
+	defer server.Close()
+
+	fitPath := filepath.Join(t.TempDir(), "test.fit")
+	if err := os.WriteFile(fitPath, []byte("fake FIT data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+
+	receipt, err := c.Push(context.Background(), fitPath)
+	if !consumer.IsDuplicate(err) {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+	if receipt == nil || !receipt.Duplicate || receipt.RemoteID != "i789" {
+		t.Errorf("expected duplicate receipt for i789, got %+v", receipt)
+	}
+}
+
+func TestConsumer_ActivityURL(t *testing.T) {
+	c := New("athlete123", "apikey456")
+	c.BaseURL = "https://intervals.icu/"
+	if got := c.ActivityURL("i42"); got != "https://intervals.icu/activities/i42" {
+		t.Errorf("unexpected activity URL %q", got)
+	}
+}
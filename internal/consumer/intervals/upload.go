@@ -0,0 +1,88 @@
+package intervals
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+)
+
+// uploadBody streams a multipart upload form through a pipe, so the FIT file
+// is never held in memory. The form is written by a goroutine as the HTTP
+// client reads it.
+type uploadBody struct {
+	*io.PipeReader
+	contentType string
+
+	done chan struct{}
+	err  error
+}
+
+// newUploadBody starts writing the upload form for src. If compress is set
+// the file is gzipped on the fly and sent as filename.gz, which Intervals.icu
+// accepts.
+func newUploadBody(src io.Reader, filename, activityName string, compress bool) *uploadBody {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	b := &uploadBody{
+		PipeReader:  pr,
+		contentType: writer.FormDataContentType(),
+		done:        make(chan struct{}),
+	}
+
+	go func() {
+		defer close(b.done)
+		b.err = writeUploadForm(writer, src, filename, activityName, compress)
+		// Closing with a nil error signals EOF to the reader.
+		_ = pw.CloseWithError(b.err)
+	}()
+
+	return b
+}
+
+func writeUploadForm(writer *multipart.Writer, src io.Reader, filename, activityName string, compress bool) error {
+	if activityName != "" {
+		if err := writer.WriteField("name", activityName); err != nil {
+			return fmt.Errorf("write name field: %w", err)
+		}
+	}
+
+	if compress {
+		filename += ".gz"
+	}
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return fmt.Errorf("create form file: %w", err)
+	}
+
+	if compress {
+		gz := gzip.NewWriter(part)
+		if _, err := io.Copy(gz, src); err != nil {
+			return fmt.Errorf("copy file: %w", err)
+		}
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("compress file: %w", err)
+		}
+	} else if _, err := io.Copy(part, src); err != nil {
+		return fmt.Errorf("copy file: %w", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("close writer: %w", err)
+	}
+	return nil
+}
+
+// wait stops the writer if it is still running and returns the error it
+// hit, if any. A writer stopped because the client stopped reading (the
+// request failed, or the server answered early) is not an error.
+func (b *uploadBody) wait() error {
+	_ = b.PipeReader.CloseWithError(io.ErrClosedPipe)
+	<-b.done
+	if errors.Is(b.err, io.ErrClosedPipe) {
+		return nil
+	}
+	return b.err
+}
@@ -0,0 +1,166 @@
+package intervals
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// wireUpload is what an upload looked like on the wire.
+type wireUpload struct {
+	transferEncoding []string
+	name             string
+	filename         string
+	content          []byte
+}
+
+// captureServer records the multipart form of each upload.
+func captureServer(t *testing.T) (*httptest.Server, *wireUpload) {
+	got := &wireUpload{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.transferEncoding = r.TransferEncoding
+
+		reader, err := r.MultipartReader()
+		if err != nil {
+			t.Errorf("expected multipart body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Errorf("read part: %v", err)
+				break
+			}
+			data, _ := io.ReadAll(part)
+			switch part.FormName() {
+			case "name":
+				got.name = string(data)
+			case "file":
+				got.filename = part.FileName()
+				got.content = data
+			}
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id": "i1"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, got
+}
+
+func writeTestFit(t *testing.T, name string, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestConsumer_Push_StreamsFileOnWire(t *testing.T) {
+	server, got := captureServer(t)
+	fitPath, data := writeTestFit(t, "2025-02-23_Coffee_Trail.fit", 1<<20)
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+
+	if _, err := c.Push(context.Background(), fitPath); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	// A streamed body has no known length, so it's sent chunked.
+	if len(got.transferEncoding) == 0 || got.transferEncoding[0] != "chunked" {
+		t.Errorf("expected chunked transfer encoding, got %v", got.transferEncoding)
+	}
+	if got.name != "Coffee Trail" {
+		t.Errorf("expected name field, got %q", got.name)
+	}
+	if got.filename != "2025-02-23_Coffee_Trail.fit" {
+		t.Errorf("unexpected filename %q", got.filename)
+	}
+	if !bytes.Equal(got.content, data) {
+		t.Errorf("file content differs on the wire (%d bytes, want %d)", len(got.content), len(data))
+	}
+}
+
+func TestConsumer_Push_Gzip(t *testing.T) {
+	server, got := captureServer(t)
+	fitPath, data := writeTestFit(t, "ride.fit", 256<<10)
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+	c.Compress = true
+
+	if _, err := c.Push(context.Background(), fitPath); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	if got.filename != "ride.fit.gz" {
+		t.Errorf("expected .fit.gz filename, got %q", got.filename)
+	}
+	if len(got.content) >= len(data) {
+		t.Errorf("expected compressed upload smaller than %d bytes, got %d", len(data), len(got.content))
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(got.content))
+	if err != nil {
+		t.Fatalf("upload is not gzip: %v", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !bytes.Equal(plain, data) {
+		t.Error("decompressed upload differs from the original file")
+	}
+}
+
+func TestConsumer_Push_EarlyResponseStopsWriter(t *testing.T) {
+	// The server rejects the upload without reading the body; Push must
+	// not hang waiting for the form writer.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	fitPath, _ := writeTestFit(t, "ride.fit", 8<<20)
+
+	c := New("athlete123", "apikey456")
+	c.BaseURL = server.URL
+
+	_, err := c.Push(context.Background(), fitPath)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestUploadBody_PropagatesReadError(t *testing.T) {
+	readErr := errors.New("disk on fire")
+	body := newUploadBody(io.MultiReader(bytes.NewReader([]byte("partial")), errReader{readErr}), "ride.fit", "", false)
+
+	if _, err := io.ReadAll(body); !errors.Is(err, readErr) {
+		t.Errorf("expected reader to see %v, got %v", readErr, err)
+	}
+	if err := body.wait(); !errors.Is(err, readErr) {
+		t.Errorf("expected wait to return %v, got %v", readErr, err)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,150 @@
+// Package pathtmpl renders where a FIT file goes at a destination, such as
+// a library directory, bucket key or WebDAV path, from a template over the
+// file's metadata.
+package pathtmpl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// Default files activities by year and month, named by date, sport and
+// title.
+const Default = "{{year}}/{{month}}/{{date}}_{{sport}}_{{name}}.fit"
+
+// Template is a parsed path template.
+type Template struct {
+	tmpl *template.Template
+}
+
+// funcs returns the template functions for one file: those of
+// delivery.Funcs, with {{date}} giving "2025-02-23", and these, whose
+// results are safe to use as (part of) a path component.
+//
+//	year, month, day  start time, zero-padded ("2025", "02", "23")
+//	time              "063000"
+//	sport             lower-cased activity type, or "activity"
+//	name              title from the filename, or the filename itself
+//	hash              first 12 hex digits of the file's SHA-256
+func funcs(data *delivery.TemplateData, start time.Time) template.FuncMap {
+	fm := delivery.Funcs(start)
+	fm["year"] = func() string { return start.Format("2006") }
+	fm["month"] = func() string { return start.Format("01") }
+	fm["day"] = func() string { return start.Format("02") }
+	fm["time"] = func() string { return start.Format("150405") }
+	fm["sport"] = func() string {
+		if data.ActivityType == "" {
+			return "activity"
+		}
+		return Sanitize(strings.ToLower(data.ActivityType))
+	}
+	fm["name"] = func() string {
+		if data.Title != "" {
+			return Sanitize(data.Title)
+		}
+		return Sanitize(strings.TrimSuffix(data.Filename, filepath.Ext(data.Filename)))
+	}
+	fm["hash"] = func() string {
+		if len(data.Hash) < 12 {
+			return data.Hash
+		}
+		return data.Hash[:12]
+	}
+	return fm
+}
+
+// Parse parses a path template. The functions are bound per file when the
+// template is rendered.
+func Parse(text string) (*Template, error) {
+	if text == "" {
+		return nil, errors.New("path template is empty")
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").
+		Funcs(funcs(&delivery.TemplateData{}, time.Time{})).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse path template %q: %w", text, err)
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// MustParse is like Parse but panics if the template is invalid. It is for
+// templates built into the program, such as Default.
+func MustParse(text string) *Template {
+	t, err := Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Render executes the template for a file and returns the resulting path,
+// relative to the destination's root and separated by forward slashes. It
+// fails if the result would land outside the root. ".fit" is added if the
+// result has no extension.
+func (t *Template) Render(data *delivery.TemplateData, start time.Time) (string, error) {
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Funcs(funcs(data, start)).Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render path: %w", err)
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(strings.TrimSpace(buf.String())))
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("rendered path %q is outside the destination", buf.String())
+	}
+	if filepath.Ext(rel) == "" {
+		rel += ".fit"
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Load gathers the template data for a FIT file and the time to file it
+// under, in local time. Metadata is best-effort: a file that can't be
+// parsed is still filed, under its modification time. The hash always
+// covers the whole file.
+func Load(fitPath string) (*delivery.TemplateData, time.Time, error) {
+	info, err := os.Stat(fitPath)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("stat file: %w", err)
+	}
+
+	meta, _ := fitparser.Parse(fitPath)
+	data := delivery.NewTemplateData(fitPath, meta)
+	start := data.Start()
+	if start.IsZero() {
+		start = info.ModTime()
+	}
+	if data.Hash, err = fitparser.HashFile(fitPath); err != nil {
+		return nil, time.Time{}, fmt.Errorf("hash file: %w", err)
+	}
+	return data, start.Local(), nil
+}
+
+// Sanitize makes s safe to use within a file name on any platform by
+// replacing separators and reserved characters.
+func Sanitize(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20, strings.ContainsRune(`<>:"/\|?*`, r):
+			return '-'
+		case r == ' ':
+			return '_'
+		default:
+			return r
+		}
+	}, s)
+	return strings.Trim(s, ". ")
+}
@@ -0,0 +1,89 @@
+package pathtmpl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+func TestTemplate_Render(t *testing.T) {
+	start := time.Date(2025, 2, 23, 6, 30, 0, 0, time.UTC)
+	data := delivery.NewTemplateData("2025-02-23_Hudayriyat_Ascend.fit", &fitparser.Metadata{
+		ActivityType: "Cycling", Hash: "0123456789abcdef", Manufacturer: "garmin",
+	})
+
+	tests := []struct {
+		tmpl, want string
+	}{
+		{Default, "2025/02/2025-02-23_cycling_Hudayriyat_Ascend.fit"},
+		{"{{.Manufacturer}}/{{time}}-{{hash}}", "garmin/063000-0123456789ab.fit"},
+		{"backup/{{.Filename}}", "backup/2025-02-23_Hudayriyat_Ascend.fit"},
+		{"{{day}}/{{name}}.fit.gz", "23/Hudayriyat_Ascend.fit.gz"},
+		{`{{date "2006"}}/{{lower .ActivityType}}`, "2025/cycling.fit"},
+	}
+	for _, tt := range tests {
+		got, err := MustParse(tt.tmpl).Render(data, start)
+		if err != nil {
+			t.Errorf("Render(%q) failed: %v", tt.tmpl, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestTemplate_RenderOutsideRoot(t *testing.T) {
+	for _, text := range []string{"../{{name}}", "/{{name}}/../..", "a/../../b"} {
+		if _, err := MustParse(text).Render(&delivery.TemplateData{Filename: "x.fit"}, time.Now()); err == nil {
+			t.Errorf("Render(%q) should fail", text)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, text := range []string{"", "{{year", "{{nosuchfunc}}"} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) should fail", text)
+		}
+	}
+}
+
+func TestLoad_UnparseableFileUsesModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.fit")
+	if err := os.WriteFile(path, []byte("not a fit file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 9, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	data, start, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !start.Equal(mtime) || data.Filename != "corrupt.fit" || len(data.Hash) != 64 {
+		t.Errorf("unexpected data %+v at %v", data, start)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Hudayriyat Ascend", "Hudayriyat_Ascend"},
+		{"a/b\\c", "a-b-c"},
+		{"what? <this>", "what-_-this-"},
+		{"..hidden.", "hidden"},
+	}
+	for _, tt := range tests {
+		if got := Sanitize(tt.in); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
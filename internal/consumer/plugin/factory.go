@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"errors"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+func init() {
+	consumer.Register("plugin", newFromSpec)
+}
+
+// settings are the config keys of a plugin consumer.
+type settings struct {
+	Command string            `toml:"command"`
+	Args    []string          `toml:"args"`
+	Env     map[string]string `toml:"env"`
+	Config  map[string]any    `toml:"config"`
+	Timeout string            `toml:"timeout"`
+}
+
+// newFromSpec builds a consumer from its [[consumers]] entry.
+func newFromSpec(spec consumer.Spec) (consumer.Consumer, error) {
+	var s settings
+	if err := spec.Settings.Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Command == "" {
+		return nil, errors.New("command is required")
+	}
+
+	c := New(s.Command, s.Args...)
+	c.name = spec.Name
+	c.Env = s.Env
+	c.Config = s.Config
+	c.SetLogger(spec.Logger)
+	if s.Timeout != "" {
+		d, err := time.ParseDuration(s.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.Timeout = d
+	}
+	return c, nil
+}
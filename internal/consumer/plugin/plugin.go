@@ -0,0 +1,232 @@
+// Package plugin provides a consumer that delegates pushes to an external
+// process, so destinations can be scripted without changing fitwatch.
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+const (
+	defaultName = "plugin"
+
+	// DefaultTimeout bounds each request to the plugin.
+	DefaultTimeout = 5 * time.Minute
+
+	// closeGrace is how long Close waits for the plugin to exit on its own.
+	closeGrace = 5 * time.Second
+)
+
+// Consumer runs a plugin executable and sends it requests over the
+// protocol described in protocol.go. The process is started on first use
+// and restarted if it exits; a request that times out or is canceled
+// kills it.
+type Consumer struct {
+	Command string
+	Args    []string
+
+	// Env is added to fitwatch's own environment.
+	Env map[string]string
+
+	// Config is sent to the plugin with each validate request.
+	Config map[string]any
+
+	// Timeout bounds each request; zero means no limit beyond the
+	// caller's context.
+	Timeout time.Duration
+
+	name   string
+	logger *slog.Logger
+
+	mu     sync.Mutex // serialises requests
+	proc   *process
+	nextID int64
+}
+
+// New creates a plugin consumer that runs command with args.
+func New(command string, args ...string) *Consumer {
+	return &Consumer{
+		Command: command,
+		Args:    args,
+		Timeout: DefaultTimeout,
+		name:    defaultName,
+		logger:  slog.Default(),
+	}
+}
+
+// SetLogger configures the logger for the consumer.
+func (c *Consumer) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Name returns the consumer's instance name.
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Validate starts the plugin if needed and asks it to check its
+// configuration.
+func (c *Consumer) Validate() error {
+	if c.Command == "" {
+		return errors.New("command is required")
+	}
+
+	resp, err := c.call(context.Background(), Request{
+		Method: MethodValidate,
+		Name:   c.name,
+		Config: c.Config,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != StatusOK {
+		return fmt.Errorf("plugin: %s", resp.errorMessage())
+	}
+	return nil
+}
+
+// Push asks the plugin to push a FIT file.
+func (c *Consumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	if c.Command == "" {
+		return nil, consumer.Permanent(errors.New("command is required"))
+	}
+
+	req := Request{Method: MethodPush, Path: fitPath}
+	// Metadata is best-effort: a file we can't parse is still pushed.
+	if meta, err := fitparser.Parse(fitPath); err == nil {
+		req.Metadata = meta
+	}
+
+	resp, err := c.call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.Status {
+	case StatusOK:
+		return &consumer.Receipt{RemoteID: resp.RemoteID, RemoteURL: resp.RemoteURL}, nil
+
+	case StatusDuplicate:
+		return &consumer.Receipt{RemoteID: resp.RemoteID, RemoteURL: resp.RemoteURL, Duplicate: true}, nil
+
+	case StatusError:
+		err := fmt.Errorf("plugin: %s", resp.errorMessage())
+		switch {
+		case resp.RetryAfter > 0:
+			return nil, consumer.RateLimited(err, time.Duration(resp.RetryAfter*float64(time.Second)))
+		case resp.Retryable:
+			return nil, consumer.Retryable(err)
+		default:
+			return nil, consumer.Permanent(err)
+		}
+
+	default:
+		return nil, consumer.Permanent(fmt.Errorf("plugin: unknown status %q", resp.Status))
+	}
+}
+
+// Close asks the plugin to exit by closing its stdin, and kills it if it
+// hasn't within a few seconds.
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.proc
+	if p == nil {
+		return nil
+	}
+	c.proc = nil
+
+	_ = p.stdin.Close()
+	timer := time.NewTimer(closeGrace)
+	defer timer.Stop()
+	go func() {
+		for range p.responses {
+		}
+	}()
+	select {
+	case <-p.done:
+		return nil
+	case <-timer.C:
+		c.logger.Warn("plugin did not exit, killing it", "consumer", c.name)
+		p.kill()
+		return nil
+	}
+}
+
+// call sends a request and waits for its response, starting the plugin if
+// it isn't running. If ctx is done or the timeout passes first, the plugin
+// is killed.
+func (c *Consumer) call(ctx context.Context, req Request) (*Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.proc == nil {
+		p, err := startProcess(c.Command, c.Args, c.Env, c.logger.With("consumer", c.name))
+		if err != nil {
+			return nil, consumer.Permanent(err)
+		}
+		c.proc = p
+	}
+	p := c.proc
+
+	callCtx := ctx
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	c.nextID++
+	req.ID = c.nextID
+	if err := p.send(req); err != nil {
+		c.stop()
+		return nil, consumer.Retryable(fmt.Errorf("plugin: send %s: %w", req.Method, err))
+	}
+
+	for {
+		select {
+		case resp, ok := <-p.responses:
+			if !ok {
+				<-p.done
+				c.proc = nil
+				return nil, consumer.Retryable(fmt.Errorf("plugin exited during %s: %v", req.Method, p.err))
+			}
+			if resp.ID != req.ID {
+				c.logger.Warn("ignoring plugin response for another request", "consumer", c.name, "id", resp.ID, "want", req.ID)
+				continue
+			}
+			return &resp, nil
+
+		case <-callCtx.Done():
+			c.stop()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, consumer.Retryable(fmt.Errorf("plugin: %s timed out after %s", req.Method, c.Timeout))
+		}
+	}
+}
+
+// stop kills the running plugin; the next request starts a new one.
+// c.mu must be held.
+func (c *Consumer) stop() {
+	if c.proc != nil {
+		c.proc.kill()
+		c.proc = nil
+	}
+}
+
+func (r *Response) errorMessage() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	return "status " + r.Status
+}
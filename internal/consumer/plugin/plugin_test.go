@@ -0,0 +1,300 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+// helperEnv selects the fake plugin's behaviour when the test binary is run
+// as a plugin.
+const helperEnv = "FITWATCH_TEST_PLUGIN"
+
+// TestHelperPlugin isn't a real test: it is the plugin the other tests run,
+// by launching the test binary with helperEnv set.
+func TestHelperPlugin(t *testing.T) {
+	mode := os.Getenv(helperEnv)
+	if mode == "" {
+		t.Skip("helper process")
+	}
+	if mode == "sleep" {
+		// A child left behind by the "fork" plugin.
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+	runFakePlugin(mode)
+	os.Exit(0)
+}
+
+// runFakePlugin answers requests on stdin according to mode.
+func runFakePlugin(mode string) {
+	fmt.Fprintln(os.Stderr, "fake plugin starting")
+	scanner := bufio.NewScanner(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	pushes := 0
+
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			os.Exit(2)
+		}
+		resp := Response{ID: req.ID, Status: StatusOK}
+
+		switch req.Method {
+		case MethodValidate:
+			if mode == "invalid" || req.Config["token"] != "secret" {
+				resp.Status = StatusError
+				resp.Error = "token rejected"
+			}
+
+		case MethodPush:
+			pushes++
+			switch mode {
+			case "ok":
+				resp.RemoteID = fmt.Sprintf("%s#%d", filepath.Base(req.Path), pushes)
+				if req.Metadata != nil {
+					resp.RemoteURL = "https://example.com/" + req.Metadata.Hash
+				}
+			case "duplicate":
+				resp.Status = StatusDuplicate
+				resp.RemoteID = "existing"
+			case "retryable":
+				resp.Status = StatusError
+				resp.Error = "server busy"
+				resp.Retryable = true
+			case "ratelimited":
+				resp.Status = StatusError
+				resp.Error = "slow down"
+				resp.Retryable = true
+				resp.RetryAfter = 30
+			case "permanent":
+				resp.Status = StatusError
+				resp.Error = "file rejected"
+			case "noise":
+				fmt.Println("not json")
+				_ = enc.Encode(Response{ID: req.ID - 1, Status: StatusOK})
+				resp.RemoteID = "after-noise"
+			case "hang":
+				time.Sleep(time.Minute)
+			case "fork":
+				// Start a child that holds stdout open, like a script's
+				// sleep or curl, then hang.
+				child := exec.Command(os.Args[0], "-test.run=^TestHelperPlugin$")
+				child.Env = append(os.Environ(), helperEnv+"=sleep")
+				child.Stdout = os.Stdout
+				child.Stderr = os.Stderr
+				if err := child.Start(); err != nil {
+					os.Exit(4)
+				}
+				time.Sleep(time.Minute)
+			case "crash":
+				os.Exit(3)
+			}
+		}
+		_ = enc.Encode(resp)
+	}
+}
+
+// newHelper returns a consumer that runs the fake plugin in mode.
+func newHelper(t *testing.T, mode string) *Consumer {
+	t.Helper()
+	c := New(os.Args[0], "-test.run=^TestHelperPlugin$")
+	c.Env = map[string]string{helperEnv: mode}
+	c.Config = map[string]any{"token": "secret"}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func sampleFitPath(t *testing.T) string {
+	t.Helper()
+	_, thisFile, _, _ := runtime.Caller(0)
+	path := filepath.Join(filepath.Dir(thisFile), "..", "..", "..", "testdata", "sample.fit")
+	if _, err := os.Stat(path); err != nil {
+		t.Skipf("sample.fit not available: %v", err)
+	}
+	return path
+}
+
+func TestConsumer_Validate(t *testing.T) {
+	if err := newHelper(t, "ok").Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	c := newHelper(t, "ok")
+	c.Config = map[string]any{"token": "wrong"}
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected plugin to reject config")
+	}
+
+	if err := New("").Validate(); err == nil {
+		t.Fatal("expected error for missing command")
+	}
+}
+
+func TestConsumer_Validate_CommandNotFound(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "no-such-plugin"))
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected error for missing executable")
+	}
+}
+
+func TestConsumer_Push(t *testing.T) {
+	c := newHelper(t, "ok")
+	fitPath := sampleFitPath(t)
+
+	for i := 1; i <= 2; i++ {
+		receipt, err := c.Push(context.Background(), fitPath)
+		if err != nil {
+			t.Fatalf("Push %d failed: %v", i, err)
+		}
+		// One process handles both pushes.
+		if want := fmt.Sprintf("sample.fit#%d", i); receipt.RemoteID != want {
+			t.Errorf("expected %s, got %s", want, receipt.RemoteID)
+		}
+		if receipt.RemoteURL == "https://example.com/" || receipt.RemoteURL == "" {
+			t.Errorf("expected metadata hash in URL, got %q", receipt.RemoteURL)
+		}
+	}
+}
+
+func TestConsumer_Push_Statuses(t *testing.T) {
+	tests := []struct {
+		mode          string
+		wantDuplicate bool
+		wantPermanent bool
+		wantRetryable bool
+		wantWait      time.Duration
+	}{
+		{mode: "duplicate", wantDuplicate: true},
+		{mode: "retryable", wantRetryable: true},
+		{mode: "ratelimited", wantRetryable: true, wantWait: 30 * time.Second},
+		{mode: "permanent", wantPermanent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			receipt, err := newHelper(t, tt.mode).Push(context.Background(), sampleFitPath(t))
+			if tt.wantDuplicate {
+				if err != nil || !receipt.Duplicate || receipt.RemoteID != "existing" {
+					t.Fatalf("expected duplicate receipt, got %+v, %v", receipt, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if consumer.IsPermanent(err) != tt.wantPermanent {
+				t.Errorf("IsPermanent = %v, want %v", consumer.IsPermanent(err), tt.wantPermanent)
+			}
+			if consumer.IsRetryable(err) != tt.wantRetryable {
+				t.Errorf("IsRetryable = %v, want %v", consumer.IsRetryable(err), tt.wantRetryable)
+			}
+			if wait, _ := consumer.RetryAfter(err); wait != tt.wantWait {
+				t.Errorf("RetryAfter = %v, want %v", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_IgnoresNoise(t *testing.T) {
+	receipt, err := newHelper(t, "noise").Push(context.Background(), sampleFitPath(t))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if receipt.RemoteID != "after-noise" {
+		t.Errorf("expected after-noise, got %s", receipt.RemoteID)
+	}
+}
+
+func TestConsumer_Push_CrashIsRetryableAndRestarts(t *testing.T) {
+	c := newHelper(t, "crash")
+
+	_, err := c.Push(context.Background(), sampleFitPath(t))
+	if err == nil || !consumer.IsRetryable(err) {
+		t.Fatalf("expected retryable error, got %v", err)
+	}
+
+	// The next request starts a fresh process.
+	c.Env[helperEnv] = "ok"
+	if _, err := c.Push(context.Background(), sampleFitPath(t)); err != nil {
+		t.Fatalf("Push after crash failed: %v", err)
+	}
+}
+
+func TestConsumer_Push_TimeoutKills(t *testing.T) {
+	c := newHelper(t, "hang")
+	c.Timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	_, err := c.Push(context.Background(), sampleFitPath(t))
+	if err == nil || !consumer.IsRetryable(err) {
+		t.Fatalf("expected retryable timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("timeout took %v", elapsed)
+	}
+	if c.proc != nil {
+		t.Error("expected timed-out plugin to be stopped")
+	}
+}
+
+func TestConsumer_Push_TimeoutKillsChildren(t *testing.T) {
+	c := newHelper(t, "fork")
+	c.Timeout = 500 * time.Millisecond
+
+	start := time.Now()
+	_, err := c.Push(context.Background(), sampleFitPath(t))
+	if err == nil || !consumer.IsRetryable(err) {
+		t.Fatalf("expected retryable timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("timeout took %v", elapsed)
+	}
+	if c.proc != nil {
+		t.Error("expected timed-out plugin to be stopped")
+	}
+}
+
+func TestConsumer_Push_CancelKills(t *testing.T) {
+	c := newHelper(t, "hang")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	_, err := c.Push(ctx, sampleFitPath(t))
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if c.proc != nil {
+		t.Error("expected canceled plugin to be stopped")
+	}
+}
+
+func TestConsumer_Close(t *testing.T) {
+	c := newHelper(t, "ok")
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	p := c.proc
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	select {
+	case <-p.done:
+	default:
+		t.Fatal("expected plugin to have exited")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// maxLineSize bounds a single response line.
+const maxLineSize = 1 << 20
+
+// waitDelay is how long to wait for the plugin's output to close after it
+// exits. Children the plugin started may hold its stdout and stderr open
+// after it has been killed.
+const waitDelay = 2 * time.Second
+
+// process is a running plugin.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	// responses carries decoded stdout lines; it is closed when stdout is.
+	responses chan Response
+
+	// done is closed once the process has exited; err is then its exit
+	// status.
+	done chan struct{}
+	err  error
+}
+
+// startProcess launches the plugin and starts reading its output. The
+// plugin runs in its own process group, so that kill stops anything it
+// started too.
+func startProcess(command string, args []string, env map[string]string, logger *slog.Logger) (*process, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	setProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// Output goes through io.Pipes rather than StdoutPipe so that Wait owns
+	// the copying, and gives up on it after WaitDelay.
+	stdout, stdoutW := io.Pipe()
+	stderr, stderrW := io.Pipe()
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", command, err)
+	}
+
+	p := &process{
+		cmd:       cmd,
+		stdin:     stdin,
+		responses: make(chan Response),
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.responses)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+		for scanner.Scan() {
+			var resp Response
+			if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+				logger.Warn("ignoring malformed plugin output", "line", scanner.Text(), "error", err)
+				continue
+			}
+			p.responses <- resp
+		}
+		// Drain anything left so the process isn't blocked writing.
+		_, _ = io.Copy(io.Discard, stdout)
+	}()
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Info("plugin output", "line", scanner.Text())
+		}
+		_, _ = io.Copy(io.Discard, stderr)
+	}()
+	go func() {
+		p.err = cmd.Wait()
+		_ = stdoutW.Close()
+		_ = stderrW.Close()
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// send writes a request line.
+func (p *process) send(req Request) error {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = p.stdin.Write(append(line, '\n'))
+	return err
+}
+
+// kill stops the process and any children it started, and waits for it
+// to exit.
+func (p *process) kill() {
+	_ = p.stdin.Close()
+	killProcess(p.cmd)
+	p.drainAndWait()
+}
+
+// drainAndWait discards any unread responses until the process exits.
+func (p *process) drainAndWait() {
+	for range p.responses {
+	}
+	<-p.done
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package plugin
+
+import "os/exec"
+
+// setProcessGroup does nothing: process groups are Unix-only. Children the
+// plugin leaves behind are abandoned after waitDelay.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcess kills the plugin.
+func killProcess(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,65 @@
+package plugin
+
+import "github.com/johnazariah/fitwatch/internal/fitparser"
+
+// The protocol is JSON lines: fitwatch writes one Request per line to the
+// plugin's stdin and reads one Response per line from its stdout, matched
+// by ID. Requests are sent one at a time. Anything the plugin writes to
+// stderr is logged. When stdin is closed the plugin should exit.
+//
+//	-> {"id":1,"method":"validate","name":"niche","config":{"token":"..."}}
+//	<- {"id":1,"status":"ok"}
+//	-> {"id":2,"method":"push","path":"/rides/a.fit","metadata":{...}}
+//	<- {"id":2,"status":"ok","remote_id":"123","remote_url":"https://..."}
+//	<- {"id":2,"status":"error","error":"server busy","retryable":true,"retry_after":60}
+
+// Methods a plugin must handle.
+const (
+	MethodValidate = "validate"
+	MethodPush     = "push"
+)
+
+// Response statuses.
+const (
+	// StatusOK means the request succeeded.
+	StatusOK = "ok"
+
+	// StatusDuplicate means the destination already has the file; it
+	// counts as synced.
+	StatusDuplicate = "duplicate"
+
+	// StatusError means the request failed; see Error and Retryable.
+	StatusError = "error"
+)
+
+// Request is a line sent to the plugin.
+type Request struct {
+	ID     int64  `json:"id"`
+	Method string `json:"method"`
+
+	// Name and Config are sent with validate: the instance name and the
+	// entry's [consumers.config] table.
+	Name   string         `json:"name,omitempty"`
+	Config map[string]any `json:"config,omitempty"`
+
+	// Path and Metadata are sent with push. Metadata is omitted if the
+	// file couldn't be parsed.
+	Path     string              `json:"path,omitempty"`
+	Metadata *fitparser.Metadata `json:"metadata,omitempty"`
+}
+
+// Response is a line read from the plugin.
+type Response struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+
+	RemoteID  string `json:"remote_id,omitempty"`
+	RemoteURL string `json:"remote_url,omitempty"`
+
+	// Error describes a failure. The push is retried later if Retryable is
+	// set, after at least RetryAfter seconds if given; otherwise it is
+	// given up on.
+	Error      string  `json:"error,omitempty"`
+	Retryable  bool    `json:"retryable,omitempty"`
+	RetryAfter float64 `json:"retry_after,omitempty"`
+}
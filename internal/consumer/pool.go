@@ -0,0 +1,196 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+)
+
+// Limits bound the work queued for a single consumer.
+type Limits struct {
+	// Concurrency is the number of pushes to the consumer that may run at once.
+	Concurrency int
+
+	// QueueSize is the number of pushes that may wait for a free worker.
+	// Dispatching blocks while the queue is full.
+	// Zero values fall back to DefaultLimits.
+	QueueSize int
+}
+
+// DefaultLimits returns limits suited to a typical web API: one upload at
+// a time, with room for a bulk backfill to queue up.
+func DefaultLimits() Limits {
+	return Limits{
+		Concurrency: 1,
+		QueueSize:   64,
+	}
+}
+
+// job is a single push waiting in a consumer's queue.
+type job struct {
+	ctx     context.Context
+	fitPath string
+	result  chan<- Result
+}
+
+// lane is one consumer's queue and the workers draining it.
+type lane struct {
+	consumer Consumer
+	jobs     chan job
+}
+
+// SetLimits configures the queue and concurrency limits for the named
+// consumer. It must be called before Start.
+func (d *Dispatcher) SetLimits(name string, l Limits) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.limits == nil {
+		d.limits = make(map[string]Limits)
+	}
+	d.limits[name] = l
+}
+
+func (d *Dispatcher) limitsFor(name string) Limits {
+	l, ok := d.limits[name]
+	if !ok {
+		return DefaultLimits()
+	}
+	def := DefaultLimits()
+	if l.Concurrency < 1 {
+		l.Concurrency = def.Concurrency
+	}
+	if l.QueueSize < 1 {
+		l.QueueSize = def.QueueSize
+	}
+	return l
+}
+
+// Start launches a worker pool for each consumer. Once started, pushes to
+// different consumers run independently, so a slow consumer can't hold up
+// the others, and each consumer runs at most its configured number of
+// pushes at a time. Workers stop when ctx is canceled; Wait blocks until
+// they have.
+//
+// Without Start, dispatching pushes to each consumer in turn on the
+// caller's goroutine.
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lanes != nil {
+		return
+	}
+
+	d.poolCtx = ctx
+	d.lanes = make(map[string]*lane, len(d.consumers))
+	for _, c := range d.consumers {
+		l := d.limitsFor(c.Name())
+		ln := &lane{consumer: c, jobs: make(chan job, l.QueueSize)}
+		d.lanes[c.Name()] = ln
+		for i := 0; i < l.Concurrency; i++ {
+			d.workers.Add(1)
+			go d.work(ctx, ln)
+		}
+		d.logger.Debug("started consumer workers", "consumer", c.Name(), "concurrency", l.Concurrency, "queue", l.QueueSize)
+	}
+}
+
+// Wait blocks until the workers launched by Start have exited.
+func (d *Dispatcher) Wait() {
+	d.workers.Wait()
+}
+
+func (d *Dispatcher) work(ctx context.Context, ln *lane) {
+	defer d.workers.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-ln.jobs:
+			j.result <- d.push(j.ctx, ln.consumer, j.fitPath, true)
+		}
+	}
+}
+
+// DispatchAsync sends a FIT file to the named consumers without waiting.
+// Each consumer's result is delivered on the returned channel as soon as it
+// is known, and the channel is closed once every consumer has reported.
+// Names that don't match a registered consumer are ignored, and consumers
+// whose filter doesn't match the file report a skipped result straight away.
+func (d *Dispatcher) DispatchAsync(ctx context.Context, fitPath string, names []string) <-chan Result {
+	targets, skips := d.route(fitPath, d.targets(names))
+	out := make(chan Result, len(targets)+len(skips))
+	for _, r := range skips {
+		out <- r
+	}
+
+	d.mu.Lock()
+	lanes, poolCtx := d.lanes, d.poolCtx
+	d.mu.Unlock()
+
+	if lanes == nil {
+		go func() {
+			defer close(out)
+			for _, c := range targets {
+				out <- d.push(ctx, c, fitPath, false)
+			}
+		}()
+		return out
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range targets {
+		wg.Add(1)
+		go func(c Consumer, ln *lane) {
+			defer wg.Done()
+			out <- d.enqueue(ctx, poolCtx, ln, c, fitPath)
+		}(c, lanes[c.Name()])
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// enqueue queues a push on a consumer's lane and waits for its result.
+// If ctx or the pool is canceled first, the result carries that error.
+func (d *Dispatcher) enqueue(ctx, poolCtx context.Context, ln *lane, c Consumer, fitPath string) Result {
+	canceled := func(err error) Result {
+		return Result{Consumer: c.Name(), FitPath: fitPath, Error: err}
+	}
+
+	result := make(chan Result, 1)
+	select {
+	case ln.jobs <- job{ctx: ctx, fitPath: fitPath, result: result}:
+	case <-ctx.Done():
+		return canceled(ctx.Err())
+	case <-poolCtx.Done():
+		return canceled(poolCtx.Err())
+	}
+
+	select {
+	case r := <-result:
+		return r
+	case <-ctx.Done():
+		return canceled(ctx.Err())
+	case <-poolCtx.Done():
+		return canceled(poolCtx.Err())
+	}
+}
+
+// targets returns the registered consumers named in names, in
+// registration order.
+func (d *Dispatcher) targets(names []string) []Consumer {
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[n] = true
+	}
+
+	var targets []Consumer
+	for _, c := range d.consumers {
+		if wanted[c.Name()] {
+			targets = append(targets, c)
+		}
+	}
+	return targets
+}
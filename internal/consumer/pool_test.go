@@ -0,0 +1,212 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedConsumer blocks each push until released, and tracks how many
+// pushes are in flight.
+type gatedConsumer struct {
+	name string
+	gate chan struct{}
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	pushed      []string
+}
+
+func newGatedConsumer(name string) *gatedConsumer {
+	return &gatedConsumer{name: name, gate: make(chan struct{})}
+}
+
+func (g *gatedConsumer) Name() string    { return g.name }
+func (g *gatedConsumer) Validate() error { return nil }
+
+func (g *gatedConsumer) Push(ctx context.Context, fitPath string) (*Receipt, error) {
+	g.mu.Lock()
+	g.inFlight++
+	g.maxInFlight = max(g.maxInFlight, g.inFlight)
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		g.inFlight--
+		g.pushed = append(g.pushed, fitPath)
+		g.mu.Unlock()
+	}()
+
+	select {
+	case <-g.gate:
+		return &Receipt{RemoteID: g.name + ":" + fitPath}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (g *gatedConsumer) open() { close(g.gate) }
+
+func (g *gatedConsumer) peak() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.maxInFlight
+}
+
+func TestDispatcher_SlowConsumerDoesNotBlockOthers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow := newGatedConsumer("slow")
+	fast := newGatedConsumer("fast")
+	fast.open()
+
+	d := NewDispatcher(slow, fast)
+	d.Start(ctx)
+
+	results := d.DispatchAsync(ctx, "ride.fit", []string{"slow", "fast"})
+
+	select {
+	case r := <-results:
+		if r.Consumer != "fast" || !r.Success {
+			t.Fatalf("expected fast consumer to report first, got %+v", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fast consumer was blocked by slow consumer")
+	}
+
+	slow.open()
+	r, ok := <-results
+	if !ok || r.Consumer != "slow" || !r.Success {
+		t.Fatalf("expected slow result, got %+v (ok=%v)", r, ok)
+	}
+	if _, ok := <-results; ok {
+		t.Error("expected channel to close after all results")
+	}
+}
+
+func TestDispatcher_ConcurrencyLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := newGatedConsumer("limited")
+	d := NewDispatcher(c)
+	d.SetLimits("limited", Limits{Concurrency: 2, QueueSize: 10})
+	d.Start(ctx)
+
+	var all []<-chan Result
+	for i := 0; i < 6; i++ {
+		all = append(all, d.DispatchAsync(ctx, fmt.Sprintf("ride%d.fit", i), []string{"limited"}))
+	}
+
+	// Let the workers pick up as much as they're allowed to.
+	time.Sleep(100 * time.Millisecond)
+	c.open()
+
+	for _, ch := range all {
+		for r := range ch {
+			if !r.Success {
+				t.Errorf("unexpected failure: %+v", r)
+			}
+		}
+	}
+	if c.peak() != 2 {
+		t.Errorf("expected at most 2 concurrent pushes, peak was %d", c.peak())
+	}
+}
+
+func TestDispatcher_QueueIsBounded(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := newGatedConsumer("busy")
+	defer c.open()
+	d := NewDispatcher(c)
+	d.SetLimits("busy", Limits{Concurrency: 1, QueueSize: 1})
+	d.Start(ctx)
+
+	// One push runs, one waits in the queue...
+	first := d.DispatchAsync(ctx, "a.fit", []string{"busy"})
+	second := d.DispatchAsync(ctx, "b.fit", []string{"busy"})
+	time.Sleep(50 * time.Millisecond)
+
+	// ...and the next can't be queued before its deadline.
+	tctx, tcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer tcancel()
+	r := <-d.DispatchAsync(tctx, "c.fit", []string{"busy"})
+	if r.Success || !errors.Is(r.Error, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded while queue full, got %+v", r)
+	}
+
+	cancel()
+	<-first
+	<-second
+	d.Wait()
+}
+
+func TestDispatcher_DispatchToWithPoolKeepsOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := newGatedConsumer("a")
+	b := newGatedConsumer("b")
+	b.open()
+	d := NewDispatcher(a, b)
+	d.Start(ctx)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		a.open()
+	}()
+
+	results := d.DispatchTo(ctx, "ride.fit", []string{"a", "b"})
+	if len(results) != 2 || results[0].Consumer != "a" || results[1].Consumer != "b" {
+		t.Fatalf("expected results in registration order, got %+v", results)
+	}
+	if results[0].RemoteID != "a:ride.fit" {
+		t.Errorf("unexpected remote ID %q", results[0].RemoteID)
+	}
+}
+
+func TestDispatcher_DispatchAsyncWithoutPool(t *testing.T) {
+	c := newGatedConsumer("inline")
+	c.open()
+	d := NewDispatcher(c)
+
+	var got []Result
+	for r := range d.DispatchAsync(context.Background(), "ride.fit", []string{"inline", "unknown"}) {
+		got = append(got, r)
+	}
+	if len(got) != 1 || !got[0].Success {
+		t.Errorf("expected one successful result, got %+v", got)
+	}
+}
+
+func TestDispatcher_StopsWorkersOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := newGatedConsumer("stuck")
+	d := NewDispatcher(c)
+	d.Start(ctx)
+
+	results := d.DispatchAsync(context.Background(), "ride.fit", []string{"stuck"})
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case r := <-results:
+		if r.Success {
+			t.Errorf("expected canceled result, got %+v", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dispatch didn't return after the pool stopped")
+	}
+
+	// The stuck push still holds a worker until its own context ends.
+	c.open()
+	d.Wait()
+}
@@ -0,0 +1,99 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{Rate: 2, Burst: 2})
+	b.last = now
+
+	// The burst goes straight through
+	for i := 0; i < 2; i++ {
+		if wait := b.reserve(now); wait != 0 {
+			t.Fatalf("push %d: expected no wait within burst, got %v", i+1, wait)
+		}
+	}
+
+	// Then one token every 500ms
+	if wait := b.reserve(now); wait != 500*time.Millisecond {
+		t.Errorf("expected 500ms wait, got %v", wait)
+	}
+	if wait := b.reserve(now); wait != time.Second {
+		t.Errorf("expected 1s wait for the next queued push, got %v", wait)
+	}
+
+	// Tokens refill over time, up to the burst
+	if wait := b.reserve(now.Add(10 * time.Second)); wait != 0 {
+		t.Errorf("expected refilled bucket, got %v", wait)
+	}
+	if b.tokens != 1 {
+		t.Errorf("expected refill capped at burst, %v tokens left", b.tokens)
+	}
+}
+
+func TestTokenBucket_Unlimited(t *testing.T) {
+	b := newTokenBucket(RateLimit{})
+	for i := 0; i < 1000; i++ {
+		if wait := b.reserve(time.Now()); wait != 0 {
+			t.Fatalf("expected no limit, got wait %v", wait)
+		}
+	}
+}
+
+func TestTokenBucket_Pause(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{})
+	b.pause(now.Add(30 * time.Second))
+	b.pause(now.Add(10 * time.Second)) // an earlier pause doesn't shorten it
+
+	if wait := b.reserve(now); wait != 30*time.Second {
+		t.Errorf("expected 30s pause, got %v", wait)
+	}
+}
+
+func TestTokenBucket_WaitHonoursContext(t *testing.T) {
+	b := newTokenBucket(RateLimit{})
+	b.pause(time.Now().Add(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestDispatcher_RateLimitSpacesPushes(t *testing.T) {
+	c := &scriptedConsumer{}
+	d := NewDispatcher(c)
+	d.SetRateLimit("scripted", RateLimit{Rate: 20, Burst: 1})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		d.Dispatch(context.Background(), "ride.fit")
+	}
+	// Three pushes at 20/s with no burst take at least 100ms.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected pushes to be spaced out, took %v", elapsed)
+	}
+}
+
+func TestDispatcher_RetryAfterPausesConsumer(t *testing.T) {
+	c := &scriptedConsumer{errs: []error{RateLimited(errors.New("slow down"), 100*time.Millisecond)}}
+	d := NewDispatcher(c)
+
+	d.Dispatch(context.Background(), "a.fit")
+
+	start := time.Now()
+	r := d.Dispatch(context.Background(), "b.fit")
+	if !r[0].Success {
+		t.Fatalf("expected success, got %+v", r[0])
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected next push to wait for Retry-After, took %v", elapsed)
+	}
+}
@@ -0,0 +1,134 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// Settings gives a factory access to its instance's configuration.
+type Settings interface {
+	// Decode unmarshals the configuration into v, which should use
+	// toml struct tags.
+	Decode(v any) error
+}
+
+// StateStore persists small amounts of consumer state between runs, such
+// as OAuth tokens that the destination rotates. State is keyed by instance
+// name and is opaque to the store.
+type StateStore interface {
+	// LoadState returns the saved state, or nil if there is none.
+	LoadState(ctx context.Context, name string) ([]byte, error)
+
+	// SaveState replaces the saved state.
+	SaveState(ctx context.Context, name string, data []byte) error
+}
+
+// Spec describes a consumer instance to build.
+type Spec struct {
+	// Name is the unique instance name. The built consumer must return it
+	// from Name, since it identifies the instance in the sync store.
+	Name string
+
+	Settings Settings
+	Logger   *slog.Logger
+
+	// State is where the consumer may keep state between runs. Build
+	// supplies an in-memory store if it is nil.
+	State StateStore
+}
+
+// Factory builds a consumer from its spec.
+type Factory func(spec Spec) (Consumer, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a consumer type available by name, for use in the
+// [[consumers]] config. It is intended to be called from the init function
+// of the package implementing the consumer, and panics if the type is
+// registered twice.
+func Register(typ string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if f == nil {
+		panic("consumer: Register factory is nil")
+	}
+	if _, dup := registry[typ]; dup {
+		panic("consumer: Register called twice for type " + typ)
+	}
+	registry[typ] = f
+}
+
+// Build creates a consumer of the given registered type.
+func Build(typ string, spec Spec) (Consumer, error) {
+	registryMu.RLock()
+	f, ok := registry[typ]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown consumer type %q (available: %v)", typ, Types())
+	}
+
+	if spec.Logger == nil {
+		spec.Logger = slog.Default()
+	}
+	if spec.State == nil {
+		spec.State = NewMemoryState()
+	}
+
+	c, err := f(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %w", typ, spec.Name, err)
+	}
+	if c.Name() != spec.Name {
+		return nil, fmt.Errorf("%s %q: consumer reports name %q", typ, spec.Name, c.Name())
+	}
+	return c, nil
+}
+
+// Types returns the registered consumer types, sorted.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]string, 0, len(registry))
+	for typ := range registry {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// MemoryState is a StateStore that keeps state in memory, for tests and
+// for consumers built without a database.
+type MemoryState struct {
+	mu    sync.Mutex
+	state map[string][]byte
+}
+
+// NewMemoryState returns an empty MemoryState.
+func NewMemoryState() *MemoryState {
+	return &MemoryState{state: make(map[string][]byte)}
+}
+
+// LoadState returns a copy of the saved state, or nil if there is none.
+func (m *MemoryState) LoadState(ctx context.Context, name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.state[name]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// SaveState replaces the saved state.
+func (m *MemoryState) SaveState(ctx context.Context, name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state[name] = append([]byte(nil), data...)
+	return nil
+}
@@ -0,0 +1,75 @@
+package consumer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// noSettings is a Settings with nothing to decode.
+type noSettings struct{}
+
+func (noSettings) Decode(v any) error { return nil }
+
+func TestRegistry_Build(t *testing.T) {
+	Register("test-scripted", func(spec Spec) (Consumer, error) {
+		if spec.Logger == nil {
+			t.Error("expected Build to supply a logger")
+		}
+		return &scriptedConsumer{}, nil
+	})
+
+	c, err := Build("test-scripted", Spec{Name: "scripted", Settings: noSettings{}})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if c.Name() != "scripted" {
+		t.Errorf("expected scripted, got %s", c.Name())
+	}
+
+	found := false
+	for _, typ := range Types() {
+		if typ == "test-scripted" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected test-scripted in %v", Types())
+	}
+
+	// The consumer must take the instance name from its spec.
+	if _, err := Build("test-scripted", Spec{Name: "other", Settings: noSettings{}}); err == nil {
+		t.Error("expected error for mismatched name")
+	}
+}
+
+func TestRegistry_BuildUnknownType(t *testing.T) {
+	_, err := Build("no-such-type", Spec{Name: "x", Settings: noSettings{}})
+	if err == nil || !strings.Contains(err.Error(), "unknown consumer type") {
+		t.Fatalf("expected unknown type error, got %v", err)
+	}
+}
+
+func TestRegistry_BuildFactoryError(t *testing.T) {
+	boom := errors.New("bad settings")
+	Register("test-failing", func(spec Spec) (Consumer, error) {
+		return nil, boom
+	})
+
+	_, err := Build("test-failing", Spec{Name: "x", Settings: noSettings{}})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected factory error, got %v", err)
+	}
+}
+
+func TestRegistry_RegisterTwicePanics(t *testing.T) {
+	f := func(spec Spec) (Consumer, error) { return &scriptedConsumer{}, nil }
+	Register("test-twice", f)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	Register("test-twice", f)
+}
@@ -0,0 +1,47 @@
+package s3
+
+import "github.com/johnazariah/fitwatch/internal/consumer"
+
+func init() {
+	consumer.Register("s3", newFromSpec)
+}
+
+// settings are the config keys of an S3 consumer.
+type settings struct {
+	Endpoint        string `toml:"endpoint"`
+	Region          string `toml:"region"`
+	Bucket          string `toml:"bucket"`
+	AccessKeyID     string `toml:"access_key_id"`
+	SecretAccessKey string `toml:"secret_access_key"`
+	SessionToken    string `toml:"session_token"`
+	PathStyle       *bool  `toml:"path_style"`
+	Key             string `toml:"key"`
+}
+
+// newFromSpec builds a consumer from its [[consumers]] entry.
+func newFromSpec(spec consumer.Spec) (consumer.Consumer, error) {
+	var s settings
+	if err := spec.Settings.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	c := New(s.Bucket, s.AccessKeyID, s.SecretAccessKey)
+	c.name = spec.Name
+	c.SetLogger(spec.Logger)
+	c.Endpoint = s.Endpoint
+	c.SessionToken = s.SessionToken
+	if s.Region != "" {
+		c.Region = s.Region
+	}
+	// Self-hosted stores rarely have per-bucket host names.
+	c.PathStyle = s.Endpoint != ""
+	if s.PathStyle != nil {
+		c.PathStyle = *s.PathStyle
+	}
+	if s.Key != "" {
+		if err := c.SetKeyTemplate(s.Key); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
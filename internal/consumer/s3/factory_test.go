@@ -0,0 +1,42 @@
+package s3
+
+import (
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/consumertest"
+)
+
+func TestFactory_Build(t *testing.T) {
+	sc := consumertest.Build[*Consumer](t, "s3", "minio", `
+endpoint = "http://nas.local:9000"
+bucket = "rides"
+access_key_id = "key"
+secret_access_key = "secret"
+key = "{{year}}/{{name}}.fit"
+`)
+	if sc.Bucket != "rides" || sc.Region != defaultRegion {
+		t.Errorf("unexpected consumer: %+v", sc)
+	}
+	if !sc.PathStyle {
+		t.Error("expected path-style addressing for a custom endpoint")
+	}
+}
+
+func TestFactory_AWSDefaults(t *testing.T) {
+	sc := consumertest.Build[*Consumer](t, "s3", "s3", `
+region = "eu-west-1"
+bucket = "rides"
+access_key_id = "key"
+secret_access_key = "secret"
+`)
+	if sc.PathStyle || sc.Region != "eu-west-1" {
+		t.Errorf("unexpected consumer: %+v", sc)
+	}
+	if err := sc.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestFactory_InvalidKeyTemplate(t *testing.T) {
+	consumertest.BuildError(t, "s3", `key = "{{year"`, "parse path template")
+}
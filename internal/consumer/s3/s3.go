@@ -0,0 +1,368 @@
+// Package s3 provides a consumer that backs FIT files up to an
+// S3-compatible object store, such as AWS S3, MinIO or Backblaze B2.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/consumer/pathtmpl"
+)
+
+const (
+	defaultName   = "s3"
+	defaultRegion = "us-east-1"
+
+	// DefaultKeyTemplate files activities by year and month under a
+	// "fitwatch/" prefix.
+	DefaultKeyTemplate = "fitwatch/" + pathtmpl.Default
+
+	// hashMetadata is the user metadata header holding the file's SHA-256.
+	hashMetadata = "X-Amz-Meta-Sha256"
+)
+
+// Consumer uploads FIT files to a bucket, under a key given by a template
+// over the file's metadata.
+type Consumer struct {
+	// Endpoint is the service URL, e.g. "http://nas.local:9000" for MinIO.
+	// Empty means AWS S3 in Region.
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// PathStyle addresses objects as Endpoint/Bucket/Key rather than
+	// Bucket.Endpoint/Key. Most self-hosted stores need it.
+	PathStyle bool
+
+	name   string
+	tmpl   *pathtmpl.Template
+	client *http.Client
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// New creates an S3 consumer for bucket using DefaultKeyTemplate.
+func New(bucket, accessKeyID, secretAccessKey string) *Consumer {
+	return &Consumer{
+		Region:          defaultRegion,
+		Bucket:          bucket,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		name:            defaultName,
+		tmpl:            pathtmpl.MustParse(DefaultKeyTemplate),
+		client:          &http.Client{Timeout: 5 * time.Minute},
+		logger:          slog.Default(),
+		now:             time.Now,
+	}
+}
+
+// SetLogger configures the logger for the consumer.
+func (c *Consumer) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetKeyTemplate sets the template for object keys; see package pathtmpl
+// for the available functions. ".fit" is added if the result has no
+// extension.
+func (c *Consumer) SetKeyTemplate(text string) error {
+	tmpl, err := pathtmpl.Parse(text)
+	if err != nil {
+		return err
+	}
+	c.tmpl = tmpl
+	return nil
+}
+
+// Name returns the consumer's instance name.
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Validate checks configuration.
+func (c *Consumer) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("bucket is required")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return errors.New("access key ID and secret access key are required")
+	}
+	if c.Region == "" {
+		return errors.New("region is required")
+	}
+	if _, err := c.endpoint(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Push uploads a FIT file. The upload carries a Content-MD5, so the store
+// rejects a body damaged in transit, and the file's SHA-256 as object
+// metadata. If the templated key already holds this file, nothing is
+// uploaded and the push is reported as a duplicate; if it holds a
+// different file, a numbered key ("_2", "_3", ...) is used instead, so an
+// object is never overwritten.
+func (c *Consumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	if err := c.Validate(); err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	body, err := os.ReadFile(fitPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, consumer.Permanent(fmt.Errorf("read file: %w", err))
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	data, start, err := pathtmpl.Load(fitPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := c.tmpl.Render(data, start)
+	if err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	md5Sum := md5.Sum(body)
+	obj := object{
+		body:      body,
+		sha256:    sha256Hex(body),
+		md5Hex:    hex.EncodeToString(md5Sum[:]),
+		md5Base64: base64.StdEncoding.EncodeToString(md5Sum[:]),
+	}
+
+	for i := 1; i <= delivery.MaxNameCollisions; i++ {
+		candidate := delivery.Numbered(key, i)
+		existing, err := c.head(ctx, candidate)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case existing == nil:
+			if err := c.put(ctx, candidate, obj); err != nil {
+				return nil, err
+			}
+			c.logger.Info("uploaded to S3", "file", data.Filename, "bucket", c.Bucket, "key", candidate)
+			return c.receipt(candidate, false), nil
+		case existing.matches(obj):
+			c.logger.Info("already in S3", "file", data.Filename, "bucket", c.Bucket, "key", candidate)
+			return c.receipt(candidate, true), nil
+		}
+	}
+	return nil, consumer.Permanent(fmt.Errorf("%d objects already named like %s", delivery.MaxNameCollisions, key))
+}
+
+// object is a file to upload and its digests.
+type object struct {
+	body      []byte
+	sha256    string
+	md5Hex    string
+	md5Base64 string
+}
+
+// remoteObject is what HEAD says about an existing object.
+type remoteObject struct {
+	sha256 string
+	etag   string
+}
+
+// matches reports whether the remote object holds obj. Objects fitwatch
+// uploaded carry the SHA-256; for others the ETag is the MD5 of a
+// single-part upload.
+func (r *remoteObject) matches(obj object) bool {
+	if r.sha256 != "" {
+		return r.sha256 == obj.sha256
+	}
+	return r.etag == obj.md5Hex
+}
+
+// head returns what the store has at key, or nil if the key is free.
+func (c *Consumer) head(ctx context.Context, key string) (*remoteObject, error) {
+	req, err := c.newRequest(ctx, "HEAD", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, emptyPayloadHash)
+
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode >= 300:
+		// A HEAD response has no body to explain itself.
+		return nil, classify(resp, fmt.Errorf("HEAD %s: %s", key, resp.Status))
+	}
+	return &remoteObject{
+		sha256: resp.Header.Get(hashMetadata),
+		etag:   strings.Trim(resp.Header.Get("ETag"), `"`),
+	}, nil
+}
+
+// put uploads obj to key.
+func (c *Consumer) put(ctx context.Context, key string, obj object) error {
+	req, err := c.newRequest(ctx, "PUT", key, obj.body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", delivery.ContentType)
+	req.Header.Set("Content-Md5", obj.md5Base64)
+	req.Header.Set(hashMetadata, obj.sha256)
+	c.sign(req, obj.sha256)
+
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 300 {
+		return classify(resp, fmt.Errorf("PUT %s: %w", key, withCode(delivery.ReadError(resp))))
+	}
+	return nil
+}
+
+// newRequest builds a request for an object. The path is sent exactly as
+// it is signed.
+func (c *Consumer) newRequest(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	u, err := c.objectURL(key)
+	if err != nil {
+		return nil, consumer.Permanent(err)
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	return req, nil
+}
+
+func (c *Consumer) sign(req *http.Request, payloadHash string) {
+	creds := credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+	}
+	signV4(req, creds, c.Region, "s3", payloadHash, c.now())
+}
+
+func (c *Consumer) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("send request: %w", err)
+		}
+		return nil, consumer.Retryable(fmt.Errorf("send request: %w", err))
+	}
+	return resp, nil
+}
+
+// endpoint returns the service URL.
+func (c *Consumer) endpoint() (*url.URL, error) {
+	raw := c.Endpoint
+	if raw == "" {
+		raw = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid endpoint %q", raw)
+	}
+	return u, nil
+}
+
+// objectURL returns the URL of the object at key.
+func (c *Consumer) objectURL(key string) (*url.URL, error) {
+	u, err := c.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	p := strings.TrimRight(u.Path, "/")
+	if c.PathStyle {
+		p += "/" + c.Bucket
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+	}
+	u.Path = p + "/" + key
+	u.RawPath = uriEncode(u.Path, false)
+	return u, nil
+}
+
+func (c *Consumer) receipt(key string, duplicate bool) *consumer.Receipt {
+	r := &consumer.Receipt{RemoteID: key, Duplicate: duplicate}
+	if u, err := c.objectURL(key); err == nil {
+		r.RemoteURL = u.String()
+	}
+	return r
+}
+
+// s3Error is the XML body of an S3 error response.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// errorCode is the S3 error code of an error from withCode, if any.
+type errorCode struct {
+	code string
+	err  error
+}
+
+func (e *errorCode) Error() string { return e.err.Error() }
+func (e *errorCode) Unwrap() error { return e.err }
+
+// withCode describes an error response by its S3 error code, if its body
+// has one.
+func withCode(resp *delivery.ResponseError) error {
+	var e s3Error
+	if xml.Unmarshal(resp.Body, &e) == nil && e.Code != "" {
+		return &errorCode{code: e.Code, err: fmt.Errorf("%s: %s (%s)", resp.Status, e.Code, e.Message)}
+	}
+	return resp
+}
+
+// retryableCodes are S3 error codes sent with a 4xx status that are worth
+// retrying. A digest mismatch means the body was damaged on the way.
+var retryableCodes = map[string]bool{
+	"BadDigest":                 true,
+	"XAmzContentSHA256Mismatch": true,
+	"RequestTimeout":            true,
+	"RequestTimeTooSkewed":      true,
+}
+
+// classify wraps err according to the response.
+func classify(resp *http.Response, err error) error {
+	var ec *errorCode
+	if errors.As(err, &ec) && retryableCodes[ec.code] {
+		return consumer.Retryable(err)
+	}
+	if resp.StatusCode < 400 {
+		return consumer.Permanent(err)
+	}
+	return consumer.ClassifyHTTPStatus(resp.StatusCode, resp.Header, err)
+}
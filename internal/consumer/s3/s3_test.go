@@ -0,0 +1,329 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+// fakeS3 is a path-style bucket supporting signed PUT and HEAD.
+type fakeS3 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	puts    int
+
+	corrupt  bool // damage PUT bodies after the signature check
+	failWith int  // status to answer every request with, if set
+}
+
+type fakeObject struct {
+	body []byte
+	meta http.Header
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	t.Helper()
+	f := &fakeS3{objects: map[string]fakeObject{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeS3) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failWith != 0 {
+		w.WriteHeader(f.failWith)
+		fmt.Fprint(w, `<Error><Code>InternalError</Code><Message>try again</Message></Error>`)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	if msg := f.checkSignature(r, body); msg != "" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>`, msg)
+		return
+	}
+	if f.corrupt {
+		body = append(body, '!')
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/rides/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchBucket</Code></Error>`)
+		return
+	}
+
+	switch r.Method {
+	case "HEAD":
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range obj.meta {
+			w.Header()[k] = v
+		}
+		sum := md5.Sum(obj.body)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum))
+	case "PUT":
+		sum := md5.Sum(body)
+		if r.Header.Get("Content-Md5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Error><Code>BadDigest</Code><Message>The Content-MD5 you specified did not match what we received.</Message></Error>`)
+			return
+		}
+		meta := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") {
+				meta[k] = v
+			}
+		}
+		f.objects[key] = fakeObject{body: body, meta: meta}
+		f.puts++
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSignature signs a copy of the request as received and compares the
+// result, so a request changed after signing (or sent with a different
+// path encoding) is rejected.
+func (f *fakeS3) checkSignature(r *http.Request, body []byte) string {
+	auth := r.Header.Get("Authorization")
+	_, signed, ok := strings.Cut(auth, "SignedHeaders=")
+	if !ok {
+		return "missing signature"
+	}
+	signed, _, _ = strings.Cut(signed, ",")
+	when, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "bad date"
+	}
+	if r.Method == "PUT" && r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+		return "payload hash mismatch"
+	}
+
+	replay, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	for _, name := range strings.Split(signed, ";") {
+		if name != "host" {
+			replay.Header[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+		}
+	}
+	signV4(replay, credentials{AccessKeyID: "key", SecretAccessKey: "secret", SessionToken: r.Header.Get("X-Amz-Security-Token")},
+		"us-east-1", "s3", r.Header.Get("X-Amz-Content-Sha256"), when)
+	if replay.Header.Get("Authorization") != auth {
+		return "signature mismatch"
+	}
+	return ""
+}
+
+func newTestConsumer(f *fakeS3) *Consumer {
+	c := New("rides", "key", "secret")
+	c.Endpoint = f.URL
+	c.PathStyle = true
+	return c
+}
+
+// sampleFit copies the repository's sample FIT file into dir under name.
+func sampleFit(t *testing.T, dir, name string) string {
+	t.Helper()
+	_, thisFile, _, _ := runtime.Caller(0)
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(thisFile), "..", "..", "..", "testdata", "sample.fit"))
+	if err != nil {
+		t.Skipf("sample.fit not available: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeFit(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 9, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConsumer_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Consumer)
+		wantErr bool
+	}{
+		{"valid", func(*Consumer) {}, false},
+		{"missing bucket", func(c *Consumer) { c.Bucket = "" }, true},
+		{"missing secret", func(c *Consumer) { c.SecretAccessKey = "" }, true},
+		{"bad endpoint", func(c *Consumer) { c.Endpoint = "nas.local:9000" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New("rides", "key", "secret")
+			tt.mutate(c)
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_UploadsUnderTemplatedKey(t *testing.T) {
+	f := newFakeS3(t)
+	c := newTestConsumer(f)
+	c.SessionToken = "session"
+	if err := c.SetKeyTemplate("backup/{{date}} {{name}}"); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := c.Push(context.Background(), writeFit(t, "2024-03-09_Evening_Ride.fit", "fit data"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	want := "backup/2024-03-09 Evening_Ride.fit"
+	if receipt.RemoteID != want || receipt.Duplicate {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+	if receipt.RemoteURL != f.URL+"/rides/backup/2024-03-09%20Evening_Ride.fit" {
+		t.Errorf("unexpected URL: %s", receipt.RemoteURL)
+	}
+
+	obj, ok := f.objects[want]
+	if !ok || string(obj.body) != "fit data" {
+		t.Fatalf("object not stored: %v", f.objects)
+	}
+	if obj.meta.Get(hashMetadata) != sha256Hex([]byte("fit data")) {
+		t.Errorf("expected SHA-256 metadata, got %v", obj.meta)
+	}
+}
+
+func TestConsumer_Push_SampleUsesDefaultKey(t *testing.T) {
+	f := newFakeS3(t)
+	receipt, err := newTestConsumer(f).Push(context.Background(), sampleFit(t, t.TempDir(), "sample.fit"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if !strings.HasPrefix(receipt.RemoteID, "fitwatch/") || !strings.HasSuffix(receipt.RemoteID, "_sample.fit") {
+		t.Errorf("unexpected key: %s", receipt.RemoteID)
+	}
+}
+
+func TestConsumer_Push_SkipsSameObject(t *testing.T) {
+	f := newFakeS3(t)
+	c := newTestConsumer(f)
+	path := writeFit(t, "ride.fit", "fit data")
+
+	if _, err := c.Push(context.Background(), path); err != nil {
+		t.Fatalf("first Push failed: %v", err)
+	}
+	receipt, err := c.Push(context.Background(), path)
+	if err != nil {
+		t.Fatalf("second Push failed: %v", err)
+	}
+	if !receipt.Duplicate || f.puts != 1 {
+		t.Errorf("expected the second push to be skipped, got %+v after %d puts", receipt, f.puts)
+	}
+}
+
+func TestConsumer_Push_RecognisesForeignUploadByETag(t *testing.T) {
+	f := newFakeS3(t)
+	c := newTestConsumer(f)
+	f.objects["fitwatch/2024/03/2024-03-09_activity_ride.fit"] = fakeObject{body: []byte("fit data"), meta: http.Header{}}
+
+	receipt, err := c.Push(context.Background(), writeFit(t, "ride.fit", "fit data"))
+	if err != nil || !receipt.Duplicate || f.puts != 0 {
+		t.Fatalf("expected duplicate, got %+v (%v)", receipt, err)
+	}
+}
+
+func TestConsumer_Push_NeverOverwritesDifferentObject(t *testing.T) {
+	f := newFakeS3(t)
+	c := newTestConsumer(f)
+
+	first, err := c.Push(context.Background(), writeFit(t, "ride.fit", "morning"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Push(context.Background(), writeFit(t, "ride.fit", "evening"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if second.RemoteID != strings.TrimSuffix(first.RemoteID, ".fit")+"_2.fit" {
+		t.Errorf("expected numbered key, got %s", second.RemoteID)
+	}
+	if string(f.objects[first.RemoteID].body) != "morning" {
+		t.Error("first object was overwritten")
+	}
+}
+
+func TestConsumer_Push_BadDigestIsRetryable(t *testing.T) {
+	f := newFakeS3(t)
+	f.corrupt = true
+	c := newTestConsumer(f)
+
+	_, err := c.Push(context.Background(), writeFit(t, "ride.fit", "fit data"))
+	if err == nil || consumer.IsPermanent(err) {
+		t.Fatalf("expected retryable error, got %v", err)
+	}
+	if len(f.objects) != 0 {
+		t.Error("damaged object was stored")
+	}
+}
+
+func TestConsumer_Push_Errors(t *testing.T) {
+	f := newFakeS3(t)
+	c := newTestConsumer(f)
+	c.SecretAccessKey = "wrong"
+	if _, err := c.Push(context.Background(), writeFit(t, "ride.fit", "x")); !consumer.IsPermanent(err) {
+		t.Errorf("expected permanent error for bad credentials, got %v", err)
+	}
+
+	f.failWith = http.StatusServiceUnavailable
+	if _, err := newTestConsumer(f).Push(context.Background(), writeFit(t, "ride.fit", "x")); err == nil || consumer.IsPermanent(err) {
+		t.Errorf("expected retryable error for 503, got %v", err)
+	}
+
+	if _, err := newTestConsumer(f).Push(context.Background(), filepath.Join(t.TempDir(), "missing.fit")); !consumer.IsPermanent(err) {
+		t.Errorf("expected permanent error for missing file, got %v", err)
+	}
+}
+
+func TestConsumer_ObjectURL(t *testing.T) {
+	c := New("rides", "key", "secret")
+	c.Region = "eu-west-1"
+	u, _ := c.objectURL("a/b c.fit")
+	if u.String() != "https://rides.s3.eu-west-1.amazonaws.com/a/b%20c.fit" {
+		t.Errorf("virtual-hosted URL = %s", u)
+	}
+
+	c.Endpoint = "http://nas.local:9000/"
+	c.PathStyle = true
+	u, _ = c.objectURL("a/b c.fit")
+	if u.String() != "http://nas.local:9000/rides/a/b%20c.fit" {
+		t.Errorf("path-style URL = %s", u)
+	}
+}
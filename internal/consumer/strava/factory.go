@@ -0,0 +1,44 @@
+package strava
+
+import (
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+func init() {
+	consumer.Register("strava", newFromSpec)
+}
+
+// settings are the config keys of a Strava consumer.
+type settings struct {
+	ClientID     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+	RefreshToken string `toml:"refresh_token"`
+	BaseURL      string `toml:"base_url"`
+	PollTimeout  string `toml:"poll_timeout"`
+}
+
+// newFromSpec builds a consumer from its [[consumers]] entry.
+func newFromSpec(spec consumer.Spec) (consumer.Consumer, error) {
+	var s settings
+	if err := spec.Settings.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	c := New(s.ClientID, s.ClientSecret, s.RefreshToken)
+	c.name = spec.Name
+	c.SetLogger(spec.Logger)
+	c.SetStateStore(spec.State)
+	if s.BaseURL != "" {
+		c.BaseURL = s.BaseURL
+	}
+	if s.PollTimeout != "" {
+		d, err := time.ParseDuration(s.PollTimeout)
+		if err != nil {
+			return nil, err
+		}
+		c.PollTimeout = d
+	}
+	return c, nil
+}
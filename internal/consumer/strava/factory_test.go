@@ -0,0 +1,38 @@
+package strava
+
+import (
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/consumertest"
+)
+
+func TestFactory_Build(t *testing.T) {
+	settings := consumertest.Settings(`
+client_id = "12345"
+client_secret = "secret"
+refresh_token = "refresh"
+poll_timeout = "30s"
+`)
+	state := consumer.NewMemoryState()
+
+	c, err := consumer.Build("strava", consumer.Spec{Name: "strava-club", Settings: settings, State: state})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	sc := c.(*Consumer)
+	if sc.Name() != "strava-club" || sc.ClientID != "12345" || sc.RefreshToken != "refresh" {
+		t.Errorf("unexpected consumer: %+v", sc)
+	}
+	if sc.BaseURL != defaultBaseURL || sc.PollTimeout != 30*time.Second {
+		t.Errorf("unexpected defaults: %s %s", sc.BaseURL, sc.PollTimeout)
+	}
+	if sc.state != state {
+		t.Error("expected the spec's state store")
+	}
+}
+
+func TestFactory_InvalidPollTimeout(t *testing.T) {
+	consumertest.BuildError(t, "strava", `poll_timeout = "soon"`, "invalid duration")
+}
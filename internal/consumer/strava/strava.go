@@ -0,0 +1,315 @@
+// Package strava provides a consumer for Strava.
+package strava
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+	"github.com/johnazariah/fitwatch/internal/oauth"
+)
+
+const (
+	defaultBaseURL = "https://www.strava.com"
+	defaultName    = "Strava"
+
+	// scope lets fitwatch upload activities. Strava separates scopes with
+	// commas.
+	scope = "activity:write"
+
+	// DefaultPollInterval is how often an upload's status is checked.
+	DefaultPollInterval = 2 * time.Second
+
+	// DefaultPollTimeout is how long to wait for Strava to process an
+	// upload before trying again later.
+	DefaultPollTimeout = 2 * time.Minute
+
+	// rateLimitWindow is Strava's short rate limit window, used when a 429
+	// doesn't say how long to wait.
+	rateLimitWindow = 15 * time.Minute
+)
+
+// duplicatePattern finds the existing activity in a duplicate upload error,
+// e.g. "ride.fit duplicate of activity 1234567890".
+var duplicatePattern = regexp.MustCompile(`duplicate of (?:<a href='/activities/)?(?:activity )?(\d+)`)
+
+// Consumer uploads FIT files to Strava.
+type Consumer struct {
+	ClientID     string
+	ClientSecret string
+
+	// RefreshToken optionally starts the token chain, for a refresh token
+	// obtained outside fitwatch. Usually `fitwatch auth` is used instead.
+	// Strava issues a new refresh token with each access token; those are
+	// kept in the state store and take precedence.
+	RefreshToken string
+
+	BaseURL      string
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+
+	name   string
+	client *http.Client
+	logger *slog.Logger
+	state  consumer.StateStore
+
+	authOnce sync.Once
+	auth     *oauth.Source
+}
+
+// New creates a Strava consumer. Tokens are kept in memory until
+// SetStateStore is called.
+func New(clientID, clientSecret, refreshToken string) *Consumer {
+	return &Consumer{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RefreshToken: refreshToken,
+		BaseURL:      defaultBaseURL,
+		PollInterval: DefaultPollInterval,
+		PollTimeout:  DefaultPollTimeout,
+		name:         defaultName,
+		logger:       slog.Default(),
+		state:        consumer.NewMemoryState(),
+	}
+}
+
+// SetLogger configures the logger for the consumer.
+func (c *Consumer) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetStateStore sets where tokens are kept between runs. It must be called
+// before the consumer is used.
+func (c *Consumer) SetStateStore(state consumer.StateStore) {
+	c.state = state
+}
+
+// OAuth returns the consumer's token source, creating it on first use
+// from the consumer's configuration.
+func (c *Consumer) OAuth() *oauth.Source {
+	c.authOnce.Do(func() {
+		base := strings.TrimRight(c.BaseURL, "/")
+		c.auth = oauth.NewSource(oauth.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			AuthURL:      base + "/oauth/authorize",
+			TokenURL:     base + "/oauth/token",
+			Scope:        scope,
+		}, c.state, c.name)
+		c.auth.SetRefreshToken(c.RefreshToken)
+		c.auth.SetLogger(c.logger)
+		c.client = &http.Client{Transport: &oauth.Transport{Source: c.auth}}
+	})
+	return c.auth
+}
+
+// Name returns the consumer's instance name, "Strava" unless configured
+// otherwise.
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Validate checks configuration.
+func (c *Consumer) Validate() error {
+	if c.ClientID == "" {
+		return errors.New("client ID is required")
+	}
+	if c.ClientSecret == "" {
+		return errors.New("client secret is required")
+	}
+	return nil
+}
+
+// ActivityURL returns the web URL for an activity.
+func (c *Consumer) ActivityURL(activityID string) string {
+	return fmt.Sprintf("%s/activities/%s", strings.TrimRight(c.BaseURL, "/"), activityID)
+}
+
+// uploadStatus is Strava's description of an upload, returned both when it
+// is created and when it is polled.
+type uploadStatus struct {
+	ID         int64  `json:"id"`
+	Status     string `json:"status"`
+	Error      string `json:"error"`
+	ActivityID int64  `json:"activity_id"`
+}
+
+// Push uploads a FIT file to Strava and waits for it to be processed.
+// Strava processes uploads asynchronously, so the upload is polled until it
+// becomes an activity or fails. An upload Strava reports as a duplicate
+// returns the existing activity.
+func (c *Consumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	if err := c.Validate(); err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	body, contentType, err := newUploadForm(fitPath)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/api/v3/uploads", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := decodeStatus(resp)
+	if err != nil {
+		return nil, err
+	}
+	return c.awaitActivity(ctx, status)
+}
+
+// awaitActivity polls an upload until Strava has finished processing it.
+func (c *Consumer) awaitActivity(ctx context.Context, status *uploadStatus) (*consumer.Receipt, error) {
+	deadline := time.Now().Add(c.PollTimeout)
+	for {
+		if receipt, done, err := c.resolve(status); done {
+			return receipt, err
+		}
+
+		if time.Now().After(deadline) {
+			return nil, consumer.Retryable(fmt.Errorf("upload %d still processing after %s: %s", status.ID, c.PollTimeout, status.Status))
+		}
+
+		timer := time.NewTimer(c.PollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		url := fmt.Sprintf("%s/api/v3/uploads/%d", c.BaseURL, status.ID)
+		resp, err := c.do(ctx, func() (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", url, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if status, err = decodeStatus(resp); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// resolve interprets an upload's status. done is false while Strava is
+// still processing it.
+func (c *Consumer) resolve(status *uploadStatus) (receipt *consumer.Receipt, done bool, err error) {
+	if status.ActivityID != 0 {
+		id := strconv.FormatInt(status.ActivityID, 10)
+		return &consumer.Receipt{RemoteID: id, RemoteURL: c.ActivityURL(id)}, true, nil
+	}
+	if status.Error == "" {
+		return nil, false, nil
+	}
+
+	uploadErr := fmt.Errorf("upload %d: %s", status.ID, status.Error)
+	if m := duplicatePattern.FindStringSubmatch(status.Error); m != nil {
+		return &consumer.Receipt{RemoteID: m[1], RemoteURL: c.ActivityURL(m[1]), Duplicate: true}, true, consumer.Duplicate(uploadErr)
+	}
+	// Strava has looked at the file and rejected it.
+	return nil, true, consumer.Permanent(uploadErr)
+}
+
+// do sends a request built by newReq, authorized by the token source.
+// Error responses are classified and returned as errors.
+func (c *Consumer) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	c.OAuth()
+
+	req, err := newReq()
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// Token errors are already classified.
+		if ctx.Err() != nil || consumer.IsPermanent(err) {
+			return nil, fmt.Errorf("send request: %w", err)
+		}
+		return nil, consumer.Retryable(fmt.Errorf("send request: %w", err))
+	}
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		apiErr := fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
+		if resp.StatusCode == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			return nil, consumer.RateLimited(apiErr, rateLimitWindow)
+		}
+		return nil, consumer.ClassifyHTTPStatus(resp.StatusCode, resp.Header, apiErr)
+	}
+	return resp, nil
+}
+
+// decodeStatus reads an upload status response and closes its body.
+func decodeStatus(resp *http.Response) (*uploadStatus, error) {
+	defer func() { _ = resp.Body.Close() }()
+	var status uploadStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, consumer.Retryable(fmt.Errorf("decode upload status: %w", err))
+	}
+	return &status, nil
+}
+
+// newUploadForm builds the multipart upload request body. The filename
+// doubles as the external ID, and its title as the activity name.
+func newUploadForm(fitPath string) ([]byte, string, error) {
+	data, err := os.ReadFile(fitPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", consumer.Permanent(fmt.Errorf("read file: %w", err))
+		}
+		return nil, "", fmt.Errorf("read file: %w", err)
+	}
+
+	filename := filepath.Base(fitPath)
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	fields := [][2]string{
+		{"data_type", "fit"},
+		{"external_id", filename},
+	}
+	if title := fitparser.TitleFromFilename(filename); title != "" {
+		fields = append(fields, [2]string{"name", title})
+	}
+	for _, f := range fields {
+		if err := writer.WriteField(f[0], f[1]); err != nil {
+			return nil, "", fmt.Errorf("write %s field: %w", f[0], err)
+		}
+	}
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, "", fmt.Errorf("create form file: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, "", fmt.Errorf("write file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", fmt.Errorf("close writer: %w", err)
+	}
+	return buf.Bytes(), writer.FormDataContentType(), nil
+}
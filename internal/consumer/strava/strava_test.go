@@ -0,0 +1,385 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/oauth"
+)
+
+// fakeStrava mimics the token, upload and upload status endpoints.
+type fakeStrava struct {
+	*httptest.Server
+
+	mu sync.Mutex
+
+	// refreshTokens maps each valid refresh token to the access token it
+	// yields; each refresh rotates the refresh token.
+	refreshTokens map[string]string
+	accessTokens  map[string]bool
+	refreshes     int
+
+	uploads     int
+	polls       int
+	pollsNeeded int    // polls before the upload resolves
+	uploadError string // final error instead of an activity
+	uploadForm  map[string]string
+	uploadCode  int // status to answer uploads with, if set
+}
+
+func newFakeStrava(t *testing.T) *fakeStrava {
+	t.Helper()
+	f := &fakeStrava{
+		refreshTokens: map[string]string{"initial-refresh": "access-1"},
+		accessTokens:  map[string]bool{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", f.handleToken)
+	mux.HandleFunc("/api/v3/uploads", f.handleUpload)
+	mux.HandleFunc("/api/v3/uploads/", f.handleStatus)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeStrava) handleToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "secret" || r.FormValue("grant_type") != "refresh_token" {
+		http.Error(w, `{"message":"Bad Request"}`, http.StatusBadRequest)
+		return
+	}
+	access, ok := f.refreshTokens[r.FormValue("refresh_token")]
+	if !ok {
+		http.Error(w, `{"message":"Bad Request","errors":[{"field":"refresh_token","code":"invalid"}]}`, http.StatusBadRequest)
+		return
+	}
+
+	f.refreshes++
+	delete(f.refreshTokens, r.FormValue("refresh_token"))
+	next := fmt.Sprintf("refresh-%d", f.refreshes+1)
+	f.refreshTokens[next] = fmt.Sprintf("access-%d", f.refreshes+1)
+	f.accessTokens[access] = true
+
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"token_type":    "Bearer",
+		"access_token":  access,
+		"refresh_token": next,
+		"expires_at":    time.Now().Add(6 * time.Hour).Unix(),
+	})
+}
+
+func (f *fakeStrava) authorized(r *http.Request) bool {
+	return f.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+}
+
+func (f *fakeStrava) handleUpload(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.authorized(r) {
+		http.Error(w, `{"message":"Authorization Error"}`, http.StatusUnauthorized)
+		return
+	}
+	if f.uploadCode != 0 {
+		http.Error(w, `{"message":"nope"}`, f.uploadCode)
+		return
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.uploadForm = map[string]string{}
+	for k, v := range r.MultipartForm.Value {
+		f.uploadForm[k] = v[0]
+	}
+	if _, _, err := r.FormFile("file"); err != nil {
+		http.Error(w, "missing file", http.StatusBadRequest)
+		return
+	}
+
+	f.uploads++
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(f.status())
+}
+
+func (f *fakeStrava) handleStatus(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.authorized(r) {
+		http.Error(w, `{"message":"Authorization Error"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != "/api/v3/uploads/555" {
+		http.NotFound(w, r)
+		return
+	}
+	f.polls++
+	_ = json.NewEncoder(w).Encode(f.status())
+}
+
+// status describes the upload as of the current poll. f.mu must be held.
+func (f *fakeStrava) status() map[string]any {
+	s := map[string]any{"id": 555, "status": "Your activity is still being processed.", "error": nil, "activity_id": nil}
+	if f.polls < f.pollsNeeded {
+		return s
+	}
+	if f.uploadError != "" {
+		s["status"] = "There was an error processing your activity."
+		s["error"] = f.uploadError
+		return s
+	}
+	s["status"] = "Your activity is ready."
+	s["activity_id"] = 987654321
+	return s
+}
+
+func newTestConsumer(f *fakeStrava) *Consumer {
+	c := New("client", "secret", "initial-refresh")
+	c.BaseURL = f.URL
+	c.PollInterval = time.Millisecond
+	return c
+}
+
+func writeFit(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("fit data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConsumer_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *Consumer
+		wantErr bool
+	}{
+		{"valid", New("id", "secret", "refresh"), false},
+		{"missing client ID", New("", "secret", "refresh"), true},
+		{"missing secret", New("id", "", "refresh"), true},
+		{"authorized with fitwatch auth", New("id", "secret", ""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_PollsUntilReady(t *testing.T) {
+	f := newFakeStrava(t)
+	f.pollsNeeded = 3
+	c := newTestConsumer(f)
+
+	receipt, err := c.Push(context.Background(), writeFit(t, "2025-02-23_Hudayriyat_Ascend.fit"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if receipt.RemoteID != "987654321" {
+		t.Errorf("expected activity 987654321, got %s", receipt.RemoteID)
+	}
+	if receipt.RemoteURL != f.URL+"/activities/987654321" {
+		t.Errorf("unexpected URL: %s", receipt.RemoteURL)
+	}
+	if f.polls != 3 {
+		t.Errorf("expected 3 polls, got %d", f.polls)
+	}
+
+	if f.uploadForm["data_type"] != "fit" || f.uploadForm["name"] != "Hudayriyat Ascend" || f.uploadForm["external_id"] != "2025-02-23_Hudayriyat_Ascend.fit" {
+		t.Errorf("unexpected upload form: %v", f.uploadForm)
+	}
+}
+
+func TestConsumer_Push_PersistsRotatedToken(t *testing.T) {
+	f := newFakeStrava(t)
+	state := consumer.NewMemoryState()
+
+	c := newTestConsumer(f)
+	c.SetStateStore(state)
+	if _, err := c.Push(context.Background(), writeFit(t, "a.fit")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	saved, err := oauth.NewStore(state, c.Name()).Load(context.Background())
+	if err != nil || saved == nil {
+		t.Fatalf("expected saved token, got %v (%v)", saved, err)
+	}
+	if saved.RefreshToken != "refresh-2" || saved.AccessToken != "access-1" {
+		t.Errorf("unexpected saved token: %+v", saved)
+	}
+
+	// A new consumer (a restart) reuses the saved access token. The
+	// configured refresh token has been used up, so it must not fall back
+	// to it.
+	f.pollsNeeded = 0
+	restarted := newTestConsumer(f)
+	restarted.SetStateStore(state)
+	if _, err := restarted.Push(context.Background(), writeFit(t, "b.fit")); err != nil {
+		t.Fatalf("Push after restart failed: %v", err)
+	}
+	if f.refreshes != 1 {
+		t.Errorf("expected saved access token to be reused, got %d refreshes", f.refreshes)
+	}
+}
+
+func TestConsumer_Push_RefreshesExpiredToken(t *testing.T) {
+	f := newFakeStrava(t)
+	f.refreshTokens = map[string]string{"stored-refresh": "access-fresh"}
+
+	state := consumer.NewMemoryState()
+	_ = oauth.NewStore(state, defaultName).Save(context.Background(), &oauth.Token{
+		AccessToken:  "access-stale",
+		RefreshToken: "stored-refresh",
+		Expiry:       time.Now().Add(time.Minute),
+	})
+
+	c := newTestConsumer(f)
+	c.SetStateStore(state)
+	if _, err := c.Push(context.Background(), writeFit(t, "a.fit")); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if f.refreshes != 1 {
+		t.Errorf("expected a refresh with the stored token, got %d", f.refreshes)
+	}
+}
+
+func TestConsumer_Push_RefreshesRejectedToken(t *testing.T) {
+	f := newFakeStrava(t)
+
+	state := consumer.NewMemoryState()
+	_ = oauth.NewStore(state, defaultName).Save(context.Background(), &oauth.Token{
+		AccessToken:  "revoked",
+		RefreshToken: "initial-refresh",
+		Expiry:       time.Now().Add(time.Hour),
+	})
+
+	c := newTestConsumer(f)
+	c.SetStateStore(state)
+	receipt, err := c.Push(context.Background(), writeFit(t, "a.fit"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if receipt.RemoteID == "" || f.refreshes != 1 || f.uploads != 1 {
+		t.Errorf("expected one refresh and one upload, got %d and %d", f.refreshes, f.uploads)
+	}
+}
+
+func TestConsumer_Push_Duplicate(t *testing.T) {
+	f := newFakeStrava(t)
+	f.pollsNeeded = 1
+	f.uploadError = "a.fit duplicate of activity 1234567890"
+	c := newTestConsumer(f)
+
+	receipt, err := c.Push(context.Background(), writeFit(t, "a.fit"))
+	if !consumer.IsDuplicate(err) {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+	if receipt == nil || !receipt.Duplicate || receipt.RemoteID != "1234567890" {
+		t.Errorf("expected receipt for existing activity, got %+v", receipt)
+	}
+}
+
+func TestConsumer_Push_ProcessingErrorIsPermanent(t *testing.T) {
+	f := newFakeStrava(t)
+	f.uploadError = "Improperly formatted data."
+	c := newTestConsumer(f)
+
+	_, err := c.Push(context.Background(), writeFit(t, "a.fit"))
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
+
+func TestConsumer_Push_PollTimeout(t *testing.T) {
+	f := newFakeStrava(t)
+	f.pollsNeeded = 1 << 30
+	c := newTestConsumer(f)
+	c.PollTimeout = 20 * time.Millisecond
+
+	_, err := c.Push(context.Background(), writeFit(t, "a.fit"))
+	if err == nil || !consumer.IsRetryable(err) {
+		t.Fatalf("expected retryable error, got %v", err)
+	}
+}
+
+func TestConsumer_Push_InvalidRefreshToken(t *testing.T) {
+	f := newFakeStrava(t)
+	c := newTestConsumer(f)
+	c.RefreshToken = "revoked"
+
+	_, err := c.Push(context.Background(), writeFit(t, "a.fit"))
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if f.uploads != 0 {
+		t.Error("should not upload without a token")
+	}
+}
+
+func TestConsumer_Push_NotAuthorized(t *testing.T) {
+	f := newFakeStrava(t)
+	c := newTestConsumer(f)
+	c.RefreshToken = ""
+
+	_, err := c.Push(context.Background(), writeFit(t, "a.fit"))
+	if !consumer.IsPermanent(err) || !strings.Contains(err.Error(), "fitwatch auth Strava") {
+		t.Fatalf("expected permanent error asking to authorize, got %v", err)
+	}
+	if f.uploads != 0 {
+		t.Error("should not upload without a token")
+	}
+}
+
+func TestConsumer_Push_RateLimited(t *testing.T) {
+	f := newFakeStrava(t)
+	f.uploadCode = http.StatusTooManyRequests
+	c := newTestConsumer(f)
+
+	_, err := c.Push(context.Background(), writeFit(t, "a.fit"))
+	if wait, ok := consumer.RetryAfter(err); !ok || wait != rateLimitWindow {
+		t.Fatalf("expected rate limit with %v wait, got %v (%v)", rateLimitWindow, wait, err)
+	}
+}
+
+func TestConsumer_Push_MissingFile(t *testing.T) {
+	f := newFakeStrava(t)
+	_, err := newTestConsumer(f).Push(context.Background(), filepath.Join(t.TempDir(), "missing.fit"))
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
+
+func TestDuplicatePattern(t *testing.T) {
+	tests := []struct {
+		msg, want string
+	}{
+		{"a.fit duplicate of activity 1234567890", "1234567890"},
+		{"a.fit duplicate of <a href='/activities/42' target='_blank'>Morning Ride</a>", "42"},
+		{"Improperly formatted data.", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if m := duplicatePattern.FindStringSubmatch(tt.msg); m != nil {
+			got = m[1]
+		}
+		if got != tt.want {
+			t.Errorf("duplicate ID in %q = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/johnazariah/fitwatch/internal/transform"
+)
+
+// SetTransforms makes the dispatcher push the named consumer a copy of
+// each file transformed by chain, rather than the file itself. The
+// original is never modified. It must be called before dispatching.
+func (d *Dispatcher) SetTransforms(name string, chain transform.Chain) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.transforms == nil {
+		d.transforms = make(map[string]transform.Chain)
+	}
+	d.transforms[name] = chain
+}
+
+// SetTransformCache sets where transformed copies are kept. Without it,
+// they are kept in the system's temporary directory.
+func (d *Dispatcher) SetTransformCache(c *transform.Cache) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.transformCache = c
+}
+
+// transformFor returns the path to push to the named consumer, a
+// transformed copy if it has transforms, and the chain applied. A file
+// that is missing or can't be transformed fails permanently.
+func (d *Dispatcher) transformFor(ctx context.Context, name, fitPath string) (string, string, error) {
+	d.mu.Lock()
+	chain := d.transforms[name]
+	if len(chain) > 0 && d.transformCache == nil {
+		d.transformCache = transform.NewCache(filepath.Join(os.TempDir(), "fitwatch-transformed"))
+	}
+	cache := d.transformCache
+	d.mu.Unlock()
+
+	if len(chain) == 0 {
+		return fitPath, "", nil
+	}
+	path, err := cache.Path(ctx, fitPath, chain)
+	if err != nil {
+		err = fmt.Errorf("transform: %w", err)
+		var te *transform.Error
+		switch {
+		case ctx.Err() != nil:
+			return "", chain.String(), err
+		case errors.As(err, &te), errors.Is(err, os.ErrNotExist):
+			return "", chain.String(), Permanent(err)
+		}
+		return "", chain.String(), Retryable(err)
+	}
+	return path, chain.String(), nil
+}
@@ -0,0 +1,81 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/transform"
+)
+
+// stamp is a transform that appends a marker, or fails with err if set.
+type stamp struct{ err error }
+
+func (stamp) Name() string { return "stamp" }
+
+func (s stamp) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return append(append([]byte(nil), data...), " (stamped)"...), nil
+}
+
+func TestDispatcher_PushesTransformedCopy(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "ride.fit")
+	if err := os.WriteFile(src, []byte("ride"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	public := newGatedConsumer("public")
+	private := newGatedConsumer("private")
+	public.open()
+	private.open()
+	d := NewDispatcher(public, private)
+	d.SetTransforms("public", transform.Chain{stamp{}})
+	d.SetTransformCache(transform.NewCache(t.TempDir()))
+
+	results := d.Dispatch(context.Background(), src)
+	if len(results) != 2 || !results[0].Success || !results[1].Success {
+		t.Fatalf("expected 2 successes, got %+v", results)
+	}
+	if results[0].FitPath != src || results[0].Transforms != "stamp" || results[1].Transforms != "" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	if data, _ := os.ReadFile(public.pushed[0]); string(data) != "ride (stamped)" {
+		t.Errorf("public consumer got %q", data)
+	}
+	if filepath.Base(public.pushed[0]) != "ride.fit" {
+		t.Errorf("transformed copy renamed to %s", public.pushed[0])
+	}
+	if private.pushed[0] != src {
+		t.Errorf("private consumer got %s, want the original", private.pushed[0])
+	}
+	if data, _ := os.ReadFile(src); string(data) != "ride" {
+		t.Error("original was modified")
+	}
+}
+
+func TestDispatcher_TransformFailureIsPermanent(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "ride.fit")
+	if err := os.WriteFile(src, []byte("ride"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newGatedConsumer("public")
+	c.open()
+	d := NewDispatcher(c)
+	d.SetMaxRetries(3)
+	d.SetTransforms("public", transform.Chain{stamp{err: errors.New("not an activity")}})
+	d.SetTransformCache(transform.NewCache(t.TempDir()))
+
+	results := d.Dispatch(context.Background(), src)
+	if len(results) != 1 || results[0].Success || !IsPermanent(results[0].Error) {
+		t.Fatalf("expected permanent failure, got %+v", results)
+	}
+	if len(c.pushed) != 0 {
+		t.Errorf("consumer was pushed %v", c.pushed)
+	}
+}
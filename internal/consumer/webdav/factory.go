@@ -0,0 +1,33 @@
+package webdav
+
+import "github.com/johnazariah/fitwatch/internal/consumer"
+
+func init() {
+	consumer.Register("webdav", newFromSpec)
+}
+
+// settings are the config keys of a WebDAV consumer.
+type settings struct {
+	URL      string `toml:"url"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	Path     string `toml:"path"`
+}
+
+// newFromSpec builds a consumer from its [[consumers]] entry.
+func newFromSpec(spec consumer.Spec) (consumer.Consumer, error) {
+	var s settings
+	if err := spec.Settings.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	c := New(s.URL, s.Username, s.Password)
+	c.name = spec.Name
+	c.SetLogger(spec.Logger)
+	if s.Path != "" {
+		if err := c.SetPathTemplate(s.Path); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,29 @@
+package webdav
+
+import (
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/consumertest"
+)
+
+func TestFactory_Build(t *testing.T) {
+	wc := consumertest.Build[*Consumer](t, "webdav", "nextcloud", `
+url = "https://cloud.example.com/remote.php/dav/files/alice/Rides"
+username = "alice"
+password = "app-password"
+path = "{{year}}/{{name}}.fit"
+`)
+	if wc.Username != "alice" || wc.Password != "app-password" {
+		t.Errorf("unexpected consumer: %+v", wc)
+	}
+	if err := wc.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestFactory_InvalidPathTemplate(t *testing.T) {
+	consumertest.BuildError(t, "webdav", `
+url = "https://cloud.example.com/dav"
+path = "{{year"
+`, "parse path template")
+}
@@ -0,0 +1,82 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+)
+
+// propfindBody asks for the properties fitwatch checks.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getetag/></d:prop></d:propfind>`
+
+// multistatus is a 207 Multi-Status response body.
+type multistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength int64  `xml:"DAV: getcontentlength"`
+				ETag          string `xml:"DAV: getetag"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// properties are what PROPFIND says about a resource.
+type properties struct {
+	Collection    bool
+	ContentLength int64
+	ETag          string
+}
+
+// propfind returns the properties of the resource at rel, or nil if there
+// is none.
+func (c *Consumer) propfind(ctx context.Context, rel string) (*properties, error) {
+	header := http.Header{
+		"Depth":        {"0"},
+		"Content-Type": {`application/xml; charset="utf-8"`},
+	}
+	resp, err := c.do(ctx, "PROPFIND", rel, header, []byte(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusMultiStatus:
+	default:
+		return nil, classify(resp, fmt.Errorf("PROPFIND %s: %w", rel, delivery.ReadError(resp)))
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, consumer.Retryable(fmt.Errorf("PROPFIND %s: decode response: %w", rel, err))
+	}
+	// With Depth 0 the only response is for the resource itself. Its
+	// properties are in the propstat with a 200 status.
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			return &properties{
+				Collection:    ps.Prop.ResourceType.Collection != nil,
+				ContentLength: ps.Prop.ContentLength,
+				ETag:          strings.Trim(ps.Prop.ETag, `"`),
+			}, nil
+		}
+	}
+	return nil, consumer.Retryable(fmt.Errorf("PROPFIND %s: no properties in response", rel))
+}
@@ -0,0 +1,309 @@
+// Package webdav provides a consumer that backs FIT files up to a WebDAV
+// server, such as Nextcloud or ownCloud.
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/consumer/pathtmpl"
+)
+
+const defaultName = "webdav"
+
+// Consumer uploads FIT files to a WebDAV collection, at a path given by a
+// template over the file's metadata. Missing collections are created.
+type Consumer struct {
+	// URL is the collection to upload into, e.g.
+	// "https://cloud.example.com/remote.php/dav/files/alice/Rides". It must
+	// exist.
+	URL string
+
+	// Username and Password are sent with basic auth if set. For Nextcloud
+	// and ownCloud, use an app password.
+	Username string
+	Password string
+
+	name   string
+	tmpl   *pathtmpl.Template
+	client *http.Client
+	logger *slog.Logger
+
+	// collections are the collections known to exist, so each is only
+	// created once.
+	mu          sync.Mutex
+	collections map[string]bool
+}
+
+// New creates a WebDAV consumer that uploads into the collection at
+// baseURL using pathtmpl.Default.
+func New(baseURL, username, password string) *Consumer {
+	return &Consumer{
+		URL:         baseURL,
+		Username:    username,
+		Password:    password,
+		name:        defaultName,
+		tmpl:        pathtmpl.MustParse(pathtmpl.Default),
+		client:      &http.Client{Timeout: 5 * time.Minute},
+		logger:      slog.Default(),
+		collections: make(map[string]bool),
+	}
+}
+
+// SetLogger configures the logger for the consumer.
+func (c *Consumer) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetPathTemplate sets the template for paths within the collection; see
+// package pathtmpl for the available functions. ".fit" is added if the
+// result has no extension.
+func (c *Consumer) SetPathTemplate(text string) error {
+	tmpl, err := pathtmpl.Parse(text)
+	if err != nil {
+		return err
+	}
+	c.tmpl = tmpl
+	return nil
+}
+
+// Name returns the consumer's instance name.
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Validate checks configuration.
+func (c *Consumer) Validate() error {
+	if c.URL == "" {
+		return errors.New("URL is required")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid URL %q", c.URL)
+	}
+	if c.Password != "" && c.Username == "" {
+		return errors.New("username is required with a password")
+	}
+	return nil
+}
+
+// Push uploads a FIT file. Collections on the way are created with MKCOL,
+// and the upload is checked with PROPFIND afterwards. If the templated path
+// already holds this file, nothing is uploaded and the push is reported as
+// a duplicate; if it holds a different file, a numbered name ("_2", "_3",
+// ...) is used instead, so a file is never overwritten.
+func (c *Consumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	if err := c.Validate(); err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	body, err := os.ReadFile(fitPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, consumer.Permanent(fmt.Errorf("read file: %w", err))
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	data, start, err := pathtmpl.Load(fitPath)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := c.tmpl.Render(data, start)
+	if err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	if err := c.ensureCollections(ctx, path.Dir(rel)); err != nil {
+		return nil, err
+	}
+
+	for i := 1; i <= delivery.MaxNameCollisions; i++ {
+		candidate := delivery.Numbered(rel, i)
+		props, err := c.propfind(ctx, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if props != nil {
+			if props.Collection || props.ContentLength != int64(len(body)) {
+				continue
+			}
+			same, err := c.holds(ctx, candidate, body)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				continue
+			}
+			c.logger.Info("already in WebDAV", "file", data.Filename, "path", candidate)
+			return c.receipt(candidate, true), nil
+		}
+
+		if err := c.put(ctx, candidate, body); err != nil {
+			return nil, err
+		}
+		if err := c.verify(ctx, candidate, int64(len(body))); err != nil {
+			return nil, err
+		}
+		c.logger.Info("uploaded to WebDAV", "file", data.Filename, "path", candidate)
+		return c.receipt(candidate, false), nil
+	}
+	return nil, consumer.Permanent(fmt.Errorf("%d files already named like %s", delivery.MaxNameCollisions, rel))
+}
+
+// ensureCollections creates dir and its parents, relative to the base
+// collection, if they don't exist.
+func (c *Consumer) ensureCollections(ctx context.Context, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	var p string
+	for _, segment := range strings.Split(dir, "/") {
+		p = path.Join(p, segment)
+
+		c.mu.Lock()
+		known := c.collections[p]
+		c.mu.Unlock()
+		if known {
+			continue
+		}
+
+		resp, err := c.do(ctx, "MKCOL", p+"/", nil, nil)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		// 405 means it already exists.
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return classify(resp, fmt.Errorf("MKCOL %s: %s", p, resp.Status))
+		}
+
+		c.mu.Lock()
+		c.collections[p] = true
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// put uploads body to rel.
+func (c *Consumer) put(ctx context.Context, rel string, body []byte) error {
+	header := http.Header{"Content-Type": {delivery.ContentType}}
+	resp, err := c.do(ctx, "PUT", rel, header, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusConflict {
+		// A parent collection has gone; create it again next time.
+		c.mu.Lock()
+		clear(c.collections)
+		c.mu.Unlock()
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return classify(resp, fmt.Errorf("PUT %s: %w", rel, delivery.ReadError(resp)))
+	}
+	return nil
+}
+
+// verify checks the server has the whole file at rel.
+func (c *Consumer) verify(ctx context.Context, rel string, size int64) error {
+	props, err := c.propfind(ctx, rel)
+	if err != nil {
+		return err
+	}
+	if props == nil {
+		return consumer.Retryable(fmt.Errorf("verify %s: not found after upload", rel))
+	}
+	if props.ContentLength != size {
+		return consumer.Retryable(fmt.Errorf("verify %s: server has %d bytes, uploaded %d", rel, props.ContentLength, size))
+	}
+	return nil
+}
+
+// holds reports whether the file at rel has the same content as body.
+func (c *Consumer) holds(ctx context.Context, rel string, body []byte) (bool, error) {
+	resp, err := c.do(ctx, "GET", rel, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, classify(resp, fmt.Errorf("GET %s: %w", rel, delivery.ReadError(resp)))
+	}
+	got, err := io.ReadAll(io.LimitReader(resp.Body, int64(len(body))+1))
+	if err != nil {
+		return false, consumer.Retryable(fmt.Errorf("GET %s: %w", rel, err))
+	}
+	return bytes.Equal(got, body), nil
+}
+
+// do sends an authenticated request for rel, a path relative to the base
+// collection.
+func (c *Consumer) do(ctx context.Context, method, rel string, header http.Header, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.resourceURL(rel), r)
+	if err != nil {
+		return nil, consumer.Permanent(fmt.Errorf("create request: %w", err))
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("send request: %w", err)
+		}
+		return nil, consumer.Retryable(fmt.Errorf("send request: %w", err))
+	}
+	return resp, nil
+}
+
+// resourceURL returns the URL of rel within the base collection.
+func (c *Consumer) resourceURL(rel string) string {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return c.URL
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + rel
+	u.RawPath = ""
+	return u.String()
+}
+
+func (c *Consumer) receipt(rel string, duplicate bool) *consumer.Receipt {
+	return &consumer.Receipt{RemoteID: rel, RemoteURL: c.resourceURL(rel), Duplicate: duplicate}
+}
+
+// classify wraps err according to the response. A 409 Conflict means a
+// parent collection is missing, perhaps deleted since it was created, so it
+// is worth trying again.
+func classify(resp *http.Response, err error) error {
+	if resp.StatusCode == http.StatusConflict {
+		return consumer.Retryable(err)
+	}
+	if resp.StatusCode < 400 {
+		return consumer.Permanent(err)
+	}
+	return consumer.ClassifyHTTPStatus(resp.StatusCode, resp.Header, err)
+}
@@ -0,0 +1,274 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+const basePath = "/remote.php/dav/files/alice/Rides"
+
+// fakeDAV is a WebDAV server supporting MKCOL, PUT, PROPFIND and GET, with
+// basic auth.
+type fakeDAV struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nodes    map[string][]byte // nil for collections
+	methods  []string
+	truncate bool // store only half of each upload
+}
+
+func newFakeDAV(t *testing.T) *fakeDAV {
+	t.Helper()
+	f := &fakeDAV{nodes: map[string][]byte{basePath: nil}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeDAV) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "app-password" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.methods = append(f.methods, r.Method)
+
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	node, exists := f.nodes[p]
+	_, parentExists := f.nodes[path.Dir(p)]
+
+	switch r.Method {
+	case "MKCOL":
+		switch {
+		case exists:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case !parentExists:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			f.nodes[p] = nil
+			w.WriteHeader(http.StatusCreated)
+		}
+	case "PUT":
+		if !parentExists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if f.truncate {
+			data = data[:len(data)/2]
+		}
+		f.nodes[p] = data
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Depth") != "0" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		props := "<d:resourcetype><d:collection/></d:resourcetype>"
+		if node != nil {
+			props = fmt.Sprintf(`<d:resourcetype/><d:getcontentlength>%d</d:getcontentlength><d:getetag>"e%d"</d:getetag>`, len(node), len(node))
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+ <d:response>
+  <d:href>%s</d:href>
+  <d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>
+  <d:propstat><d:prop><oc:checksums/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>
+ </d:response>
+</d:multistatus>`, r.URL.EscapedPath(), props)
+	case "GET":
+		if !exists || node == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(node)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestConsumer(f *fakeDAV) *Consumer {
+	return New(f.URL+basePath, "alice", "app-password")
+}
+
+func writeFit(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 9, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestConsumer_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *Consumer
+		wantErr bool
+	}{
+		{"valid", New("https://cloud.example.com/dav", "alice", "pw"), false},
+		{"anonymous", New("https://cloud.example.com/dav", "", ""), false},
+		{"missing URL", New("", "alice", "pw"), true},
+		{"relative URL", New("cloud.example.com/dav", "alice", "pw"), true},
+		{"password without user", New("https://cloud.example.com/dav", "", "pw"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_CreatesCollectionsAndVerifies(t *testing.T) {
+	f := newFakeDAV(t)
+	c := newTestConsumer(f)
+
+	receipt, err := c.Push(context.Background(), writeFit(t, "2024-03-09_Evening Ride.fit", "fit data"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	want := "2024/03/2024-03-09_activity_Evening_Ride.fit"
+	if receipt.RemoteID != want || receipt.Duplicate {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+	if receipt.RemoteURL != f.URL+basePath+"/"+want {
+		t.Errorf("unexpected URL: %s", receipt.RemoteURL)
+	}
+	if string(f.nodes[basePath+"/"+want]) != "fit data" {
+		t.Fatalf("file not stored: %v", f.nodes)
+	}
+	if got := strings.Join(f.methods, ","); got != "MKCOL,MKCOL,PROPFIND,PUT,PROPFIND" {
+		t.Errorf("unexpected requests: %s", got)
+	}
+
+	// Collections are only created once.
+	f.methods = nil
+	if _, err := c.Push(context.Background(), writeFit(t, "other.fit", "more data")); err != nil {
+		t.Fatalf("second Push failed: %v", err)
+	}
+	if got := strings.Join(f.methods, ","); got != "PROPFIND,PUT,PROPFIND" {
+		t.Errorf("unexpected requests: %s", got)
+	}
+}
+
+func TestConsumer_Push_SameFileIsDuplicate(t *testing.T) {
+	f := newFakeDAV(t)
+	c := newTestConsumer(f)
+	p := writeFit(t, "ride.fit", "fit data")
+
+	first, err := c.Push(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.Push(context.Background(), p)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if !second.Duplicate || second.RemoteID != first.RemoteID {
+		t.Errorf("expected duplicate of %s, got %+v", first.RemoteID, second)
+	}
+}
+
+func TestConsumer_Push_NeverOverwritesDifferentFile(t *testing.T) {
+	f := newFakeDAV(t)
+	c := newTestConsumer(f)
+
+	first, err := c.Push(context.Background(), writeFit(t, "ride.fit", "morning"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same size, different content: only a GET tells them apart.
+	second, err := c.Push(context.Background(), writeFit(t, "ride.fit", "evening"))
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if second.RemoteID != strings.TrimSuffix(first.RemoteID, ".fit")+"_2.fit" || second.Duplicate {
+		t.Errorf("expected numbered name, got %+v", second)
+	}
+	if string(f.nodes[basePath+"/"+first.RemoteID]) != "morning" {
+		t.Error("first file was overwritten")
+	}
+}
+
+func TestConsumer_Push_IncompleteUploadIsRetryable(t *testing.T) {
+	f := newFakeDAV(t)
+	f.truncate = true
+
+	_, err := newTestConsumer(f).Push(context.Background(), writeFit(t, "ride.fit", "fit data"))
+	if err == nil || !consumer.IsRetryable(err) {
+		t.Fatalf("expected retryable error, got %v", err)
+	}
+}
+
+func TestConsumer_Push_WrongPasswordIsPermanent(t *testing.T) {
+	f := newFakeDAV(t)
+	c := newTestConsumer(f)
+	c.Password = "wrong"
+
+	_, err := c.Push(context.Background(), writeFit(t, "ride.fit", "fit data"))
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
+
+func TestConsumer_Push_RecreatesDeletedCollection(t *testing.T) {
+	f := newFakeDAV(t)
+	c := newTestConsumer(f)
+	if err := c.SetPathTemplate("{{year}}/{{name}}"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Push(context.Background(), writeFit(t, "a.fit", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone tidies up the backup folder.
+	delete(f.nodes, basePath+"/2024")
+	delete(f.nodes, basePath+"/2024/a.fit")
+
+	_, err := c.Push(context.Background(), writeFit(t, "b.fit", "b"))
+	if err == nil || !consumer.IsRetryable(err) {
+		t.Fatalf("expected retryable error, got %v", err)
+	}
+	if _, err := c.Push(context.Background(), writeFit(t, "b.fit", "b")); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if string(f.nodes[basePath+"/2024/b.fit"]) != "b" {
+		t.Errorf("file not stored: %v", f.nodes)
+	}
+}
+
+func TestConsumer_Push_MissingFile(t *testing.T) {
+	f := newFakeDAV(t)
+	_, err := newTestConsumer(f).Push(context.Background(), filepath.Join(t.TempDir(), "missing.fit"))
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"strings"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+func init() {
+	consumer.Register("webhook", newFromSpec)
+}
+
+// settings are the config keys of a webhook consumer.
+type settings struct {
+	URL             string            `toml:"url"`
+	Method          string            `toml:"method"`
+	Format          string            `toml:"format"`
+	FileField       string            `toml:"file_field"`
+	Body            string            `toml:"body"`
+	Headers         map[string]string `toml:"headers"`
+	Secret          string            `toml:"secret"`
+	SignatureHeader string            `toml:"signature_header"`
+	SuccessStatus   []int             `toml:"success_status"`
+	DuplicateStatus []int             `toml:"duplicate_status"`
+}
+
+// newFromSpec builds a consumer from its [[consumers]] entry.
+func newFromSpec(spec consumer.Spec) (consumer.Consumer, error) {
+	var s settings
+	if err := spec.Settings.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	c := New(s.URL)
+	c.name = spec.Name
+	c.SetLogger(spec.Logger)
+	if s.Method != "" {
+		c.Method = strings.ToUpper(s.Method)
+	}
+	if s.Format != "" {
+		c.Format = Format(strings.ToLower(s.Format))
+	}
+	if s.FileField != "" {
+		c.FileField = s.FileField
+	}
+	if s.SignatureHeader != "" {
+		c.SignatureHeader = s.SignatureHeader
+	}
+	c.Headers = s.Headers
+	c.Secret = s.Secret
+	c.SuccessStatus = s.SuccessStatus
+	c.DuplicateStatus = s.DuplicateStatus
+
+	if s.Body != "" {
+		if err := c.SetBodyTemplate(s.Body); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
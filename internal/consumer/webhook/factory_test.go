@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/consumertest"
+)
+
+func TestFactory_Build(t *testing.T) {
+	wc := consumertest.Build[*Consumer](t, "webhook", "team-api", `
+url = "https://example.com/hook"
+method = "put"
+format = "JSON"
+body = '{"name": {{json .Title}}}'
+secret = "s3cret"
+success_status = [201, 202]
+duplicate_status = [409]
+
+[headers]
+Authorization = "Bearer token"
+`)
+	if wc.Method != "PUT" || wc.Format != FormatJSON {
+		t.Errorf("unexpected consumer: method=%s format=%s", wc.Method, wc.Format)
+	}
+	if wc.bodyTmpl == nil || wc.Secret != "s3cret" || wc.Headers["Authorization"] != "Bearer token" {
+		t.Errorf("settings not applied: %+v", wc)
+	}
+	if len(wc.SuccessStatus) != 2 || len(wc.DuplicateStatus) != 1 {
+		t.Errorf("unexpected statuses: %v %v", wc.SuccessStatus, wc.DuplicateStatus)
+	}
+	if err := wc.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
+
+func TestFactory_Defaults(t *testing.T) {
+	wc := consumertest.Build[*Consumer](t, "webhook", "webhook", `url = "https://example.com"`)
+	if wc.Method != "POST" || wc.Format != FormatRaw || wc.FileField != "file" || wc.SignatureHeader != DefaultSignatureHeader {
+		t.Errorf("unexpected defaults: %+v", wc)
+	}
+}
+
+func TestFactory_InvalidBodyTemplate(t *testing.T) {
+	consumertest.BuildError(t, "webhook", `
+url = "https://example.com"
+format = "json"
+body = "{{.Title"
+`, "parse body template")
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+)
+
+// Format is how the FIT file is sent.
+type Format string
+
+const (
+	// FormatRaw sends the FIT file as the request body.
+	FormatRaw Format = "raw"
+
+	// FormatMultipart sends a multipart form with the file and a JSON
+	// metadata field.
+	FormatMultipart Format = "multipart"
+
+	// FormatJSON sends a JSON document rendered from the body template.
+	FormatJSON Format = "json"
+)
+
+// payload is a FIT file being sent, with the data available to body
+// templates.
+type payload struct {
+	*delivery.TemplateData
+	content []byte
+}
+
+// templateFuncs returns the body template functions for one file: those of
+// delivery.Funcs and
+//
+//	json      encodes a value as JSON, e.g. "name": {{json .Title}}
+//	rfc3339   formats an optional time, or "" if unset
+//	base64    the FIT file, base64-encoded
+func templateFuncs(p *payload) template.FuncMap {
+	fm := delivery.Funcs(p.Start())
+	fm["json"] = func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+	fm["rfc3339"] = func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	fm["base64"] = func() string {
+		return base64.StdEncoding.EncodeToString(p.content)
+	}
+	return fm
+}
+
+// parseBodyTemplate parses a JSON body template. The functions are bound
+// per file when the template is executed.
+func parseBodyTemplate(text string) (*template.Template, error) {
+	empty := &payload{TemplateData: &delivery.TemplateData{}}
+	tmpl, err := template.New("body").Funcs(templateFuncs(empty)).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse body template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderJSON builds a JSON body from the template, or from the metadata
+// itself if there is no template. The result must be valid JSON.
+func renderJSON(tmpl *template.Template, p *payload) ([]byte, error) {
+	if tmpl == nil {
+		return json.Marshal(p.TemplateData)
+	}
+
+	t, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Funcs(templateFuncs(p)).Execute(&buf, p.TemplateData); err != nil {
+		return nil, fmt.Errorf("render body: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("rendered body is not valid JSON: %s", truncate(buf.String(), 200))
+	}
+	return buf.Bytes(), nil
+}
+
+// renderMultipart builds a form with the file in field and the metadata,
+// as JSON, in a "metadata" field.
+func renderMultipart(p *payload, field string) (body []byte, contentType string, err error) {
+	meta, err := json.Marshal(p.TemplateData)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	if err := writer.WriteField("metadata", string(meta)); err != nil {
+		return nil, "", fmt.Errorf("write metadata field: %w", err)
+	}
+	part, err := writer.CreateFormFile(field, p.Filename)
+	if err != nil {
+		return nil, "", fmt.Errorf("create form file: %w", err)
+	}
+	if _, err := part.Write(p.content); err != nil {
+		return nil, "", fmt.Errorf("write file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", fmt.Errorf("close writer: %w", err)
+	}
+	return buf.Bytes(), writer.FormDataContentType(), nil
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
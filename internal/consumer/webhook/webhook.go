@@ -0,0 +1,262 @@
+// Package webhook provides a consumer that sends FIT files to an HTTP
+// endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+const (
+	defaultName = "webhook"
+
+	// DefaultSignatureHeader carries the HMAC signature of signed requests.
+	DefaultSignatureHeader = "X-Fitwatch-Signature"
+
+	// TimestampHeader carries the Unix time a signed request was made.
+	TimestampHeader = "X-Fitwatch-Timestamp"
+)
+
+// Consumer sends FIT files to an HTTP endpoint.
+type Consumer struct {
+	URL    string
+	Method string
+	Format Format
+
+	// FileField is the form field holding the file (multipart only).
+	FileField string
+
+	// Headers are added to every request.
+	Headers map[string]string
+
+	// Secret, if set, signs each request: SignatureHeader is set to
+	// "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>", where
+	// timestamp is the value of the X-Fitwatch-Timestamp header.
+	Secret          string
+	SignatureHeader string
+
+	// SuccessStatus lists the status codes that mean the file was
+	// accepted; empty means any 2xx. DuplicateStatus lists codes that mean
+	// the endpoint already has it.
+	SuccessStatus   []int
+	DuplicateStatus []int
+
+	name     string
+	bodyTmpl *template.Template
+	client   *http.Client
+	logger   *slog.Logger
+}
+
+// New creates a webhook consumer that POSTs the raw FIT file to url.
+func New(url string) *Consumer {
+	return &Consumer{
+		URL:             url,
+		Method:          http.MethodPost,
+		Format:          FormatRaw,
+		FileField:       "file",
+		SignatureHeader: DefaultSignatureHeader,
+		name:            defaultName,
+		client:          &http.Client{},
+		logger:          slog.Default(),
+	}
+}
+
+// SetLogger configures the logger for the consumer.
+func (c *Consumer) SetLogger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// SetBodyTemplate sets the template for FormatJSON bodies; see
+// templateFuncs for the available functions. Without one the body is the
+// file's metadata as JSON.
+func (c *Consumer) SetBodyTemplate(text string) error {
+	tmpl, err := parseBodyTemplate(text)
+	if err != nil {
+		return err
+	}
+	c.bodyTmpl = tmpl
+	return nil
+}
+
+// Name returns the consumer's instance name.
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Validate checks configuration.
+func (c *Consumer) Validate() error {
+	if c.URL == "" {
+		return errors.New("URL is required")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL must be http or https, got %q", c.URL)
+	}
+	switch c.Format {
+	case FormatRaw, FormatMultipart, FormatJSON:
+	default:
+		return fmt.Errorf("unknown format %q (want raw, multipart or json)", c.Format)
+	}
+	return nil
+}
+
+// Push sends a FIT file to the endpoint.
+func (c *Consumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	if err := c.Validate(); err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	content, err := os.ReadFile(fitPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, consumer.Permanent(fmt.Errorf("read file: %w", err))
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	filename := filepath.Base(fitPath)
+	// Metadata is best-effort: a file we can't parse is still sent.
+	meta, _ := fitparser.ParseReader(bytes.NewReader(content), int64(len(content)))
+	p := &payload{TemplateData: delivery.NewTemplateData(fitPath, meta), content: content}
+
+	body, contentType, err := c.buildBody(p)
+	if err != nil {
+		return nil, consumer.Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.Method, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, consumer.Permanent(fmt.Errorf("create request: %w", err))
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Fitwatch-Filename", filename)
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	if c.Secret != "" {
+		c.sign(req, body, time.Now())
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("send request: %w", err)
+		}
+		return nil, consumer.Retryable(fmt.Errorf("send request: %w", err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, consumer.Retryable(fmt.Errorf("read response: %w", err))
+	}
+
+	switch {
+	case slices.Contains(c.DuplicateStatus, resp.StatusCode):
+		receipt := parseReceipt(respBody)
+		receipt.Duplicate = true
+		return receipt, consumer.Duplicate(fmt.Errorf("webhook returned %d", resp.StatusCode))
+
+	case c.isSuccess(resp.StatusCode):
+		return parseReceipt(respBody), nil
+
+	case resp.StatusCode < 400:
+		// Not an error as far as HTTP is concerned, but not what the
+		// endpoint was configured to return either; retrying won't help.
+		return nil, consumer.Permanent(fmt.Errorf("unexpected status %d: %s", resp.StatusCode, truncate(string(respBody), 200)))
+
+	default:
+		apiErr := fmt.Errorf("webhook error %d: %s", resp.StatusCode, truncate(string(respBody), 200))
+		return nil, consumer.ClassifyHTTPStatus(resp.StatusCode, resp.Header, apiErr)
+	}
+}
+
+// buildBody returns the request body and content type for the configured
+// format.
+func (c *Consumer) buildBody(p *payload) ([]byte, string, error) {
+	switch c.Format {
+	case FormatMultipart:
+		return renderMultipart(p, c.FileField)
+	case FormatJSON:
+		body, err := renderJSON(c.bodyTmpl, p)
+		return body, "application/json", err
+	default:
+		return p.content, delivery.ContentType, nil
+	}
+}
+
+// sign adds the timestamp and HMAC signature headers to a request.
+func (c *Consumer) sign(req *http.Request, body []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(c.signatureHeader(), "sha256="+Signature(c.Secret, ts, body))
+}
+
+func (c *Consumer) signatureHeader() string {
+	if c.SignatureHeader == "" {
+		return DefaultSignatureHeader
+	}
+	return c.SignatureHeader
+}
+
+// Signature returns the hex HMAC-SHA256 of "<timestamp>.<body>" under
+// secret. Receivers can use it to verify a request.
+func Signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Consumer) isSuccess(status int) bool {
+	if len(c.SuccessStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	return slices.Contains(c.SuccessStatus, status)
+}
+
+// parseReceipt reads "id" and "url" from a JSON response, if present.
+// Anything else yields an empty receipt: the push itself succeeded.
+func parseReceipt(body []byte) *consumer.Receipt {
+	var resp struct {
+		ID  json.RawMessage `json:"id"`
+		URL string          `json:"url"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return &consumer.Receipt{}
+	}
+
+	receipt := &consumer.Receipt{RemoteURL: resp.URL}
+	var id string
+	if err := json.Unmarshal(resp.ID, &id); err == nil {
+		receipt.RemoteID = id
+	} else if len(resp.ID) > 0 && string(resp.ID) != "null" {
+		// Numeric IDs are recorded as written.
+		receipt.RemoteID = string(resp.ID)
+	}
+	return receipt
+}
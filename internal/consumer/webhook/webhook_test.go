@@ -0,0 +1,368 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/consumer/delivery"
+)
+
+// request is what a test server received.
+type request struct {
+	method string
+	header http.Header
+	body   []byte
+}
+
+// newServer starts a server that records requests and answers with status
+// and body.
+func newServer(t *testing.T, status int, body string) (*httptest.Server, <-chan request) {
+	t.Helper()
+	reqs := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		reqs <- request{method: r.Method, header: r.Header.Clone(), body: data}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, reqs
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sampleFit(t *testing.T) []byte {
+	t.Helper()
+	_, thisFile, _, _ := runtime.Caller(0)
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(thisFile), "..", "..", "..", "testdata", "sample.fit"))
+	if err != nil {
+		t.Skipf("sample.fit not available: %v", err)
+	}
+	return data
+}
+
+func TestConsumer_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       *Consumer
+		wantErr bool
+	}{
+		{"valid", New("https://example.com/hook"), false},
+		{"missing URL", New(""), true},
+		{"bad scheme", New("ftp://example.com"), true},
+		{"bad format", &Consumer{URL: "https://example.com", Format: "xml"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.c.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_Raw(t *testing.T) {
+	server, reqs := newServer(t, http.StatusCreated, `{"id": 42, "url": "https://example.com/a/42"}`)
+	fitPath := writeFile(t, "2025-02-23_Morning_Ride.fit", []byte("fit data"))
+
+	c := New(server.URL)
+	c.Headers = map[string]string{"Authorization": "Bearer token"}
+
+	receipt, err := c.Push(context.Background(), fitPath)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if receipt.RemoteID != "42" || receipt.RemoteURL != "https://example.com/a/42" {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+
+	req := <-reqs
+	if req.method != http.MethodPost {
+		t.Errorf("expected POST, got %s", req.method)
+	}
+	if string(req.body) != "fit data" {
+		t.Errorf("unexpected body: %q", req.body)
+	}
+	if got := req.header.Get("Content-Type"); got != delivery.ContentType {
+		t.Errorf("unexpected content type: %s", got)
+	}
+	if got := req.header.Get("X-Fitwatch-Filename"); got != "2025-02-23_Morning_Ride.fit" {
+		t.Errorf("unexpected filename header: %s", got)
+	}
+	if got := req.header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("unexpected Authorization: %s", got)
+	}
+	if req.header.Get(DefaultSignatureHeader) != "" {
+		t.Error("unsigned consumer should not send a signature")
+	}
+}
+
+func TestConsumer_Push_Multipart(t *testing.T) {
+	var file, metadata string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+			return
+		}
+		f, header, err := r.FormFile("upload")
+		if err != nil {
+			t.Errorf("form file: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		file = header.Filename + ":" + string(data)
+		metadata = r.FormValue("metadata")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	fitPath := writeFile(t, "2025-02-23_Morning_Ride.fit", []byte("fit data"))
+	c := New(server.URL)
+	c.Format = FormatMultipart
+	c.FileField = "upload"
+
+	if _, err := c.Push(context.Background(), fitPath); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if file != "2025-02-23_Morning_Ride.fit:fit data" {
+		t.Errorf("unexpected file part: %q", file)
+	}
+
+	var meta map[string]any
+	if err := json.Unmarshal([]byte(metadata), &meta); err != nil {
+		t.Fatalf("metadata is not JSON: %v (%q)", err, metadata)
+	}
+	if meta["Title"] != "Morning Ride" {
+		t.Errorf("unexpected metadata title: %v", meta["Title"])
+	}
+}
+
+func TestConsumer_Push_JSONTemplate(t *testing.T) {
+	server, reqs := newServer(t, http.StatusOK, "")
+	fitPath := writeFile(t, "ride.fit", sampleFit(t))
+
+	c := New(server.URL)
+	c.Format = FormatJSON
+	c.Method = http.MethodPut
+	if err := c.SetBodyTemplate(`{
+		"title": {{json .Title}},
+		"sport": {{json .ActivityType}},
+		"distance_m": {{.DistanceMeters}},
+		"start": {{json (rfc3339 .StartTime)}},
+		"file": {{json base64}}
+	}`); err != nil {
+		t.Fatalf("SetBodyTemplate failed: %v", err)
+	}
+
+	if _, err := c.Push(context.Background(), fitPath); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	req := <-reqs
+	if req.method != http.MethodPut {
+		t.Errorf("expected PUT, got %s", req.method)
+	}
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("unexpected content type: %s", got)
+	}
+
+	var body struct {
+		Title    string  `json:"title"`
+		Sport    string  `json:"sport"`
+		Distance float64 `json:"distance_m"`
+		Start    string  `json:"start"`
+		File     []byte  `json:"file"`
+	}
+	if err := json.Unmarshal(req.body, &body); err != nil {
+		t.Fatalf("body is not JSON: %v\n%s", err, req.body)
+	}
+	if body.Title != "ride" || body.Sport == "" || body.Distance <= 0 {
+		t.Errorf("unexpected body: %+v", body)
+	}
+	if _, err := time.Parse(time.RFC3339, body.Start); err != nil {
+		t.Errorf("unexpected start %q: %v", body.Start, err)
+	}
+	if len(body.File) != len(sampleFit(t)) {
+		t.Errorf("expected file of %d bytes, got %d", len(sampleFit(t)), len(body.File))
+	}
+}
+
+func TestConsumer_Push_JSONDefaultsToMetadata(t *testing.T) {
+	server, reqs := newServer(t, http.StatusOK, "")
+	fitPath := writeFile(t, "2025-02-23_Morning_Ride.fit", []byte("not a FIT file"))
+
+	c := New(server.URL)
+	c.Format = FormatJSON
+	if _, err := c.Push(context.Background(), fitPath); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal((<-reqs).body, &body); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if body["Filename"] != "2025-02-23_Morning_Ride.fit" {
+		t.Errorf("unexpected body: %v", body)
+	}
+}
+
+func TestConsumer_Push_InvalidJSONIsPermanent(t *testing.T) {
+	server, reqs := newServer(t, http.StatusOK, "")
+	fitPath := writeFile(t, "ride.fit", []byte("data"))
+
+	c := New(server.URL)
+	c.Format = FormatJSON
+	if err := c.SetBodyTemplate(`{"title": {{.Title}}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.Push(context.Background(), fitPath)
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if len(reqs) != 0 {
+		t.Error("invalid body should not be sent")
+	}
+}
+
+func TestConsumer_Push_Signed(t *testing.T) {
+	server, reqs := newServer(t, http.StatusOK, "")
+	fitPath := writeFile(t, "ride.fit", []byte("fit data"))
+
+	c := New(server.URL)
+	c.Secret = "s3cret"
+	c.SignatureHeader = "X-Hub-Signature-256"
+	if _, err := c.Push(context.Background(), fitPath); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	req := <-reqs
+	ts := req.header.Get(TimestampHeader)
+	if ts == "" {
+		t.Fatal("missing timestamp header")
+	}
+	want := "sha256=" + Signature("s3cret", ts, req.body)
+	if got := req.header.Get("X-Hub-Signature-256"); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if Signature("other", ts, req.body) == Signature("s3cret", ts, req.body) {
+		t.Error("signature should depend on the secret")
+	}
+}
+
+func TestConsumer_Push_StatusHandling(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		success       []int
+		duplicate     []int
+		wantErr       bool
+		wantPermanent bool
+		wantDuplicate bool
+		wantRetryable bool
+	}{
+		{name: "any 2xx", status: http.StatusAccepted},
+		{name: "listed success", status: http.StatusCreated, success: []int{201}},
+		{name: "unlisted 2xx", status: http.StatusOK, success: []int{201}, wantErr: true, wantPermanent: true},
+		{name: "redirect", status: http.StatusFound, wantErr: true, wantPermanent: true},
+		{name: "duplicate", status: http.StatusConflict, duplicate: []int{409}, wantErr: true, wantDuplicate: true},
+		{name: "conflict not configured", status: http.StatusConflict, wantErr: true, wantPermanent: true},
+		{name: "server error", status: http.StatusBadGateway, wantErr: true, wantRetryable: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, wantErr: true, wantRetryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status == http.StatusFound {
+					// Without a Location the client returns it as is.
+					w.Header().Set("Location", "")
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"id": "abc"}`))
+			}))
+			defer server.Close()
+
+			c := New(server.URL)
+			c.SuccessStatus = tt.success
+			c.DuplicateStatus = tt.duplicate
+
+			receipt, err := c.Push(context.Background(), writeFile(t, "ride.fit", []byte("data")))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Push error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if receipt.RemoteID != "abc" {
+					t.Errorf("unexpected receipt: %+v", receipt)
+				}
+				return
+			}
+			if consumer.IsPermanent(err) != tt.wantPermanent {
+				t.Errorf("IsPermanent = %v, want %v (%v)", consumer.IsPermanent(err), tt.wantPermanent, err)
+			}
+			if consumer.IsDuplicate(err) != tt.wantDuplicate {
+				t.Errorf("IsDuplicate = %v, want %v", consumer.IsDuplicate(err), tt.wantDuplicate)
+			}
+			if consumer.IsRetryable(err) != tt.wantRetryable {
+				t.Errorf("IsRetryable = %v, want %v", consumer.IsRetryable(err), tt.wantRetryable)
+			}
+			if tt.wantDuplicate && (receipt == nil || !receipt.Duplicate || receipt.RemoteID != "abc") {
+				t.Errorf("expected duplicate receipt, got %+v", receipt)
+			}
+		})
+	}
+}
+
+func TestConsumer_Push_MissingFile(t *testing.T) {
+	c := New("http://127.0.0.1:1")
+	_, err := c.Push(context.Background(), filepath.Join(t.TempDir(), "missing.fit"))
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
+
+func TestParseReceipt(t *testing.T) {
+	tests := []struct {
+		body   string
+		wantID string
+	}{
+		{`{"id": "a1"}`, "a1"},
+		{`{"id": 17}`, "17"},
+		{`{"id": null}`, ""},
+		{`not json`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := parseReceipt([]byte(tt.body)).RemoteID; got != tt.wantID {
+			t.Errorf("parseReceipt(%q).RemoteID = %q, want %q", tt.body, got, tt.wantID)
+		}
+	}
+}
+
+func TestSignature_Stable(t *testing.T) {
+	got := Signature("key", "1700000000", []byte("body"))
+	if len(got) != 64 || strings.Trim(got, "0123456789abcdef") != "" {
+		t.Errorf("expected hex SHA-256, got %q", got)
+	}
+	if got != Signature("key", "1700000000", []byte("body")) {
+		t.Error("signature is not deterministic")
+	}
+}
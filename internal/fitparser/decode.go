@@ -0,0 +1,227 @@
+package fitparser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	// ErrNotFIT is returned for data that doesn't have a FIT file header.
+	ErrNotFIT = errors.New("not a FIT file")
+
+	// ErrChecksum is returned when a FIT file's header or data CRC is wrong,
+	// usually because the file was truncated or corrupted.
+	ErrChecksum = errors.New("FIT checksum mismatch")
+)
+
+// Record header bits.
+const (
+	headerCompressed = 0x80
+	headerDefinition = 0x40
+	headerDevData    = 0x20
+	localTypeMask    = 0x0F
+)
+
+// ReadFile decodes the FIT file at path; see Decode.
+func ReadFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	return Decode(f)
+}
+
+// Decode reads a FIT file into its messages, checking the CRCs. Messages
+// sent with compressed timestamp headers get an explicit FieldTimestamp.
+// Chained FIT files (several files back to back) are not supported.
+func Decode(r io.Reader) (*File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read FIT: %w", err)
+	}
+
+	if len(data) < 12 {
+		return nil, ErrNotFIT
+	}
+	headerSize := int(data[0])
+	if (headerSize != 12 && headerSize != 14) || len(data) < headerSize || string(data[8:12]) != ".FIT" {
+		return nil, ErrNotFIT
+	}
+	end := headerSize + int(binary.LittleEndian.Uint32(data[4:8]))
+	if len(data) < end+2 {
+		return nil, fmt.Errorf("%w: file is truncated", ErrChecksum)
+	}
+	if len(data) > end+2 {
+		return nil, errors.New("chained FIT files are not supported")
+	}
+	// A header CRC of 0 means the writer didn't compute one.
+	if headerSize == 14 {
+		if crc := binary.LittleEndian.Uint16(data[12:14]); crc != 0 && crc != checksum(data[:12]) {
+			return nil, fmt.Errorf("%w: header", ErrChecksum)
+		}
+	}
+	if checksum(data[:end]) != binary.LittleEndian.Uint16(data[end:]) {
+		return nil, ErrChecksum
+	}
+
+	d := decoder{data: data[headerSize:end], offset: headerSize}
+	f := &File{
+		ProtocolVersion: data[1],
+		ProfileVersion:  binary.LittleEndian.Uint16(data[2:4]),
+	}
+	for len(d.data) > 0 {
+		m, err := d.record()
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			f.Messages = append(f.Messages, m)
+		}
+	}
+	return f, nil
+}
+
+// definition is the layout of the messages of one local type.
+type definition struct {
+	num       uint16
+	bigEndian bool
+	fields    []fieldDef
+	devFields []devFieldDef
+}
+
+type fieldDef struct {
+	num  uint8
+	size uint8
+	typ  BaseType
+}
+
+type devFieldDef struct {
+	num      uint8
+	size     uint8
+	devIndex uint8
+}
+
+type decoder struct {
+	data   []byte
+	offset int // of data in the file, for errors
+
+	defs      [localTypeMask + 1]*definition
+	timestamp uint32 // the last one seen, for compressed timestamps
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	if len(d.data) < n {
+		return nil, fmt.Errorf("FIT record at offset %d is truncated", d.offset)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	d.offset += n
+	return b, nil
+}
+
+// record reads one record, returning the message for a data record and
+// nil for a definition.
+func (d *decoder) record() (*Message, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	header := b[0]
+
+	switch {
+	case header&headerCompressed != 0:
+		local := (header >> 5) & 0x03
+		m, err := d.message(local)
+		if err != nil {
+			return nil, err
+		}
+		// The header holds the low five bits of the timestamp; it is the
+		// first time after the last timestamp with those bits.
+		offset := uint32(header & 0x1F)
+		t := d.timestamp&^0x1F | offset
+		if t < d.timestamp {
+			t += 0x20
+		}
+		d.timestamp = t
+		m.Remove(FieldTimestamp)
+		m.SetUint(FieldTimestamp, TypeUint32, uint64(t))
+		return m, nil
+
+	case header&headerDefinition != 0:
+		return nil, d.definition(header)
+
+	default:
+		return d.message(header & localTypeMask)
+	}
+}
+
+func (d *decoder) definition(header uint8) error {
+	b, err := d.read(5)
+	if err != nil {
+		return err
+	}
+	def := &definition{bigEndian: b[1] == 1}
+	if def.bigEndian {
+		def.num = binary.BigEndian.Uint16(b[2:4])
+	} else {
+		def.num = binary.LittleEndian.Uint16(b[2:4])
+	}
+
+	fields, err := d.read(3 * int(b[4]))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(fields); i += 3 {
+		def.fields = append(def.fields, fieldDef{num: fields[i], size: fields[i+1], typ: BaseType(fields[i+2])})
+	}
+
+	if header&headerDevData != 0 {
+		n, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		devFields, err := d.read(3 * int(n[0]))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(devFields); i += 3 {
+			def.devFields = append(def.devFields, devFieldDef{num: devFields[i], size: devFields[i+1], devIndex: devFields[i+2]})
+		}
+	}
+
+	d.defs[header&localTypeMask] = def
+	return nil
+}
+
+func (d *decoder) message(local uint8) (*Message, error) {
+	def := d.defs[local]
+	if def == nil {
+		return nil, fmt.Errorf("FIT message at offset %d uses undefined local type %d", d.offset-1, local)
+	}
+
+	m := &Message{Num: def.num, LocalType: local, BigEndian: def.bigEndian}
+	m.Fields = make([]Field, 0, len(def.fields))
+	for _, fd := range def.fields {
+		b, err := d.read(int(fd.size))
+		if err != nil {
+			return nil, err
+		}
+		m.Fields = append(m.Fields, Field{Num: fd.num, Type: fd.typ, Data: append([]byte(nil), b...)})
+	}
+	for _, fd := range def.devFields {
+		b, err := d.read(int(fd.size))
+		if err != nil {
+			return nil, err
+		}
+		m.DevFields = append(m.DevFields, DevField{Num: fd.num, DevIndex: fd.devIndex, Data: append([]byte(nil), b...)})
+	}
+
+	if t, ok := m.Uint(FieldTimestamp); ok {
+		d.timestamp = uint32(t)
+	}
+	return m, nil
+}
@@ -0,0 +1,220 @@
+package fitparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Header versions Encode uses for a File that doesn't set them: protocol
+// 2.0, which allows developer fields, and profile 21.40.
+const (
+	defaultProtocolVersion = 0x20
+	defaultProfileVersion  = 2140
+)
+
+// Encode writes the file in FIT format, with a 14-byte header and correct
+// header and file CRCs. A definition message is written before each
+// message whose layout differs from the previous one of its local type.
+func (f *File) Encode(w io.Writer) error {
+	var body bytes.Buffer
+	var defs [localTypeMask + 1]*definition
+
+	for i, m := range f.Messages {
+		def, err := m.definition()
+		if err != nil {
+			return fmt.Errorf("message %d (global %d): %w", i, m.Num, err)
+		}
+		if !def.equal(defs[m.LocalType]) {
+			def.write(&body, m.LocalType)
+			defs[m.LocalType] = def
+		}
+		body.WriteByte(m.LocalType)
+		for _, fld := range m.Fields {
+			body.Write(fld.Data)
+		}
+		for _, fld := range m.DevFields {
+			body.Write(fld.Data)
+		}
+	}
+	if body.Len() > 0xFFFFFFFF {
+		return fmt.Errorf("FIT data too large (%d bytes)", body.Len())
+	}
+
+	protocol, profile := f.ProtocolVersion, f.ProfileVersion
+	if protocol == 0 {
+		protocol = defaultProtocolVersion
+	}
+	if profile == 0 {
+		profile = defaultProfileVersion
+	}
+	header := make([]byte, 14)
+	header[0] = 14
+	header[1] = protocol
+	binary.LittleEndian.PutUint16(header[2:], profile)
+	binary.LittleEndian.PutUint32(header[4:], uint32(body.Len()))
+	copy(header[8:], ".FIT")
+	binary.LittleEndian.PutUint16(header[12:], checksum(header[:12]))
+
+	crc := make([]byte, 2)
+	binary.LittleEndian.PutUint16(crc, checksumUpdate(checksum(header), body.Bytes()))
+
+	for _, b := range [][]byte{header, body.Bytes(), crc} {
+		if _, err := w.Write(b); err != nil {
+			return fmt.Errorf("write FIT: %w", err)
+		}
+	}
+	return nil
+}
+
+// Edit decodes FIT data, lets edit change it, and encodes the result: the
+// way to derive one file from another. If edit reports no change, data is
+// returned as it was rather than re-encoded.
+func Edit(data []byte, edit func(f *File) (changed bool, err error)) ([]byte, error) {
+	f, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode FIT: %w", err)
+	}
+	changed, err := edit(f)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	if err := f.Encode(&buf); err != nil {
+		return nil, fmt.Errorf("encode FIT: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile encodes the file to path, replacing it atomically so readers
+// never see a partial file.
+func (f *File) WriteFile(path string) error {
+	var buf bytes.Buffer
+	if err := f.Encode(&buf); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*.fit")
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// definition returns the message's layout, checking it can be encoded.
+func (m *Message) definition() (*definition, error) {
+	if m.LocalType > localTypeMask {
+		return nil, fmt.Errorf("local type %d out of range", m.LocalType)
+	}
+	if len(m.Fields) > 255 || len(m.DevFields) > 255 {
+		return nil, fmt.Errorf("too many fields")
+	}
+
+	def := &definition{num: m.Num, bigEndian: m.BigEndian}
+	for _, fld := range m.Fields {
+		if len(fld.Data) == 0 || len(fld.Data) > 255 {
+			return nil, fmt.Errorf("field %d has %d bytes", fld.Num, len(fld.Data))
+		}
+		if size := fld.Type.Size(); size > 0 && len(fld.Data)%size != 0 {
+			return nil, fmt.Errorf("field %d has %d bytes, not a multiple of its type's %d", fld.Num, len(fld.Data), size)
+		}
+		def.fields = append(def.fields, fieldDef{num: fld.Num, size: uint8(len(fld.Data)), typ: fld.Type})
+	}
+	for _, fld := range m.DevFields {
+		if len(fld.Data) == 0 || len(fld.Data) > 255 {
+			return nil, fmt.Errorf("developer field %d has %d bytes", fld.Num, len(fld.Data))
+		}
+		def.devFields = append(def.devFields, devFieldDef{num: fld.Num, size: uint8(len(fld.Data)), devIndex: fld.DevIndex})
+	}
+	return def, nil
+}
+
+func (d *definition) equal(o *definition) bool {
+	if o == nil || d.num != o.num || d.bigEndian != o.bigEndian ||
+		len(d.fields) != len(o.fields) || len(d.devFields) != len(o.devFields) {
+		return false
+	}
+	for i := range d.fields {
+		if d.fields[i] != o.fields[i] {
+			return false
+		}
+	}
+	for i := range d.devFields {
+		if d.devFields[i] != o.devFields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *definition) write(buf *bytes.Buffer, local uint8) {
+	header := headerDefinition | local
+	if len(d.devFields) > 0 {
+		header |= headerDevData
+	}
+	buf.WriteByte(header)
+	buf.WriteByte(0) // reserved
+
+	num := make([]byte, 2)
+	if d.bigEndian {
+		buf.WriteByte(1)
+		binary.BigEndian.PutUint16(num, d.num)
+	} else {
+		buf.WriteByte(0)
+		binary.LittleEndian.PutUint16(num, d.num)
+	}
+	buf.Write(num)
+
+	buf.WriteByte(uint8(len(d.fields)))
+	for _, fd := range d.fields {
+		buf.Write([]byte{fd.num, fd.size, uint8(fd.typ)})
+	}
+	if len(d.devFields) > 0 {
+		buf.WriteByte(uint8(len(d.devFields)))
+		for _, fd := range d.devFields {
+			buf.Write([]byte{fd.num, fd.size, fd.devIndex})
+		}
+	}
+}
+
+// crcTable is the nibble table of the FIT CRC-16.
+var crcTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+// checksum returns the FIT CRC of data.
+func checksum(data []byte) uint16 {
+	return checksumUpdate(0, data)
+}
+
+func checksumUpdate(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := crcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ crcTable[b&0xF]
+
+		tmp = crcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ crcTable[(b>>4)&0xF]
+	}
+	return crc
+}
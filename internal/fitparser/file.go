@@ -0,0 +1,384 @@
+package fitparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"time"
+)
+
+// File is a FIT file as its sequence of messages, kept as raw field data
+// rather than interpreted against the FIT profile. That makes it lossless:
+// developer fields and messages this package knows nothing about survive a
+// Decode and Encode unchanged, so it is the way to write corrected or
+// anonymised copies of a file. Use Parse to read what a file means.
+type File struct {
+	// ProtocolVersion and ProfileVersion are copied from the file header.
+	// Encode uses defaults for a File that leaves them zero.
+	ProtocolVersion uint8
+	ProfileVersion  uint16
+
+	Messages []*Message
+}
+
+// Message is one data message: a file_id, record, lap, session, etc.
+type Message struct {
+	// Num is the global message number, e.g. MesgRecord.
+	Num uint16
+
+	// LocalType is the local message type (0-15) the message was written
+	// with. Encode writes a new definition whenever a message's fields
+	// differ from the last message of the same local type, so giving each
+	// kind of message its own local type keeps files small.
+	LocalType uint8
+
+	// BigEndian is the byte order of multi-byte field data.
+	BigEndian bool
+
+	Fields    []Field
+	DevFields []DevField
+}
+
+// Field is one field of a message.
+type Field struct {
+	Num  uint8
+	Type BaseType
+
+	// Data is the value in the message's byte order. It may hold an array
+	// of several values of Type.
+	Data []byte
+}
+
+// DevField is a developer field, described by the field_description
+// message with the same developer data index and field number.
+type DevField struct {
+	Num      uint8
+	DevIndex uint8
+	Data     []byte
+}
+
+// Global message numbers from the FIT profile.
+const (
+	MesgFileID               uint16 = 0
+	MesgSession              uint16 = 18
+	MesgLap                  uint16 = 19
+	MesgRecord               uint16 = 20
+	MesgEvent                uint16 = 21
+	MesgDeviceInfo           uint16 = 23
+	MesgCoursePoint          uint16 = 32
+	MesgActivity             uint16 = 34
+	MesgTrainingFile         uint16 = 72
+	MesgHrv                  uint16 = 78
+	MesgLength               uint16 = 101
+	MesgWeatherConditions    uint16 = 128
+	MesgWeatherAlert         uint16 = 129
+	MesgHr                   uint16 = 132
+	MesgSegmentLap           uint16 = 142
+	MesgSegmentPoint         uint16 = 150
+	MesgGpsMetadata          uint16 = 160
+	MesgTimestampCorrelation uint16 = 162
+	MesgFieldDescription     uint16 = 206
+	MesgDeveloperDataID      uint16 = 207
+	MesgBeatIntervals        uint16 = 290
+	MesgHrvStatusSummary     uint16 = 370
+	MesgHrvValue             uint16 = 371
+)
+
+// FieldTimestamp is the field number of every message's timestamp.
+const FieldTimestamp uint8 = 253
+
+// FileIDType is the file_id field holding the file type, FileTypeActivity
+// for an activity.
+const (
+	FileIDType       uint8 = 0
+	FileTypeActivity       = 4
+)
+
+// Field numbers used when editing activities, from the FIT profile.
+const (
+	RecordPositionLat  uint8 = 0
+	RecordPositionLong uint8 = 1
+	RecordDistance     uint8 = 5 // uint32, centimetres
+
+	// Laps and sessions use the same numbers for the fields they share.
+	LapStartTime         uint8 = 2
+	LapStartPositionLat  uint8 = 3
+	LapStartPositionLong uint8 = 4
+	LapEndPositionLat    uint8 = 5
+	LapEndPositionLong   uint8 = 6
+	LapTotalElapsedTime  uint8 = 7 // uint32, milliseconds
+	LapTotalDistance     uint8 = 9 // uint32, centimetres
+	LapNecLat            uint8 = 27
+	LapNecLong           uint8 = 28
+	LapSwcLat            uint8 = 29
+	LapSwcLong           uint8 = 30
+
+	SessionStartTime         uint8 = 2
+	SessionStartPositionLat  uint8 = 3
+	SessionStartPositionLong uint8 = 4
+	SessionTotalElapsedTime  uint8 = 7 // uint32, milliseconds
+	SessionTotalDistance     uint8 = 9 // uint32, centimetres
+	SessionNecLat            uint8 = 29
+	SessionNecLong           uint8 = 30
+	SessionSwcLat            uint8 = 31
+	SessionSwcLong           uint8 = 32
+	SessionEndPositionLat    uint8 = 38
+	SessionEndPositionLong   uint8 = 39
+)
+
+// BaseType is a FIT base type, which sets a field's size, signedness and
+// the value that marks it invalid (missing).
+type BaseType uint8
+
+// FIT base types. The 0x80 bit marks types whose byte order matters.
+const (
+	TypeEnum    BaseType = 0x00
+	TypeSint8   BaseType = 0x01
+	TypeUint8   BaseType = 0x02
+	TypeSint16  BaseType = 0x83
+	TypeUint16  BaseType = 0x84
+	TypeSint32  BaseType = 0x85
+	TypeUint32  BaseType = 0x86
+	TypeString  BaseType = 0x07
+	TypeFloat32 BaseType = 0x88
+	TypeFloat64 BaseType = 0x89
+	TypeUint8z  BaseType = 0x0A
+	TypeUint16z BaseType = 0x8B
+	TypeUint32z BaseType = 0x8C
+	TypeByte    BaseType = 0x0D
+	TypeSint64  BaseType = 0x8E
+	TypeUint64  BaseType = 0x8F
+	TypeUint64z BaseType = 0x90
+)
+
+// baseTypes describes each base type, indexed by its number (the low five
+// bits).
+var baseTypes = [...]struct {
+	size    int
+	signed  bool
+	float   bool
+	invalid uint64
+}{
+	0x00: {1, false, false, 0xFF},               // enum
+	0x01: {1, true, false, 0x7F},                // sint8
+	0x02: {1, false, false, 0xFF},               // uint8
+	0x03: {2, true, false, 0x7FFF},              // sint16
+	0x04: {2, false, false, 0xFFFF},             // uint16
+	0x05: {4, true, false, 0x7FFFFFFF},          // sint32
+	0x06: {4, false, false, 0xFFFFFFFF},         // uint32
+	0x07: {1, false, false, 0x00},               // string
+	0x08: {4, false, true, 0xFFFFFFFF},          // float32
+	0x09: {8, false, true, math.MaxUint64},      // float64
+	0x0A: {1, false, false, 0x00},               // uint8z
+	0x0B: {2, false, false, 0x0000},             // uint16z
+	0x0C: {4, false, false, 0x00000000},         // uint32z
+	0x0D: {1, false, false, 0xFF},               // byte
+	0x0E: {8, true, false, math.MaxInt64},       // sint64
+	0x0F: {8, false, false, math.MaxUint64},     // uint64
+	0x10: {8, false, false, 0x0000000000000000}, // uint64z
+}
+
+// Size returns the size in bytes of one value of the type, or 0 if the type
+// is unknown.
+func (t BaseType) Size() int {
+	if n := int(t & 0x1F); n < len(baseTypes) {
+		return baseTypes[n].size
+	}
+	return 0
+}
+
+func (t BaseType) known() bool     { return t.Size() > 0 }
+func (t BaseType) signed() bool    { return t.known() && baseTypes[t&0x1F].signed }
+func (t BaseType) float() bool     { return t.known() && baseTypes[t&0x1F].float }
+func (t BaseType) invalid() uint64 { return baseTypes[t&0x1F].invalid }
+
+// fitEpoch is the zero of FIT timestamps, 1989-12-31T00:00:00Z.
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Find returns the messages with the given global number, in file order.
+func (f *File) Find(num uint16) []*Message {
+	var found []*Message
+	for _, m := range f.Messages {
+		if m.Num == num {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+// Clone returns a deep copy of the file, for editing without changing f.
+func (f *File) Clone() *File {
+	c := &File{ProtocolVersion: f.ProtocolVersion, ProfileVersion: f.ProfileVersion}
+	c.Messages = make([]*Message, len(f.Messages))
+	for i, m := range f.Messages {
+		c.Messages[i] = m.Clone()
+	}
+	return c
+}
+
+// Clone returns a deep copy of the message.
+func (m *Message) Clone() *Message {
+	c := *m
+	c.Fields = slices.Clone(m.Fields)
+	for i := range c.Fields {
+		c.Fields[i].Data = bytes.Clone(c.Fields[i].Data)
+	}
+	c.DevFields = slices.Clone(m.DevFields)
+	for i := range c.DevFields {
+		c.DevFields[i].Data = bytes.Clone(c.DevFields[i].Data)
+	}
+	return &c
+}
+
+// Field returns the message's field with the given number, or nil.
+func (m *Message) Field(num uint8) *Field {
+	for i := range m.Fields {
+		if m.Fields[i].Num == num {
+			return &m.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Remove deletes the field with the given number, if the message has it.
+func (m *Message) Remove(num uint8) {
+	for i := range m.Fields {
+		if m.Fields[i].Num == num {
+			m.Fields = append(m.Fields[:i], m.Fields[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *Message) order() binary.ByteOrder {
+	if m.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// raw returns the bits of a single-valued integer field, and whether the
+// message has such a field.
+func (m *Message) raw(num uint8) (uint64, BaseType, bool) {
+	f := m.Field(num)
+	if f == nil || !f.Type.known() || f.Type.float() || f.Type == TypeString || len(f.Data) != f.Type.Size() {
+		return 0, 0, false
+	}
+	var v uint64
+	switch len(f.Data) {
+	case 1:
+		v = uint64(f.Data[0])
+	case 2:
+		v = uint64(m.order().Uint16(f.Data))
+	case 4:
+		v = uint64(m.order().Uint32(f.Data))
+	case 8:
+		v = m.order().Uint64(f.Data)
+	}
+	return v, f.Type, true
+}
+
+// Uint returns the value of an unsigned integer field. ok is false if the
+// message has no such field or its value is invalid.
+func (m *Message) Uint(num uint8) (v uint64, ok bool) {
+	v, typ, ok := m.raw(num)
+	if !ok || typ.signed() || v == typ.invalid() {
+		return 0, false
+	}
+	return v, true
+}
+
+// Int returns the value of a signed integer field. ok is false if the
+// message has no such field or its value is invalid.
+func (m *Message) Int(num uint8) (v int64, ok bool) {
+	raw, typ, ok := m.raw(num)
+	if !ok || !typ.signed() || raw == typ.invalid() {
+		return 0, false
+	}
+	// Sign-extend from the field's size.
+	shift := 64 - 8*typ.Size()
+	return int64(raw<<shift) >> shift, true
+}
+
+// Time returns the value of a date_time field, such as FieldTimestamp.
+func (m *Message) Time(num uint8) (time.Time, bool) {
+	v, ok := m.Uint(num)
+	if !ok {
+		return time.Time{}, false
+	}
+	return fitEpoch.Add(time.Duration(v) * time.Second), true
+}
+
+// Position returns a latitude and longitude in degrees from a pair of
+// semicircle fields, such as RecordPositionLat and RecordPositionLong.
+func (m *Message) Position(latField, lonField uint8) (lat, lon float64, ok bool) {
+	latSC, ok1 := m.Int(latField)
+	lonSC, ok2 := m.Int(lonField)
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+	return semicirclesToDegrees(latSC), semicirclesToDegrees(lonSC), true
+}
+
+// SetPosition sets a pair of semicircle fields from degrees, adding them if
+// needed.
+func (m *Message) SetPosition(latField, lonField uint8, lat, lon float64) {
+	m.SetInt(latField, TypeSint32, degreesToSemicircles(lat))
+	m.SetInt(lonField, TypeSint32, degreesToSemicircles(lon))
+}
+
+func semicirclesToDegrees(sc int64) float64  { return float64(sc) * 180 / (1 << 31) }
+func degreesToSemicircles(deg float64) int64 { return int64(math.Round(deg * (1 << 31) / 180)) }
+
+// SetUint sets an unsigned integer field. A field the message doesn't have
+// is added with type typ; an existing field keeps its type.
+func (m *Message) SetUint(num uint8, typ BaseType, v uint64) {
+	m.setRaw(num, typ, v)
+}
+
+// SetInt sets a signed integer field. A field the message doesn't have is
+// added with type typ; an existing field keeps its type.
+func (m *Message) SetInt(num uint8, typ BaseType, v int64) {
+	m.setRaw(num, typ, uint64(v))
+}
+
+// SetTime sets a date_time field, adding it if needed.
+func (m *Message) SetTime(num uint8, t time.Time) {
+	m.SetUint(num, TypeUint32, uint64(t.Sub(fitEpoch)/time.Second))
+}
+
+func (m *Message) setRaw(num uint8, typ BaseType, v uint64) {
+	f := m.Field(num)
+	if f == nil {
+		m.Fields = append(m.Fields, Field{Num: num, Type: typ, Data: make([]byte, typ.Size())})
+		f = &m.Fields[len(m.Fields)-1]
+	}
+	putRaw(m.order(), f.Data, v)
+}
+
+// Invalidate sets every value of a field to its type's invalid value,
+// marking it missing. It does nothing if the message has no such field.
+func (m *Message) Invalidate(num uint8) {
+	f := m.Field(num)
+	if f == nil || !f.Type.known() {
+		return
+	}
+	size := f.Type.Size()
+	for i := 0; i+size <= len(f.Data); i += size {
+		putRaw(m.order(), f.Data[i:i+size], f.Type.invalid())
+	}
+}
+
+func putRaw(order binary.ByteOrder, b []byte, v uint64) {
+	switch len(b) {
+	case 1:
+		b[0] = uint8(v)
+	case 2:
+		order.PutUint16(b, uint16(v))
+	case 4:
+		order.PutUint32(b, uint32(v))
+	case 8:
+		order.PutUint64(b, v)
+	}
+}
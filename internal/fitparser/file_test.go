@@ -0,0 +1,373 @@
+package fitparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tormoder/fit"
+)
+
+func readSample(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(getTestdataPath(), "sample.fit"))
+	if os.IsNotExist(err) {
+		t.Skip("sample.fit not found in testdata")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encode(t *testing.T, f *File) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := f.Encode(&buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncode_RoundTripsSample(t *testing.T) {
+	data := readSample(t)
+
+	f, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	for _, num := range []uint16{MesgFileID, MesgSession, MesgLap, MesgRecord, MesgEvent, MesgDeveloperDataID, MesgFieldDescription} {
+		if len(f.Find(num)) == 0 {
+			t.Errorf("expected messages of type %d", num)
+		}
+	}
+	if len(f.Find(MesgRecord)) != 7562 {
+		t.Errorf("expected 7562 records, got %d", len(f.Find(MesgRecord)))
+	}
+	devFields := 0
+	for _, m := range f.Messages {
+		devFields += len(m.DevFields)
+	}
+	if devFields == 0 {
+		t.Error("expected developer fields")
+	}
+
+	// The sample has one definition per layout change and no compressed
+	// timestamps, so it encodes back to exactly the same bytes.
+	out := encode(t, f)
+	if !bytes.Equal(out, data) {
+		t.Fatalf("round trip changed the file: %d bytes in, %d out", len(data), len(out))
+	}
+
+	again, err := Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Decode of output failed: %v", err)
+	}
+	if !reflect.DeepEqual(again, f) {
+		t.Error("decoded output differs from input")
+	}
+}
+
+func TestEncode_EditedSampleDecodes(t *testing.T) {
+	f, err := Decode(bytes.NewReader(readSample(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited := f.Clone()
+	for _, m := range edited.Find(MesgRecord) {
+		ts, ok := m.Time(FieldTimestamp)
+		if !ok {
+			t.Fatal("record without timestamp")
+		}
+		m.SetTime(FieldTimestamp, ts.Add(time.Hour))
+		m.Invalidate(0) // position_lat
+		m.Invalidate(1) // position_long
+	}
+	edited.Messages = append(edited.Messages, &Message{Num: MesgEvent, Fields: []Field{
+		{Num: FieldTimestamp, Type: TypeUint32, Data: []byte{0, 0, 0, 0}},
+	}})
+
+	// The standard decoder checks the CRCs and reads the edits back.
+	before, err := fit.Decode(bytes.NewReader(encode(t, f)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := fit.Decode(bytes.NewReader(encode(t, edited)))
+	if err != nil {
+		t.Fatalf("edited file doesn't decode: %v", err)
+	}
+	beforeAct, _ := before.Activity()
+	afterAct, err := after.Activity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(afterAct.Records) != len(beforeAct.Records) || len(afterAct.Events) != len(beforeAct.Events)+1 {
+		t.Fatalf("expected %d records and %d events, got %d and %d",
+			len(beforeAct.Records), len(beforeAct.Events)+1, len(afterAct.Records), len(afterAct.Events))
+	}
+	for i, r := range afterAct.Records {
+		if !r.Timestamp.Equal(beforeAct.Records[i].Timestamp.Add(time.Hour)) {
+			t.Fatalf("record %d at %v, want an hour after %v", i, r.Timestamp, beforeAct.Records[i].Timestamp)
+		}
+		if !r.PositionLat.Invalid() || !r.PositionLong.Invalid() {
+			t.Fatalf("record %d still has a position", i)
+		}
+		if r.Power != beforeAct.Records[i].Power {
+			t.Fatalf("record %d lost its power", i)
+		}
+	}
+	if !reflect.DeepEqual(afterAct.Sessions, beforeAct.Sessions) {
+		t.Error("sessions changed")
+	}
+
+	// Editing the clone left the original alone.
+	if _, ok := f.Find(MesgRecord)[0].Int(0); !ok {
+		t.Error("original lost its position")
+	}
+}
+
+func TestEncode_DeveloperFields(t *testing.T) {
+	created := time.Date(2024, 3, 9, 18, 0, 0, 0, time.UTC)
+	fileID := &Message{Num: MesgFileID}
+	fileID.SetUint(0, TypeEnum, 4)     // type: activity
+	fileID.SetUint(1, TypeUint16, 255) // manufacturer: development
+	fileID.SetTime(4, created)
+
+	f := &File{Messages: []*Message{
+		fileID,
+		{Num: MesgDeveloperDataID, LocalType: 1, Fields: []Field{
+			{Num: 1, Type: TypeByte, Data: bytes.Repeat([]byte{0xAB}, 16)}, // application_id
+			{Num: 3, Type: TypeUint8, Data: []byte{0}},                     // developer_data_index
+		}},
+		{Num: MesgFieldDescription, LocalType: 2, Fields: []Field{
+			{Num: 0, Type: TypeUint8, Data: []byte{0}},                    // developer_data_index
+			{Num: 1, Type: TypeUint8, Data: []byte{0}},                    // field_definition_number
+			{Num: 2, Type: TypeUint8, Data: []byte{uint8(TypeUint16)}},    // fit_base_type_id
+			{Num: 3, Type: TypeString, Data: []byte("core_temp\x00\x00")}, // field_name
+		}},
+	}}
+	for i := 0; i < 3; i++ {
+		r := &Message{Num: MesgRecord, LocalType: 3, BigEndian: i == 2}
+		r.SetTime(FieldTimestamp, created.Add(time.Duration(i)*time.Second))
+		r.SetUint(3, TypeUint8, uint64(120+i)) // heart_rate
+		temp := make([]byte, 2)
+		r.order().PutUint16(temp, uint16(3700+i))
+		r.DevFields = []DevField{{Num: 0, DevIndex: 0, Data: temp}}
+		f.Messages = append(f.Messages, r)
+	}
+
+	out := encode(t, f)
+	f.ProtocolVersion, f.ProfileVersion = defaultProtocolVersion, defaultProfileVersion
+	got, err := Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, f) {
+		t.Errorf("round trip differs:\n got %+v\nwant %+v", got.Messages, f.Messages)
+	}
+
+	decoded, err := fit.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("standard decoder rejects the file: %v", err)
+	}
+	act, err := decoded.Activity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(act.Records) != 3 || act.Records[2].HeartRate != 122 || !act.Records[2].Timestamp.Equal(created.Add(2*time.Second)) {
+		t.Errorf("unexpected records: %+v", act.Records)
+	}
+}
+
+// rawFIT wraps records in a FIT header and CRC.
+func rawFIT(records ...[]byte) []byte {
+	body := bytes.Join(records, nil)
+	header := []byte{12, 0x20, 0, 0, 0, 0, 0, 0, '.', 'F', 'I', 'T'}
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(body)))
+	data := append(header, body...)
+	return binary.LittleEndian.AppendUint16(data, checksum(data))
+}
+
+func TestDecode_CompressedTimestamps(t *testing.T) {
+	data := rawFIT(
+		// Local 0: record with timestamp and heart rate.
+		[]byte{0x40, 0, 0, 20, 0, 2, 253, 4, 0x86, 3, 1, 0x02},
+		[]byte{0x00, 0x3E, 0, 0, 0, 100}, // timestamp 62, heart rate 100
+		// Local 1: record with only heart rate, sent with compressed
+		// timestamps.
+		[]byte{0x41, 0, 0, 20, 0, 1, 3, 1, 0x02},
+		[]byte{0x80 | 1<<5 | 0x1F, 101}, // 63
+		[]byte{0x80 | 1<<5 | 0x02, 102}, // wraps to 66
+	)
+
+	f, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	var times []uint64
+	for _, m := range f.Find(MesgRecord) {
+		ts, _ := m.Uint(FieldTimestamp)
+		times = append(times, ts)
+	}
+	if !reflect.DeepEqual(times, []uint64{62, 63, 66}) {
+		t.Errorf("unexpected timestamps %v", times)
+	}
+
+	// Encoding writes the timestamps out in full.
+	again, err := Decode(bytes.NewReader(encode(t, f)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hr, _ := again.Messages[2].Uint(3); hr != 102 {
+		t.Errorf("unexpected heart rate %d", hr)
+	}
+	if ts, _ := again.Messages[2].Uint(FieldTimestamp); ts != 66 {
+		t.Errorf("unexpected timestamp %d", ts)
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	sample := readSample(t)
+	corrupt := bytes.Clone(sample)
+	corrupt[1000] ^= 0xFF
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrNotFIT},
+		{"not FIT", []byte("this is not a FIT file at all"), ErrNotFIT},
+		{"corrupt", corrupt, ErrChecksum},
+		{"truncated", sample[:len(sample)-100], ErrChecksum},
+		{"chained", append(bytes.Clone(sample), sample...), nil},
+		{"undefined local type", rawFIT([]byte{0x03}), nil},
+		{"record cut short", rawFIT([]byte{0x40, 0, 0, 20, 0, 1, 253, 4, 0x86}, []byte{0x00, 1, 2}), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(bytes.NewReader(tt.data))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestMessage_Values(t *testing.T) {
+	m := &Message{}
+	m.SetInt(0, TypeSint32, -495723112)
+	m.SetUint(5, TypeUint32, 123456)
+	m.SetInt(9, TypeSint8, -3)
+
+	if v, ok := m.Int(0); !ok || v != -495723112 {
+		t.Errorf("Int = %d, %v", v, ok)
+	}
+	if v, ok := m.Int(9); !ok || v != -3 {
+		t.Errorf("Int = %d, %v", v, ok)
+	}
+	if v, ok := m.Uint(5); !ok || v != 123456 {
+		t.Errorf("Uint = %d, %v", v, ok)
+	}
+	if _, ok := m.Uint(0); ok {
+		t.Error("Uint read a signed field")
+	}
+	if _, ok := m.Uint(7); ok {
+		t.Error("Uint read a missing field")
+	}
+
+	m.Invalidate(0)
+	if _, ok := m.Int(0); ok {
+		t.Error("invalidated field still has a value")
+	}
+	if f := m.Field(0); f == nil || !bytes.Equal(f.Data, []byte{0xFF, 0xFF, 0xFF, 0x7F}) {
+		t.Errorf("unexpected invalid data %v", f)
+	}
+
+	m.Remove(5)
+	if m.Field(5) != nil || len(m.Fields) != 2 {
+		t.Errorf("field not removed: %+v", m.Fields)
+	}
+
+	m.SetPosition(RecordPositionLat, RecordPositionLong, 51.501364, -0.14189)
+	if lat, lon, ok := m.Position(RecordPositionLat, RecordPositionLong); !ok || math.Abs(lat-51.501364) > 1e-6 || math.Abs(lon+0.14189) > 1e-6 {
+		t.Errorf("Position = %f, %f, %v", lat, lon, ok)
+	}
+
+	when := time.Date(2024, 3, 9, 18, 30, 0, 0, time.UTC)
+	m.SetTime(FieldTimestamp, when)
+	if got, ok := m.Time(FieldTimestamp); !ok || !got.Equal(when) {
+		t.Errorf("Time = %v, %v", got, ok)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	data := readSample(t)
+
+	out, err := Edit(data, func(f *File) (bool, error) { return false, nil })
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	if &out[0] != &data[0] {
+		t.Error("expected unchanged data to be returned as it was")
+	}
+
+	out, err = Edit(data, func(f *File) (bool, error) {
+		for _, m := range f.Find(MesgRecord) {
+			m.Invalidate(RecordPositionLat)
+			m.Invalidate(RecordPositionLong)
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+	f, err := Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Decode of output failed: %v", err)
+	}
+	if _, _, ok := f.Find(MesgRecord)[0].Position(RecordPositionLat, RecordPositionLong); ok {
+		t.Error("expected edited record to have no position")
+	}
+
+	wantErr := errors.New("edit failed")
+	if _, err := Edit(data, func(f *File) (bool, error) { return false, wantErr }); err != wantErr {
+		t.Errorf("expected edit's error, got %v", err)
+	}
+	if _, err := Edit([]byte("not a FIT file"), func(f *File) (bool, error) { return true, nil }); !errors.Is(err, ErrNotFIT) {
+		t.Errorf("expected ErrNotFIT, got %v", err)
+	}
+}
+
+func TestFile_WriteFile(t *testing.T) {
+	f, err := Decode(bytes.NewReader(readSample(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "copy.fit")
+	if err := f.WriteFile(path); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if len(got.Messages) != len(f.Messages) {
+		t.Errorf("expected %d messages, got %d", len(f.Messages), len(got.Messages))
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the written file, got %v", entries)
+	}
+}
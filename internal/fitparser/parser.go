@@ -1,4 +1,6 @@
-// Package fitparser provides FIT file parsing functionality.
+// Package fitparser provides FIT file parsing functionality, and a lossless
+// FIT codec (File, Edit) for writing modified copies of files, which the
+// transform package's transforms are built on.
 package fitparser
 
 import (
@@ -6,7 +8,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/tormoder/fit"
@@ -19,7 +25,11 @@ type Metadata struct {
 	Size int64
 
 	// Activity data
+	// ActivityType is the sub-sport if there is one (e.g. "VirtualActivity"),
+	// otherwise the sport (e.g. "Cycling").
 	ActivityType   string
+	Sport          string
+	SubSport       string
 	ActivityName   string
 	StartTime      *time.Time
 	EndTime        *time.Time
@@ -48,6 +58,43 @@ type Metadata struct {
 	Product         string
 	SerialNumber    uint32
 	SoftwareVersion string
+
+	// Sessions are the activity's sessions in order: one for most
+	// activities, or one per leg (and transition) of a multisport activity
+	// such as a triathlon. The fields above total them up: sums of times,
+	// distances, calories and elevation, maximums of maximums, and averages
+	// weighted by each session's moving time. The sport is "Multisport" if
+	// the sessions' sports differ.
+	Sessions []Session
+}
+
+// Session is one session of an activity. Fields the device didn't record
+// are zero.
+type Session struct {
+	// ActivityType is the sub-sport if there is one, otherwise the sport.
+	ActivityType string
+	Sport        string
+	SubSport     string
+
+	StartTime      *time.Time
+	EndTime        *time.Time
+	DurationSecs   int
+	ElapsedSecs    int
+	DistanceMeters float64
+	Calories       int
+
+	AvgPower     int
+	MaxPower     int
+	NormPower    int
+	AvgHeartRate int
+	MaxHeartRate int
+	AvgCadence   int
+	MaxCadence   int
+	AvgSpeedMPS  float64
+	MaxSpeedMPS  float64
+
+	TotalAscent  float64
+	TotalDescent float64
 }
 
 // Parse reads a FIT file and extracts metadata.
@@ -131,86 +178,175 @@ func HashFile(path string) (string, error) {
 }
 
 func extractActivity(meta *Metadata, activity *fit.ActivityFile) {
-	// Only use first session (if available)
-	if len(activity.Sessions) == 0 {
+	for _, session := range activity.Sessions {
+		meta.Sessions = append(meta.Sessions, extractSession(session))
+	}
+	if len(meta.Sessions) == 0 {
 		return
 	}
-	session := activity.Sessions[0]
+
+	// Activity type: the sessions' own if they share one.
+	first := meta.Sessions[0]
+	meta.Sport, meta.SubSport, meta.ActivityType = first.Sport, first.SubSport, first.ActivityType
+	for _, s := range meta.Sessions[1:] {
+		if s.Sport != meta.Sport {
+			meta.Sport = fit.SportMultisport.String()
+			meta.SubSport = ""
+			meta.ActivityType = meta.Sport
+			break
+		}
+		if s.SubSport != meta.SubSport {
+			meta.SubSport = ""
+			meta.ActivityType = meta.Sport
+		}
+	}
+
+	// Totals, and the averages weighted by moving time.
+	var powerTime, hrTime, cadenceTime, speedTime, normTime float64
+	var power, hr, cadence, speed, norm4 float64
+	for _, s := range meta.Sessions {
+		if s.StartTime != nil && (meta.StartTime == nil || s.StartTime.Before(*meta.StartTime)) {
+			meta.StartTime = s.StartTime
+		}
+		if s.EndTime != nil && (meta.EndTime == nil || s.EndTime.After(*meta.EndTime)) {
+			meta.EndTime = s.EndTime
+		}
+		meta.DurationSecs += s.DurationSecs
+		meta.ElapsedSecs += s.ElapsedSecs
+		meta.DistanceMeters += s.DistanceMeters
+		meta.Calories += s.Calories
+		meta.TotalAscent += s.TotalAscent
+		meta.TotalDescent += s.TotalDescent
+
+		meta.MaxPower = max(meta.MaxPower, s.MaxPower)
+		meta.MaxHeartRate = max(meta.MaxHeartRate, s.MaxHeartRate)
+		meta.MaxCadence = max(meta.MaxCadence, s.MaxCadence)
+		meta.MaxSpeedMPS = max(meta.MaxSpeedMPS, s.MaxSpeedMPS)
+
+		// A single session's average stands even without a moving time.
+		t := max(float64(s.DurationSecs), 1)
+		if s.AvgPower > 0 {
+			power += t * float64(s.AvgPower)
+			powerTime += t
+		}
+		if s.AvgHeartRate > 0 {
+			hr += t * float64(s.AvgHeartRate)
+			hrTime += t
+		}
+		if s.AvgCadence > 0 {
+			cadence += t * float64(s.AvgCadence)
+			cadenceTime += t
+		}
+		if s.AvgSpeedMPS > 0 {
+			speed += t * s.AvgSpeedMPS
+			speedTime += t
+		}
+		// Normalized power is a fourth-power mean, so combine it as one.
+		if s.NormPower > 0 {
+			norm4 += t * math.Pow(float64(s.NormPower), 4)
+			normTime += t
+		}
+	}
+	if powerTime > 0 {
+		meta.AvgPower = int(math.Round(power / powerTime))
+	}
+	if hrTime > 0 {
+		meta.AvgHeartRate = int(math.Round(hr / hrTime))
+	}
+	if cadenceTime > 0 {
+		meta.AvgCadence = int(math.Round(cadence / cadenceTime))
+	}
+	if speedTime > 0 {
+		meta.AvgSpeedMPS = speed / speedTime
+	}
+	if normTime > 0 {
+		meta.NormPower = int(math.Round(math.Pow(norm4/normTime, 0.25)))
+	}
+}
+
+func extractSession(session *fit.SessionMsg) Session {
+	var s Session
 
 	// Activity type
-	meta.ActivityType = session.Sport.String()
+	s.Sport = session.Sport.String()
+	s.ActivityType = s.Sport
 	if session.SubSport != fit.SubSportGeneric {
-		meta.ActivityType = session.SubSport.String()
+		s.SubSport = session.SubSport.String()
+		s.ActivityType = s.SubSport
 	}
 
 	// Timestamps
 	if !session.StartTime.IsZero() {
 		t := session.StartTime
-		meta.StartTime = &t
+		s.StartTime = &t
 	}
 	if !session.Timestamp.IsZero() {
 		t := session.Timestamp
-		meta.EndTime = &t
+		s.EndTime = &t
 	}
 
 	// Duration (stored as milliseconds in uint32)
-	if session.TotalTimerTime != 0 {
-		meta.DurationSecs = int(session.TotalTimerTime / 1000)
+	if session.TotalTimerTime != 0 && session.TotalTimerTime != 0xFFFFFFFF {
+		s.DurationSecs = int(session.TotalTimerTime / 1000)
 	}
-	if session.TotalElapsedTime != 0 {
-		meta.ElapsedSecs = int(session.TotalElapsedTime / 1000)
+	if session.TotalElapsedTime != 0 && session.TotalElapsedTime != 0xFFFFFFFF {
+		s.ElapsedSecs = int(session.TotalElapsedTime / 1000)
 	}
 
 	// Distance
-	if session.TotalDistance != 0 {
-		meta.DistanceMeters = float64(session.TotalDistance) / 100 // cm to m
+	if session.TotalDistance != 0 && session.TotalDistance != 0xFFFFFFFF {
+		s.DistanceMeters = float64(session.TotalDistance) / 100 // cm to m
 	}
 
 	// Calories
-	meta.Calories = int(session.TotalCalories)
+	if session.TotalCalories != 0xFFFF {
+		s.Calories = int(session.TotalCalories)
+	}
 
 	// Power
 	if session.AvgPower != 0xFFFF {
-		meta.AvgPower = int(session.AvgPower)
+		s.AvgPower = int(session.AvgPower)
 	}
 	if session.MaxPower != 0xFFFF {
-		meta.MaxPower = int(session.MaxPower)
+		s.MaxPower = int(session.MaxPower)
 	}
 	if session.NormalizedPower != 0xFFFF {
-		meta.NormPower = int(session.NormalizedPower)
+		s.NormPower = int(session.NormalizedPower)
 	}
 
 	// Heart rate
 	if session.AvgHeartRate != 0xFF {
-		meta.AvgHeartRate = int(session.AvgHeartRate)
+		s.AvgHeartRate = int(session.AvgHeartRate)
 	}
 	if session.MaxHeartRate != 0xFF {
-		meta.MaxHeartRate = int(session.MaxHeartRate)
+		s.MaxHeartRate = int(session.MaxHeartRate)
 	}
 
 	// Cadence
 	if session.AvgCadence != 0xFF {
-		meta.AvgCadence = int(session.AvgCadence)
+		s.AvgCadence = int(session.AvgCadence)
 	}
 	if session.MaxCadence != 0xFF {
-		meta.MaxCadence = int(session.MaxCadence)
+		s.MaxCadence = int(session.MaxCadence)
 	}
 
 	// Speed
 	if session.AvgSpeed != 0xFFFF {
-		meta.AvgSpeedMPS = float64(session.AvgSpeed) / 1000 // mm/s to m/s
+		s.AvgSpeedMPS = float64(session.AvgSpeed) / 1000 // mm/s to m/s
 	}
 	if session.MaxSpeed != 0xFFFF {
-		meta.MaxSpeedMPS = float64(session.MaxSpeed) / 1000
+		s.MaxSpeedMPS = float64(session.MaxSpeed) / 1000
 	}
 
 	// Elevation
 	if session.TotalAscent != 0xFFFF {
-		meta.TotalAscent = float64(session.TotalAscent)
+		s.TotalAscent = float64(session.TotalAscent)
 	}
 	if session.TotalDescent != 0xFFFF {
-		meta.TotalDescent = float64(session.TotalDescent)
+		s.TotalDescent = float64(session.TotalDescent)
 	}
+
+	return s
 }
 
 func extractDeviceInfo(meta *Metadata, fitFile *fit.File) {
@@ -225,3 +361,32 @@ func extractDeviceInfo(meta *Metadata, fitFile *fit.File) {
 		meta.SerialNumber = fitFile.FileId.SerialNumber
 	}
 }
+
+// datePrefix matches a leading YYYY-MM-DD date, as in "2025-02-23_Name".
+var datePrefix = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[_-]?`)
+
+// TitleFromFilename extracts a human-readable name from a FIT filename.
+// Examples:
+//   - "2025-02-23_Hudayriyat_Ascend.fit" -> "Hudayriyat Ascend"
+//   - "2025-02-23_.fit" -> "" (no name)
+//   - "activity.fit" -> "" (no recognizable pattern)
+func TitleFromFilename(filename string) string {
+	// Remove extension
+	name := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	name = datePrefix.ReplaceAllString(name, "")
+
+	// If nothing left after removing date, no name
+	if name == "" || name == "_" {
+		return ""
+	}
+
+	// Replace underscores and hyphens with spaces
+	name = strings.ReplaceAll(name, "_", " ")
+	name = strings.ReplaceAll(name, "-", " ")
+
+	// Clean up multiple spaces and trim
+	name = strings.Join(strings.Fields(name), " ")
+
+	return name
+}
@@ -1,10 +1,13 @@
 package fitparser
 
 import (
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func getTestdataPath() string {
@@ -66,6 +69,139 @@ func TestParse_ActivityType(t *testing.T) {
 	if meta.ActivityType == "" {
 		t.Log("Warning: no activity type found")
 	}
+	if meta.ActivityType != meta.Sport && meta.ActivityType != meta.SubSport {
+		t.Errorf("activity type %q is neither sport %q nor sub-sport %q", meta.ActivityType, meta.Sport, meta.SubSport)
+	}
+}
+
+func TestParse_SingleSession(t *testing.T) {
+	samplePath := filepath.Join(getTestdataPath(), "sample.fit")
+
+	if _, err := os.Stat(samplePath); os.IsNotExist(err) {
+		t.Skip("sample.fit not found in testdata")
+	}
+
+	meta, err := Parse(samplePath)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if len(meta.Sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(meta.Sessions))
+	}
+	s := meta.Sessions[0]
+	if s.Sport != meta.Sport || s.ActivityType != meta.ActivityType || s.DurationSecs != meta.DurationSecs ||
+		s.DistanceMeters != meta.DistanceMeters || s.AvgPower != meta.AvgPower || s.NormPower != meta.NormPower ||
+		s.AvgHeartRate != meta.AvgHeartRate || s.AvgSpeedMPS != meta.AvgSpeedMPS {
+		t.Errorf("totals differ from the only session:\nsession %+v\ntotals  %+v", s, meta)
+	}
+}
+
+// writeMultisport writes a triathlon: a swim, a transition, a ride and a run.
+func writeMultisport(t *testing.T) string {
+	t.Helper()
+	start := time.Date(2024, 6, 2, 7, 0, 0, 0, time.UTC)
+
+	fileID := &Message{Num: MesgFileID}
+	fileID.SetUint(0, TypeEnum, 4) // type: activity
+	fileID.SetUint(1, TypeUint16, 1)
+	fileID.SetTime(4, start)
+	f := &File{Messages: []*Message{fileID}}
+
+	legs := []struct {
+		sport, subSport                    uint64
+		secs, meters                       uint64
+		calories, avgHR, maxHR, avgPower   uint64
+		normPower, avgSpeedMMS, maxSpeedMS uint64
+	}{
+		{5, 18, 1800, 1500, 400, 140, 160, 0xFFFF, 0xFFFF, 833, 1200},  // open water swim
+		{3, 0, 120, 100, 20, 150, 155, 0xFFFF, 0xFFFF, 833, 2000},      // transition
+		{2, 0, 3600, 40000, 900, 150, 175, 200, 220, 11111, 16000},     // road ride
+		{1, 0, 2400, 10000, 700, 165, 185, 0xFFFF, 0xFFFF, 4167, 5000}, // run
+	}
+	at := start
+	for i, leg := range legs {
+		s := &Message{Num: MesgSession, LocalType: 1}
+		s.SetTime(FieldTimestamp, at.Add(time.Duration(leg.secs)*time.Second))
+		s.SetTime(2, at)
+		s.SetUint(5, TypeEnum, leg.sport)
+		s.SetUint(6, TypeEnum, leg.subSport)
+		s.SetUint(7, TypeUint32, leg.secs*1000)
+		s.SetUint(8, TypeUint32, leg.secs*1000)
+		s.SetUint(9, TypeUint32, leg.meters*100)
+		s.SetUint(11, TypeUint16, leg.calories)
+		s.SetUint(14, TypeUint16, leg.avgSpeedMMS)
+		s.SetUint(15, TypeUint16, leg.maxSpeedMS)
+		s.SetUint(16, TypeUint8, leg.avgHR)
+		s.SetUint(17, TypeUint8, leg.maxHR)
+		s.SetUint(20, TypeUint16, leg.avgPower)
+		s.SetUint(34, TypeUint16, leg.normPower)
+		s.SetUint(254, TypeUint16, uint64(i)) // message_index
+		f.Messages = append(f.Messages, s)
+		at = at.Add(time.Duration(leg.secs) * time.Second)
+	}
+
+	activity := &Message{Num: MesgActivity, LocalType: 2}
+	activity.SetTime(FieldTimestamp, at)
+	activity.SetUint(1, TypeUint16, uint64(len(legs))) // num_sessions
+	f.Messages = append(f.Messages, activity)
+
+	path := filepath.Join(t.TempDir(), "triathlon.fit")
+	if err := f.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParse_Multisport(t *testing.T) {
+	meta, err := Parse(writeMultisport(t))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if len(meta.Sessions) != 4 {
+		t.Fatalf("expected 4 sessions, got %d", len(meta.Sessions))
+	}
+	var sports []string
+	for _, s := range meta.Sessions {
+		sports = append(sports, s.ActivityType)
+	}
+	if strings.Join(sports, ",") != "OpenWater,Transition,Cycling,Running" {
+		t.Errorf("unexpected sessions %v", sports)
+	}
+	swim := meta.Sessions[0]
+	if swim.Sport != "Swimming" || swim.DistanceMeters != 1500 || swim.DurationSecs != 1800 || swim.AvgPower != 0 {
+		t.Errorf("unexpected swim %+v", swim)
+	}
+
+	if meta.Sport != "Multisport" || meta.ActivityType != "Multisport" || meta.SubSport != "" {
+		t.Errorf("expected multisport, got %q/%q/%q", meta.Sport, meta.SubSport, meta.ActivityType)
+	}
+	if meta.DurationSecs != 7920 || meta.ElapsedSecs != 7920 || meta.DistanceMeters != 51600 || meta.Calories != 2020 {
+		t.Errorf("unexpected totals: %ds, %ds elapsed, %.0f m, %d kcal", meta.DurationSecs, meta.ElapsedSecs, meta.DistanceMeters, meta.Calories)
+	}
+	if want := time.Date(2024, 6, 2, 7, 0, 0, 0, time.UTC); meta.StartTime == nil || !meta.StartTime.Equal(want) {
+		t.Errorf("expected start %v, got %v", want, meta.StartTime)
+	}
+	if want := time.Date(2024, 6, 2, 9, 12, 0, 0, time.UTC); meta.EndTime == nil || !meta.EndTime.Equal(want) {
+		t.Errorf("expected end %v, got %v", want, meta.EndTime)
+	}
+
+	// Power only covers the ride; heart rate and speed every leg.
+	if meta.AvgPower != 200 || meta.NormPower != 220 {
+		t.Errorf("expected the ride's power, got avg %d NP %d", meta.AvgPower, meta.NormPower)
+	}
+	if meta.MaxHeartRate != 185 || meta.MaxSpeedMPS != 16 {
+		t.Errorf("unexpected maximums: HR %d, speed %.1f", meta.MaxHeartRate, meta.MaxSpeedMPS)
+	}
+	// (1800*140 + 120*150 + 3600*150 + 2400*165) / 7920
+	if meta.AvgHeartRate != 152 {
+		t.Errorf("expected time-weighted average HR 152, got %d", meta.AvgHeartRate)
+	}
+	// Total distance over total time.
+	if want := 51600.0 / 7920; math.Abs(meta.AvgSpeedMPS-want) > 0.01 {
+		t.Errorf("expected average speed %.2f, got %.2f", want, meta.AvgSpeedMPS)
+	}
 }
 
 func TestParse_HasTimestamps(t *testing.T) {
@@ -290,3 +426,29 @@ func BenchmarkHashFile(b *testing.B) {
 		}
 	}
 }
+
+func TestTitleFromFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"2025-02-23_Hudayriyat_Ascend.fit", "Hudayriyat Ascend"},
+		{"2025-02-23_Coffee_Trail.fit", "Coffee Trail"},
+		{"2025-05-05_Area_52.fit", "Area 52"},
+		{"2025-02-23_.fit", ""},
+		{"2025-02-23.fit", ""},
+		{"activity.fit", "activity"},
+		{"My_Ride.fit", "My Ride"},
+		{"2025-04-07_Al_Wathba-L.fit", "Al Wathba L"},
+		{"2025-05-30_Heian_Samurai_Circuit.fit", "Heian Samurai Circuit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got := TitleFromFilename(tt.filename)
+			if got != tt.want {
+				t.Errorf("TitleFromFilename(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// CallbackPath is the path of the loopback redirect URI.
+const CallbackPath = "/callback"
+
+// AuthorizeOptions configures an authorization.
+type AuthorizeOptions struct {
+	// Port is the loopback port to listen on for the redirect. Providers
+	// that match redirect URIs exactly need a fixed port; zero picks a free
+	// one.
+	Port int
+
+	// Out is where the authorization URL is printed for the user to open.
+	Out io.Writer
+
+	// Open, if set, is called with the authorization URL to open it in a
+	// browser. Failing to open it isn't an error, since the URL has been
+	// printed.
+	Open func(url string) error
+}
+
+// Consumer is implemented by consumers that authenticate with OAuth2, so
+// `fitwatch auth` can authorize them.
+type Consumer interface {
+	OAuth() *Source
+}
+
+// callbackResult is what the provider redirected back with.
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// Authorize runs the authorization code flow with PKCE: it listens on a
+// loopback port, has the user approve fitwatch in a browser, exchanges the
+// code the provider redirects back with for a token, and saves it. It
+// returns when the token is saved or ctx is done.
+func (s *Source) Authorize(ctx context.Context, opts AuthorizeOptions) (*Token, error) {
+	if s.cfg.AuthURL == "" || s.cfg.TokenURL == "" {
+		return nil, errors.New("provider has no authorization endpoints")
+	}
+
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(opts.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("listen for redirect: %w", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	// Providers commonly allow "localhost" as a callback domain without
+	// registering a port.
+	redirectURI := fmt.Sprintf("http://localhost:%d%s", port, CallbackPath)
+
+	results := make(chan callbackResult, 1)
+	srv := &http.Server{
+		Handler:           callbackHandler(state, results),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer func() { _ = srv.Close() }()
+
+	authURL := s.AuthCodeURL(state, challenge(verifier), redirectURI)
+	if opts.Out != nil {
+		fmt.Fprintf(opts.Out, "Open this URL to authorize fitwatch:\n\n  %s\n\nWaiting for authorization on %s ...\n", authURL, redirectURI)
+	}
+	if opts.Open != nil {
+		_ = opts.Open(authURL)
+	}
+
+	var result callbackResult
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result = <-results:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	tok, err := exchange(ctx, s.client, s.cfg, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {result.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	if err := s.set(ctx, tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// AuthCodeURL returns the URL at which the user authorizes the client.
+func (s *Source) AuthCodeURL(state, codeChallenge, redirectURI string) string {
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.cfg.ClientID},
+		"redirect_uri":          {redirectURI},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	if s.cfg.Scope != "" {
+		q.Set("scope", s.cfg.Scope)
+	}
+	for k, v := range s.cfg.AuthParams {
+		q.Set(k, v)
+	}
+
+	u, err := url.Parse(s.cfg.AuthURL)
+	if err != nil {
+		return s.cfg.AuthURL + "?" + q.Encode()
+	}
+	existing := u.Query()
+	for k, v := range q {
+		existing[k] = v
+	}
+	u.RawQuery = existing.Encode()
+	return u.String()
+}
+
+// callbackHandler receives the provider's redirect and reports the code,
+// or why there isn't one, on results. Only the first valid redirect counts.
+func callbackHandler(state string, results chan<- callbackResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(CallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("state") != state {
+			http.Error(w, "Unexpected state; start the authorization again.", http.StatusBadRequest)
+			return
+		}
+
+		var result callbackResult
+		switch {
+		case q.Get("error") != "":
+			result.err = fmt.Errorf("authorization denied: %s %s", q.Get("error"), q.Get("error_description"))
+		case q.Get("code") == "":
+			result.err = errors.New("authorization response has no code")
+		default:
+			result.code = q.Get("code")
+		}
+
+		select {
+		case results <- result:
+		default:
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if result.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "<p>fitwatch was not authorized: %s</p>", html.EscapeString(result.err.Error()))
+			return
+		}
+		fmt.Fprint(w, "<p>fitwatch is authorized. You can close this window.</p>")
+	})
+	return mux
+}
+
+// challenge derives the S256 PKCE code challenge from a verifier.
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes, base64url-encoded. With n = 32 it
+// makes a 43 character PKCE verifier.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,114 @@
+// Package oauth manages OAuth2 tokens for consumers. Tokens are kept in the
+// consumer's state in the sync store, refreshed shortly before they expire,
+// and obtained in the first place with an authorization code flow that uses
+// PKCE and a loopback redirect. Transport authenticates HTTP requests with
+// them.
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+// expiryMargin is how long before expiry an access token is refreshed.
+const expiryMargin = 5 * time.Minute
+
+// Config describes a provider and the client registered with it.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+
+	// AuthURL is where the user authorizes the client; TokenURL is where
+	// codes and refresh tokens are exchanged for tokens.
+	AuthURL  string
+	TokenURL string
+
+	// Scope is sent as is, since providers differ in how they separate
+	// scopes (Strava uses commas).
+	Scope string
+
+	// AuthParams are extra parameters for the authorization URL.
+	AuthParams map[string]string
+}
+
+// Token is an access token and the refresh token that renews it.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid reports whether the access token can be used for a while yet.
+// A token without an expiry is assumed not to expire.
+func (t *Token) Valid(now time.Time) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || t.Expiry.Sub(now) > expiryMargin
+}
+
+// tokenResponse is a token endpoint's reply. Providers give the lifetime as
+// expires_in (standard) or expires_at (Strava also sends this).
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+// exchange posts a token request and returns the token it yields. The
+// client credentials are sent in the form, which every provider we use
+// accepts.
+func exchange(ctx context.Context, client *http.Client, cfg Config, form url.Values) (*Token, error) {
+	form.Set("client_id", cfg.ClientID)
+	if cfg.ClientSecret != "" {
+		form.Set("client_secret", cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("token request: %w", err)
+		}
+		return nil, consumer.Retryable(fmt.Errorf("token request: %w", err))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, consumer.Retryable(fmt.Errorf("read token response: %w", err))
+	}
+	if resp.StatusCode >= 400 {
+		apiErr := fmt.Errorf("token request: error %d: %s", resp.StatusCode, string(body))
+		return nil, consumer.ClassifyHTTPStatus(resp.StatusCode, resp.Header, apiErr)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil || tr.AccessToken == "" {
+		return nil, consumer.Retryable(fmt.Errorf("token request: unexpected response: %s", string(body)))
+	}
+
+	tok := &Token{AccessToken: tr.AccessToken, RefreshToken: tr.RefreshToken}
+	switch {
+	case tr.ExpiresAt > 0:
+		tok.Expiry = time.Unix(tr.ExpiresAt, 0)
+	case tr.ExpiresIn > 0:
+		tok.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
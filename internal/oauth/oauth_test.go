@@ -0,0 +1,355 @@
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+// fakeProvider mimics an OAuth2 provider's authorize and token endpoints,
+// and an API that accepts its access tokens.
+type fakeProvider struct {
+	*httptest.Server
+
+	mu sync.Mutex
+
+	// challenges maps issued codes to their PKCE challenge.
+	challenges    map[string]string
+	refreshTokens map[string]bool
+	accessTokens  map[string]bool
+	issued        int
+	refreshes     int
+	deny          bool
+	apiBodies     []string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	f := &fakeProvider{
+		challenges:    map[string]string{},
+		refreshTokens: map[string]bool{"seed-refresh": true},
+		accessTokens:  map[string]bool{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", f.handleAuthorize)
+	mux.HandleFunc("/token", f.handleToken)
+	mux.HandleFunc("/api", f.handleAPI)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeProvider) config() Config {
+	return Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		AuthURL:      f.URL + "/authorize",
+		TokenURL:     f.URL + "/token",
+		Scope:        "read,write",
+	}
+}
+
+// handleAuthorize approves (or denies) straight away and redirects back.
+func (f *fakeProvider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	back, _ := url.Parse(q.Get("redirect_uri"))
+	params := url.Values{"state": {q.Get("state")}}
+	if f.deny {
+		params.Set("error", "access_denied")
+	} else if q.Get("client_id") != "client" || q.Get("code_challenge_method") != "S256" || q.Get("scope") != "read,write" {
+		params.Set("error", "invalid_request")
+	} else {
+		code := fmt.Sprintf("code-%d", len(f.challenges)+1)
+		f.challenges[code] = q.Get("code_challenge")
+		params.Set("code", code)
+	}
+	back.RawQuery = params.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (f *fakeProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.FormValue("client_id") != "client" || r.FormValue("client_secret") != "secret" {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		want, ok := f.challenges[r.FormValue("code")]
+		if !ok || challenge(r.FormValue("code_verifier")) != want {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(f.challenges, r.FormValue("code"))
+	case "refresh_token":
+		if !f.refreshTokens[r.FormValue("refresh_token")] {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(f.refreshTokens, r.FormValue("refresh_token"))
+		f.refreshes++
+	default:
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+
+	f.issued++
+	access := fmt.Sprintf("access-%d", f.issued)
+	refresh := fmt.Sprintf("refresh-%d", f.issued)
+	f.accessTokens[access] = true
+	f.refreshTokens[refresh] = true
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  access,
+		"refresh_token": refresh,
+		"expires_in":    3600,
+	})
+}
+
+func (f *fakeProvider) handleAPI(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.accessTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.apiBodies = append(f.apiBodies, string(body))
+	fmt.Fprint(w, "ok")
+}
+
+func TestStore_KeepsOtherState(t *testing.T) {
+	ctx := context.Background()
+	state := consumer.NewMemoryState()
+	_ = state.SaveState(ctx, "c", []byte(`{"cursor":42}`))
+	store := NewStore(state, "c")
+
+	if tok, err := store.Load(ctx); err != nil || tok != nil {
+		t.Fatalf("expected no token, got %v (%v)", tok, err)
+	}
+
+	want := &Token{AccessToken: "a", RefreshToken: "r", Expiry: time.Unix(1700000000, 0).UTC()}
+	if err := store.Save(ctx, want); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	got, err := store.Load(ctx)
+	if err != nil || got == nil || *got != *want {
+		t.Fatalf("Load = %+v (%v), want %+v", got, err, want)
+	}
+
+	data, _ := state.LoadState(ctx, "c")
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil || string(doc["cursor"]) != "42" {
+		t.Errorf("other state lost: %s", data)
+	}
+}
+
+func TestSource_RefreshesSeedAndSaves(t *testing.T) {
+	f := newFakeProvider(t)
+	state := consumer.NewMemoryState()
+	src := NewSource(f.config(), state, "c")
+	src.SetRefreshToken("seed-refresh")
+
+	tok, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token failed: %v", err)
+	}
+	if tok.AccessToken != "access-1" || tok.Expiry.Before(time.Now().Add(50*time.Minute)) {
+		t.Errorf("unexpected token: %+v", tok)
+	}
+
+	// The token is still good, so a restarted source reuses it.
+	restarted := NewSource(f.config(), state, "c")
+	restarted.SetRefreshToken("seed-refresh")
+	if tok, err := restarted.Token(context.Background()); err != nil || tok.AccessToken != "access-1" {
+		t.Fatalf("expected saved token, got %+v (%v)", tok, err)
+	}
+	if f.refreshes != 1 {
+		t.Errorf("expected 1 refresh, got %d", f.refreshes)
+	}
+}
+
+func TestSource_RefreshesBeforeExpiry(t *testing.T) {
+	f := newFakeProvider(t)
+	state := consumer.NewMemoryState()
+	f.refreshTokens["stored-refresh"] = true
+	_ = NewStore(state, "c").Save(context.Background(), &Token{
+		AccessToken:  "expiring",
+		RefreshToken: "stored-refresh",
+		Expiry:       time.Now().Add(time.Minute),
+	})
+
+	tok, err := NewSource(f.config(), state, "c").Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token failed: %v", err)
+	}
+	if tok.AccessToken == "expiring" || f.refreshes != 1 {
+		t.Errorf("expected a refresh, got %+v after %d refreshes", tok, f.refreshes)
+	}
+
+	saved, _ := NewStore(state, "c").Load(context.Background())
+	if saved.RefreshToken != tok.RefreshToken {
+		t.Errorf("rotated refresh token not saved: %+v", saved)
+	}
+}
+
+func TestSource_NotAuthorized(t *testing.T) {
+	f := newFakeProvider(t)
+	_, err := NewSource(f.config(), consumer.NewMemoryState(), "club").Token(context.Background())
+	if !consumer.IsPermanent(err) || !strings.Contains(err.Error(), "fitwatch auth club") {
+		t.Fatalf("expected permanent error asking to authorize, got %v", err)
+	}
+}
+
+func TestSource_RevokedRefreshToken(t *testing.T) {
+	f := newFakeProvider(t)
+	src := NewSource(f.config(), consumer.NewMemoryState(), "club")
+	src.SetRefreshToken("revoked")
+
+	_, err := src.Token(context.Background())
+	if !consumer.IsPermanent(err) || !strings.Contains(err.Error(), "fitwatch auth club") {
+		t.Fatalf("expected permanent error asking to re-authorize, got %v", err)
+	}
+}
+
+func TestTransport_RetriesRejectedToken(t *testing.T) {
+	f := newFakeProvider(t)
+	state := consumer.NewMemoryState()
+	_ = NewStore(state, "c").Save(context.Background(), &Token{
+		AccessToken:  "revoked",
+		RefreshToken: "seed-refresh",
+		Expiry:       time.Now().Add(time.Hour),
+	})
+	src := NewSource(f.config(), state, "c")
+	client := &http.Client{Transport: &Transport{Source: src}}
+
+	req, _ := http.NewRequest("POST", f.URL+"/api", bytes.NewReader([]byte("payload")))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after refresh, got %d", resp.StatusCode)
+	}
+	if f.refreshes != 1 || len(f.apiBodies) != 1 || f.apiBodies[0] != "payload" {
+		t.Errorf("expected one refresh and the body replayed, got %d refreshes and %q", f.refreshes, f.apiBodies)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Error("transport modified the caller's request")
+	}
+}
+
+func TestTransport_TokenErrorKeepsClassification(t *testing.T) {
+	f := newFakeProvider(t)
+	client := &http.Client{Transport: &Transport{Source: NewSource(f.config(), consumer.NewMemoryState(), "c")}}
+
+	_, err := client.Get(f.URL + "/api")
+	if !consumer.IsPermanent(err) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+}
+
+func TestSource_Authorize(t *testing.T) {
+	f := newFakeProvider(t)
+	state := consumer.NewMemoryState()
+	src := NewSource(f.config(), state, "c")
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Open plays the browser: following the provider's redirect lands on
+	// the loopback callback.
+	tok, err := src.Authorize(ctx, AuthorizeOptions{
+		Out: &out,
+		Open: func(u string) error {
+			resp, err := http.Get(u)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			return err
+		},
+	})
+	if err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+	if tok.AccessToken != "access-1" || !strings.Contains(out.String(), f.URL+"/authorize?") {
+		t.Errorf("unexpected token %+v or output %q", tok, out.String())
+	}
+
+	saved, _ := NewStore(state, "c").Load(context.Background())
+	if saved == nil || saved.RefreshToken != "refresh-1" {
+		t.Errorf("token not saved: %+v", saved)
+	}
+	if got, err := src.Token(context.Background()); err != nil || got.AccessToken != "access-1" || f.refreshes != 0 {
+		t.Errorf("expected the new token to be used, got %+v (%v)", got, err)
+	}
+}
+
+func TestSource_Authorize_Denied(t *testing.T) {
+	f := newFakeProvider(t)
+	f.deny = true
+	src := NewSource(f.config(), consumer.NewMemoryState(), "c")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := src.Authorize(ctx, AuthorizeOptions{
+		Open: func(u string) error {
+			resp, err := http.Get(u)
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+			return err
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Fatalf("expected denial, got %v", err)
+	}
+}
+
+func TestSource_AuthCodeURL(t *testing.T) {
+	cfg := Config{ClientID: "id", AuthURL: "https://example.com/auth?prompt=consent", Scope: "a b", AuthParams: map[string]string{"approval_prompt": "auto"}}
+	u, err := url.Parse(NewSource(cfg, consumer.NewMemoryState(), "c").AuthCodeURL("st", challenge("verifier"), "http://localhost:1/callback"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	for k, want := range map[string]string{
+		"prompt":                "consent",
+		"approval_prompt":       "auto",
+		"response_type":         "code",
+		"client_id":             "id",
+		"scope":                 "a b",
+		"state":                 "st",
+		"code_challenge_method": "S256",
+		"redirect_uri":          "http://localhost:1/callback",
+	} {
+		if q.Get(k) != want {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), want)
+		}
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	// The example from RFC 7636, appendix B.
+	if got := challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("challenge = %s", got)
+	}
+}
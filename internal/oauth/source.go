@@ -0,0 +1,154 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+// Source hands out access tokens for one consumer instance, refreshing them
+// shortly before they expire and saving each new token to the store. It is
+// safe for concurrent use.
+type Source struct {
+	cfg    Config
+	store  *Store
+	name   string
+	client *http.Client
+	logger *slog.Logger
+
+	// seed is a refresh token from the config, used until a token has been
+	// saved.
+	seed string
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewSource returns a Source for the named consumer instance, keeping its
+// tokens in state.
+func NewSource(cfg Config, state consumer.StateStore, name string) *Source {
+	return &Source{
+		cfg:    cfg,
+		store:  NewStore(state, name),
+		name:   name,
+		client: &http.Client{},
+		logger: slog.Default(),
+	}
+}
+
+// SetRefreshToken seeds the source with a refresh token obtained outside
+// fitwatch. It is only used while no token has been saved: providers that
+// rotate refresh tokens invalidate it after the first refresh.
+func (s *Source) SetRefreshToken(refreshToken string) {
+	s.seed = refreshToken
+}
+
+// SetHTTPClient sets the client used for token requests.
+func (s *Source) SetHTTPClient(client *http.Client) {
+	s.client = client
+}
+
+// SetLogger configures the logger for the source.
+func (s *Source) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// Config returns the provider configuration.
+func (s *Source) Config() Config {
+	return s.cfg
+}
+
+// Token returns a token whose access token is good for a while yet,
+// refreshing it if needed.
+func (s *Source) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadLocked(ctx); err != nil {
+		return nil, err
+	}
+	if s.token.Valid(time.Now()) {
+		return s.token, nil
+	}
+	return s.refreshLocked(ctx)
+}
+
+// Refresh renews a token the provider rejected. If the token has already
+// been replaced, by a concurrent request for example, the replacement is
+// returned rather than refreshing again.
+func (s *Source) Refresh(ctx context.Context, rejected *Token) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.loadLocked(ctx); err != nil {
+		return nil, err
+	}
+	if rejected != nil && s.token.AccessToken != rejected.AccessToken && s.token.Valid(time.Now()) {
+		return s.token, nil
+	}
+	return s.refreshLocked(ctx)
+}
+
+// loadLocked reads the saved token the first time it is needed. s.mu must
+// be held.
+func (s *Source) loadLocked(ctx context.Context) error {
+	if s.token != nil {
+		return nil
+	}
+	tok, err := s.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("load token: %w", err)
+	}
+	if tok == nil || (tok.RefreshToken == "" && tok.AccessToken == "") {
+		tok = &Token{RefreshToken: s.seed}
+	}
+	s.token = tok
+	return nil
+}
+
+// refreshLocked exchanges the refresh token for a new token and saves it.
+// s.mu must be held.
+func (s *Source) refreshLocked(ctx context.Context) (*Token, error) {
+	if s.token.RefreshToken == "" {
+		return nil, consumer.Permanent(fmt.Errorf("%s is not authorized: run `fitwatch auth %s`", s.name, s.name))
+	}
+
+	tok, err := exchange(ctx, s.client, s.cfg, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.token.RefreshToken},
+	})
+	if err != nil {
+		if consumer.IsPermanent(err) {
+			return nil, fmt.Errorf("refresh token (run `fitwatch auth %s` to re-authorize): %w", s.name, err)
+		}
+		return nil, fmt.Errorf("refresh token: %w", err)
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = s.token.RefreshToken
+	}
+	s.token = tok
+
+	if err := s.store.Save(ctx, tok); err != nil {
+		// The new token still works for this run.
+		s.logger.Warn("failed to save refreshed token", "consumer", s.name, "error", err)
+	}
+	return tok, nil
+}
+
+// set replaces the token and saves it.
+func (s *Source) set(ctx context.Context, tok *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.Save(ctx, tok); err != nil {
+		return fmt.Errorf("save token: %w", err)
+	}
+	s.token = tok
+	return nil
+}
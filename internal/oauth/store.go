@@ -0,0 +1,74 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+)
+
+// tokenKey is where the token lives in a consumer's state document.
+const tokenKey = "token"
+
+// Store keeps a consumer's token in its state, under the "token" key of a
+// JSON object. Any other keys in the state are left alone.
+type Store struct {
+	state consumer.StateStore
+	name  string
+}
+
+// NewStore returns a Store for the named consumer instance.
+func NewStore(state consumer.StateStore, name string) *Store {
+	return &Store{state: state, name: name}
+}
+
+// Load returns the saved token, or nil if there is none.
+func (s *Store) Load(ctx context.Context) (*Token, error) {
+	doc, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := doc[tokenKey]
+	if !ok {
+		return nil, nil
+	}
+	var tok Token
+	if err := json.Unmarshal(raw, &tok); err != nil {
+		return nil, fmt.Errorf("decode token for %s: %w", s.name, err)
+	}
+	return &tok, nil
+}
+
+// Save replaces the saved token.
+func (s *Store) Save(ctx context.Context, tok *Token) error {
+	doc, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	doc[tokenKey] = raw
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return s.state.SaveState(ctx, s.name, data)
+}
+
+func (s *Store) load(ctx context.Context) (map[string]json.RawMessage, error) {
+	data, err := s.state.LoadState(ctx, s.name)
+	if err != nil {
+		return nil, fmt.Errorf("load state for %s: %w", s.name, err)
+	}
+	doc := make(map[string]json.RawMessage)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("decode state for %s: %w", s.name, err)
+		}
+	}
+	return doc, nil
+}
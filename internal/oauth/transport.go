@@ -0,0 +1,72 @@
+package oauth
+
+import (
+	"io"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that authorizes requests with a bearer
+// token from Source. If the provider rejects the token with a 401, it is
+// refreshed and the request sent once more, provided its body can be
+// replayed (see http.Request.GetBody).
+type Transport struct {
+	Source *Source
+
+	// Base sends the requests; http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tok, err := t.Source.Token(req.Context())
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	resp, err := t.base().RoundTrip(authorize(req, tok))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	fresh, err := t.Source.Refresh(req.Context(), tok)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	retry := authorize(req, fresh)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return t.base().RoundTrip(retry)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// authorize returns a copy of req carrying the access token. A RoundTripper
+// must not modify the request it is given.
+func authorize(req *http.Request, tok *Token) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	return r
+}
+
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
@@ -0,0 +1,286 @@
+// Package pipeline connects detected FIT files to the store and dispatcher.
+// It records every file and its per-consumer sync state so that restarts
+// don't re-upload files that were already synced.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+	"github.com/johnazariah/fitwatch/internal/store"
+)
+
+// Pipeline records FIT files in the store and dispatches them to consumers
+// that haven't successfully received them yet.
+type Pipeline struct {
+	dispatcher *consumer.Dispatcher
+	store      *store.Store
+	logger     *slog.Logger
+	retry      RetryPolicy
+}
+
+// New creates a pipeline over the given dispatcher and store.
+func New(dispatcher *consumer.Dispatcher, s *store.Store, logger *slog.Logger) *Pipeline {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Pipeline{
+		dispatcher: dispatcher,
+		store:      s,
+		logger:     logger,
+		retry:      DefaultRetryPolicy(),
+	}
+}
+
+// HandleFile processes a single FIT file.
+// The file is recorded in the store (if new), a sync record is ensured for
+// each consumer, and the file is dispatched to every consumer that hasn't
+// attempted it yet. Sync results are persisted; failures are scheduled for
+// retry by RetryDue rather than re-dispatched here.
+func (p *Pipeline) HandleFile(ctx context.Context, path string) ([]consumer.Result, error) {
+	fileID, err := p.ensureFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := p.pendingConsumers(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		p.logger.Debug("no pending consumers", "path", path)
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(pending))
+	previous := make(map[string]string)
+	for name, rec := range pending {
+		names = append(names, name)
+		if rec.RemoteID != "" {
+			previous[name] = rec.RemoteID
+		}
+	}
+	ctx = consumer.WithPreviousRemoteIDs(ctx, previous)
+
+	// Record each result as it arrives, so a slow consumer doesn't delay
+	// recording the others.
+	var results []consumer.Result
+	for r := range p.dispatcher.DispatchAsync(ctx, path, names) {
+		p.recordResult(ctx, fileID, pending[r.Consumer].Retries, r)
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// ensureFile returns the store ID for path, parsing and inserting it if needed.
+// A path whose content matches an already-recorded file (by SHA-256) is
+// linked to that file rather than recorded as a new activity. A known path
+// is hashed again only if its size or modification time has changed since
+// it was last checked.
+func (p *Pipeline) ensureFile(ctx context.Context, path string) (int64, error) {
+	// Stat before hashing: if the file changes in between, the recorded
+	// time is older than the content, so the next check hashes it again.
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("stat file: %w", err)
+	}
+
+	existing, err := p.store.GetFileByPath(ctx, path)
+	if err != nil {
+		return 0, fmt.Errorf("lookup file: %w", err)
+	}
+	if existing != nil {
+		modTime, err := p.store.PathModTime(ctx, path)
+		if err != nil {
+			return 0, fmt.Errorf("lookup modification time: %w", err)
+		}
+		if info.Size() == existing.Size && info.ModTime().Equal(modTime) {
+			return existing.ID, nil
+		}
+	}
+
+	hash, err := fitparser.HashFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("hash file: %w", err)
+	}
+	var id int64
+	if existing != nil {
+		id, err = p.refreshFile(ctx, path, hash, existing)
+	} else {
+		id, err = p.recordFile(ctx, path, hash)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := p.store.SetPathModTime(ctx, path, info.ModTime()); err != nil {
+		return 0, fmt.Errorf("record modification time: %w", err)
+	}
+	return id, nil
+}
+
+// refreshFile checks whether a known path still holds the recorded content.
+// A device may still have been writing the file when it was recorded, or
+// may have overwritten it since. Changed content is re-recorded and sent
+// to every consumer again.
+func (p *Pipeline) refreshFile(ctx context.Context, path, hash string, existing *store.FitFile) (int64, error) {
+	if hash == existing.Hash {
+		return existing.ID, nil
+	}
+
+	// An alias path gets a record of its own; the file it pointed at
+	// still exists elsewhere.
+	if existing.Path != path {
+		p.logger.Info("alias path content changed, recording it separately", "path", path, "was", existing.Path)
+		if err := p.store.RemoveFilePath(ctx, path); err != nil {
+			return 0, fmt.Errorf("unlink path: %w", err)
+		}
+		return p.recordFile(ctx, path, hash)
+	}
+
+	f, err := p.describeFile(path, hash)
+	if err != nil {
+		return 0, err
+	}
+	p.logger.Info("file content changed, re-recording", "path", path, "size", f.Size, "was", existing.Size)
+	if err := p.store.ReplaceFile(ctx, existing.ID, f); err != nil {
+		return 0, fmt.Errorf("replace file: %w", err)
+	}
+	return existing.ID, nil
+}
+
+// recordFile links path to the file with the same content, or records it
+// as a new file.
+func (p *Pipeline) recordFile(ctx context.Context, path, hash string) (int64, error) {
+	existing, err := p.store.GetFileByHash(ctx, hash)
+	if err != nil {
+		return 0, fmt.Errorf("lookup hash: %w", err)
+	}
+	if existing != nil {
+		p.logger.Info("duplicate content, linking to existing file", "path", path, "existing", existing.Path)
+		if err := p.store.AddFilePath(ctx, existing.ID, path); err != nil {
+			return 0, fmt.Errorf("link path: %w", err)
+		}
+		return existing.ID, nil
+	}
+
+	f, err := p.describeFile(path, hash)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := p.store.InsertFile(ctx, f)
+	if err != nil {
+		return 0, fmt.Errorf("insert file: %w", err)
+	}
+	return id, nil
+}
+
+// describeFile builds a store record for path from its parsed metadata.
+// Files that can't be decoded are still recorded by hash and size so that
+// consumers which accept them aren't blocked by parser limitations.
+// The record keeps hash, the one checked for duplicates, rather than the
+// parser's own.
+func (p *Pipeline) describeFile(path, hash string) (*store.FitFile, error) {
+	meta, err := fitparser.Parse(path)
+	if err == nil {
+		meta.Hash = hash
+	} else {
+		p.logger.Warn("could not parse FIT file, recording without metadata", "path", path, "error", err)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("stat file: %w", err)
+		}
+		meta = &fitparser.Metadata{Hash: hash, Size: info.Size()}
+	}
+
+	return FileFromMetadata(path, meta), nil
+}
+
+// pendingConsumers ensures a sync record exists for every consumer and
+// returns the still-pending records, keyed by consumer name.
+// Succeeded, failed (awaiting retry), dead and skipped records are left
+// alone.
+func (p *Pipeline) pendingConsumers(ctx context.Context, fileID int64) (map[string]*store.SyncRecord, error) {
+	pending := make(map[string]*store.SyncRecord)
+
+	for _, name := range p.dispatcher.ConsumerNames() {
+		if _, err := p.store.CreateSyncRecord(ctx, fileID, name); err != nil {
+			return nil, fmt.Errorf("create sync record: %w", err)
+		}
+
+		rec, err := p.store.GetSyncRecord(ctx, fileID, name)
+		if err != nil {
+			return nil, fmt.Errorf("get sync record: %w", err)
+		}
+		if rec == nil || rec.Status != store.SyncStatusPending {
+			continue
+		}
+
+		if err := p.store.UpdateSyncAttempted(ctx, fileID, name); err != nil {
+			return nil, fmt.Errorf("update sync record: %w", err)
+		}
+		pending[name] = rec
+	}
+
+	return pending, nil
+}
+
+// FileFromMetadata converts parsed FIT metadata into a store record.
+func FileFromMetadata(path string, meta *fitparser.Metadata) *store.FitFile {
+	sessions := make([]store.FitSession, 0, len(meta.Sessions))
+	for i, s := range meta.Sessions {
+		sessions = append(sessions, store.FitSession{
+			Index:        i,
+			ActivityType: s.ActivityType,
+			Sport:        s.Sport,
+			SubSport:     s.SubSport,
+			StartedAt:    s.StartTime,
+			EndedAt:      s.EndTime,
+			DurationSecs: s.DurationSecs,
+			ElapsedSecs:  s.ElapsedSecs,
+			DistanceM:    s.DistanceMeters,
+			Calories:     s.Calories,
+			AvgPowerW:    s.AvgPower,
+			MaxPowerW:    s.MaxPower,
+			NormPowerW:   s.NormPower,
+			AvgHR:        s.AvgHeartRate,
+			MaxHR:        s.MaxHeartRate,
+			AvgCadence:   s.AvgCadence,
+			AvgSpeedMPS:  s.AvgSpeedMPS,
+			TotalAscentM: s.TotalAscent,
+		})
+	}
+
+	return &store.FitFile{
+		Path:            path,
+		Hash:            meta.Hash,
+		Size:            meta.Size,
+		DiscoveredAt:    time.Now(),
+		Source:          "watch",
+		ActivityType:    meta.ActivityType,
+		ActivityName:    meta.ActivityName,
+		StartedAt:       meta.StartTime,
+		DurationSecs:    meta.DurationSecs,
+		DistanceM:       meta.DistanceMeters,
+		Calories:        meta.Calories,
+		AvgPowerW:       meta.AvgPower,
+		MaxPowerW:       meta.MaxPower,
+		NormPowerW:      meta.NormPower,
+		AvgHR:           meta.AvgHeartRate,
+		MaxHR:           meta.MaxHeartRate,
+		AvgCadence:      meta.AvgCadence,
+		AvgSpeedMPS:     meta.AvgSpeedMPS,
+		TotalAscentM:    meta.TotalAscent,
+		DeviceName:      meta.Manufacturer,
+		SoftwareVersion: meta.SoftwareVersion,
+		Sessions:        sessions,
+	}
+}
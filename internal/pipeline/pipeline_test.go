@@ -0,0 +1,549 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+	"github.com/johnazariah/fitwatch/internal/store"
+	"github.com/johnazariah/fitwatch/internal/transform"
+)
+
+// fakeConsumer records pushes, and the remote ID of any earlier version
+// of each file, and optionally fails, with err if set.
+type fakeConsumer struct {
+	name string
+	fail bool
+	err  error
+
+	mu       sync.Mutex
+	pushes   []string
+	previous []string
+}
+
+func (f *fakeConsumer) Name() string    { return f.name }
+func (f *fakeConsumer) Validate() error { return nil }
+
+func (f *fakeConsumer) Push(ctx context.Context, fitPath string) (*consumer.Receipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pushes = append(f.pushes, fitPath)
+	f.previous = append(f.previous, consumer.PreviousRemoteID(ctx, f.name))
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.fail {
+		return nil, errors.New("upload failed")
+	}
+	id := fmt.Sprintf("%s-%d", f.name, len(f.pushes))
+	return &consumer.Receipt{RemoteID: id, RemoteURL: "https://example.com/" + id}, nil
+}
+
+func (f *fakeConsumer) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pushes)
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func writeFakeFit(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("fake FIT data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newDispatcher(consumers ...consumer.Consumer) *consumer.Dispatcher {
+	d := consumer.NewDispatcher(consumers...)
+	d.SetMaxRetries(0)
+	return d
+}
+
+func TestPipeline_RecordsFileAndSyncs(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test"}
+	p := New(newDispatcher(c), s, slog.Default())
+
+	path := writeFakeFit(t, "ride.fit")
+	results, err := p.HandleFile(ctx, path)
+	if err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if len(results) != 1 || !results[0].Success {
+		t.Fatalf("expected 1 successful result, got %+v", results)
+	}
+
+	f, err := s.GetFileByPath(ctx, path)
+	if err != nil {
+		t.Fatalf("GetFileByPath failed: %v", err)
+	}
+	if f == nil {
+		t.Fatal("expected file to be recorded")
+	}
+	if f.Hash == "" {
+		t.Error("expected hash to be recorded for unparseable file")
+	}
+
+	rec, err := s.GetSyncRecord(ctx, f.ID, "test")
+	if err != nil {
+		t.Fatalf("GetSyncRecord failed: %v", err)
+	}
+	if rec == nil || rec.Status != store.SyncStatusSuccess {
+		t.Fatalf("expected success sync record, got %+v", rec)
+	}
+	if rec.RemoteID != "test-1" {
+		t.Errorf("expected remote ID test-1, got %q", rec.RemoteID)
+	}
+	if rec.RemoteURL != "https://example.com/test-1" {
+		t.Errorf("expected remote URL to be recorded, got %q", rec.RemoteURL)
+	}
+}
+
+func TestPipeline_SkipsAlreadySynced(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test"}
+	path := writeFakeFit(t, "ride.fit")
+
+	// First run uploads
+	if _, err := New(newDispatcher(c), s, nil).HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	// Simulate a restart with a fresh pipeline over the same store
+	results, err := New(newDispatcher(c), s, nil).HandleFile(ctx, path)
+	if err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no dispatch for synced file, got %+v", results)
+	}
+	if c.count() != 1 {
+		t.Errorf("expected 1 push, got %d", c.count())
+	}
+}
+
+func TestPipeline_RecordsFilteredConsumersAsSkipped(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	zwift := &fakeConsumer{name: "zwift-only"}
+	all := &fakeConsumer{name: "all"}
+	d := newDispatcher(zwift, all)
+	d.SetFilter("zwift-only", consumer.Filter{Dirs: []string{filepath.Join("Documents", "Zwift")}})
+	p := New(d, s, nil)
+
+	path := writeFakeFit(t, "ride.fit")
+	results, err := p.HandleFile(ctx, path)
+	if err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	if zwift.count() != 0 || all.count() != 1 {
+		t.Errorf("expected only the unfiltered consumer pushed, got %d and %d", zwift.count(), all.count())
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, err := s.GetSyncRecord(ctx, f.ID, "zwift-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != store.SyncStatusSkipped || !strings.Contains(rec.Error, "not under") {
+		t.Errorf("expected skipped record, got %+v", rec)
+	}
+
+	// A skipped file isn't dispatched again.
+	results, err = p.HandleFile(ctx, path)
+	if err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no dispatch, got %+v", results)
+	}
+}
+
+// redact is a transform that replaces a file's content.
+type redact struct{}
+
+func (redact) Name() string { return "redact" }
+
+func (redact) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	return []byte("redacted"), nil
+}
+
+func TestPipeline_RecordsTransforms(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	public := &fakeConsumer{name: "public"}
+	private := &fakeConsumer{name: "private"}
+	d := newDispatcher(public, private)
+	d.SetTransforms("public", transform.Chain{redact{}})
+	d.SetTransformCache(transform.NewCache(t.TempDir()))
+	p := New(d, s, nil)
+
+	path := writeFakeFit(t, "ride.fit")
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	if data, _ := os.ReadFile(public.pushes[0]); string(data) != "redacted" {
+		t.Errorf("public consumer got %q", data)
+	}
+	if private.pushes[0] != path {
+		t.Errorf("private consumer got %s, want the original", private.pushes[0])
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	for name, want := range map[string]string{"public": "redact", "private": ""} {
+		rec, err := s.GetSyncRecord(ctx, f.ID, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Status != store.SyncStatusSuccess || rec.Transforms != want {
+			t.Errorf("%s: expected transforms %q, got %+v", name, want, rec)
+		}
+	}
+}
+
+func TestPipeline_OnlyRetriesUnsyncedConsumers(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	good := &fakeConsumer{name: "good"}
+	bad := &fakeConsumer{name: "bad", fail: true}
+	path := writeFakeFit(t, "ride.fit")
+
+	p := New(newDispatcher(good, bad), s, nil)
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, err := s.GetSyncRecord(ctx, f.ID, "bad")
+	if err != nil {
+		t.Fatalf("GetSyncRecord failed: %v", err)
+	}
+	if rec.Status != store.SyncStatusFailed {
+		t.Errorf("expected failed status, got %s", rec.Status)
+	}
+	if rec.Error == "" {
+		t.Error("expected error message to be recorded")
+	}
+
+	// Seeing the file again doesn't re-dispatch; failures wait for the retry queue
+	results, err := p.HandleFile(ctx, path)
+	if err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no dispatch on rescan, got %+v", results)
+	}
+
+	// The retry pass only retries the failed consumer
+	bad.fail = false
+	p.SetRetryPolicy(RetryPolicy{MaxAttempts: 5})
+	if err := s.ScheduleRetry(ctx, f.ID, "bad", "upload failed", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("ScheduleRetry failed: %v", err)
+	}
+	n, err := p.RetryDue(ctx)
+	if err != nil {
+		t.Fatalf("RetryDue failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 retry, got %d", n)
+	}
+	if good.count() != 1 {
+		t.Errorf("expected good consumer pushed once, got %d", good.count())
+	}
+	if bad.count() != 2 {
+		t.Errorf("expected bad consumer pushed twice, got %d", bad.count())
+	}
+}
+
+func TestPipeline_RealFitFileMetadata(t *testing.T) {
+	samplePath := filepath.Join("..", "..", "testdata", "sample.fit")
+	if _, err := os.Stat(samplePath); os.IsNotExist(err) {
+		t.Skip("sample.fit not found")
+	}
+
+	ctx := context.Background()
+	s := newTestStore(t)
+	p := New(newDispatcher(&fakeConsumer{name: "test"}), s, nil)
+
+	if _, err := p.HandleFile(ctx, samplePath); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	f, err := s.GetFileByPath(ctx, samplePath)
+	if err != nil {
+		t.Fatalf("GetFileByPath failed: %v", err)
+	}
+	if f.ActivityType == "" {
+		t.Error("expected activity type from parsed metadata")
+	}
+	if f.StartedAt == nil {
+		t.Error("expected start time from parsed metadata")
+	}
+	if hash, err := fitparser.HashFile(samplePath); err != nil || f.Hash != hash {
+		t.Errorf("expected hash %s, got %s (%v)", hash, f.Hash, err)
+	}
+
+	sessions, err := s.ListSessions(ctx, f.ID)
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ActivityType != f.ActivityType {
+		t.Errorf("expected one %s session, got %+v", f.ActivityType, sessions)
+	}
+}
+
+func TestPipeline_ResyncsChangedFile(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test"}
+	p := New(newDispatcher(c), s, nil)
+
+	// Recorded while the device was still writing it.
+	path := filepath.Join(t.TempDir(), "ride.fit")
+	if err := os.WriteFile(path, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	before, err := s.GetFileByPath(ctx, path)
+	if err != nil {
+		t.Fatalf("GetFileByPath failed: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("partial, now complete"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, err := p.HandleFile(ctx, path)
+	if err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if len(results) != 1 || c.count() != 2 {
+		t.Fatalf("expected changed file to be pushed again, got %+v (%d pushes)", results, c.count())
+	}
+	if c.previous[0] != "" || c.previous[1] != "test-1" {
+		t.Errorf("expected the earlier upload to be passed on, got %q", c.previous)
+	}
+
+	after, err := s.GetFileByPath(ctx, path)
+	if err != nil {
+		t.Fatalf("GetFileByPath failed: %v", err)
+	}
+	if after.ID != before.ID || after.Hash == before.Hash || after.Size != int64(len("partial, now complete")) {
+		t.Errorf("expected record to be updated in place, got %+v (was %+v)", after, before)
+	}
+	rec, err := s.GetSyncRecord(ctx, after.ID, "test")
+	if err != nil {
+		t.Fatalf("GetSyncRecord failed: %v", err)
+	}
+	if rec.Status != store.SyncStatusSuccess || rec.RemoteID != "test-2" {
+		t.Errorf("expected new sync result, got %+v", rec)
+	}
+
+	// Unchanged, it isn't pushed again.
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if c.count() != 2 {
+		t.Errorf("expected no push for unchanged file, got %d pushes", c.count())
+	}
+}
+
+func TestPipeline_ResyncsChangedFileOfSameSize(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test"}
+	p := New(newDispatcher(c), s, nil)
+
+	path := writeFakeFit(t, "ride.fit")
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	// Touched without changing: hashed again, but not pushed.
+	mtime = mtime.Add(time.Minute)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if c.count() != 1 {
+		t.Fatalf("expected no push for a touched file, got %d pushes", c.count())
+	}
+
+	// Overwritten with different content of the same size.
+	if err := os.WriteFile(path, []byte("fake FIT DATA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime = mtime.Add(time.Minute)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if c.count() != 2 {
+		t.Errorf("expected changed file to be pushed again, got %d pushes", c.count())
+	}
+}
+
+func TestPipeline_ChangedFileUnlinksAliases(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test"}
+	p := New(newDispatcher(c), s, nil)
+
+	original := writeFakeFit(t, "ride.fit")
+	copied := writeFakeFit(t, "ride (copy).fit")
+	for _, path := range []string{original, copied} {
+		if _, err := p.HandleFile(ctx, path); err != nil {
+			t.Fatalf("HandleFile failed: %v", err)
+		}
+	}
+
+	// The original is overwritten; the copy still holds the old content,
+	// so it no longer stands in for the file.
+	if err := os.WriteFile(original, []byte("a different activity"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.HandleFile(ctx, original); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	f, err := s.GetFileByPath(ctx, original)
+	if err != nil {
+		t.Fatalf("GetFileByPath failed: %v", err)
+	}
+	paths, err := s.ListFilePaths(ctx, f.ID)
+	if err != nil {
+		t.Fatalf("ListFilePaths failed: %v", err)
+	}
+	if len(paths) != 1 || paths[0] != original {
+		t.Errorf("expected only the original path, got %v", paths)
+	}
+	if cp, err := s.GetFileByPath(ctx, copied); err != nil || cp != nil {
+		t.Errorf("expected the copy to be unknown, got %+v, %v", cp, err)
+	}
+}
+
+func TestPipeline_ChangedAliasRecordedSeparately(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test"}
+	p := New(newDispatcher(c), s, nil)
+
+	original := writeFakeFit(t, "ride.fit")
+	copied := writeFakeFit(t, "ride (copy).fit")
+	for _, path := range []string{original, copied} {
+		if _, err := p.HandleFile(ctx, path); err != nil {
+			t.Fatalf("HandleFile failed: %v", err)
+		}
+	}
+
+	if err := os.WriteFile(copied, []byte("a different activity"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.HandleFile(ctx, copied); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if c.count() != 2 {
+		t.Errorf("expected changed copy to be pushed, got %d pushes", c.count())
+	}
+
+	orig, err := s.GetFileByPath(ctx, original)
+	if err != nil {
+		t.Fatalf("GetFileByPath failed: %v", err)
+	}
+	cp, err := s.GetFileByPath(ctx, copied)
+	if err != nil {
+		t.Fatalf("GetFileByPath failed: %v", err)
+	}
+	if orig.ID == cp.ID || cp.Path != copied {
+		t.Errorf("expected copy to get its own record, got %+v and %+v", orig, cp)
+	}
+	paths, err := s.ListFilePaths(ctx, orig.ID)
+	if err != nil {
+		t.Fatalf("ListFilePaths failed: %v", err)
+	}
+	if len(paths) != 1 || paths[0] != original {
+		t.Errorf("expected only the original path, got %v", paths)
+	}
+}
+
+func TestPipeline_DuplicateContentAtNewPath(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test"}
+	p := New(newDispatcher(c), s, nil)
+
+	original := writeFakeFit(t, "ride.fit")
+	copied := writeFakeFit(t, "ride (copy).fit")
+
+	if _, err := p.HandleFile(ctx, original); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	results, err := p.HandleFile(ctx, copied)
+	if err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected copy not to be dispatched, got %+v", results)
+	}
+	if c.count() != 1 {
+		t.Errorf("expected 1 push, got %d", c.count())
+	}
+
+	stats, err := s.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.TotalFiles != 1 {
+		t.Errorf("expected 1 logical file, got %d", stats.TotalFiles)
+	}
+
+	f, err := s.GetFileByPath(ctx, copied)
+	if err != nil {
+		t.Fatalf("GetFileByPath failed: %v", err)
+	}
+	if f == nil || f.Path != original {
+		t.Fatalf("expected copy to resolve to original, got %+v", f)
+	}
+	paths, err := s.ListFilePaths(ctx, f.ID)
+	if err != nil {
+		t.Fatalf("ListFilePaths failed: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Errorf("expected 2 paths, got %v", paths)
+	}
+}
@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/store"
+)
+
+// RetryPolicy controls how failed syncs are rescheduled.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts (including the first)
+	// before a sync is marked dead.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry. Each subsequent
+	// retry doubles the delay, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Jitter randomises each delay by up to ±Jitter (a fraction, e.g. 0.2)
+	// so that a batch of failures doesn't retry in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns a policy that keeps retrying for several days,
+// long enough to ride out a weekend outage.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 12,
+		BaseDelay:   5 * time.Minute,
+		MaxDelay:    24 * time.Hour,
+		Jitter:      0.2,
+	}
+}
+
+// Backoff returns the delay before the retry following the given attempt
+// number (1 for the first failure).
+func (rp RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := float64(rp.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if rp.MaxDelay > 0 && delay > float64(rp.MaxDelay) {
+		delay = float64(rp.MaxDelay)
+	}
+
+	if rp.Jitter > 0 {
+		delay *= 1 + rp.Jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(delay)
+}
+
+// SetRetryPolicy configures how failed syncs are rescheduled.
+func (p *Pipeline) SetRetryPolicy(rp RetryPolicy) {
+	p.retry = rp
+}
+
+// RetryDue re-dispatches every failed sync whose next attempt is due.
+// Returns the number of syncs attempted.
+func (p *Pipeline) RetryDue(ctx context.Context) (int, error) {
+	due, err := p.store.GetDueRetries(ctx, time.Now(), 0)
+	if err != nil {
+		return 0, err
+	}
+
+	registered := make(map[string]bool)
+	for _, name := range p.dispatcher.ConsumerNames() {
+		registered[name] = true
+	}
+
+	attempted := 0
+	for _, rec := range due {
+		if ctx.Err() != nil {
+			return attempted, ctx.Err()
+		}
+
+		// Consumers removed from config keep their records but aren't retried.
+		if !registered[rec.Consumer] {
+			continue
+		}
+
+		f, err := p.store.GetFileByID(ctx, rec.FileID)
+		if err != nil {
+			return attempted, err
+		}
+		if f == nil {
+			continue
+		}
+
+		path, ok := p.resolvePath(ctx, f)
+		if !ok {
+			p.logger.Warn("file no longer exists, giving up", "path", f.Path, "consumer", rec.Consumer)
+			if err := p.store.MarkSyncDead(ctx, f.ID, rec.Consumer, "file no longer exists"); err != nil {
+				return attempted, err
+			}
+			continue
+		}
+
+		p.logger.Info("retrying sync", "path", path, "consumer", rec.Consumer, "attempt", rec.Retries+1)
+
+		if err := p.store.UpdateSyncAttempted(ctx, f.ID, rec.Consumer); err != nil {
+			return attempted, err
+		}
+		pushCtx := consumer.WithPreviousRemoteIDs(ctx, map[string]string{rec.Consumer: rec.RemoteID})
+		for _, r := range p.dispatcher.DispatchTo(pushCtx, path, []string{rec.Consumer}) {
+			p.recordResult(ctx, f.ID, rec.Retries, r)
+		}
+		attempted++
+	}
+
+	return attempted, nil
+}
+
+// RunRetries calls RetryDue every interval until ctx is canceled.
+func (p *Pipeline) RunRetries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.RetryDue(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Error("retry pass failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordResult persists a push outcome. Retryable failures are rescheduled
+// according to the retry policy (never sooner than a rate limit asks), and
+// marked dead once the budget is exhausted. Permanent failures are marked
+// dead straight away, and files a consumer's filter skipped are marked
+// skipped. previousRetries is the record's retry count before this attempt.
+//
+// A failure caused by shutdown is not recorded, leaving the record pending
+// for the next run. Any other result is recorded even if ctx has been
+// canceled since the push, so a shutdown doesn't lose an upload that went
+// through.
+func (p *Pipeline) recordResult(ctx context.Context, fileID int64, previousRetries int, r consumer.Result) {
+	if !r.Success && !r.Skipped && (ctx.Err() != nil || errors.Is(r.Error, context.Canceled)) {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	var err error
+
+	switch {
+	case r.Skipped:
+		err = p.store.MarkSyncSkipped(ctx, fileID, r.Consumer, r.SkipReason)
+
+	case r.Success:
+		err = p.store.UpdateSyncSuccess(ctx, fileID, r.Consumer, r.RemoteID, r.RemoteURL)
+
+	case consumer.IsPermanent(r.Error):
+		p.logger.Error("permanent sync failure, not retrying", "path", r.FitPath, "consumer", r.Consumer, "error", r.Error)
+		err = p.store.MarkSyncDead(ctx, fileID, r.Consumer, r.Error.Error())
+
+	default:
+		attempt := previousRetries + 1
+		if p.retry.MaxAttempts > 0 && attempt >= p.retry.MaxAttempts {
+			p.logger.Error("giving up on sync", "path", r.FitPath, "consumer", r.Consumer, "attempts", attempt, "error", r.Error)
+			err = p.store.MarkSyncDead(ctx, fileID, r.Consumer, r.Error.Error())
+		} else {
+			delay := p.retry.Backoff(attempt)
+			if after, ok := consumer.RetryAfter(r.Error); ok && after > delay {
+				delay = after
+			}
+			next := time.Now().Add(delay)
+			p.logger.Info("scheduled retry", "path", r.FitPath, "consumer", r.Consumer, "attempt", attempt, "next", next.Format(time.RFC3339))
+			err = p.store.ScheduleRetry(ctx, fileID, r.Consumer, r.Error.Error(), next)
+		}
+	}
+
+	// Record what was pushed, so a stripped copy can be told from the
+	// original later. A skipped file had nothing pushed.
+	if err == nil && !r.Skipped {
+		err = p.store.SetSyncTransforms(ctx, fileID, r.Consumer, r.Transforms)
+	}
+
+	if err != nil {
+		p.logger.Error("failed to record sync result", "path", r.FitPath, "consumer", r.Consumer, "error", err)
+	}
+}
+
+// resolvePath returns a path at which the file's content can still be read,
+// preferring the primary path and falling back to known aliases.
+func (p *Pipeline) resolvePath(ctx context.Context, f *store.FitFile) (string, bool) {
+	if _, err := os.Stat(f.Path); err == nil {
+		return f.Path, true
+	}
+
+	aliases, err := p.store.ListFilePaths(ctx, f.ID)
+	if err != nil {
+		p.logger.Warn("failed to list alias paths", "path", f.Path, "error", err)
+		return "", false
+	}
+	for _, alias := range aliases {
+		if _, err := os.Stat(alias); err == nil {
+			return alias, true
+		}
+	}
+	return "", false
+}
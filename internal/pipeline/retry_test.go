@@ -0,0 +1,314 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/consumer"
+	"github.com/johnazariah/fitwatch/internal/store"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	rp := RetryPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{7, time.Hour}, // 64m capped
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := rp.Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicy_BackoffJitter(t *testing.T) {
+	rp := RetryPolicy{BaseDelay: 10 * time.Minute, MaxDelay: time.Hour, Jitter: 0.2}
+
+	for i := 0; i < 100; i++ {
+		got := rp.Backoff(1)
+		if got < 8*time.Minute || got > 12*time.Minute {
+			t.Fatalf("Backoff(1) = %v, want within ±20%% of 10m", got)
+		}
+	}
+}
+
+func TestRetryDue_SchedulesBackoffAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test", fail: true}
+	p := New(newDispatcher(c), s, nil)
+	p.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	path := writeFakeFit(t, "ride.fit")
+	before := time.Now()
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, err := s.GetSyncRecord(ctx, f.ID, "test")
+	if err != nil {
+		t.Fatalf("GetSyncRecord failed: %v", err)
+	}
+	if rec.Status != store.SyncStatusFailed {
+		t.Errorf("expected failed status, got %s", rec.Status)
+	}
+	if rec.NextAttemptAt == nil || rec.NextAttemptAt.Before(before.Add(time.Hour)) {
+		t.Errorf("expected next attempt an hour out, got %v", rec.NextAttemptAt)
+	}
+
+	// Not due yet: nothing is attempted
+	n, err := p.RetryDue(ctx)
+	if err != nil {
+		t.Fatalf("RetryDue failed: %v", err)
+	}
+	if n != 0 || c.count() != 1 {
+		t.Errorf("expected no retry before due, got %d retries and %d pushes", n, c.count())
+	}
+}
+
+func TestRetryDue_MarksDeadAfterBudget(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test", fail: true}
+	p := New(newDispatcher(c), s, nil)
+	p.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+
+	path := writeFakeFit(t, "ride.fit")
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	// Zero delays make every failure immediately due
+	for i := 0; i < 5; i++ {
+		if _, err := p.RetryDue(ctx); err != nil {
+			t.Fatalf("RetryDue failed: %v", err)
+		}
+	}
+
+	if c.count() != 3 {
+		t.Errorf("expected 3 attempts, got %d", c.count())
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, err := s.GetSyncRecord(ctx, f.ID, "test")
+	if err != nil {
+		t.Fatalf("GetSyncRecord failed: %v", err)
+	}
+	if rec.Status != store.SyncStatusDead {
+		t.Errorf("expected dead status, got %s", rec.Status)
+	}
+
+	stats, err := s.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.DeadByConsumer["test"] != 1 {
+		t.Errorf("expected 1 dead sync, got %d", stats.DeadByConsumer["test"])
+	}
+}
+
+func TestRetryDue_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	path := writeFakeFit(t, "ride.fit")
+
+	failing := &fakeConsumer{name: "test", fail: true}
+	first := New(newDispatcher(failing), s, nil)
+	first.SetRetryPolicy(RetryPolicy{MaxAttempts: 5})
+	if _, err := first.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	// A new process picks the failure up from the store
+	working := &fakeConsumer{name: "test"}
+	second := New(newDispatcher(working), s, nil)
+	n, err := second.RetryDue(ctx)
+	if err != nil {
+		t.Fatalf("RetryDue failed: %v", err)
+	}
+	if n != 1 || working.count() != 1 {
+		t.Errorf("expected 1 retry after restart, got %d retries and %d pushes", n, working.count())
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, _ := s.GetSyncRecord(ctx, f.ID, "test")
+	if rec.Status != store.SyncStatusSuccess {
+		t.Errorf("expected success after retry, got %s", rec.Status)
+	}
+}
+
+func TestRetryDue_FallsBackToAliasPath(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test", fail: true}
+	p := New(newDispatcher(c), s, nil)
+	p.SetRetryPolicy(RetryPolicy{MaxAttempts: 5})
+
+	original := writeFakeFit(t, "ride.fit")
+	backup := writeFakeFit(t, "ride-backup.fit")
+	if _, err := p.HandleFile(ctx, original); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if _, err := p.HandleFile(ctx, backup); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if err := os.Remove(original); err != nil {
+		t.Fatal(err)
+	}
+
+	c.fail = false
+	if _, err := p.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue failed: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pushes) != 2 || c.pushes[1] != backup {
+		t.Errorf("expected retry from backup path, got %v", c.pushes)
+	}
+}
+
+func TestRetryDue_MissingFileIsDead(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test", fail: true}
+	p := New(newDispatcher(c), s, nil)
+	p.SetRetryPolicy(RetryPolicy{MaxAttempts: 5})
+
+	path := writeFakeFit(t, "ride.fit")
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue failed: %v", err)
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, _ := s.GetSyncRecord(ctx, f.ID, "test")
+	if rec.Status != store.SyncStatusDead {
+		t.Errorf("expected dead status for missing file, got %s", rec.Status)
+	}
+}
+
+func TestRecordResult_PermanentFailureIsDead(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test", err: consumer.Permanent(errors.New("bad API key"))}
+	p := New(newDispatcher(c), s, nil)
+
+	path := writeFakeFit(t, "ride.fit")
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, _ := s.GetSyncRecord(ctx, f.ID, "test")
+	if rec.Status != store.SyncStatusDead {
+		t.Errorf("expected dead status for permanent error, got %s", rec.Status)
+	}
+}
+
+func TestRecordResult_RateLimitDelaysRetry(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test", err: consumer.RateLimited(errors.New("slow down"), 2*time.Hour)}
+	p := New(newDispatcher(c), s, nil)
+	p.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Minute})
+
+	path := writeFakeFit(t, "ride.fit")
+	before := time.Now()
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, _ := s.GetSyncRecord(ctx, f.ID, "test")
+	if rec.NextAttemptAt == nil || rec.NextAttemptAt.Before(before.Add(2*time.Hour)) {
+		t.Errorf("expected retry no sooner than Retry-After, got %v", rec.NextAttemptAt)
+	}
+}
+
+func TestRecordResult_DuplicateIsSuccess(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	c := &fakeConsumer{name: "test", err: consumer.Duplicate(errors.New("already exists"))}
+	p := New(newDispatcher(c), s, nil)
+
+	path := writeFakeFit(t, "ride.fit")
+	if _, err := p.HandleFile(ctx, path); err != nil {
+		t.Fatalf("HandleFile failed: %v", err)
+	}
+
+	f, _ := s.GetFileByPath(ctx, path)
+	rec, _ := s.GetSyncRecord(ctx, f.ID, "test")
+	if rec.Status != store.SyncStatusSuccess {
+		t.Errorf("expected success for duplicate, got %s", rec.Status)
+	}
+}
+
+func TestRecordResult_RecordsAfterCancel(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	p := New(newDispatcher(&fakeConsumer{name: "test"}), s, nil)
+
+	fileID, err := s.InsertFile(ctx, &store.FitFile{Path: "/path/to/ride.fit", Hash: "ride", DiscoveredAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateSyncRecord(ctx, fileID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shutting down while a push completes still records the upload.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	p.recordResult(canceled, fileID, 0, consumer.Result{Consumer: "test", Success: true, RemoteID: "123"})
+
+	rec, _ := s.GetSyncRecord(ctx, fileID, "test")
+	if rec.Status != store.SyncStatusSuccess || rec.RemoteID != "123" {
+		t.Errorf("expected success recorded after cancel, got %+v", rec)
+	}
+}
+
+func TestRecordResult_LeavesCanceledFailurePending(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	p := New(newDispatcher(&fakeConsumer{name: "test"}), s, nil)
+	p.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	fileID, err := s.InsertFile(ctx, &store.FitFile{Path: "/path/to/ride.fit", Hash: "ride", DiscoveredAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateSyncRecord(ctx, fileID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A push cut short by shutdown, and a queued job the dispatcher gave
+	// up on, neither use up a retry nor mark the record dead.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	p.recordResult(canceled, fileID, 0, consumer.Result{Consumer: "test", Error: errors.New("connection reset")})
+	p.recordResult(ctx, fileID, 0, consumer.Result{Consumer: "test", Error: context.Canceled})
+
+	rec, _ := s.GetSyncRecord(ctx, fileID, "test")
+	if rec.Status != store.SyncStatusPending || rec.Retries != 0 {
+		t.Errorf("expected canceled failure left pending, got %+v", rec)
+	}
+}
@@ -0,0 +1,260 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was written by a newer
+// version of fitwatch than the running binary understands.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of fitwatch")
+
+// migration is a single numbered schema change.
+// Migrations are applied in order and never edited once released;
+// add a new entry to change the schema.
+type migration struct {
+	version     int
+	description string
+	up          string
+}
+
+// migrations lists every schema change, oldest first.
+// Versions must be contiguous starting at 1.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		up: `
+		CREATE TABLE IF NOT EXISTS fit_files (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			path TEXT UNIQUE NOT NULL,
+			hash TEXT,
+			size INTEGER,
+			discovered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			source TEXT DEFAULT 'watch',
+
+			-- Parsed FIT metadata
+			activity_type TEXT,
+			activity_name TEXT,
+			started_at TIMESTAMP,
+			duration_secs INTEGER,
+			distance_m REAL,
+			calories INTEGER,
+			avg_power_w INTEGER,
+			max_power_w INTEGER,
+			norm_power_w INTEGER,
+			avg_hr INTEGER,
+			max_hr INTEGER,
+			avg_cadence INTEGER,
+			avg_speed_mps REAL,
+			total_ascent_m REAL,
+			device_name TEXT,
+			software_version TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS sync_records (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file_id INTEGER NOT NULL REFERENCES fit_files(id),
+			consumer TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempted_at TIMESTAMP,
+			completed_at TIMESTAMP,
+			remote_id TEXT,
+			remote_url TEXT,
+			error TEXT,
+			retries INTEGER DEFAULT 0,
+			UNIQUE(file_id, consumer)
+		);
+
+		CREATE TABLE IF NOT EXISTS consumers (
+			name TEXT PRIMARY KEY,
+			enabled BOOLEAN DEFAULT 0,
+			config_json TEXT,
+			last_sync TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_sync_pending ON sync_records(consumer, status) WHERE status = 'pending';
+		CREATE INDEX IF NOT EXISTS idx_sync_failed ON sync_records(status) WHERE status = 'failed';
+		CREATE INDEX IF NOT EXISTS idx_files_hash ON fit_files(hash);
+		CREATE INDEX IF NOT EXISTS idx_files_started ON fit_files(started_at);
+		CREATE INDEX IF NOT EXISTS idx_files_type ON fit_files(activity_type);
+		`,
+	},
+	{
+		version:     2,
+		description: "alias paths for duplicate content",
+		up: `
+		CREATE TABLE IF NOT EXISTS fit_file_paths (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file_id INTEGER NOT NULL REFERENCES fit_files(id),
+			path TEXT UNIQUE NOT NULL,
+			seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_paths_file ON fit_file_paths(file_id);
+
+		INSERT OR IGNORE INTO fit_file_paths (file_id, path, seen_at)
+		SELECT id, path, discovered_at FROM fit_files;
+		`,
+	},
+	{
+		version:     3,
+		description: "scheduled retries",
+		up: `
+		ALTER TABLE sync_records ADD COLUMN next_attempt_at TIMESTAMP;
+
+		CREATE INDEX IF NOT EXISTS idx_sync_due ON sync_records(next_attempt_at) WHERE status = 'failed';
+		`,
+	},
+	{
+		version:     4,
+		description: "transform provenance",
+		up: `
+		ALTER TABLE sync_records ADD COLUMN transforms TEXT;
+		`,
+	},
+	{
+		version:     5,
+		description: "activity sessions",
+		up: `
+		CREATE TABLE IF NOT EXISTS fit_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file_id INTEGER NOT NULL REFERENCES fit_files(id),
+			session_index INTEGER NOT NULL,
+			activity_type TEXT,
+			sport TEXT,
+			sub_sport TEXT,
+			started_at TIMESTAMP,
+			ended_at TIMESTAMP,
+			duration_secs INTEGER,
+			elapsed_secs INTEGER,
+			distance_m REAL,
+			calories INTEGER,
+			avg_power_w INTEGER,
+			max_power_w INTEGER,
+			norm_power_w INTEGER,
+			avg_hr INTEGER,
+			max_hr INTEGER,
+			avg_cadence INTEGER,
+			avg_speed_mps REAL,
+			total_ascent_m REAL,
+			UNIQUE(file_id, session_index)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_sessions_sport ON fit_sessions(sport);
+		`,
+	},
+	{
+		version:     6,
+		description: "path modification times",
+		up: `
+		ALTER TABLE fit_file_paths ADD COLUMN mod_time TIMESTAMP;
+		`,
+	},
+}
+
+// latestSchemaVersion is the schema version this binary writes.
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate brings the database schema up to the latest version.
+// Each migration runs in its own transaction together with its
+// schema_version row, so a failure leaves the database at the last
+// fully-applied version.
+func (s *Store) migrate() error {
+	ctx := context.Background()
+
+	if _, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current == 0 {
+		// Databases created before schema versioning already contain the
+		// initial schema; record them as version 1 rather than replaying it.
+		legacy, err := s.tableExists(ctx, "fit_files")
+		if err != nil {
+			return err
+		}
+		if legacy {
+			if err := s.applyMigration(ctx, migration{version: 1, description: migrations[0].description}); err != nil {
+				return err
+			}
+			current = 1
+		}
+	}
+
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("%w (database version %d, supported version %d)", ErrSchemaTooNew, current, latestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := s.applyMigration(ctx, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs a single migration and records it, atomically.
+func (s *Store) applyMigration(ctx context.Context, m migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migration %d: begin: %w", m.version, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if m.up != "" {
+		if _, err := tx.ExecContext(ctx, m.up); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)
+	`, m.version, m.description, time.Now()); err != nil {
+		return fmt.Errorf("migration %d: record version: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %d: commit: %w", m.version, err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the highest applied schema version, or 0 for an
+// empty database.
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func (s *Store) tableExists(ctx context.Context, name string) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?
+	`, name).Scan(&count)
+	return count > 0, err
+}
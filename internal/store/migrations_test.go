@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadFixture creates a database at dbPath from a SQL fixture in testdata.
+func loadFixture(t *testing.T, dbPath, fixture string) {
+	t.Helper()
+
+	script, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open fixture db: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.Exec(string(script)); err != nil {
+		t.Fatalf("failed to load fixture: %v", err)
+	}
+}
+
+func TestMigrate_NewDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	version, err := store.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read version: %v", err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("expected version %d, got %d", latestSchemaVersion(), version)
+	}
+}
+
+func TestMigrate_UpgradesV1Fixture(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	loadFixture(t, dbPath, "schema_v1.sql")
+
+	store, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open v1 database: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	version, err := store.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("failed to read version: %v", err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("expected version %d, got %d", latestSchemaVersion(), version)
+	}
+
+	// Existing data survives the upgrade
+	f, err := store.GetFileByPath(ctx, "/zwift/2025-06-30_Heian_Samurai_Circuit.fit")
+	if err != nil {
+		t.Fatalf("failed to get file: %v", err)
+	}
+	if f == nil || f.Hash != "hash-heian" {
+		t.Fatalf("expected fixture file, got %+v", f)
+	}
+
+	rec, err := store.GetSyncRecord(ctx, f.ID, "Intervals.icu")
+	if err != nil {
+		t.Fatalf("failed to get sync record: %v", err)
+	}
+	if rec == nil || rec.Status != SyncStatusSuccess || rec.RemoteID != "i1001" {
+		t.Errorf("expected synced fixture record, got %+v", rec)
+	}
+
+	// v2: existing primary paths are backfilled as aliases
+	paths, err := store.ListFilePaths(ctx, f.ID)
+	if err != nil {
+		t.Fatalf("failed to list paths: %v", err)
+	}
+	if len(paths) != 1 || paths[0] != f.Path {
+		t.Errorf("expected backfilled primary path, got %v", paths)
+	}
+
+	// Reopening an upgraded database is a no-op
+	_ = store.Close()
+	store, err = New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to reopen upgraded database: %v", err)
+	}
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if stats.TotalFiles != 2 {
+		t.Errorf("expected 2 files, got %d", stats.TotalFiles)
+	}
+	if stats.FailedByConsumer["Intervals.icu"] != 1 {
+		t.Errorf("expected 1 failed sync, got %d", stats.FailedByConsumer["Intervals.icu"])
+	}
+}
+
+func TestMigrate_RefusesNewerDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	future := latestSchemaVersion() + 1
+	if _, err := store.db.Exec("INSERT INTO schema_version (version, description) VALUES (?, 'from the future')", future); err != nil {
+		t.Fatalf("failed to bump version: %v", err)
+	}
+	_ = store.Close()
+
+	_, err = New(dbPath)
+	if err == nil {
+		t.Fatal("expected error opening newer database")
+	}
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestMigrate_FailedMigrationRollsBack(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+
+	store, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	bad := migration{
+		version:     latestSchemaVersion() + 1,
+		description: "broken",
+		up: `
+		CREATE TABLE half_applied (id INTEGER);
+		THIS IS NOT SQL;
+		`,
+	}
+	if err := store.applyMigration(context.Background(), bad); err == nil {
+		t.Fatal("expected broken migration to fail")
+	}
+
+	exists, err := store.tableExists(context.Background(), "half_applied")
+	if err != nil {
+		t.Fatalf("failed to check table: %v", err)
+	}
+	if exists {
+		t.Error("expected partial migration to be rolled back")
+	}
+
+	version, err := store.SchemaVersion(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read version: %v", err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("expected version to stay at %d, got %d", latestSchemaVersion(), version)
+	}
+}
+
+func TestMigrations_AreContiguous(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration at index %d has version %d, want %d", i, m.version, i+1)
+		}
+	}
+}
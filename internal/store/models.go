@@ -31,6 +31,33 @@ type FitFile struct {
 	TotalAscentM    float64    `json:"totalAscentM,omitempty"`
 	DeviceName      string     `json:"deviceName,omitempty"`
 	SoftwareVersion string     `json:"softwareVersion,omitempty"`
+
+	// Sessions are the activity's sessions, e.g. each leg of a triathlon;
+	// the fields above total them. InsertFile stores them and ListSessions
+	// reads them back; the other queries leave them empty.
+	Sessions []FitSession `json:"sessions,omitempty"`
+}
+
+// FitSession is one session of an activity.
+type FitSession struct {
+	Index        int        `json:"index"`
+	ActivityType string     `json:"activityType,omitempty"`
+	Sport        string     `json:"sport,omitempty"`
+	SubSport     string     `json:"subSport,omitempty"`
+	StartedAt    *time.Time `json:"startedAt,omitempty"`
+	EndedAt      *time.Time `json:"endedAt,omitempty"`
+	DurationSecs int        `json:"durationSecs,omitempty"`
+	ElapsedSecs  int        `json:"elapsedSecs,omitempty"`
+	DistanceM    float64    `json:"distanceM,omitempty"`
+	Calories     int        `json:"calories,omitempty"`
+	AvgPowerW    int        `json:"avgPowerW,omitempty"`
+	MaxPowerW    int        `json:"maxPowerW,omitempty"`
+	NormPowerW   int        `json:"normPowerW,omitempty"`
+	AvgHR        int        `json:"avgHr,omitempty"`
+	MaxHR        int        `json:"maxHr,omitempty"`
+	AvgCadence   int        `json:"avgCadence,omitempty"`
+	AvgSpeedMPS  float64    `json:"avgSpeedMps,omitempty"`
+	TotalAscentM float64    `json:"totalAscentM,omitempty"`
 }
 
 // SyncStatus represents the state of a sync attempt.
@@ -40,6 +67,8 @@ const (
 	SyncStatusPending SyncStatus = "pending"
 	SyncStatusSuccess SyncStatus = "success"
 	SyncStatusFailed  SyncStatus = "failed"
+	SyncStatusDead    SyncStatus = "dead"    // retry budget exhausted; not retried automatically
+	SyncStatusSkipped SyncStatus = "skipped" // the consumer's filter didn't match the file
 )
 
 // SyncRecord represents an attempt to sync a file to a consumer.
@@ -54,6 +83,13 @@ type SyncRecord struct {
 	RemoteURL   string     `json:"remoteUrl,omitempty"`
 	Error       string     `json:"error,omitempty"`
 	Retries     int        `json:"retries"`
+
+	// NextAttemptAt is when a failed sync becomes due for retry.
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+
+	// Transforms names the transforms applied to the copy that was pushed,
+	// e.g. "strip_gps,strip_heart_rate"; empty if it was pushed unmodified.
+	Transforms string `json:"transforms,omitempty"`
 }
 
 // ConsumerConfig stores consumer settings.
@@ -71,4 +107,6 @@ type StoreStats struct {
 	PendingByConsumer map[string]int `json:"pendingByConsumer"`
 	FailedByConsumer  map[string]int `json:"failedByConsumer"`
 	SuccessByConsumer map[string]int `json:"successByConsumer"`
+	DeadByConsumer    map[string]int `json:"deadByConsumer"`
+	SkippedByConsumer map[string]int `json:"skippedByConsumer"`
 }
@@ -48,71 +48,17 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-// migrate creates tables if they don't exist.
-func (s *Store) migrate() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS fit_files (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		path TEXT UNIQUE NOT NULL,
-		hash TEXT,
-		size INTEGER,
-		discovered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		source TEXT DEFAULT 'watch',
-		
-		-- Parsed FIT metadata
-		activity_type TEXT,
-		activity_name TEXT,
-		started_at TIMESTAMP,
-		duration_secs INTEGER,
-		distance_m REAL,
-		calories INTEGER,
-		avg_power_w INTEGER,
-		max_power_w INTEGER,
-		norm_power_w INTEGER,
-		avg_hr INTEGER,
-		max_hr INTEGER,
-		avg_cadence INTEGER,
-		avg_speed_mps REAL,
-		total_ascent_m REAL,
-		device_name TEXT,
-		software_version TEXT
-	);
-
-	CREATE TABLE IF NOT EXISTS sync_records (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		file_id INTEGER NOT NULL REFERENCES fit_files(id),
-		consumer TEXT NOT NULL,
-		status TEXT NOT NULL DEFAULT 'pending',
-		attempted_at TIMESTAMP,
-		completed_at TIMESTAMP,
-		remote_id TEXT,
-		remote_url TEXT,
-		error TEXT,
-		retries INTEGER DEFAULT 0,
-		UNIQUE(file_id, consumer)
-	);
-
-	CREATE TABLE IF NOT EXISTS consumers (
-		name TEXT PRIMARY KEY,
-		enabled BOOLEAN DEFAULT 0,
-		config_json TEXT,
-		last_sync TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_sync_pending ON sync_records(consumer, status) WHERE status = 'pending';
-	CREATE INDEX IF NOT EXISTS idx_sync_failed ON sync_records(status) WHERE status = 'failed';
-	CREATE INDEX IF NOT EXISTS idx_files_hash ON fit_files(hash);
-	CREATE INDEX IF NOT EXISTS idx_files_started ON fit_files(started_at);
-	CREATE INDEX IF NOT EXISTS idx_files_type ON fit_files(activity_type);
-	`
-	_, err := s.db.Exec(schema)
-	return err
-}
-
-// InsertFile adds a new FIT file to the database.
+// InsertFile adds a new FIT file to the database, with its path and
+// sessions, in one transaction.
 // Returns the file ID.
 func (s *Store) InsertFile(ctx context.Context, f *FitFile) (int64, error) {
-	result, err := s.db.ExecContext(ctx, `
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(ctx, `
 		INSERT INTO fit_files (
 			path, hash, size, discovered_at, source,
 			activity_type, activity_name, started_at, duration_secs,
@@ -130,10 +76,239 @@ func (s *Store) InsertFile(ctx context.Context, f *FitFile) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := addFilePath(ctx, tx, id, f.Path); err != nil {
+		return 0, fmt.Errorf("record path: %w", err)
+	}
+	if err := insertSessions(ctx, tx, id, f.Sessions); err != nil {
+		return 0, fmt.Errorf("record session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return id, nil
+}
+
+// ReplaceFile updates a file whose content changed: its hash, size,
+// metadata and sessions are replaced with f's, every sync record is reset
+// to pending so consumers receive the new content, and its alias paths are
+// unlinked, since they may still hold the old content. Sync records keep
+// the remote ID and URL of what was sent for the old content until the new
+// content is sent. It runs in one transaction.
+func (s *Store) ReplaceFile(ctx context.Context, id int64, f *FitFile) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE fit_files SET
+			hash = ?, size = ?, discovered_at = ?,
+			activity_type = ?, activity_name = ?, started_at = ?, duration_secs = ?,
+			distance_m = ?, calories = ?, avg_power_w = ?, max_power_w = ?, norm_power_w = ?,
+			avg_hr = ?, max_hr = ?, avg_cadence = ?, avg_speed_mps = ?, total_ascent_m = ?,
+			device_name = ?, software_version = ?
+		WHERE id = ?
+	`,
+		f.Hash, f.Size, f.DiscoveredAt,
+		nullString(f.ActivityType), nullString(f.ActivityName), f.StartedAt, nullInt(f.DurationSecs),
+		nullFloat(f.DistanceM), nullInt(f.Calories), nullInt(f.AvgPowerW), nullInt(f.MaxPowerW), nullInt(f.NormPowerW),
+		nullInt(f.AvgHR), nullInt(f.MaxHR), nullInt(f.AvgCadence), nullFloat(f.AvgSpeedMPS), nullFloat(f.TotalAscentM),
+		nullString(f.DeviceName), nullString(f.SoftwareVersion),
+		id,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM fit_sessions WHERE file_id = ?", id); err != nil {
+		return fmt.Errorf("clear sessions: %w", err)
+	}
+	if err := insertSessions(ctx, tx, id, f.Sessions); err != nil {
+		return fmt.Errorf("record session: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE sync_records SET
+			status = 'pending', attempted_at = NULL, completed_at = NULL,
+			error = NULL, retries = 0, next_attempt_at = NULL, transforms = NULL
+		WHERE file_id = ?
+	`, id); err != nil {
+		return fmt.Errorf("reset sync records: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM fit_file_paths
+		WHERE file_id = ? AND path NOT IN (SELECT path FROM fit_files)
+	`, id); err != nil {
+		return fmt.Errorf("unlink aliases: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// execer is the part of *sql.DB and *sql.Tx used by writes that may run
+// inside a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertSessions(ctx context.Context, db execer, fileID int64, sessions []FitSession) error {
+	for i := range sessions {
+		fs := &sessions[i]
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO fit_sessions (
+				file_id, session_index, activity_type, sport, sub_sport,
+				started_at, ended_at, duration_secs, elapsed_secs, distance_m, calories,
+				avg_power_w, max_power_w, norm_power_w, avg_hr, max_hr,
+				avg_cadence, avg_speed_mps, total_ascent_m
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(file_id, session_index) DO NOTHING
+		`,
+			fileID, fs.Index, nullString(fs.ActivityType), nullString(fs.Sport), nullString(fs.SubSport),
+			fs.StartedAt, fs.EndedAt, nullInt(fs.DurationSecs), nullInt(fs.ElapsedSecs), nullFloat(fs.DistanceM), nullInt(fs.Calories),
+			nullInt(fs.AvgPowerW), nullInt(fs.MaxPowerW), nullInt(fs.NormPowerW), nullInt(fs.AvgHR), nullInt(fs.MaxHR),
+			nullInt(fs.AvgCadence), nullFloat(fs.AvgSpeedMPS), nullFloat(fs.TotalAscentM),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSessions returns a file's sessions in order. Files recorded before
+// sessions were stored have none.
+func (s *Store) ListSessions(ctx context.Context, fileID int64) ([]FitSession, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT session_index, activity_type, sport, sub_sport,
+			started_at, ended_at, duration_secs, elapsed_secs, distance_m, calories,
+			avg_power_w, max_power_w, norm_power_w, avg_hr, max_hr,
+			avg_cadence, avg_speed_mps, total_ascent_m
+		FROM fit_sessions
+		WHERE file_id = ?
+		ORDER BY session_index ASC
+	`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sessions []FitSession
+	for rows.Next() {
+		var fs FitSession
+		var activityType, sport, subSport sql.NullString
+		var startedAt, endedAt sql.NullTime
+		var duration, elapsed, calories, avgPower, maxPower, normPower, avgHR, maxHR, avgCadence sql.NullInt64
+		var distance, avgSpeed, ascent sql.NullFloat64
+
+		err := rows.Scan(
+			&fs.Index, &activityType, &sport, &subSport,
+			&startedAt, &endedAt, &duration, &elapsed, &distance, &calories,
+			&avgPower, &maxPower, &normPower, &avgHR, &maxHR,
+			&avgCadence, &avgSpeed, &ascent,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		fs.ActivityType = activityType.String
+		fs.Sport = sport.String
+		fs.SubSport = subSport.String
+		if startedAt.Valid {
+			fs.StartedAt = &startedAt.Time
+		}
+		if endedAt.Valid {
+			fs.EndedAt = &endedAt.Time
+		}
+		fs.DurationSecs = int(duration.Int64)
+		fs.ElapsedSecs = int(elapsed.Int64)
+		fs.DistanceM = distance.Float64
+		fs.Calories = int(calories.Int64)
+		fs.AvgPowerW = int(avgPower.Int64)
+		fs.MaxPowerW = int(maxPower.Int64)
+		fs.NormPowerW = int(normPower.Int64)
+		fs.AvgHR = int(avgHR.Int64)
+		fs.MaxHR = int(maxHR.Int64)
+		fs.AvgCadence = int(avgCadence.Int64)
+		fs.AvgSpeedMPS = avgSpeed.Float64
+		fs.TotalAscentM = ascent.Float64
+		sessions = append(sessions, fs)
+	}
+	return sessions, rows.Err()
 }
 
-// GetFileByPath retrieves a file by its path.
+// AddFilePath records an additional path at which a file's content was seen.
+// Paths already linked to a file are left unchanged.
+func (s *Store) AddFilePath(ctx context.Context, fileID int64, path string) error {
+	return addFilePath(ctx, s.db, fileID, path)
+}
+
+func addFilePath(ctx context.Context, db execer, fileID int64, path string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO fit_file_paths (file_id, path, seen_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(path) DO NOTHING
+	`, fileID, path, time.Now())
+	return err
+}
+
+// RemoveFilePath unlinks an alias path from its file, for when the path
+// no longer holds that file's content. A file's original path is kept.
+func (s *Store) RemoveFilePath(ctx context.Context, path string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM fit_file_paths
+		WHERE path = ? AND path NOT IN (SELECT path FROM fit_files)
+	`, path)
+	return err
+}
+
+// PathModTime returns the modification time a path had when its content
+// was last checked, or the zero time if it isn't known.
+func (s *Store) PathModTime(ctx context.Context, path string) (time.Time, error) {
+	var modTime sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT mod_time FROM fit_file_paths WHERE path = ?", path).Scan(&modTime)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+	return modTime.Time, nil
+}
+
+// SetPathModTime records the modification time a path had when its
+// content was checked.
+func (s *Store) SetPathModTime(ctx context.Context, path string, modTime time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE fit_file_paths SET mod_time = ? WHERE path = ?", modTime, path)
+	return err
+}
+
+// ListFilePaths returns every path a file has been seen at, oldest first.
+func (s *Store) ListFilePaths(ctx context.Context, fileID int64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT path FROM fit_file_paths
+		WHERE file_id = ?
+		ORDER BY seen_at ASC, id ASC
+	`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
+
+// GetFileByPath retrieves a file by its path or any linked alias path.
 func (s *Store) GetFileByPath(ctx context.Context, path string) (*FitFile, error) {
 	row := s.db.QueryRowContext(ctx, `
 		SELECT id, path, hash, size, discovered_at, source,
@@ -141,8 +316,23 @@ func (s *Store) GetFileByPath(ctx context.Context, path string) (*FitFile, error
 			distance_m, calories, avg_power_w, max_power_w, norm_power_w,
 			avg_hr, max_hr, avg_cadence, avg_speed_mps, total_ascent_m,
 			device_name, software_version
-		FROM fit_files WHERE path = ?
-	`, path)
+		FROM fit_files
+		WHERE path = ? OR id = (SELECT file_id FROM fit_file_paths WHERE path = ?)
+		LIMIT 1
+	`, path, path)
+	return s.scanFile(row)
+}
+
+// GetFileByID retrieves a file by its ID.
+func (s *Store) GetFileByID(ctx context.Context, id int64) (*FitFile, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, path, hash, size, discovered_at, source,
+			activity_type, activity_name, started_at, duration_secs,
+			distance_m, calories, avg_power_w, max_power_w, norm_power_w,
+			avg_hr, max_hr, avg_cadence, avg_speed_mps, total_ascent_m,
+			device_name, software_version
+		FROM fit_files WHERE id = ?
+	`, id)
 	return s.scanFile(row)
 }
 
@@ -155,16 +345,20 @@ func (s *Store) GetFileByHash(ctx context.Context, hash string) (*FitFile, error
 			avg_hr, max_hr, avg_cadence, avg_speed_mps, total_ascent_m,
 			device_name, software_version
 		FROM fit_files WHERE hash = ?
+		ORDER BY id ASC
+		LIMIT 1
 	`, hash)
 	return s.scanFile(row)
 }
 
-// FileExists checks if a file exists by path or hash.
+// FileExists checks if a file exists by path (including aliases) or hash.
 func (s *Store) FileExists(ctx context.Context, path, hash string) (bool, error) {
 	var count int
 	err := s.db.QueryRowContext(ctx, `
-		SELECT COUNT(*) FROM fit_files WHERE path = ? OR hash = ?
-	`, path, hash).Scan(&count)
+		SELECT COUNT(*) FROM fit_files
+		WHERE path = ? OR hash = ?
+			OR id IN (SELECT file_id FROM fit_file_paths WHERE path = ?)
+	`, path, hash, path).Scan(&count)
 	return count > 0, err
 }
 
@@ -218,7 +412,7 @@ func (s *Store) UpdateSyncSuccess(ctx context.Context, fileID int64, consumer, r
 	now := time.Now()
 	_, err := s.db.ExecContext(ctx, `
 		UPDATE sync_records
-		SET status = 'success', completed_at = ?, remote_id = ?, remote_url = ?, error = NULL
+		SET status = 'success', completed_at = ?, remote_id = ?, remote_url = ?, error = NULL, next_attempt_at = NULL
 		WHERE file_id = ? AND consumer = ?
 	`, now, remoteID, remoteURL, fileID, consumer)
 	return err
@@ -235,6 +429,86 @@ func (s *Store) UpdateSyncFailed(ctx context.Context, fileID int64, consumer, er
 	return err
 }
 
+// ScheduleRetry marks a sync as failed and schedules its next attempt.
+func (s *Store) ScheduleRetry(ctx context.Context, fileID int64, consumer, errMsg string, nextAttempt time.Time) error {
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE sync_records
+		SET status = 'failed', attempted_at = ?, error = ?, retries = retries + 1, next_attempt_at = ?
+		WHERE file_id = ? AND consumer = ?
+	`, now, errMsg, nextAttempt.UTC(), fileID, consumer)
+	return err
+}
+
+// MarkSyncDead marks a sync as permanently failed.
+// Dead syncs are never retried automatically.
+func (s *Store) MarkSyncDead(ctx context.Context, fileID int64, consumer, errMsg string) error {
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE sync_records
+		SET status = 'dead', attempted_at = ?, error = ?, retries = retries + 1, next_attempt_at = NULL
+		WHERE file_id = ? AND consumer = ?
+	`, now, errMsg, fileID, consumer)
+	return err
+}
+
+// MarkSyncSkipped records that a file wasn't sent to a consumer because
+// the consumer's filter didn't match it. reason is kept in the error column.
+// Skipped syncs are never retried.
+func (s *Store) MarkSyncSkipped(ctx context.Context, fileID int64, consumer, reason string) error {
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE sync_records
+		SET status = 'skipped', completed_at = ?, error = ?, next_attempt_at = NULL
+		WHERE file_id = ? AND consumer = ?
+	`, now, reason, fileID, consumer)
+	return err
+}
+
+// SetSyncTransforms records the transforms applied to the copy of a file
+// pushed to a consumer, as named by transform.Chain.String. An empty chain
+// means the file was pushed unmodified.
+func (s *Store) SetSyncTransforms(ctx context.Context, fileID int64, consumer, chain string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE sync_records
+		SET transforms = ?
+		WHERE file_id = ? AND consumer = ?
+	`, nullString(chain), fileID, consumer)
+	return err
+}
+
+// GetDueRetries returns failed sync records whose next attempt is at or
+// before now, oldest first. A limit of 0 returns all due records.
+func (s *Store) GetDueRetries(ctx context.Context, now time.Time, limit int) ([]*SyncRecord, error) {
+	query := `
+		SELECT id, file_id, consumer, status, attempted_at, completed_at,
+			remote_id, remote_url, error, retries, next_attempt_at, transforms
+		FROM sync_records
+		WHERE status = 'failed' AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+		ORDER BY next_attempt_at ASC, id ASC
+	`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	// Schedules are stored in UTC so they compare correctly as text.
+	rows, err := s.db.QueryContext(ctx, query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []*SyncRecord
+	for rows.Next() {
+		r, err := scanSyncRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
 // UpdateSyncAttempted marks that a sync was attempted.
 func (s *Store) UpdateSyncAttempted(ctx context.Context, fileID int64, consumer string) error {
 	now := time.Now()
@@ -279,7 +553,7 @@ func (s *Store) GetPendingFiles(ctx context.Context, consumer string) ([]*FitFil
 func (s *Store) GetFailedSyncs(ctx context.Context, consumer string, maxRetries int) ([]*SyncRecord, error) {
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT id, file_id, consumer, status, attempted_at, completed_at,
-			remote_id, remote_url, error, retries
+			remote_id, remote_url, error, retries, next_attempt_at, transforms
 		FROM sync_records
 		WHERE consumer = ? AND status = 'failed' AND retries < ?
 		ORDER BY attempted_at ASC
@@ -291,12 +565,7 @@ func (s *Store) GetFailedSyncs(ctx context.Context, consumer string, maxRetries
 
 	var records []*SyncRecord
 	for rows.Next() {
-		r := &SyncRecord{}
-		err := rows.Scan(
-			&r.ID, &r.FileID, &r.Consumer, &r.Status,
-			&r.AttemptedAt, &r.CompletedAt,
-			&r.RemoteID, &r.RemoteURL, &r.Error, &r.Retries,
-		)
+		r, err := scanSyncRecord(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -305,6 +574,22 @@ func (s *Store) GetFailedSyncs(ctx context.Context, consumer string, maxRetries
 	return records, rows.Err()
 }
 
+// GetSyncRecord retrieves the sync record for a file and consumer.
+// Returns nil if no record exists.
+func (s *Store) GetSyncRecord(ctx context.Context, fileID int64, consumer string) (*SyncRecord, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, file_id, consumer, status, attempted_at, completed_at,
+			remote_id, remote_url, error, retries, next_attempt_at, transforms
+		FROM sync_records
+		WHERE file_id = ? AND consumer = ?
+	`, fileID, consumer)
+	r, err := scanSyncRecord(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
 // ResetToRetry changes failed syncs back to pending for retry.
 func (s *Store) ResetToRetry(ctx context.Context, consumer string, maxRetries int) (int64, error) {
 	result, err := s.db.ExecContext(ctx, `
@@ -318,12 +603,40 @@ func (s *Store) ResetToRetry(ctx context.Context, consumer string, maxRetries in
 	return result.RowsAffected()
 }
 
+// LoadState returns the state saved for a consumer, such as its OAuth
+// tokens. Returns nil if none has been saved.
+func (s *Store) LoadState(ctx context.Context, consumer string) ([]byte, error) {
+	var data sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT config_json FROM consumers WHERE name = ?
+	`, consumer).Scan(&data)
+	if err == sql.ErrNoRows || !data.Valid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data.String), nil
+}
+
+// SaveState replaces the state saved for a consumer.
+func (s *Store) SaveState(ctx context.Context, consumer string, data []byte) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO consumers (name, enabled, config_json)
+		VALUES (?, 1, ?)
+		ON CONFLICT(name) DO UPDATE SET config_json = excluded.config_json
+	`, consumer, string(data))
+	return err
+}
+
 // Stats returns aggregate statistics.
 func (s *Store) Stats(ctx context.Context) (*StoreStats, error) {
 	stats := &StoreStats{
 		PendingByConsumer: make(map[string]int),
 		FailedByConsumer:  make(map[string]int),
 		SuccessByConsumer: make(map[string]int),
+		DeadByConsumer:    make(map[string]int),
+		SkippedByConsumer: make(map[string]int),
 	}
 
 	// Total files
@@ -362,6 +675,10 @@ func (s *Store) Stats(ctx context.Context) (*StoreStats, error) {
 			stats.FailedByConsumer[consumer] = count
 		case "success":
 			stats.SuccessByConsumer[consumer] = count
+		case "dead":
+			stats.DeadByConsumer[consumer] = count
+		case "skipped":
+			stats.SkippedByConsumer[consumer] = count
 		}
 	}
 
@@ -455,6 +772,42 @@ func (s *Store) scanFileRows(rows *sql.Rows) (*FitFile, error) {
 	return f, nil
 }
 
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSyncRecord(row rowScanner) (*SyncRecord, error) {
+	r := &SyncRecord{}
+	var attemptedAt, completedAt, nextAttemptAt sql.NullTime
+	var remoteID, remoteURL, errMsg, transforms sql.NullString
+
+	err := row.Scan(
+		&r.ID, &r.FileID, &r.Consumer, &r.Status,
+		&attemptedAt, &completedAt,
+		&remoteID, &remoteURL, &errMsg, &r.Retries, &nextAttemptAt, &transforms,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if attemptedAt.Valid {
+		r.AttemptedAt = &attemptedAt.Time
+	}
+	if completedAt.Valid {
+		r.CompletedAt = &completedAt.Time
+	}
+	if nextAttemptAt.Valid {
+		r.NextAttemptAt = &nextAttemptAt.Time
+	}
+	r.RemoteID = remoteID.String
+	r.RemoteURL = remoteURL.String
+	r.Error = errMsg.String
+	r.Transforms = transforms.String
+
+	return r, nil
+}
+
 func nullString(s string) sql.NullString {
 	if s == "" {
 		return sql.NullString{}
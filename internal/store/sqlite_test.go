@@ -279,3 +279,508 @@ func TestStore_PersistsAcrossReopen(t *testing.T) {
 		t.Errorf("hash mismatch: got %s, want %s", got.Hash, file.Hash)
 	}
 }
+
+func TestStore_GetSyncRecord(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	store, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	fileID, err := store.InsertFile(ctx, &FitFile{
+		Path:         "/path/to/test.fit",
+		Hash:         "abc123",
+		DiscoveredAt: time.Now(),
+		Source:       "test",
+	})
+	if err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+
+	// Missing record
+	rec, err := store.GetSyncRecord(ctx, fileID, "intervals.icu")
+	if err != nil {
+		t.Fatalf("failed to get sync record: %v", err)
+	}
+	if rec != nil {
+		t.Errorf("expected nil record, got %+v", rec)
+	}
+
+	// Pending record with NULL columns
+	if _, err := store.CreateSyncRecord(ctx, fileID, "intervals.icu"); err != nil {
+		t.Fatalf("failed to create sync record: %v", err)
+	}
+	rec, err = store.GetSyncRecord(ctx, fileID, "intervals.icu")
+	if err != nil {
+		t.Fatalf("failed to get sync record: %v", err)
+	}
+	if rec == nil || rec.Status != SyncStatusPending {
+		t.Fatalf("expected pending record, got %+v", rec)
+	}
+
+	// Failed record
+	if err := store.UpdateSyncFailed(ctx, fileID, "intervals.icu", "timeout"); err != nil {
+		t.Fatalf("failed to update failed: %v", err)
+	}
+	rec, err = store.GetSyncRecord(ctx, fileID, "intervals.icu")
+	if err != nil {
+		t.Fatalf("failed to get sync record: %v", err)
+	}
+	if rec.Status != SyncStatusFailed || rec.Error != "timeout" || rec.Retries != 1 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if rec.AttemptedAt == nil {
+		t.Error("expected attempted_at to be set")
+	}
+}
+
+func TestStore_SkippedSync(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	fileID, err := store.InsertFile(ctx, &FitFile{Path: "/path/to/short.fit", Hash: "short", DiscoveredAt: time.Now()})
+	if err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+	if _, err := store.CreateSyncRecord(ctx, fileID, "archive"); err != nil {
+		t.Fatalf("failed to create sync record: %v", err)
+	}
+
+	if err := store.MarkSyncSkipped(ctx, fileID, "archive", "duration 3m0s shorter than 5m0s"); err != nil {
+		t.Fatalf("failed to mark skipped: %v", err)
+	}
+	rec, err := store.GetSyncRecord(ctx, fileID, "archive")
+	if err != nil {
+		t.Fatalf("failed to get sync record: %v", err)
+	}
+	if rec.Status != SyncStatusSkipped || rec.Error != "duration 3m0s shorter than 5m0s" || rec.Retries != 0 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if rec.CompletedAt == nil {
+		t.Error("expected completed_at to be set")
+	}
+
+	// Skipped syncs are neither pending nor due for retry.
+	pending, err := store.GetPendingFiles(ctx, "archive")
+	if err != nil {
+		t.Fatalf("failed to get pending: %v", err)
+	}
+	due, err := store.GetDueRetries(ctx, time.Now(), 0)
+	if err != nil {
+		t.Fatalf("failed to get due retries: %v", err)
+	}
+	if len(pending) != 0 || len(due) != 0 {
+		t.Errorf("expected nothing pending or due, got %d and %d", len(pending), len(due))
+	}
+
+	stats, err := store.Stats(ctx)
+	if err != nil {
+		t.Fatalf("failed to get stats: %v", err)
+	}
+	if stats.SkippedByConsumer["archive"] != 1 {
+		t.Errorf("expected 1 skipped, got %v", stats.SkippedByConsumer)
+	}
+}
+
+func TestStore_FilePaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	store, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	fileID, err := store.InsertFile(ctx, &FitFile{
+		Path:         "/zwift/ride.fit",
+		Hash:         "abc123",
+		DiscoveredAt: time.Now(),
+		Source:       "test",
+	})
+	if err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+
+	// Link an alias, twice to check idempotence
+	for i := 0; i < 2; i++ {
+		if err := store.AddFilePath(ctx, fileID, "/dropbox/ride (conflict).fit"); err != nil {
+			t.Fatalf("failed to add path: %v", err)
+		}
+	}
+
+	paths, err := store.ListFilePaths(ctx, fileID)
+	if err != nil {
+		t.Fatalf("failed to list paths: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 paths, got %v", paths)
+	}
+	if paths[0] != "/zwift/ride.fit" {
+		t.Errorf("expected primary path first, got %s", paths[0])
+	}
+
+	// Lookup by alias resolves to the original file
+	got, err := store.GetFileByPath(ctx, "/dropbox/ride (conflict).fit")
+	if err != nil {
+		t.Fatalf("failed to get file by alias: %v", err)
+	}
+	if got == nil || got.ID != fileID {
+		t.Fatalf("expected file %d by alias, got %+v", fileID, got)
+	}
+	if got.Path != "/zwift/ride.fit" {
+		t.Errorf("expected primary path, got %s", got.Path)
+	}
+
+	exists, err := store.FileExists(ctx, "/dropbox/ride (conflict).fit", "otherhash")
+	if err != nil {
+		t.Fatalf("failed to check existence: %v", err)
+	}
+	if !exists {
+		t.Error("expected file to exist by alias path")
+	}
+
+	// Modification times are kept per path.
+	modTime, err := store.PathModTime(ctx, "/zwift/ride.fit")
+	if err != nil {
+		t.Fatalf("failed to get modification time: %v", err)
+	}
+	if !modTime.IsZero() {
+		t.Errorf("expected no modification time yet, got %v", modTime)
+	}
+	mtime := time.Date(2025, 2, 23, 6, 30, 0, 123456789, time.UTC)
+	if err := store.SetPathModTime(ctx, "/dropbox/ride (conflict).fit", mtime); err != nil {
+		t.Fatalf("failed to set modification time: %v", err)
+	}
+	for path, want := range map[string]time.Time{"/zwift/ride.fit": {}, "/dropbox/ride (conflict).fit": mtime, "/unknown.fit": {}} {
+		got, err := store.PathModTime(ctx, path)
+		if err != nil {
+			t.Fatalf("failed to get modification time: %v", err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("%s: expected modification time %v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestStore_ScheduledRetries(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	store, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		id, err := store.InsertFile(ctx, &FitFile{
+			Path:         filepath.Join("/path", string(rune('a'+i))+".fit"),
+			Hash:         string(rune('a' + i)),
+			DiscoveredAt: now,
+			Source:       "test",
+		})
+		if err != nil {
+			t.Fatalf("failed to insert file: %v", err)
+		}
+		if _, err := store.CreateSyncRecord(ctx, id, "intervals.icu"); err != nil {
+			t.Fatalf("failed to create sync record: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	// One due, one in the future, one dead
+	if err := store.ScheduleRetry(ctx, ids[0], "intervals.icu", "503", now.Add(-time.Minute)); err != nil {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	if err := store.ScheduleRetry(ctx, ids[1], "intervals.icu", "503", now.Add(time.Hour)); err != nil {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	if err := store.MarkSyncDead(ctx, ids[2], "intervals.icu", "401"); err != nil {
+		t.Fatalf("failed to mark dead: %v", err)
+	}
+
+	due, err := store.GetDueRetries(ctx, now, 0)
+	if err != nil {
+		t.Fatalf("failed to get due retries: %v", err)
+	}
+	if len(due) != 1 || due[0].FileID != ids[0] {
+		t.Fatalf("expected only first file due, got %+v", due)
+	}
+	if due[0].Retries != 1 || due[0].NextAttemptAt == nil {
+		t.Errorf("unexpected due record: %+v", due[0])
+	}
+
+	// Later, the second becomes due too
+	due, err = store.GetDueRetries(ctx, now.Add(2*time.Hour), 0)
+	if err != nil {
+		t.Fatalf("failed to get due retries: %v", err)
+	}
+	if len(due) != 2 {
+		t.Errorf("expected 2 due retries, got %d", len(due))
+	}
+
+	// Success clears the schedule
+	if err := store.UpdateSyncSuccess(ctx, ids[0], "intervals.icu", "", ""); err != nil {
+		t.Fatalf("failed to update success: %v", err)
+	}
+	rec, err := store.GetSyncRecord(ctx, ids[0], "intervals.icu")
+	if err != nil {
+		t.Fatalf("failed to get sync record: %v", err)
+	}
+	if rec.NextAttemptAt != nil {
+		t.Errorf("expected schedule cleared on success, got %v", rec.NextAttemptAt)
+	}
+}
+
+func TestStore_ConsumerState(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	data, err := store.LoadState(ctx, "strava")
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if data != nil {
+		t.Errorf("expected no state, got %s", data)
+	}
+
+	if err := store.SaveState(ctx, "strava", []byte(`{"v":1}`)); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+	if err := store.SaveState(ctx, "strava", []byte(`{"v":2}`)); err != nil {
+		t.Fatalf("SaveState (update) failed: %v", err)
+	}
+	if err := store.SaveState(ctx, "other", []byte(`{"v":3}`)); err != nil {
+		t.Fatalf("SaveState (other) failed: %v", err)
+	}
+
+	data, err = store.LoadState(ctx, "strava")
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if string(data) != `{"v":2}` {
+		t.Errorf("expected latest state, got %s", data)
+	}
+}
+
+func TestStore_SyncTransforms(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	fileID, err := store.InsertFile(ctx, &FitFile{Path: "/path/to/ride.fit", Hash: "ride", DiscoveredAt: time.Now()})
+	if err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+	if _, err := store.CreateSyncRecord(ctx, fileID, "strava"); err != nil {
+		t.Fatalf("failed to create sync record: %v", err)
+	}
+
+	rec, err := store.GetSyncRecord(ctx, fileID, "strava")
+	if err != nil {
+		t.Fatalf("failed to get sync record: %v", err)
+	}
+	if rec.Transforms != "" {
+		t.Errorf("expected no transforms, got %q", rec.Transforms)
+	}
+
+	if err := store.SetSyncTransforms(ctx, fileID, "strava", "strip_gps,strip_heart_rate"); err != nil {
+		t.Fatalf("failed to set transforms: %v", err)
+	}
+	if err := store.ScheduleRetry(ctx, fileID, "strava", "timeout", time.Now()); err != nil {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	due, err := store.GetDueRetries(ctx, time.Now(), 0)
+	if err != nil {
+		t.Fatalf("failed to get due retries: %v", err)
+	}
+	if len(due) != 1 || due[0].Transforms != "strip_gps,strip_heart_rate" {
+		t.Errorf("unexpected due retries: %+v", due)
+	}
+}
+
+func TestStore_Sessions(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	start := time.Date(2024, 6, 2, 7, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Minute)
+	fileID, err := store.InsertFile(ctx, &FitFile{
+		Path:         "/path/to/tri.fit",
+		Hash:         "tri",
+		DiscoveredAt: time.Now(),
+		ActivityType: "Multisport",
+		Sessions: []FitSession{
+			{Index: 0, ActivityType: "LapSwimming", Sport: "Swimming", SubSport: "LapSwimming", StartedAt: &start, EndedAt: &end, DurationSecs: 1800, DistanceM: 1500},
+			{Index: 1, ActivityType: "Cycling", Sport: "Cycling", StartedAt: &end, DurationSecs: 3600, DistanceM: 40000, AvgPowerW: 210, NormPowerW: 225},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+
+	sessions, err := store.ListSessions(ctx, fileID)
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	if sessions[0].Sport != "Swimming" || sessions[0].SubSport != "LapSwimming" || sessions[0].DistanceM != 1500 {
+		t.Errorf("unexpected swim session: %+v", sessions[0])
+	}
+	if sessions[0].StartedAt == nil || !sessions[0].StartedAt.Equal(start) || sessions[0].EndedAt == nil || !sessions[0].EndedAt.Equal(end) {
+		t.Errorf("unexpected swim times: %v - %v", sessions[0].StartedAt, sessions[0].EndedAt)
+	}
+	if sessions[1].Index != 1 || sessions[1].Sport != "Cycling" || sessions[1].SubSport != "" || sessions[1].NormPowerW != 225 {
+		t.Errorf("unexpected ride session: %+v", sessions[1])
+	}
+	if sessions[1].EndedAt != nil {
+		t.Errorf("expected no end time, got %v", sessions[1].EndedAt)
+	}
+
+	// A file without sessions has none.
+	otherID, err := store.InsertFile(ctx, &FitFile{Path: "/path/to/other.fit", Hash: "other", DiscoveredAt: time.Now()})
+	if err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+	if sessions, err := store.ListSessions(ctx, otherID); err != nil || len(sessions) != 0 {
+		t.Errorf("expected no sessions, got %+v (%v)", sessions, err)
+	}
+}
+
+func TestStore_InsertFileIsAtomic(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	// Make the session insert fail.
+	if _, err := store.db.ExecContext(ctx, "DROP TABLE fit_sessions"); err != nil {
+		t.Fatalf("failed to drop sessions: %v", err)
+	}
+	_, err = store.InsertFile(ctx, &FitFile{
+		Path:         "/path/to/tri.fit",
+		Hash:         "tri",
+		DiscoveredAt: time.Now(),
+		Sessions:     []FitSession{{Index: 0, Sport: "Swimming"}},
+	})
+	if err == nil {
+		t.Fatal("expected insert to fail")
+	}
+
+	got, err := store.GetFileByPath(ctx, "/path/to/tri.fit")
+	if err != nil {
+		t.Fatalf("failed to get file: %v", err)
+	}
+	if got != nil {
+		t.Errorf("expected no file after failed insert, got %+v", got)
+	}
+	if paths, err := store.ListFilePaths(ctx, 1); err != nil || len(paths) != 0 {
+		t.Errorf("expected no paths after failed insert, got %v (%v)", paths, err)
+	}
+}
+
+func TestStore_ReplaceFile(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+
+	fileID, err := store.InsertFile(ctx, &FitFile{
+		Path:         "/path/to/ride.fit",
+		Hash:         "partial",
+		Size:         100,
+		DiscoveredAt: time.Now(),
+		Sessions:     []FitSession{{Index: 0, Sport: "Cycling"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert file: %v", err)
+	}
+	if _, err := store.CreateSyncRecord(ctx, fileID, "strava"); err != nil {
+		t.Fatalf("failed to create sync record: %v", err)
+	}
+	if err := store.UpdateSyncSuccess(ctx, fileID, "strava", "123", "https://strava.com/activities/123"); err != nil {
+		t.Fatalf("failed to update sync: %v", err)
+	}
+	if err := store.AddFilePath(ctx, fileID, "/backup/ride.fit"); err != nil {
+		t.Fatalf("failed to add path: %v", err)
+	}
+
+	err = store.ReplaceFile(ctx, fileID, &FitFile{
+		Hash:         "complete",
+		Size:         2048,
+		DiscoveredAt: time.Now(),
+		ActivityType: "Multisport",
+		Sessions:     []FitSession{{Index: 0, Sport: "Running"}, {Index: 1, Sport: "Cycling"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to replace file: %v", err)
+	}
+
+	got, err := store.GetFileByID(ctx, fileID)
+	if err != nil {
+		t.Fatalf("failed to get file: %v", err)
+	}
+	if got.Path != "/path/to/ride.fit" || got.Hash != "complete" || got.Size != 2048 || got.ActivityType != "Multisport" {
+		t.Errorf("unexpected file after replace: %+v", got)
+	}
+	sessions, err := store.ListSessions(ctx, fileID)
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].Sport != "Running" {
+		t.Errorf("expected replaced sessions, got %+v", sessions)
+	}
+	rec, err := store.GetSyncRecord(ctx, fileID, "strava")
+	if err != nil {
+		t.Fatalf("failed to get sync record: %v", err)
+	}
+	if rec.Status != SyncStatusPending || rec.RemoteID != "123" || rec.Retries != 0 {
+		t.Errorf("expected sync record reset to pending, keeping the remote ID, got %+v", rec)
+	}
+	paths, err := store.ListFilePaths(ctx, fileID)
+	if err != nil {
+		t.Fatalf("failed to list paths: %v", err)
+	}
+	if len(paths) != 1 || paths[0] != "/path/to/ride.fit" {
+		t.Errorf("expected aliases of the old content unlinked, got %v", paths)
+	}
+}
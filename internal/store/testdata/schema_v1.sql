@@ -0,0 +1,66 @@
+-- Database as written by fitwatch before schema versioning (schema v1).
+-- There is no schema_version table; the store must detect and upgrade it.
+
+CREATE TABLE fit_files (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	path TEXT UNIQUE NOT NULL,
+	hash TEXT,
+	size INTEGER,
+	discovered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	source TEXT DEFAULT 'watch',
+	activity_type TEXT,
+	activity_name TEXT,
+	started_at TIMESTAMP,
+	duration_secs INTEGER,
+	distance_m REAL,
+	calories INTEGER,
+	avg_power_w INTEGER,
+	max_power_w INTEGER,
+	norm_power_w INTEGER,
+	avg_hr INTEGER,
+	max_hr INTEGER,
+	avg_cadence INTEGER,
+	avg_speed_mps REAL,
+	total_ascent_m REAL,
+	device_name TEXT,
+	software_version TEXT
+);
+
+CREATE TABLE sync_records (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	file_id INTEGER NOT NULL REFERENCES fit_files(id),
+	consumer TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempted_at TIMESTAMP,
+	completed_at TIMESTAMP,
+	remote_id TEXT,
+	remote_url TEXT,
+	error TEXT,
+	retries INTEGER DEFAULT 0,
+	UNIQUE(file_id, consumer)
+);
+
+CREATE TABLE consumers (
+	name TEXT PRIMARY KEY,
+	enabled BOOLEAN DEFAULT 0,
+	config_json TEXT,
+	last_sync TIMESTAMP
+);
+
+CREATE INDEX idx_sync_pending ON sync_records(consumer, status) WHERE status = 'pending';
+CREATE INDEX idx_sync_failed ON sync_records(status) WHERE status = 'failed';
+CREATE INDEX idx_files_hash ON fit_files(hash);
+CREATE INDEX idx_files_started ON fit_files(started_at);
+CREATE INDEX idx_files_type ON fit_files(activity_type);
+
+INSERT INTO fit_files (id, path, hash, size, discovered_at, source, activity_type, duration_secs)
+VALUES (1, '/zwift/2025-06-30_Heian_Samurai_Circuit.fit', 'hash-heian', 1024, '2025-06-30 18:00:00', 'watch', 'virtual_activity', 3600);
+
+INSERT INTO fit_files (id, path, hash, size, discovered_at, source, activity_type, duration_secs)
+VALUES (2, '/zwift/2025-07-20_Downtown_LA.fit', 'hash-downtown', 2048, '2025-07-20 07:30:00', 'scan', 'virtual_activity', 5400);
+
+INSERT INTO sync_records (file_id, consumer, status, completed_at, remote_id)
+VALUES (1, 'Intervals.icu', 'success', '2025-06-30 18:05:00', 'i1001');
+
+INSERT INTO sync_records (file_id, consumer, status, attempted_at, error, retries)
+VALUES (2, 'Intervals.icu', 'failed', '2025-07-20 07:35:00', 'API error 503', 2);
@@ -0,0 +1,178 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+func init() {
+	Register("strip_gps", func(Settings) (Transformer, error) { return StripGPS{}, nil })
+	Register("strip_heart_rate", func(Settings) (Transformer, error) { return StripHeartRate{}, nil })
+	Register("shift_time", newShiftTime)
+}
+
+// StripGPS removes every position from an activity: the track, lap and
+// session start and end points, bounding boxes, and the positions in
+// segment, course point, weather and GPS metadata messages. Distances and
+// speeds are kept.
+type StripGPS struct{}
+
+// Name returns "strip_gps".
+func (StripGPS) Name() string { return "strip_gps" }
+
+// Transform returns the activity without positions.
+func (StripGPS) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	return editActivity(data, func(f *fitparser.File) {
+		for _, m := range f.Messages {
+			for _, num := range positionFields[m.Num] {
+				m.Invalidate(num)
+			}
+		}
+	})
+}
+
+// positionFields are the latitude and longitude fields of each message,
+// from the FIT profile, in pairs.
+var positionFields = map[uint16][]uint8{
+	fitparser.MesgRecord:            {0, 1},
+	fitparser.MesgLap:               {3, 4, 5, 6, 27, 28, 29, 30},
+	fitparser.MesgSession:           {3, 4, 29, 30, 31, 32, 38, 39},
+	fitparser.MesgCoursePoint:       {2, 3},
+	fitparser.MesgWeatherConditions: {10, 11},
+	fitparser.MesgSegmentLap:        {3, 4, 5, 6, 25, 26, 27, 28},
+	fitparser.MesgSegmentPoint:      {1, 2},
+	fitparser.MesgGpsMetadata:       {1, 2},
+}
+
+// StripHeartRate removes heart rate from an activity: the samples,
+// averages, minimums, maximums, time in zones and beat-to-beat (HRV) data.
+type StripHeartRate struct{}
+
+// Name returns "strip_heart_rate".
+func (StripHeartRate) Name() string { return "strip_heart_rate" }
+
+// Transform returns the activity without heart rate data.
+func (StripHeartRate) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	return editActivity(data, func(f *fitparser.File) {
+		kept := f.Messages[:0]
+		for _, m := range f.Messages {
+			if heartRateMessages[m.Num] {
+				continue
+			}
+			for _, num := range heartRateFields[m.Num] {
+				m.Invalidate(num)
+			}
+			kept = append(kept, m)
+		}
+		f.Messages = kept
+	})
+}
+
+// heartRateMessages hold nothing but heart rate data.
+var heartRateMessages = map[uint16]bool{
+	fitparser.MesgHrv:              true,
+	fitparser.MesgHr:               true,
+	fitparser.MesgBeatIntervals:    true,
+	fitparser.MesgHrvStatusSummary: true,
+	fitparser.MesgHrvValue:         true,
+}
+
+// heartRateFields are the heart rate and time in heart rate zone fields of
+// each message, from the FIT profile.
+var heartRateFields = map[uint16][]uint8{
+	fitparser.MesgRecord:     {3},
+	fitparser.MesgLap:        {15, 16, 57, 63},
+	fitparser.MesgSession:    {16, 17, 64, 65},
+	fitparser.MesgSegmentLap: {15, 16, 49, 55},
+}
+
+// ShiftTime moves every timestamp in an activity by Offset, for a device
+// whose clock was wrong.
+type ShiftTime struct {
+	Offset time.Duration
+}
+
+// Name returns "shift_time(<offset>)".
+func (s ShiftTime) Name() string { return fmt.Sprintf("shift_time(%s)", s.Offset) }
+
+// Transform returns the activity with its timestamps moved.
+func (s ShiftTime) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	return editActivity(data, func(f *fitparser.File) {
+		for _, m := range f.Messages {
+			s.shift(m, fitparser.FieldTimestamp)
+			for _, num := range timeFields[m.Num] {
+				s.shift(m, num)
+			}
+		}
+	})
+}
+
+// shift moves a date_time field. Values below minDateTime are seconds
+// since the device powered on rather than times, and are left alone, as
+// are missing ones.
+func (s ShiftTime) shift(m *fitparser.Message, num uint8) {
+	if v, ok := m.Uint(num); ok && v >= minDateTime {
+		t, _ := m.Time(num)
+		m.SetTime(num, t.Add(s.Offset))
+	}
+}
+
+// minDateTime is the smallest FIT date_time that is a time.
+const minDateTime = 0x10000000
+
+// timeFields are the date_time fields of each message other than
+// FieldTimestamp, from the FIT profile.
+var timeFields = map[uint16][]uint8{
+	fitparser.MesgFileID:               {4},
+	fitparser.MesgSession:              {2},
+	fitparser.MesgLap:                  {2},
+	fitparser.MesgCoursePoint:          {1},
+	fitparser.MesgActivity:             {5},
+	fitparser.MesgTrainingFile:         {4},
+	fitparser.MesgLength:               {2},
+	fitparser.MesgWeatherConditions:    {9},
+	fitparser.MesgWeatherAlert:         {1, 2},
+	fitparser.MesgSegmentLap:           {2},
+	fitparser.MesgTimestampCorrelation: {3},
+}
+
+func newShiftTime(settings Settings) (Transformer, error) {
+	var s struct {
+		Offset string `toml:"offset"`
+	}
+	if err := settings.Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Offset == "" {
+		return nil, errors.New("offset is required, e.g. \"1h\" or \"-30m\"")
+	}
+	offset, err := time.ParseDuration(s.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offset: %w", err)
+	}
+	if offset == 0 {
+		return nil, errors.New("offset must not be zero")
+	}
+	return ShiftTime{Offset: offset}, nil
+}
+
+// editActivity checks that data is an activity file and lets edit change
+// it, with fitparser.Edit, so developer fields and messages edit doesn't
+// touch are kept as they were.
+func editActivity(data []byte, edit func(f *fitparser.File)) ([]byte, error) {
+	return fitparser.Edit(data, func(f *fitparser.File) (bool, error) {
+		ids := f.Find(fitparser.MesgFileID)
+		if len(ids) == 0 {
+			return false, errors.New("not an activity file: no file_id message")
+		}
+		if typ, _ := ids[0].Uint(fitparser.FileIDType); typ != fitparser.FileTypeActivity {
+			return false, fmt.Errorf("not an activity file: file type %d", typ)
+		}
+		edit(f)
+		return true, nil
+	})
+}
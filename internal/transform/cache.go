@@ -0,0 +1,98 @@
+package transform
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// Cache keeps transformed files on disk, keyed by the SHA-256 of the input
+// and the chain, so each file is transformed once however many consumers
+// and retries need the same copy. Entries are never evicted; the directory
+// can be deleted at any time.
+type Cache struct {
+	dir string
+}
+
+// NewCache returns a cache in dir, which is created when first needed.
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// Dir returns the cache directory.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Path returns the path of a copy of src transformed by chain, making it if
+// the cache doesn't have one. The copy has src's base name and modification
+// time, since consumers name uploads after them.
+func (c *Cache) Path(ctx context.Context, src string, chain Chain) (string, error) {
+	hash, err := fitparser.HashFile(src)
+	if err != nil {
+		return "", fmt.Errorf("hash file: %w", err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", fmt.Errorf("stat file: %w", err)
+	}
+
+	dir := filepath.Join(c.dir, cacheKey(hash, chain))
+	dst := filepath.Join(dir, filepath.Base(src))
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("check cache: %w", err)
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	out, err := chain.Apply(ctx, data)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create cache dir: %w", err)
+	}
+	// Write to a temporary name and rename, so a concurrent or interrupted
+	// transform never leaves a partial file at dst.
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("create cache file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(out); err != nil {
+		_ = tmp.Close()
+		return "", fmt.Errorf("write cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("write cache file: %w", err)
+	}
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return "", fmt.Errorf("set cache file time: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", fmt.Errorf("write cache file: %w", err)
+	}
+	return dst, nil
+}
+
+// cacheVersion changes when transforms with unchanged names start writing
+// different output, so copies made by older versions aren't reused. 2: the
+// activity transforms keep developer fields.
+const cacheVersion = "2"
+
+// cacheKey names the cache entry for an input hash and chain.
+func cacheKey(inputHash string, chain Chain) string {
+	sum := sha256.Sum256([]byte(cacheVersion + "\x00" + inputHash + "\x00" + chain.String()))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,296 @@
+package transform
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// Zone is a circle around a place that shouldn't appear in public
+// activities, such as home.
+type Zone struct {
+	Lat, Lon     float64 // centre, in degrees
+	RadiusMeters float64
+}
+
+// Contains reports whether a position, in degrees, is inside the zone.
+func (z Zone) Contains(lat, lon float64) bool {
+	return haversine(z.Lat, z.Lon, lat, lon) <= z.RadiusMeters
+}
+
+// PrivacyMode says what PrivacyZones does with track points inside a zone.
+type PrivacyMode string
+
+const (
+	// PrivacyTruncate removes the records inside zones, and takes the
+	// distance covered inside them off the activity's distances.
+	PrivacyTruncate PrivacyMode = "truncate"
+
+	// PrivacyStrip keeps the records inside zones, with their heart rate,
+	// power, etc., but removes their positions.
+	PrivacyStrip PrivacyMode = "strip"
+)
+
+// PrivacyZones hides the parts of an activity's track inside any of Zones.
+// Lap and session start and end positions and bounding boxes are
+// recalculated from what is left. A record without a position counts as
+// inside a zone if the last position before it was. Positions inside zones
+// in other messages, such as course points and GPS metadata, are removed.
+// Activities with nothing inside a zone are passed through unchanged.
+//
+// It edits the file with fitparser.Edit, so developer fields and other
+// messages are kept.
+type PrivacyZones struct {
+	Zones []Zone
+	Mode  PrivacyMode // default PrivacyTruncate
+}
+
+// Name returns "privacy_zones(<mode>,<fingerprint>)". The fingerprint
+// changes with the zones without revealing where they are, since the name
+// is recorded in the sync store.
+func (p PrivacyZones) Name() string {
+	h := sha256.New()
+	for _, z := range p.Zones {
+		fmt.Fprintf(h, "%g,%g,%g;", z.Lat, z.Lon, z.RadiusMeters)
+	}
+	return fmt.Sprintf("privacy_zones(%s,%x)", p.mode(), h.Sum(nil)[:4])
+}
+
+func (p PrivacyZones) mode() PrivacyMode {
+	if p.Mode == "" {
+		return PrivacyTruncate
+	}
+	return p.Mode
+}
+
+// Transform returns the activity with the track inside zones hidden.
+func (p PrivacyZones) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	return fitparser.Edit(data, func(f *fitparser.File) (bool, error) {
+		return p.apply(f), nil
+	})
+}
+
+func (p PrivacyZones) inZone(lat, lon float64) bool {
+	for _, z := range p.Zones {
+		if z.Contains(lat, lon) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackPoint is a record left in the activity.
+type trackPoint struct {
+	time     time.Time
+	lat, lon float64
+	hasPos   bool
+}
+
+// removal is distance taken off the track, in centimetres, at a time.
+type removal struct {
+	time time.Time
+	cm   uint64
+}
+
+// apply hides the track inside zones, reporting whether anything was.
+func (p PrivacyZones) apply(f *fitparser.File) bool {
+	truncate := p.mode() == PrivacyTruncate
+
+	var (
+		messages []*fitparser.Message
+		track    []trackPoint
+		removals []removal
+		removed  uint64 // centimetres, so far
+		changed  bool
+
+		inside, prevHidden bool
+		prevDist           uint64
+	)
+	for _, m := range f.Messages {
+		if m.Num != fitparser.MesgRecord {
+			if m.Num != fitparser.MesgLap && m.Num != fitparser.MesgSession {
+				changed = p.hidePositions(m) || changed
+			}
+			messages = append(messages, m)
+			continue
+		}
+
+		lat, lon, hasPos := m.Position(fitparser.RecordPositionLat, fitparser.RecordPositionLong)
+		if hasPos {
+			inside = p.inZone(lat, lon)
+		}
+		hidden := inside
+		changed = changed || hidden
+		ts, _ := m.Time(fitparser.FieldTimestamp)
+
+		dist, hasDist := m.Uint(fitparser.RecordDistance)
+		var step uint64
+		if hasDist {
+			if dist >= prevDist {
+				step = dist - prevDist
+			}
+			prevDist = dist
+		}
+
+		if truncate {
+			// The way into and out of a zone goes too, so each remaining
+			// stretch carries on from where the last one stopped.
+			if hidden || prevHidden {
+				removed += step
+				removals = append(removals, removal{ts, step})
+			}
+			prevHidden = hidden
+			if hidden {
+				continue
+			}
+			if hasDist && removed > 0 {
+				m.SetUint(fitparser.RecordDistance, fitparser.TypeUint32, dist-min(removed, dist))
+			}
+		} else if hidden {
+			m.Invalidate(fitparser.RecordPositionLat)
+			m.Invalidate(fitparser.RecordPositionLong)
+			hasPos = false
+		}
+
+		messages = append(messages, m)
+		track = append(track, trackPoint{time: ts, lat: lat, lon: lon, hasPos: hasPos})
+	}
+	if !changed {
+		return false
+	}
+	f.Messages = messages
+
+	for _, m := range f.Find(fitparser.MesgLap) {
+		fixSummary(m, track, removals, summaryFields{
+			totalDistance: fitparser.LapTotalDistance,
+			startLat:      fitparser.LapStartPositionLat,
+			startLon:      fitparser.LapStartPositionLong,
+			endLat:        fitparser.LapEndPositionLat,
+			endLon:        fitparser.LapEndPositionLong,
+			necLat:        fitparser.LapNecLat,
+			necLon:        fitparser.LapNecLong,
+			swcLat:        fitparser.LapSwcLat,
+			swcLon:        fitparser.LapSwcLong,
+		})
+	}
+	for _, m := range f.Find(fitparser.MesgSession) {
+		fixSummary(m, track, removals, summaryFields{
+			totalDistance: fitparser.SessionTotalDistance,
+			startLat:      fitparser.SessionStartPositionLat,
+			startLon:      fitparser.SessionStartPositionLong,
+			endLat:        fitparser.SessionEndPositionLat,
+			endLon:        fitparser.SessionEndPositionLong,
+			necLat:        fitparser.SessionNecLat,
+			necLon:        fitparser.SessionNecLong,
+			swcLat:        fitparser.SessionSwcLat,
+			swcLon:        fitparser.SessionSwcLong,
+		})
+	}
+	return true
+}
+
+// hidePositions invalidates the positions of m inside zones, reporting
+// whether there were any. Laps and sessions are recalculated by fixSummary
+// instead.
+func (p PrivacyZones) hidePositions(m *fitparser.Message) bool {
+	fields := positionFields[m.Num]
+	hidden := false
+	for i := 0; i+1 < len(fields); i += 2 {
+		if lat, lon, ok := m.Position(fields[i], fields[i+1]); ok && p.inZone(lat, lon) {
+			m.Invalidate(fields[i])
+			m.Invalidate(fields[i+1])
+			hidden = true
+		}
+	}
+	return hidden
+}
+
+// summaryFields are the field numbers of a lap or session that describe
+// its track.
+type summaryFields struct {
+	totalDistance                  uint8
+	startLat, startLon             uint8
+	endLat, endLon                 uint8
+	necLat, necLon, swcLat, swcLon uint8
+}
+
+// fixSummary recalculates a lap or session from the track left within its
+// time span: the start position (added if missing), and the distance, end
+// position and bounding box if it has them.
+func fixSummary(m *fitparser.Message, track []trackPoint, removals []removal, fields summaryFields) {
+	// Laps and sessions keep their start time and elapsed time in the same
+	// fields. Some devices (Zwift) write a session timestamp equal to its
+	// start, so the span ends at whichever is later of the timestamp and
+	// start plus elapsed time.
+	start, hasStart := m.Time(fitparser.LapStartTime)
+	end, hasEnd := m.Time(fitparser.FieldTimestamp)
+	if elapsed, ok := m.Uint(fitparser.LapTotalElapsedTime); ok && hasStart {
+		if e := start.Add(time.Duration(elapsed) * time.Millisecond); !hasEnd || e.After(end) {
+			end, hasEnd = e, true
+		}
+	}
+	within := func(t time.Time) bool {
+		return (!hasStart || !t.Before(start)) && (!hasEnd || !t.After(end))
+	}
+
+	if dist, ok := m.Uint(fields.totalDistance); ok {
+		var cm uint64
+		for _, r := range removals {
+			if within(r.time) {
+				cm += r.cm
+			}
+		}
+		m.SetUint(fields.totalDistance, fitparser.TypeUint32, dist-min(cm, dist))
+	}
+
+	var first, last *trackPoint
+	nec := trackPoint{lat: -90, lon: -180}
+	swc := trackPoint{lat: 90, lon: 180}
+	for i := range track {
+		pt := &track[i]
+		if !pt.hasPos || !within(pt.time) {
+			continue
+		}
+		if first == nil {
+			first = pt
+		}
+		last = pt
+		nec.lat, nec.lon = max(nec.lat, pt.lat), max(nec.lon, pt.lon)
+		swc.lat, swc.lon = min(swc.lat, pt.lat), min(swc.lon, pt.lon)
+	}
+
+	if first == nil {
+		for _, num := range []uint8{fields.startLat, fields.startLon, fields.endLat, fields.endLon, fields.necLat, fields.necLon, fields.swcLat, fields.swcLon} {
+			m.Invalidate(num)
+		}
+		return
+	}
+	m.SetPosition(fields.startLat, fields.startLon, first.lat, first.lon)
+	setIfPresent(m, fields.endLat, fields.endLon, last.lat, last.lon)
+	setIfPresent(m, fields.necLat, fields.necLon, nec.lat, nec.lon)
+	setIfPresent(m, fields.swcLat, fields.swcLon, swc.lat, swc.lon)
+}
+
+func setIfPresent(m *fitparser.Message, latField, lonField uint8, lat, lon float64) {
+	if m.Field(latField) != nil && m.Field(lonField) != nil {
+		m.SetPosition(latField, lonField, lat, lon)
+	}
+}
+
+// earthRadius is the mean radius of the Earth, in metres.
+const earthRadius = 6371008.8
+
+// haversine returns the great-circle distance in metres between two
+// positions in degrees.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(min(a, 1)))
+}
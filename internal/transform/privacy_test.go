@@ -0,0 +1,189 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// sampleStart returns sample.fit's data and its first position.
+func sampleStart(t *testing.T) ([]byte, float64, float64) {
+	t.Helper()
+	data, err := os.ReadFile(samplePath(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, a := decodeActivity(t, data)
+	r := a.Records[0]
+	return data, r.PositionLat.Degrees(), r.PositionLong.Degrees()
+}
+
+func TestPrivacyZones_Truncate(t *testing.T) {
+	data, lat, lon := sampleStart(t)
+	_, before := decodeActivity(t, data)
+	zone := Zone{Lat: lat, Lon: lon, RadiusMeters: 500}
+
+	out, err := PrivacyZones{Zones: []Zone{zone}}.Transform(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	f, after := decodeActivity(t, out)
+
+	if len(after.Records) == 0 || len(after.Records) >= len(before.Records) {
+		t.Fatalf("expected some records removed, kept %d of %d", len(after.Records), len(before.Records))
+	}
+	for i, r := range after.Records {
+		if zone.Contains(r.PositionLat.Degrees(), r.PositionLong.Degrees()) {
+			t.Fatalf("record %d is inside the zone", i)
+		}
+	}
+
+	// Distances start again from the edge of the zone.
+	first, last := after.Records[0], after.Records[len(after.Records)-1]
+	if first.Distance != 0 {
+		t.Errorf("expected the track to start at 0 m, got %d cm", first.Distance)
+	}
+	s, s0 := after.Sessions[0], before.Sessions[0]
+	removed := s0.TotalDistance - s.TotalDistance
+	if removed == 0 || last.Distance != before.Records[len(before.Records)-1].Distance-removed {
+		t.Errorf("session distance %d, was %d; last record %d", s.TotalDistance, s0.TotalDistance, last.Distance)
+	}
+
+	// The session and first lap now start where the visible track does.
+	if s.StartPositionLat != first.PositionLat || s.StartPositionLong != first.PositionLong {
+		t.Errorf("session starts at %v,%v, track at %v,%v", s.StartPositionLat, s.StartPositionLong, first.PositionLat, first.PositionLong)
+	}
+	if l := after.Laps[0]; l.StartPositionLat != first.PositionLat || l.StartPositionLong != first.PositionLong {
+		t.Errorf("first lap starts at %v,%v", l.StartPositionLat, l.StartPositionLong)
+	}
+
+	// Developer data and the other messages survive.
+	g, err := fitparser.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Find(fitparser.MesgFieldDescription)) == 0 || f.FileCreator == nil {
+		t.Error("output lost messages")
+	}
+}
+
+func TestPrivacyZones_Strip(t *testing.T) {
+	data, lat, lon := sampleStart(t)
+	_, before := decodeActivity(t, data)
+	zone := Zone{Lat: lat, Lon: lon, RadiusMeters: 500}
+
+	out, err := PrivacyZones{Zones: []Zone{zone}, Mode: PrivacyStrip}.Transform(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	_, after := decodeActivity(t, out)
+
+	if len(after.Records) != len(before.Records) {
+		t.Fatalf("expected %d records, got %d", len(before.Records), len(after.Records))
+	}
+	stripped := 0
+	for i, r := range after.Records {
+		if r.Distance != before.Records[i].Distance || r.Power != before.Records[i].Power {
+			t.Fatalf("record %d changed", i)
+		}
+		if r.PositionLat.Invalid() {
+			stripped++
+			continue
+		}
+		if zone.Contains(r.PositionLat.Degrees(), r.PositionLong.Degrees()) {
+			t.Fatalf("record %d is inside the zone", i)
+		}
+	}
+	if stripped == 0 {
+		t.Error("expected positions stripped")
+	}
+	if after.Sessions[0].TotalDistance != before.Sessions[0].TotalDistance {
+		t.Error("strip changed the distance")
+	}
+	if zone.Contains(after.Sessions[0].StartPositionLat.Degrees(), after.Sessions[0].StartPositionLong.Degrees()) {
+		t.Error("session starts inside the zone")
+	}
+}
+
+func TestPrivacyZones_OutsideZonesUnchanged(t *testing.T) {
+	data, lat, lon := sampleStart(t)
+
+	// Somewhere a long way from the ride.
+	zones := []Zone{{Lat: -lat, Lon: lon / 2, RadiusMeters: 1000}}
+	out, err := PrivacyZones{Zones: zones}.Transform(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Error("activity outside every zone was changed")
+	}
+}
+
+func TestPrivacyZones_HidesOtherPositions(t *testing.T) {
+	data, lat, lon := sampleStart(t)
+	zone := Zone{Lat: lat, Lon: lon, RadiusMeters: 500}
+
+	// A GPS metadata message at the start of the ride, inside the zone.
+	f, err := fitparser.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gps := &fitparser.Message{Num: fitparser.MesgGpsMetadata, LocalType: 15}
+	gps.SetPosition(1, 2, lat, lon)
+	f.Messages = append(f.Messages, gps)
+	var buf bytes.Buffer
+	if err := f.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []PrivacyMode{PrivacyTruncate, PrivacyStrip} {
+		out, err := PrivacyZones{Zones: []Zone{zone}, Mode: mode}.Transform(context.Background(), buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: Transform failed: %v", mode, err)
+		}
+		g, err := fitparser.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range g.Find(fitparser.MesgGpsMetadata) {
+			if lat, lon, ok := m.Position(1, 2); ok && zone.Contains(lat, lon) {
+				t.Errorf("%s: gps_metadata is inside the zone", mode)
+			}
+		}
+	}
+}
+
+func TestPrivacyZones_Name(t *testing.T) {
+	home := PrivacyZones{Zones: []Zone{{Lat: 51.501364, Lon: -0.14189, RadiusMeters: 400}}}
+	name := home.Name()
+	if !strings.HasPrefix(name, "privacy_zones(truncate,") || strings.Contains(name, "51.5") {
+		t.Errorf("unexpected name %q", name)
+	}
+
+	moved := PrivacyZones{Zones: []Zone{{Lat: 51.501364, Lon: -0.14189, RadiusMeters: 500}}}
+	if moved.Name() == name {
+		t.Error("name doesn't change with the zones")
+	}
+	strip := PrivacyZones{Zones: home.Zones, Mode: PrivacyStrip}
+	if !strings.HasPrefix(strip.Name(), "privacy_zones(strip,") {
+		t.Errorf("unexpected name %q", strip.Name())
+	}
+}
+
+func TestZone_Contains(t *testing.T) {
+	// Buckingham Palace to Trafalgar Square is about 1.2 km.
+	palace := Zone{Lat: 51.501364, Lon: -0.14189, RadiusMeters: 1000}
+	if !palace.Contains(51.5014, -0.1419) {
+		t.Error("centre not in zone")
+	}
+	if palace.Contains(51.508039, -0.128069) {
+		t.Error("Trafalgar Square is more than 1 km away")
+	}
+	if d := haversine(51.501364, -0.14189, 51.508039, -0.128069); d < 1150 || d > 1250 {
+		t.Errorf("unexpected distance %.0f m", d)
+	}
+}
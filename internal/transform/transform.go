@@ -0,0 +1,120 @@
+// Package transform produces modified copies of FIT files before they are
+// pushed, such as a copy without GPS for a public platform, leaving the
+// original untouched. Each consumer can have its own chain of transforms.
+package transform
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Transformer modifies FIT files.
+type Transformer interface {
+	// Name identifies the transform and any settings that change its
+	// output, e.g. "shift_time(1h0m0s)". It is recorded with each sync and
+	// keys the cache, so it must change whenever the output would.
+	Name() string
+
+	// Transform returns a modified copy of the FIT file data. It must not
+	// modify data.
+	Transform(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// Chain is a sequence of transforms, applied in order.
+type Chain []Transformer
+
+// String returns the names of the transforms, comma-separated, e.g.
+// "strip_gps,strip_heart_rate". It identifies the chain in the sync store.
+func (c Chain) String() string {
+	names := make([]string, len(c))
+	for i, t := range c {
+		names[i] = t.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Apply runs data through every transform in the chain.
+func (c Chain) Apply(ctx context.Context, data []byte) ([]byte, error) {
+	for _, t := range c {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		out, err := t.Transform(ctx, data)
+		if err != nil {
+			return nil, &Error{Transform: t.Name(), Err: err}
+		}
+		data = out
+	}
+	return data, nil
+}
+
+// Error is a transform failing on a file, e.g. because it isn't a valid
+// activity, as opposed to the file or cache being unreadable. Trying
+// again won't help.
+type Error struct {
+	Transform string
+	Err       error
+}
+
+func (e *Error) Error() string { return e.Transform + ": " + e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Settings gives a factory access to a transform's configuration.
+type Settings interface {
+	// Decode unmarshals the configuration into v, which should use
+	// toml struct tags.
+	Decode(v any) error
+}
+
+// Factory builds a transform from its settings.
+type Factory func(settings Settings) (Transformer, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a transform type available by name, for use in a
+// consumer's transforms config. It panics if the type is registered twice.
+func Register(typ string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if f == nil {
+		panic("transform: Register factory is nil")
+	}
+	if _, dup := registry[typ]; dup {
+		panic("transform: Register called twice for type " + typ)
+	}
+	registry[typ] = f
+}
+
+// Build creates a transform of the given registered type.
+func Build(typ string, settings Settings) (Transformer, error) {
+	registryMu.RLock()
+	f, ok := registry[typ]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown transform type %q (available: %v)", typ, Types())
+	}
+
+	t, err := f(settings)
+	if err != nil {
+		return nil, fmt.Errorf("transform %s: %w", typ, err)
+	}
+	return t, nil
+}
+
+// Types returns the registered transform types, sorted.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]string, 0, len(registry))
+	for typ := range registry {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,353 @@
+package transform
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/tormoder/fit"
+
+	"github.com/johnazariah/fitwatch/internal/fitparser"
+)
+
+// tomlSettings decodes from a TOML document, as a transforms entry would.
+type tomlSettings string
+
+func (s tomlSettings) Decode(v any) error { return toml.Unmarshal([]byte(s), v) }
+
+// upper is a transform that upper-cases its input and counts its calls.
+type upper struct {
+	calls int
+	err   error
+}
+
+func (u *upper) Name() string { return "upper" }
+
+func (u *upper) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	u.calls++
+	if u.err != nil {
+		return nil, u.err
+	}
+	return bytes.ToUpper(data), nil
+}
+
+// suffix is a transform that appends its text.
+type suffix string
+
+func (s suffix) Name() string { return "suffix(" + string(s) + ")" }
+
+func (s suffix) Transform(ctx context.Context, data []byte) ([]byte, error) {
+	return append(append([]byte(nil), data...), s...), nil
+}
+
+func samplePath(t *testing.T) string {
+	t.Helper()
+	_, filename, _, _ := runtime.Caller(0)
+	p := filepath.Join(filepath.Dir(filename), "..", "..", "testdata", "sample.fit")
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		t.Skip("sample.fit not found in testdata")
+	}
+	return p
+}
+
+func TestChain_Apply(t *testing.T) {
+	chain := Chain{&upper{}, suffix("!")}
+	if got := chain.String(); got != "upper,suffix(!)" {
+		t.Errorf("unexpected name %q", got)
+	}
+
+	out, err := chain.Apply(context.Background(), []byte("ride"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "RIDE!" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	failing := Chain{&upper{err: errors.New("boom")}}
+	if _, err := failing.Apply(context.Background(), []byte("ride")); err == nil || !strings.Contains(err.Error(), "upper: boom") {
+		t.Errorf("expected error naming the transform, got %v", err)
+	}
+}
+
+func TestCache_Path(t *testing.T) {
+	ctx := context.Background()
+	src := filepath.Join(t.TempDir(), "2024-03-09_Evening Ride.fit")
+	if err := os.WriteFile(src, []byte("ride"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 9, 18, 0, 0, 0, time.Local)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache(filepath.Join(t.TempDir(), "cache"))
+	u := &upper{}
+	chain := Chain{u}
+
+	p, err := cache.Path(ctx, src, chain)
+	if err != nil {
+		t.Fatalf("Path failed: %v", err)
+	}
+	if filepath.Base(p) != filepath.Base(src) || !strings.HasPrefix(p, cache.Dir()) {
+		t.Errorf("unexpected path %s", p)
+	}
+	if data, _ := os.ReadFile(p); string(data) != "RIDE" {
+		t.Errorf("unexpected content %q", data)
+	}
+	if info, _ := os.Stat(p); !info.ModTime().Equal(mtime) {
+		t.Errorf("expected source mtime, got %v", info.ModTime())
+	}
+	if data, _ := os.ReadFile(src); string(data) != "ride" {
+		t.Error("original was modified")
+	}
+
+	// The same input and chain come from the cache.
+	again, err := cache.Path(ctx, src, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != p || u.calls != 1 {
+		t.Errorf("expected cached %s after 1 call, got %s after %d", p, again, u.calls)
+	}
+
+	// A different chain is a different entry.
+	other, err := cache.Path(ctx, src, Chain{u, suffix("!")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == p {
+		t.Error("different chains share a cache entry")
+	}
+
+	// So is different content.
+	if err := os.WriteFile(src, []byte("walk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := cache.Path(ctx, src, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(changed); changed == p || string(data) != "WALK" {
+		t.Errorf("stale entry for changed content: %s %q", changed, data)
+	}
+}
+
+func TestCache_FailedTransformLeavesNothing(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "ride.fit")
+	if err := os.WriteFile(src, []byte("ride"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCache(filepath.Join(t.TempDir(), "cache"))
+
+	if _, err := cache.Path(context.Background(), src, Chain{&upper{err: errors.New("boom")}}); err == nil {
+		t.Fatal("expected error")
+	}
+	entries, _ := os.ReadDir(cache.Dir())
+	if len(entries) != 0 {
+		t.Errorf("expected empty cache, got %v", entries)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	tr, err := Build("shift_time", tomlSettings(`offset = "-1h30m"`))
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if tr.Name() != "shift_time(-1h30m0s)" {
+		t.Errorf("unexpected name %q", tr.Name())
+	}
+
+	for _, settings := range []string{``, `offset = "soon"`, `offset = "0s"`} {
+		if _, err := Build("shift_time", tomlSettings(settings)); err == nil {
+			t.Errorf("expected error for %q", settings)
+		}
+	}
+	if _, err := Build("teleport", tomlSettings(``)); err == nil || !strings.Contains(err.Error(), "strip_gps") {
+		t.Errorf("expected unknown type error listing types, got %v", err)
+	}
+}
+
+func decodeActivity(t *testing.T, data []byte) (*fit.File, *fit.ActivityFile) {
+	t.Helper()
+	f, err := fit.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("output doesn't decode: %v", err)
+	}
+	a, err := f.Activity()
+	if err != nil {
+		t.Fatalf("output isn't an activity: %v", err)
+	}
+	return f, a
+}
+
+func TestStripGPS(t *testing.T) {
+	data, err := os.ReadFile(samplePath(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, before := decodeActivity(t, data)
+
+	out, err := StripGPS{}.Transform(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	_, after := decodeActivity(t, out)
+
+	if len(after.Records) != len(before.Records) {
+		t.Fatalf("expected %d records, got %d", len(before.Records), len(after.Records))
+	}
+	for i, r := range after.Records {
+		if !r.PositionLat.Invalid() || !r.PositionLong.Invalid() {
+			t.Fatalf("record %d still has a position", i)
+		}
+		if r.Distance != before.Records[i].Distance || r.HeartRate != before.Records[i].HeartRate {
+			t.Fatalf("record %d lost other data", i)
+		}
+	}
+}
+
+func TestStripHeartRate(t *testing.T) {
+	data, err := os.ReadFile(samplePath(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := StripHeartRate{}.Transform(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	_, after := decodeActivity(t, out)
+
+	for i, r := range after.Records {
+		if r.HeartRate != 0xFF {
+			t.Fatalf("record %d still has heart rate %d", i, r.HeartRate)
+		}
+	}
+	s := after.Sessions[0]
+	if s.AvgHeartRate != 0xFF || s.MaxHeartRate != 0xFF {
+		t.Errorf("session still has heart rate: avg %d max %d", s.AvgHeartRate, s.MaxHeartRate)
+	}
+	if s.AvgPower == 0xFFFF {
+		t.Error("session lost its power")
+	}
+}
+
+func TestShiftTime(t *testing.T) {
+	data, err := os.ReadFile(samplePath(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, before := decodeActivity(t, data)
+
+	out, err := ShiftTime{Offset: time.Hour}.Transform(context.Background(), data)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	f, after := decodeActivity(t, out)
+
+	if got, want := after.Sessions[0].StartTime, before.Sessions[0].StartTime.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("session start %v, want %v", got, want)
+	}
+	if got, want := after.Records[0].Timestamp, before.Records[0].Timestamp.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("first record at %v, want %v", got, want)
+	}
+	if fit.IsBaseTime(f.FileId.TimeCreated) {
+		t.Error("file creation time was lost")
+	}
+}
+
+func TestActivityTransforms_KeepDeveloperFields(t *testing.T) {
+	data, err := os.ReadFile(samplePath(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := fitparser.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devFields := func(f *fitparser.File) int {
+		n := 0
+		for _, m := range f.Messages {
+			n += len(m.DevFields)
+		}
+		return n
+	}
+	if devFields(before) == 0 {
+		t.Skip("sample.fit has no developer fields")
+	}
+
+	for _, tr := range []Transformer{StripGPS{}, StripHeartRate{}, ShiftTime{Offset: time.Hour}} {
+		out, err := tr.Transform(context.Background(), data)
+		if err != nil {
+			t.Fatalf("%s failed: %v", tr.Name(), err)
+		}
+		after, err := fitparser.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%s output doesn't decode: %v", tr.Name(), err)
+		}
+		if got, want := devFields(after), devFields(before); got != want {
+			t.Errorf("%s: expected %d developer fields, got %d", tr.Name(), want, got)
+		}
+		if got, want := len(after.Find(fitparser.MesgFieldDescription)), len(before.Find(fitparser.MesgFieldDescription)); got != want {
+			t.Errorf("%s: expected %d field descriptions, got %d", tr.Name(), want, got)
+		}
+	}
+}
+
+func TestStripHeartRate_RemovesHRVMessages(t *testing.T) {
+	f := &fitparser.File{Messages: []*fitparser.Message{
+		{Num: fitparser.MesgFileID, Fields: []fitparser.Field{{Num: fitparser.FileIDType, Type: fitparser.TypeEnum, Data: []byte{fitparser.FileTypeActivity}}}},
+		{Num: fitparser.MesgHrv, LocalType: 1, Fields: []fitparser.Field{{Num: 0, Type: fitparser.TypeUint16, Data: []byte{0x20, 0x03}}}},
+		{Num: fitparser.MesgRecord, LocalType: 2, Fields: []fitparser.Field{
+			{Num: 3, Type: fitparser.TypeUint8, Data: []byte{150}},
+			{Num: 7, Type: fitparser.TypeUint16, Data: []byte{200, 0}},
+		}},
+	}}
+	var buf bytes.Buffer
+	if err := f.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := StripHeartRate{}.Transform(context.Background(), buf.Bytes())
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	after, err := fitparser.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("output doesn't decode: %v", err)
+	}
+	if hrv := after.Find(fitparser.MesgHrv); len(hrv) != 0 {
+		t.Errorf("expected HRV messages to be removed, got %d", len(hrv))
+	}
+	records := after.Find(fitparser.MesgRecord)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	if hr, ok := records[0].Uint(3); ok {
+		t.Errorf("record still has heart rate %d", hr)
+	}
+	if power, ok := records[0].Uint(7); !ok || power != 200 {
+		t.Errorf("record lost its power: %d", power)
+	}
+}
+
+func TestActivityTransforms_RejectOtherFiles(t *testing.T) {
+	f := &fitparser.File{Messages: []*fitparser.Message{
+		{Num: fitparser.MesgFileID, Fields: []fitparser.Field{{Num: fitparser.FileIDType, Type: fitparser.TypeEnum, Data: []byte{6}}}}, // course
+	}}
+	var buf bytes.Buffer
+	if err := f.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (StripGPS{}).Transform(context.Background(), buf.Bytes()); err == nil || !strings.Contains(err.Error(), "not an activity") {
+		t.Errorf("expected not an activity error, got %v", err)
+	}
+}
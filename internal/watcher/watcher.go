@@ -13,12 +13,16 @@ import (
 	"github.com/fsnotify/fsnotify"
 )
 
+// DefaultWorkers is the number of new files handled at once by default.
+const DefaultWorkers = 4
+
 // Watcher monitors directories for new FIT files.
 type Watcher struct {
 	dirs    []string
 	onNew   func(path string)
 	logger  *slog.Logger
 	watcher *fsnotify.Watcher
+	workers int
 
 	mu   sync.Mutex
 	seen map[string]bool // Track files we've already processed
@@ -30,11 +34,21 @@ func New(dirs []string, onNew func(path string), logger *slog.Logger) *Watcher {
 		logger = slog.Default()
 	}
 	return &Watcher{
-		dirs:   dirs,
-		onNew:  onNew,
-		logger: logger,
-		seen:   make(map[string]bool),
+		dirs:    dirs,
+		onNew:   onNew,
+		logger:  logger,
+		workers: DefaultWorkers,
+		seen:    make(map[string]bool),
+	}
+}
+
+// SetWorkers configures how many new files are handled at once.
+// Files detected while all workers are busy wait their turn.
+func (w *Watcher) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
 	}
+	w.workers = n
 }
 
 // Watch starts watching for new FIT files.
@@ -54,6 +68,19 @@ func (w *Watcher) Watch(ctx context.Context) error {
 		}
 	}
 
+	// A fixed set of workers handles new files, so a burst of files
+	// (e.g. a bulk copy) doesn't start an upload for every one at once.
+	queue := make(chan string, 256)
+	var wg sync.WaitGroup
+	for i := 0; i < w.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work(ctx, queue)
+		}()
+	}
+	defer wg.Wait()
+
 	// Process events
 	for {
 		select {
@@ -64,7 +91,7 @@ func (w *Watcher) Watch(ctx context.Context) error {
 			if !ok {
 				return nil
 			}
-			w.handleEvent(event)
+			w.handleEvent(ctx, event, queue)
 
 		case err, ok := <-w.watcher.Errors:
 			if !ok {
@@ -155,7 +182,7 @@ func (w *Watcher) scanDir(dir string) error {
 	return nil
 }
 
-func (w *Watcher) handleEvent(event fsnotify.Event) {
+func (w *Watcher) handleEvent(ctx context.Context, event fsnotify.Event, queue chan<- string) {
 	// Only care about create and write events
 	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
 		return
@@ -174,14 +201,27 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	w.logger.Info("new FIT file detected", "path", event.Name)
 	w.MarkSeen(event.Name)
 
-	// Wait for file to be ready (not locked by another process)
-	go func(path string) {
-		if err := w.waitForFileReady(path, 30*time.Second); err != nil {
-			w.logger.Warn("file not ready after waiting", "path", path, "error", err)
+	select {
+	case queue <- event.Name:
+	case <-ctx.Done():
+	}
+}
+
+// work handles queued files until ctx is canceled.
+func (w *Watcher) work(ctx context.Context, queue <-chan string) {
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case path := <-queue:
+			// Wait for file to be ready (not locked by another process)
+			if err := w.waitForFileReady(path, 30*time.Second); err != nil {
+				w.logger.Warn("file not ready after waiting", "path", path, "error", err)
+				continue
+			}
+			w.onNew(path)
 		}
-		w.onNew(path)
-	}(event.Name)
+	}
 }
 
 // waitForFileReady waits until a file can be opened for reading.
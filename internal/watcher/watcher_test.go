@@ -339,3 +339,60 @@ func TestWatcher_ScanExisting(t *testing.T) {
 		t.Error("should not have called back for non-FIT file")
 	}
 }
+
+func TestWatcher_BoundsConcurrentHandlers(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var mu sync.Mutex
+	inFlight, peak, handled := 0, 0, 0
+
+	w := New([]string{tmpDir}, func(path string) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		handled++
+		mu.Unlock()
+	}, slog.Default())
+	w.SetWorkers(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() { _ = w.Watch(ctx) }()
+
+	// Give watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 6; i++ {
+		fitPath := filepath.Join(tmpDir, "burst"+string(rune('a'+i))+".fit")
+		if err := os.WriteFile(fitPath, []byte("fake fit data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		done := handled
+		mu.Unlock()
+		if done == 6 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if handled != 6 {
+		t.Errorf("expected 6 files handled, got %d", handled)
+	}
+	if peak > 2 {
+		t.Errorf("expected at most 2 concurrent handlers, peak was %d", peak)
+	}
+}
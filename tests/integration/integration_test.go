@@ -92,7 +92,7 @@ func TestEndToEnd_NewFileDetectedAndUploaded(t *testing.T) {
 		}
 
 		// Upload to Intervals.icu
-		syncErr := consumer.Push(ctx, path)
+		receipt, syncErr := consumer.Push(ctx, path)
 
 		// Record sync result
 		_, _ = db.CreateSyncRecord(ctx, fileID, consumer.Name())
@@ -100,7 +100,7 @@ func TestEndToEnd_NewFileDetectedAndUploaded(t *testing.T) {
 		if syncErr != nil {
 			_ = db.UpdateSyncFailed(ctx, fileID, consumer.Name(), syncErr.Error())
 		} else {
-			_ = db.UpdateSyncSuccess(ctx, fileID, consumer.Name(), "activity123", "")
+			_ = db.UpdateSyncSuccess(ctx, fileID, consumer.Name(), receipt.RemoteID, receipt.RemoteURL)
 		}
 
 		processedMu.Lock()
@@ -82,7 +82,7 @@ func TestIntervalsAPI_InvalidCredentials(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	err := consumer.Push(ctx, fitPath)
+	_, err := consumer.Push(ctx, fitPath)
 	if err == nil {
 		t.Error("expected error for invalid credentials")
 	}
@@ -105,7 +105,7 @@ func TestIntervalsAPI_UploadRealFitFile(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	err := consumer.Push(ctx, fitPath)
+	_, err := consumer.Push(ctx, fitPath)
 	if err != nil {
 		// 409 Conflict means duplicate - that's OK, file was already uploaded
 		if strings.Contains(err.Error(), "409") {
@@ -133,12 +133,17 @@ func TestIntervalsAPI_UploadDuplicate(t *testing.T) {
 	defer cancel()
 
 	// First upload (may succeed or be duplicate)
-	_ = consumer.Push(ctx, fitPath)
+	_, _ = consumer.Push(ctx, fitPath)
 
-	// Second upload should be duplicate
-	err := consumer.Push(ctx, fitPath)
+	// Second upload should be detected as a duplicate, either before
+	// uploading (overlapping activity) or by the API (409 Conflict)
+	receipt, err := consumer.Push(ctx, fitPath)
 	if err == nil {
-		t.Log("No error on duplicate - Intervals.icu may have accepted it")
+		if receipt.Duplicate {
+			t.Logf("Found existing activity %s before uploading", receipt.RemoteID)
+		} else {
+			t.Log("No error on duplicate - Intervals.icu may have accepted it")
+		}
 		return
 	}
 